@@ -0,0 +1,145 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package dictionarystore saves the TraceZip dictionaries of the exporter and the
+// receiver, in a directory or with a storage extension.
+package dictionarystore // import "angrychow/otel/dictionary-store"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/extension/experimental/storage"
+)
+
+// Store keeps TraceZip dictionary snapshots across restarts. It is
+// the part of storage.Client the exporter and the receiver use: Get returns nil
+// for a key that was never set.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte) error
+	Delete(ctx context.Context, key string) error
+	Close(ctx context.Context) error
+}
+
+// New returns the store of the component id of kind: the client of
+// the storage extension storageID if it is set, else files in dir, else nil.
+func New(ctx context.Context, host component.Host, kind component.Kind, id component.ID, dir string, storageID *component.ID) (Store, error) {
+	if storageID != nil {
+		ext, ok := host.GetExtensions()[*storageID]
+		if !ok {
+			return nil, fmt.Errorf("storage extension %q not found", storageID)
+		}
+		storageExt, ok := ext.(storage.Extension)
+		if !ok {
+			return nil, fmt.Errorf("extension %q is not a storage extension", storageID)
+		}
+		return storageExt.GetClient(ctx, kind, id, "tracezip")
+	}
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &directoryStore{dir: dir, prefix: strings.ReplaceAll(id.String(), "/", "_") + "-"}, nil
+}
+
+// directoryStore is a Store of one file per key in a local directory.
+// Files are replaced atomically, so that a crash leaves the last complete one.
+type directoryStore struct {
+	dir    string
+	prefix string
+}
+
+func (s *directoryStore) path(key string) (string, error) {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return "", fmt.Errorf("invalid dictionary key %q", key)
+		}
+	}
+	return filepath.Join(s.dir, s.prefix+key+".json"), nil
+}
+
+func (s *directoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *directoryStore) Set(_ context.Context, key string, value []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tracezip-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(value); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *directoryStore) Delete(_ context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *directoryStore) Close(context.Context) error {
+	return nil
+}
+
+// RunEvery calls f every interval until the returned stop is called, to save or
+// expire dictionaries. stop waits for a call in progress to return, so that the
+// store can be closed after it.
+func RunEvery(interval time.Duration, f func(now time.Time)) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				f(now)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
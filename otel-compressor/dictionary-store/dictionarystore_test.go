@@ -0,0 +1,78 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package dictionarystore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+)
+
+// host is a component.Host without extensions.
+type host struct {
+	component.Host
+}
+
+func (host) GetExtensions() map[component.ID]component.Component {
+	return nil
+}
+
+func TestDirectoryStore(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "dictionaries")
+	id := component.NewIDWithName(component.MustNewType("otlp"), "a/b")
+	store, err := New(ctx, host{}, component.KindExporter, id, dir, nil)
+	require.NoError(t, err)
+
+	data, err := store.Get(ctx, "traces")
+	require.NoError(t, err)
+	assert.Nil(t, data)
+
+	require.NoError(t, store.Set(ctx, "traces", []byte("one")))
+	require.NoError(t, store.Set(ctx, "traces", []byte("two")))
+	data, err = store.Get(ctx, "traces")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("two"), data)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "otlp_a_b-traces.json", entries[0].Name())
+
+	assert.Error(t, store.Set(ctx, "../traces", nil))
+	require.NoError(t, store.Delete(ctx, "traces"))
+	require.NoError(t, store.Delete(ctx, "traces"))
+	data, err = store.Get(ctx, "traces")
+	require.NoError(t, err)
+	assert.Nil(t, data)
+	require.NoError(t, store.Close(ctx))
+}
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	id := component.NewID(component.MustNewType("otlp"))
+	store, err := New(ctx, host{}, component.KindReceiver, id, "", nil)
+	require.NoError(t, err)
+	assert.Nil(t, store)
+
+	storageID := component.NewID(component.MustNewType("file_storage"))
+	_, err = New(ctx, host{}, component.KindReceiver, id, "", &storageID)
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestRunEvery(t *testing.T) {
+	var calls atomic.Int32
+	stop := RunEvery(time.Millisecond, func(time.Time) { calls.Add(1) })
+	assert.Eventually(t, func() bool { return calls.Load() >= 2 }, time.Second, time.Millisecond)
+	stop()
+	stopped := calls.Load()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, stopped, calls.Load())
+}
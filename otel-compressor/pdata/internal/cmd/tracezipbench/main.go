@@ -0,0 +1,201 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// tracezipbench compresses a captured span dataset with every attribute order
+// strategy of the TraceZip compressor and reports the compressed sizes.
+//
+// The dataset is a folder of OTLP/JSON traces requests, one per .json or .txt
+// file, like the ones the wrk script sends. Files are read in name order and merge
+// of them form a batch:
+//
+//	go run ./internal/cmd/tracezipbench -merge 10 ../spans
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+)
+
+var strategies = []struct {
+	name     string
+	strategy ptraceotlp.OrderStrategy
+}{
+	{name: "cardinality", strategy: ptraceotlp.OrderCardinality},
+	{name: "entropy", strategy: ptraceotlp.OrderEntropy},
+}
+
+// sizes are the bytes a strategy sends.
+type sizes struct {
+	payload    int
+	dictionary int
+	gzipped    int
+	fulls      int
+}
+
+func check(e error) {
+	if e != nil {
+		fmt.Fprintln(os.Stderr, e)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	merge := flag.Int("merge", 1, "number of files per batch")
+	sampleBuffer := flag.Int("sample_buffer", 30000, "spans in the sample buffer")
+	attrLimit := flag.Int("attr_limit", 100, "distinct values of a trie attribute")
+	threshold := flag.Int("srt_threshold", 50000, "trie paths before the trie is rebuilt")
+	format := flag.String("tracezip_format", "json", "wire format, json or binary")
+	verify := flag.Bool("verify", false, "decode every batch and check its span count")
+	flag.Parse()
+	if flag.NArg() != 1 || *merge < 1 {
+		fmt.Fprintln(os.Stderr, "usage: tracezipbench [flags] <folder>")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	batches, input := readBatches(flag.Arg(0), *merge)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "strategy\tpayload\tdictionary\ttotal\tgzip\tfull dictionaries\tratio\t\n")
+	for _, s := range strategies {
+		c := ptraceotlp.NewTraceZipCompressor(ptraceotlp.TraceZipSettings{
+			BufferSize:    *sampleBuffer,
+			AttrLimit:     *attrLimit,
+			ThresholdRate: *threshold,
+			AttrOrder:     s.strategy,
+		})
+		var dict *ptraceotlp.TraceZipDictionary
+		if *verify {
+			dict = ptraceotlp.NewTraceZipDictionary()
+		}
+		var total sizes
+		for _, td := range batches {
+			check(compress(c, dict, td, *format, &total))
+		}
+		sum := total.payload + total.dictionary
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%.2f\t\n", s.name, total.payload, total.dictionary, sum, total.gzipped,
+			total.fulls, float64(input)/float64(sum))
+	}
+	check(w.Flush())
+}
+
+// readBatches reads the requests of folder into batches of merge requests, and
+// returns them with their size as OTLP/JSON.
+func readBatches(folder string, merge int) ([]ptraceotlp.ExportRequest, int) {
+	entries, err := os.ReadDir(folder)
+	check(err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && (strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".txt")) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var unmarshaler ptrace.JSONUnmarshaler
+	var marshaler ptrace.JSONMarshaler
+	batches := make([]ptraceotlp.ExportRequest, 0)
+	input := 0
+	var batch ptrace.Traces
+	for i, name := range names {
+		data, err := os.ReadFile(filepath.Join(folder, name))
+		check(err)
+		td, err := unmarshaler.UnmarshalTraces(data)
+		check(err)
+		if i%merge == 0 {
+			batch = ptrace.NewTraces()
+		}
+		td.ResourceSpans().MoveAndAppendTo(batch.ResourceSpans())
+		if i%merge == merge-1 || i == len(names)-1 {
+			raw, err := marshaler.MarshalTraces(batch)
+			check(err)
+			input += len(raw)
+			batches = append(batches, ptraceotlp.NewExportRequestFromTraces(batch))
+		}
+	}
+	return batches, input
+}
+
+// compress compresses a batch with c and adds what it sends to total. With a
+// dictionary, it also decodes the batch and checks its span count.
+func compress(c *ptraceotlp.TraceZipCompressor, dict *ptraceotlp.TraceZipDictionary, request ptraceotlp.ExportRequest, format string, total *sizes) error {
+	uuid, full, increment, export := c.MarshalWithTraceZip(request, false)
+	var payload []byte
+	var err error
+	if format == "binary" {
+		payload, err = ptraceotlp.MarshalTraceZipBinary(uuid, export)
+	} else {
+		payload, err = json.Marshal(export)
+	}
+	if err != nil {
+		return err
+	}
+	update := full
+	if update == nil {
+		update = increment
+	} else {
+		total.fulls++
+	}
+	var dictionary []byte
+	if update != nil {
+		if dictionary, err = json.Marshal(update); err != nil {
+			return err
+		}
+	}
+	total.payload += len(payload)
+	total.dictionary += len(dictionary)
+	total.gzipped += gzipSize(payload) + gzipSize(dictionary)
+	if dict == nil {
+		return nil
+	}
+
+	var parts []json.RawMessage
+	if update != nil {
+		if err = json.Unmarshal(dictionary, &parts); err != nil {
+			return err
+		}
+		if full != nil {
+			err = dict.FullUpdate(parts)
+		} else {
+			err = dict.IncrementUpdate(parts)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if format == "binary" {
+		_, export, err = ptraceotlp.UnmarshalTraceZipBinary(payload)
+		if err != nil {
+			return err
+		}
+	}
+	td, err := ptraceotlp.DecodeWithTraceZip(dict, export)
+	if err != nil {
+		return err
+	}
+	if td.SpanCount() != request.Traces().SpanCount() {
+		return fmt.Errorf("decoded %d spans, want %d", td.SpanCount(), request.Traces().SpanCount())
+	}
+	return nil
+}
+
+func gzipSize(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write(data)
+	_ = gz.Close()
+	return buf.Len()
+}
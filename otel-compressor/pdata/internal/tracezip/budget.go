@@ -0,0 +1,115 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+import (
+	"sort"
+)
+
+// EvictionPolicy picks the dictionary entries a Budget evicts first.
+type EvictionPolicy uint8
+
+const (
+	// EvictLRU evicts the least recently used entries first.
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently used entries first, the least recently
+	// used first among equally used ones.
+	EvictLFU
+)
+
+// Budget bounds the memory of the dictionaries of a compressor. Its dictionaries
+// remember when and how often their entries are used, counted in batches, and
+// Evict drops entries that the current batch did not use until the memory is back
+// under the limit. The state that can not lose single entries, like tries and the
+// dictionaries their paths refer to, only counts towards the limit.
+type Budget struct {
+	limit  int
+	policy EvictionPolicy
+	batch  uint64
+	dicts  []*Dict
+}
+
+// NewBudget returns a budget of limit bytes, 0 for no limit.
+func NewBudget(limit int, policy EvictionPolicy) *Budget {
+	return &Budget{limit: limit, policy: policy}
+}
+
+// NewDict returns an empty dictionary whose entries the budget may evict.
+func (b *Budget) NewDict() *Dict {
+	d := NewDict()
+	d.budget = b
+	d.uses = make(map[string]*dictUse)
+	b.dicts = append(b.dicts, d)
+	return d
+}
+
+// Next starts a batch. Entries used by the batch are not evicted before the next
+// call of Next.
+func (b *Budget) Next() {
+	b.batch++
+}
+
+// Batch returns the current batch, the number of calls of Next.
+func (b *Budget) Batch() uint64 {
+	return b.batch
+}
+
+// Size returns the bytes of the dictionaries of the budget and pinned bytes of
+// other state.
+func (b *Budget) Size(pinned int) int {
+	size := pinned
+	for _, d := range b.dicts {
+		size += d.size
+	}
+	return size
+}
+
+// Evict evicts entries of the dictionaries of the budget, if they and pinned bytes
+// of other state exceed the limit, until they take at most nine tenths of it, so
+// that not every batch evicts. It returns the number of evicted entries, and false
+// if the memory is still over the limit, because the pinned state or the current
+// batch takes too much; then only dropping all state helps. Entries used in floor
+// or a later batch are not evicted either, for payloads of earlier batches still
+// on their way to the receiver; a floor beyond the current batch protects the
+// current batch only.
+func (b *Budget) Evict(pinned int, floor uint64) (int, bool) {
+	if b.limit <= 0 {
+		return 0, true
+	}
+	size := b.Size(pinned)
+	if size <= b.limit {
+		return 0, true
+	}
+	type candidate struct {
+		dict *Dict
+		code string
+		use  dictUse
+	}
+	keep := min(floor, b.batch)
+	candidates := make([]candidate, 0)
+	for _, d := range b.dicts {
+		for code, use := range d.uses {
+			if use.last < keep {
+				candidates = append(candidates, candidate{dict: d, code: code, use: *use})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		x, y := candidates[i].use, candidates[j].use
+		if b.policy == EvictLFU && x.count != y.count {
+			return x.count < y.count
+		}
+		return x.last < y.last
+	})
+	target := b.limit - b.limit/10
+	evicted := 0
+	for _, c := range candidates {
+		if size <= target {
+			break
+		}
+		size -= c.dict.evict(c.code)
+		evicted++
+	}
+	return evicted, size <= b.limit
+}
@@ -0,0 +1,130 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudgetEvict(t *testing.T) {
+	entrySize := len("A") + len("value-x") + dictEntryOverhead
+	for _, tt := range []struct {
+		name    string
+		policy  EvictionPolicy
+		evicted []string
+	}{
+		// value-a was used last in the first batch, value-b in the second one.
+		{name: "lru", policy: EvictLRU, evicted: []string{"value-a"}},
+		// value-a was used three times, value-b once.
+		{name: "lfu", policy: EvictLFU, evicted: []string{"value-b"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBudget(3*entrySize-1, tt.policy)
+			d := b.NewDict()
+			other := b.NewDict()
+
+			b.Next()
+			d.Code("value-a")
+			d.Code("value-a")
+			d.Code("value-a")
+			b.Next()
+			d.Code("value-b")
+			b.Next()
+			code, _ := other.Code("value-c")
+			d.TakeUpdates()
+			other.TakeUpdates()
+
+			evicted, ok := b.Evict(0, b.Batch())
+			assert.True(t, ok)
+			assert.Equal(t, 1, evicted)
+			for _, value := range tt.evicted {
+				_, found := d.Lookup(value)
+				assert.False(t, found, value)
+			}
+			// The entry of the current batch stays.
+			_, found := other.Lookup("value-c")
+			assert.True(t, found)
+			assert.Equal(t, "value-c", other.Values()[code])
+			assert.Equal(t, 2*entrySize, d.Size()+other.Size())
+
+			updates := d.TakeUpdates()
+			assert.Len(t, updates, 1)
+			assert.True(t, updates[0].Evicted)
+			values := map[string]string{"A": "value-a", "B": "value-b"}
+			ApplyUpdates(values, updates)
+			assert.Len(t, values, 1)
+
+			// An evicted value comes back with a new code.
+			code, added := d.Code(tt.evicted[0])
+			assert.True(t, added)
+			assert.Equal(t, "C", code)
+		})
+	}
+}
+
+func TestBudgetOverLimit(t *testing.T) {
+	b := NewBudget(1000, EvictLRU)
+	d := b.NewDict()
+	b.Next()
+	d.Code("value")
+
+	// Nothing but the current batch can be evicted.
+	evicted, ok := b.Evict(1000, b.Batch())
+	assert.False(t, ok)
+	assert.Zero(t, evicted)
+
+	// No limit, no eviction.
+	evicted, ok = NewBudget(0, EvictLRU).Evict(1<<30, 0)
+	assert.True(t, ok)
+	assert.Zero(t, evicted)
+}
+
+func TestBudgetEvictFloor(t *testing.T) {
+	entrySize := len("A") + len("value-x") + dictEntryOverhead
+	b := NewBudget(entrySize, EvictLRU)
+	d := b.NewDict()
+	b.Next()
+	a, _ := d.Code("value-a")
+	b.Next()
+	bb, _ := d.Code("value-b")
+	b.Next()
+	d.Code("value-c")
+
+	// A payload of the second batch is held, its entry stays.
+	evicted, ok := b.Evict(0, 2)
+	assert.False(t, ok)
+	assert.Equal(t, 1, evicted)
+	assert.NotContains(t, d.Values(), a)
+	assert.Contains(t, d.Values(), bb)
+
+	evicted, ok = b.Evict(0, b.Batch())
+	assert.True(t, ok)
+	assert.Equal(t, 1, evicted)
+	assert.NotContains(t, d.Values(), bb)
+}
+
+func TestHolds(t *testing.T) {
+	var h Holds
+	_, _, ok := h.Oldest(1)
+	assert.False(t, ok)
+
+	first := h.Add(1, 3, 7)
+	h.Add(1, 4, 8)
+	h.Add(0, 1, 2)
+	batch, version, ok := h.Oldest(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(7), batch)
+	assert.Equal(t, uint64(3), version)
+
+	h.Release(first)
+	h.Release(first)
+	batch, version, ok = h.Oldest(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(8), batch)
+	assert.Equal(t, uint64(4), version)
+	_, _, ok = h.Oldest(2)
+	assert.False(t, ok)
+}
@@ -0,0 +1,233 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+import (
+	"net/url"
+	"strings"
+)
+
+// structuredCodecs split the values of the semantic convention attributes with a
+// known structure into a template and parameters.
+var structuredCodecs = map[string]func(string) (Template, []string, bool){
+	"http.url":     SplitURL,
+	"url.full":     SplitURL,
+	"db.statement": SplitSQL,
+}
+
+// SplitStructured splits the value of the attribute key with the codec of the
+// attribute. It returns false if the attribute has no codec, or the codec finds
+// no parameters in value.
+func SplitStructured(key string, value string) (Template, []string, bool) {
+	split, ok := structuredCodecs[key]
+	if !ok {
+		return Template{}, nil, false
+	}
+	return split(value)
+}
+
+// SplitURL splits an absolute URL into a template of its scheme, host, path and
+// query keys, in their order, and parameters for the path segments that are
+// numbers, UUIDs or hex strings and for the query values.
+func SplitURL(value string) (Template, []string, bool) {
+	if _, err := url.Parse(value); err != nil {
+		return Template{}, nil, false
+	}
+	schemeEnd := strings.Index(value, "://")
+	if schemeEnd <= 0 {
+		return Template{}, nil, false
+	}
+	rest := value[schemeEnd+len("://"):]
+	hostEnd := strings.IndexAny(rest, "/?#")
+	if hostEnd < 0 {
+		hostEnd = len(rest)
+	}
+	if hostEnd == 0 {
+		return Template{}, nil, false
+	}
+	var b templateBuilder
+	b.lit(value[:schemeEnd+len("://")+hostEnd])
+	rest = rest[hostEnd:]
+
+	rest, fragment, hasFragment := strings.Cut(rest, "#")
+	path, query, hasQuery := strings.Cut(rest, "?")
+	for i, segment := range strings.Split(path, "/") {
+		if i > 0 {
+			b.lit("/")
+		}
+		if typ, ok := segmentType(segment); ok {
+			b.param(typ, segment)
+		} else {
+			b.lit(segment)
+		}
+	}
+	if hasQuery {
+		b.lit("?")
+		for i, pair := range strings.Split(query, "&") {
+			if i > 0 {
+				b.lit("&")
+			}
+			if key, v, ok := strings.Cut(pair, "="); ok {
+				b.lit(key + "=")
+				b.param(ParamAny, v)
+			} else {
+				b.lit(pair)
+			}
+		}
+	}
+	if hasFragment {
+		b.lit("#" + fragment)
+	}
+	return b.done()
+}
+
+// segmentType returns the type of a path segment that is an id.
+func segmentType(segment string) (ParamType, bool) {
+	switch {
+	case isNumber(segment):
+		return ParamNumber, true
+	case isUUID(segment):
+		return ParamUUID, true
+	case len(segment) >= 8 && isHex(segment) && strings.IndexAny(segment, "0123456789") >= 0:
+		return ParamHex, true
+	}
+	return ParamAny, false
+}
+
+// SplitSQL splits a SQL statement into a template, the statement with its string
+// and number literals taken out, and the literals as parameters. Quoted
+// identifiers and comments stay in the template.
+func SplitSQL(value string) (Template, []string, bool) {
+	var b templateBuilder
+	for i := 0; i < len(value); {
+		c := value[i]
+		switch {
+		case c == '\'':
+			end, ok := closingQuote(value, i)
+			if !ok {
+				return Template{}, nil, false
+			}
+			b.lit("'")
+			b.param(ParamString, value[i+1:end])
+			b.lit("'")
+			i = end + 1
+		case c == '"' || c == '`':
+			end := strings.IndexByte(value[i+1:], c)
+			if end < 0 {
+				return Template{}, nil, false
+			}
+			b.lit(value[i : i+end+2])
+			i += end + 2
+		case c == '-' && strings.HasPrefix(value[i:], "--"):
+			end := strings.IndexByte(value[i:], '\n')
+			if end < 0 {
+				end = len(value) - i
+			}
+			b.lit(value[i : i+end])
+			i += end
+		case isDigit(c) && (i == 0 || !isIdentifier(value[i-1])):
+			end := i
+			for end < len(value) && isDigit(value[end]) {
+				end++
+			}
+			if end+1 < len(value) && value[end] == '.' && isDigit(value[end+1]) {
+				end++
+				for end < len(value) && isDigit(value[end]) {
+					end++
+				}
+			}
+			if end < len(value) && isIdentifier(value[end]) {
+				// a name that starts with a digit
+				for end < len(value) && isIdentifier(value[end]) {
+					end++
+				}
+				b.lit(value[i:end])
+			} else {
+				b.param(ParamNumber, value[i:end])
+			}
+			i = end
+		default:
+			b.lit(value[i : i+1])
+			i++
+		}
+	}
+	return b.done()
+}
+
+// closingQuote returns the index of the quote that closes the string literal
+// starting at start, skipping doubled quotes.
+func closingQuote(value string, start int) (int, bool) {
+	for i := start + 1; i < len(value); i++ {
+		if value[i] != '\'' {
+			continue
+		}
+		if i+1 < len(value) && value[i+1] == '\'' {
+			i++
+			continue
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+func (t ParamType) valid(param string) bool {
+	switch t {
+	case ParamNumber:
+		whole, fraction, ok := strings.Cut(param, ".")
+		return isNumber(whole) && (!ok || isNumber(fraction))
+	case ParamUUID:
+		return isUUID(param)
+	case ParamHex:
+		return param != "" && isHex(param)
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentifier(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !isDigit(c) && (c < 'a' || c > 'f') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if !isHex(s[i : i+1]) {
+				return false
+			}
+		}
+	}
+	return true
+}
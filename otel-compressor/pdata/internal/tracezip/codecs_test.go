@@ -0,0 +1,71 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitURL(t *testing.T) {
+	tests := []struct {
+		value  string
+		tokens []string
+		types  []ParamType
+		params []string
+	}{
+		{
+			value:  "http://ts-order-service:12031/api/v1/orders/17?user=u7&debug#top",
+			tokens: []string{"http://ts-order-service:12031/api/v1/orders/", "", "?user=", "", "&debug#top"},
+			types:  []ParamType{ParamNumber, ParamAny},
+			params: []string{"17", "u7"},
+		},
+		{
+			value:  "https://example.com/trace/4bf92f3577b34da6a3ce929d0e0e4736/span/00f067aa-0ba9-02b7-0000-000000000000",
+			tokens: []string{"https://example.com/trace/", "", "/span/", ""},
+			types:  []ParamType{ParamHex, ParamUUID},
+			params: []string{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa-0ba9-02b7-0000-000000000000"},
+		},
+	}
+	for _, tt := range tests {
+		tmpl, params, ok := SplitStructured("url.full", tt.value)
+		require.True(t, ok, tt.value)
+		assert.Equal(t, Template{Tokens: tt.tokens, Types: tt.types}, tmpl)
+		assert.Equal(t, tt.params, params)
+		value, err := FillTemplate(MarshalTemplate(tmpl), params)
+		require.NoError(t, err)
+		assert.Equal(t, tt.value, value)
+	}
+
+	// Without ids there is nothing to take out, relative URLs are left alone.
+	for _, value := range []string{"http://ts-order-service/api/v1/orders", "/api/v1/orders/17", "http://[::1"} {
+		_, _, ok := SplitURL(value)
+		assert.False(t, ok, value)
+	}
+}
+
+func TestSplitSQL(t *testing.T) {
+	value := "SELECT * FROM \"order 2\" WHERE id = 42 AND price > 1.5 AND name = 'O''Brien' -- 7\nLIMIT 10"
+	tmpl, params, ok := SplitStructured("db.statement", value)
+	require.True(t, ok)
+	assert.Equal(t, []string{"42", "1.5", "O''Brien", "10"}, params)
+	assert.Equal(t, []ParamType{ParamNumber, ParamNumber, ParamString, ParamNumber}, tmpl.Types)
+	filled, err := FillTemplate(MarshalTemplate(tmpl), params)
+	require.NoError(t, err)
+	assert.Equal(t, value, filled)
+
+	// The same statement with other literals shares the template.
+	other, _, ok := SplitSQL("SELECT * FROM \"order 2\" WHERE id = 7 AND price > 3 AND name = '' -- 7\nLIMIT 20")
+	require.True(t, ok)
+	assert.Equal(t, tmpl, other)
+
+	_, err = FillTemplate(MarshalTemplate(tmpl), []string{"x", "1", "", "1"})
+	assert.Error(t, err)
+	for _, value := range []string{"SELECT * FROM t2", "SELECT 'unterminated"} {
+		_, _, ok := SplitSQL(value)
+		assert.False(t, ok, value)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package tracezip holds the pieces of the TraceZip encoding that are shared by
+// the traces, logs and metrics compressors.
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+import (
+	"fmt"
+	"strings"
+)
+
+const asciiChars string = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// Number2String returns the base62 dictionary code of number.
+func Number2String(number int) string {
+	ret := ""
+	for {
+		bytes := []byte{asciiChars[number%62]}
+		ret = ret + string(bytes)
+		number /= 62
+		if number == 0 {
+			break
+		}
+	}
+	return ret
+}
+
+// String2Number is the inverse of Number2String.
+func String2Number(code string) (int, error) {
+	if code == "" {
+		return 0, fmt.Errorf("empty code")
+	}
+	number := 0
+	for i := len(code) - 1; i >= 0; i-- {
+		digit := strings.IndexByte(asciiChars, code[i])
+		if digit < 0 {
+			return 0, fmt.Errorf("invalid code %q", code)
+		}
+		number = number*62 + digit
+	}
+	return number, nil
+}
@@ -0,0 +1,218 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// UpdatesEntry is one entry of an incremental dictionary update. An entry with
+// Evicted set tells the receiver to drop the code, its value is empty.
+type UpdatesEntry struct {
+	Key     string `json:"k"`
+	Value   string `json:"v"`
+	Evicted bool   `json:"x,omitempty"`
+}
+
+// dictEntryOverhead estimates the bytes a dictionary entry takes besides its code
+// and value: two map slots, two string headers and its use.
+const dictEntryOverhead = 96
+
+// Dict assigns base62 codes to the strings it sees, and remembers the entries it
+// added or evicted since the last call of TakeUpdates. Codes are never reused, so
+// an evicted value that comes back gets a new code.
+type Dict struct {
+	codes   map[string]string
+	values  map[string]string
+	updates []UpdatesEntry
+	next    int
+	size    int
+
+	// budget is the Budget the dictionary belongs to, nil if it is not evicted
+	budget *Budget
+	uses   map[string]*dictUse
+}
+
+// dictUse is when an entry was last used, as a batch of its Budget, and how often.
+type dictUse struct {
+	last  uint64
+	count uint64
+}
+
+// NewDict returns an empty dictionary.
+func NewDict() *Dict {
+	return &Dict{
+		codes:   make(map[string]string),
+		values:  make(map[string]string),
+		updates: make([]UpdatesEntry, 0),
+	}
+}
+
+// Code returns the code of value, adding value to the dictionary if it is new.
+func (d *Dict) Code(value string) (string, bool) {
+	if code, ok := d.codes[value]; ok {
+		d.use(code)
+		return code, false
+	}
+	code := Number2String(d.next)
+	d.next++
+	d.codes[value] = code
+	d.values[code] = value
+	d.size += len(code) + len(value) + dictEntryOverhead
+	d.updates = append(d.updates, UpdatesEntry{Key: code, Value: value})
+	if d.budget != nil {
+		d.uses[code] = &dictUse{}
+		d.use(code)
+	}
+	return code, true
+}
+
+// Clear drops all entries and pending updates. The dictionary stays in its budget
+// and keeps counting codes.
+func (d *Dict) Clear() {
+	d.codes = make(map[string]string)
+	d.values = make(map[string]string)
+	d.updates = make([]UpdatesEntry, 0)
+	d.size = 0
+	if d.budget != nil {
+		d.uses = make(map[string]*dictUse)
+	}
+}
+
+// Lookup returns the code of value, if value is in the dictionary.
+func (d *Dict) Lookup(value string) (string, bool) {
+	code, ok := d.codes[value]
+	if ok {
+		d.use(code)
+	}
+	return code, ok
+}
+
+// use records a use of code in the current batch of the budget.
+func (d *Dict) use(code string) {
+	if d.budget == nil {
+		return
+	}
+	u := d.uses[code]
+	u.last = d.budget.batch
+	u.count++
+}
+
+// evict drops the entry of code and returns its size. The receiver is told with
+// the next update.
+func (d *Dict) evict(code string) int {
+	value := d.values[code]
+	delete(d.codes, value)
+	delete(d.values, code)
+	delete(d.uses, code)
+	size := len(code) + len(value) + dictEntryOverhead
+	d.size -= size
+	d.updates = append(d.updates, UpdatesEntry{Key: code, Evicted: true})
+	return size
+}
+
+// Size estimates the bytes the entries of the dictionary take.
+func (d *Dict) Size() int {
+	return d.size
+}
+
+// EntrySize estimates the bytes an entry of a decoded dictionary takes, the way
+// Dict counts its own entries.
+func EntrySize(code string, valueSize int) int {
+	return len(code) + valueSize + dictEntryOverhead
+}
+
+// MapSize estimates the bytes the entries of decoded dictionaries take.
+func MapSize(dicts ...map[string]string) int {
+	size := 0
+	for _, dict := range dicts {
+		for code, value := range dict {
+			size += EntrySize(code, len(value))
+		}
+	}
+	return size
+}
+
+// PathsSize estimates the bytes decoded paths take, see PathSize.
+func PathsSize(paths ...map[string][]string) int {
+	size := 0
+	for _, dict := range paths {
+		for code, path := range dict {
+			size += PathSize(code, path)
+		}
+	}
+	return size
+}
+
+// Len returns the number of entries of the dictionary.
+func (d *Dict) Len() int {
+	return len(d.codes)
+}
+
+// Values returns the dictionary from code to value, which is the form of the
+// dictionary in a full update. It must not be modified.
+func (d *Dict) Values() map[string]string {
+	return d.values
+}
+
+// TakeUpdates returns the entries added or evicted since the last call and forgets them.
+func (d *Dict) TakeUpdates() []UpdatesEntry {
+	updates := d.updates
+	d.updates = make([]UpdatesEntry, 0)
+	return updates
+}
+
+// ApplyUpdates adds the entries of an incremental update to dict, and drops the
+// evicted ones.
+func ApplyUpdates(dict map[string]string, entries []UpdatesEntry) {
+	for _, entry := range entries {
+		if entry.Evicted {
+			delete(dict, entry.Key)
+			continue
+		}
+		dict[entry.Key] = entry.Value
+	}
+}
+
+// UnmarshalUpdates splits a dictionary update into its parts, which must be n.
+func UnmarshalUpdates(data []json.RawMessage, n int, kind string) ([][]UpdatesEntry, error) {
+	if len(data) != n {
+		return nil, fmt.Errorf("incremental %s dictionary update has %d parts, want %d", kind, len(data), n)
+	}
+	updates := make([][]UpdatesEntry, n)
+	for i := range updates {
+		if err := json.Unmarshal(data[i], &updates[i]); err != nil {
+			return nil, fmt.Errorf("incremental %s dictionary update, part %d: %w", kind, i, err)
+		}
+	}
+	return updates, nil
+}
+
+// UnmarshalFull decodes the parts of a full dictionary update into targets.
+func UnmarshalFull(data []json.RawMessage, targets []interface{}, kind string) error {
+	if len(data) != len(targets) {
+		return fmt.Errorf("full %s dictionary update has %d parts, want %d", kind, len(data), len(targets))
+	}
+	for i, target := range targets {
+		if err := json.Unmarshal(data[i], target); err != nil {
+			return fmt.Errorf("full %s dictionary update, part %d: %w", kind, i, err)
+		}
+	}
+	return nil
+}
+
+// UnmarshalArrays decodes entries whose values are JSON arrays of strings, such
+// as paths and attribute orders.
+func UnmarshalArrays(entries []UpdatesEntry) (map[string][]string, error) {
+	ret := make(map[string][]string, len(entries))
+	for _, entry := range entries {
+		var arr []string
+		if err := json.Unmarshal([]byte(entry.Value), &arr); err != nil {
+			return nil, fmt.Errorf("entry %q: %w", entry.Key, err)
+		}
+		ret[entry.Key] = arr
+	}
+	return ret, nil
+}
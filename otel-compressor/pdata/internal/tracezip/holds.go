@@ -0,0 +1,56 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+// Holds are the payloads of a compressor that are on their way to the receiver.
+// While a payload is held, the compressor keeps the dictionary entries it uses,
+// so that the receiver can decode it with any later version of the dictionary it
+// applied first. The zero value holds nothing.
+type Holds struct {
+	next  uint64
+	holds map[uint64]hold
+}
+
+// hold is the epoch and version of the dictionary a payload needs, and the batch
+// of the Budget it was compressed in.
+type hold struct {
+	epoch   uint64
+	version uint64
+	batch   uint64
+}
+
+// Add holds a payload that needs version of epoch and was compressed in batch. It
+// returns the token that releases it.
+func (h *Holds) Add(epoch, version, batch uint64) uint64 {
+	if h.holds == nil {
+		h.holds = make(map[uint64]hold)
+	}
+	h.next++
+	h.holds[h.next] = hold{epoch: epoch, version: version, batch: batch}
+	return h.next
+}
+
+// Release forgets the payload of token. Unknown tokens are ignored.
+func (h *Holds) Release(token uint64) {
+	delete(h.holds, token)
+}
+
+// Oldest returns the oldest batch and the oldest version of the payloads held in
+// epoch, and false if none is. Payloads of other epochs are not decoded with the
+// current dictionary anyway.
+func (h *Holds) Oldest(epoch uint64) (batch uint64, version uint64, ok bool) {
+	for _, p := range h.holds {
+		if p.epoch != epoch {
+			continue
+		}
+		if !ok || p.batch < batch {
+			batch = p.batch
+		}
+		if !ok || p.version < version {
+			version = p.version
+		}
+		ok = true
+	}
+	return batch, version, ok
+}
@@ -0,0 +1,118 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+import (
+	"math"
+)
+
+// OrderStrategy picks the attributes of a trie and their order among the
+// attributes with at most limit distinct values in the window.
+type OrderStrategy uint8
+
+const (
+	// OrderCardinality takes every attribute, the ones with fewer distinct values
+	// first.
+	OrderCardinality OrderStrategy = iota
+	// OrderEntropy picks the attributes greedily: next is the attribute with the
+	// lowest entropy conditional on the attributes before it over the records of
+	// the window, the one that adds the least branching to the paths taken most,
+	// so that paths share long prefixes. Attributes that follow from the ones
+	// before them, like a status text from a status code, add no paths this way.
+	// An attribute is only taken while the paths it adds are at most half the
+	// records, so that a path is shared by two records on average; the others are
+	// cheaper sent with the record. Ties go to the attribute that adds fewer paths,
+	// then to the one with fewer values.
+	OrderEntropy
+)
+
+// OrderBy returns the attributes of group in the trie of strategy, from keys, the
+// attributes as returned by Order.
+func (s *Sampler) OrderBy(strategy OrderStrategy, group string, keys []string) []string {
+	if strategy != OrderEntropy || len(keys) == 0 {
+		return keys
+	}
+	return s.entropyOrder(group, keys)
+}
+
+// entropyOrder picks and orders keys, which are ordered by cardinality, with
+// OrderEntropy.
+func (s *Sampler) entropyOrder(group string, keys []string) []string {
+	g, _ := s.groups.lookup(group)
+	column := make(map[int32]int, len(keys))
+	for i, key := range keys {
+		k, _ := s.keys.lookup(key)
+		column[k] = i
+	}
+	// the value ids of the keys for every record of the group, -1 if missing
+	rows := make([][]int32, 0)
+	for i := 0; i < s.n; i++ {
+		record := s.ring[(s.head+i)%len(s.ring)]
+		if record.group != g {
+			continue
+		}
+		row := make([]int32, len(keys))
+		for j := range row {
+			row[j] = -1
+		}
+		for _, field := range record.fields {
+			if j, ok := column[field.key]; ok {
+				row[j] = field.value
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	type split struct {
+		prefix int32
+		value  int32
+	}
+	// prefixes[r] numbers the path of row r through the attributes picked so far
+	prefixes := make([]int32, len(rows))
+	paths := 1
+	picked := make([]bool, len(keys))
+	order := make([]string, 0, len(keys))
+	for {
+		best, bestEntropy, bestPaths := -1, 0.0, 0
+		for j := range keys {
+			if picked[j] {
+				continue
+			}
+			counts := make(map[split]int)
+			for r, row := range rows {
+				counts[split{prefix: prefixes[r], value: row[j]}]++
+			}
+			if 2*(len(counts)-paths) > len(rows) {
+				continue
+			}
+			// The entropy of the prefixes is the same for every key, so the
+			// joint entropy orders the keys like the conditional one.
+			entropy := 0.0
+			for _, count := range counts {
+				p := float64(count) / float64(len(rows))
+				entropy -= p * math.Log2(p)
+			}
+			if best < 0 || entropy < bestEntropy-1e-9 ||
+				math.Abs(entropy-bestEntropy) <= 1e-9 && len(counts) < bestPaths {
+				best, bestEntropy, bestPaths = j, entropy, len(counts)
+			}
+		}
+		if best < 0 {
+			return order
+		}
+		picked[best] = true
+		order = append(order, keys[best])
+		ids := make(map[split]int32)
+		for r, row := range rows {
+			key := split{prefix: prefixes[r], value: row[best]}
+			id, ok := ids[key]
+			if !ok {
+				id = int32(len(ids))
+				ids[key] = id
+			}
+			prefixes[r] = id
+		}
+		paths = len(ids)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderBy(t *testing.T) {
+	s := NewSampler(0, 0)
+	now := time.Now()
+	statuses := []string{"200", "200", "200", "200", "200", "200", "200", "200", "200", "200", "404", "500"}
+	for i := 0; i < 40; i++ {
+		status := statuses[i%len(statuses)]
+		s.Add(now, "GET /order", []Field{
+			{Key: "http.method", Value: []string{"GET", "POST"}[i%2]},
+			{Key: "http.status_code", Value: status},
+			// follows from the status code
+			{Key: "http.status_text", Value: "status " + status},
+			// all but unique
+			{Key: "order.id", Value: strconv.Itoa(i % 20)},
+		})
+	}
+	keys := s.Order("GET /order", 20)
+	assert.Equal(t, []string{"http.method", "http.status_code", "http.status_text", "order.id"}, keys)
+	assert.Equal(t, keys, s.OrderBy(OrderCardinality, "GET /order", keys))
+
+	// The skewed status code goes first and takes its text along, the order id
+	// would give every other record a path of its own.
+	order := s.OrderBy(OrderEntropy, "GET /order", keys)
+	assert.Equal(t, []string{"http.status_code", "http.status_text", "http.method"}, order)
+	assert.Less(t, s.trieNodes("GET /order", order), s.trieNodes("GET /order", keys))
+
+	// A single record takes every attribute.
+	s.Add(now, "GET /user", []Field{{Key: "user.id", Value: "1"}, {Key: "http.method", Value: "GET"}})
+	keys = s.Order("GET /user", 20)
+	assert.Equal(t, keys, s.OrderBy(OrderEntropy, "GET /user", keys))
+}
+
+// trieNodes returns the number of nodes a trie of the records of group in the
+// window has with the attributes of order.
+func (s *Sampler) trieNodes(group string, order []string) int {
+	g, ok := s.groups.lookup(group)
+	if !ok {
+		return 0
+	}
+	prefixes := make([]map[string]bool, len(order))
+	for i := range prefixes {
+		prefixes[i] = make(map[string]bool)
+	}
+	for i := 0; i < s.n; i++ {
+		record := s.ring[(s.head+i)%len(s.ring)]
+		if record.group != g {
+			continue
+		}
+		values := make(map[string]string, len(record.fields))
+		for _, field := range record.fields {
+			values[s.keys.names[field.key]] = s.values.names[field.value]
+		}
+		path := make([]byte, 0)
+		for depth, key := range order {
+			value, ok := values[key]
+			if !ok {
+				value = Missing
+			}
+			path = append(append(path, value...), 0)
+			prefixes[depth][string(path)] = true
+		}
+	}
+	nodes := 0
+	for _, level := range prefixes {
+		nodes += len(level)
+	}
+	return nodes
+}
@@ -0,0 +1,307 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+import (
+	"sort"
+	"time"
+)
+
+// Field is an attribute of a sampled record, with its value in a comparable form.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// fieldRef is a Field as ids of the sampler's interners.
+type fieldRef struct {
+	key   int32
+	value int32
+}
+
+// fingerprint is what the sampler keeps of a record.
+type fingerprint struct {
+	group  int32
+	added  int64
+	fields []fieldRef
+}
+
+type keyRef struct {
+	group int32
+	key   int32
+}
+
+type valueRef struct {
+	group int32
+	key   int32
+	value int32
+}
+
+// interner numbers strings. Strings are dropped when their last reference is
+// released, and their ids are reused.
+type interner struct {
+	ids   map[string]int32
+	names []string
+	refs  []int
+	free  []int32
+}
+
+func newInterner() interner {
+	return interner{ids: make(map[string]int32)}
+}
+
+// acquire returns the id of s and takes a reference on it.
+func (in *interner) acquire(s string) int32 {
+	id, ok := in.ids[s]
+	if !ok {
+		if n := len(in.free); n > 0 {
+			id = in.free[n-1]
+			in.free = in.free[:n-1]
+			in.names[id] = s
+		} else {
+			id = int32(len(in.names))
+			in.names = append(in.names, s)
+			in.refs = append(in.refs, 0)
+		}
+		in.ids[s] = id
+	}
+	in.refs[id]++
+	return id
+}
+
+// release drops a reference taken by acquire.
+func (in *interner) release(id int32) {
+	if in.refs[id]--; in.refs[id] == 0 {
+		delete(in.ids, in.names[id])
+		in.names[id] = ""
+		in.free = append(in.free, id)
+	}
+}
+
+func (in *interner) lookup(s string) (int32, bool) {
+	id, ok := in.ids[s]
+	return id, ok
+}
+
+// Sampler counts the distinct values of every attribute of every group over a
+// sliding window of records, which decides the attributes that go into the tries.
+//
+// The window holds the last size records and, with a maximum age, only the records
+// added within that age; the record added last always stays. Records are kept in a
+// ring buffer as fingerprints of interned ids, and the counts are kept up to date
+// as records enter and leave the window, so adding a record costs O(its fields).
+type Sampler struct {
+	size   int
+	maxAge time.Duration
+
+	// ring buffer, the oldest record is ring[head]
+	ring []fingerprint
+	head int
+	n    int
+
+	groups interner
+	keys   interner
+	values interner
+
+	// how often the value of the key is in the window for the group
+	counts map[valueRef]int
+	// how many distinct values the key has in the window for the group
+	cardinality map[keyRef]int
+	// the keys of the group with at least one value in the window
+	groupKeys map[int32]map[int32]struct{}
+
+	seen   map[string]bool
+	shrunk map[string]bool
+}
+
+// NewSampler returns a sampler over the last size records, and over the records of
+// the last maxAge if it is not 0. A size of 0 leaves the number of records unbounded.
+func NewSampler(size int, maxAge time.Duration) *Sampler {
+	return &Sampler{
+		size:        size,
+		maxAge:      maxAge,
+		groups:      newInterner(),
+		keys:        newInterner(),
+		values:      newInterner(),
+		counts:      make(map[valueRef]int),
+		cardinality: make(map[keyRef]int),
+		groupKeys:   make(map[int32]map[int32]struct{}),
+		seen:        make(map[string]bool),
+		shrunk:      make(map[string]bool),
+	}
+}
+
+// Add adds a record of group at now to the window and drops the records that leave
+// it. It reports whether group was never added before.
+func (s *Sampler) Add(now time.Time, group string, fields []Field) bool {
+	if s.maxAge > 0 {
+		oldest := now.Add(-s.maxAge).UnixNano()
+		for s.n > 0 && s.ring[s.head].added < oldest {
+			s.evict()
+		}
+	}
+	for s.size > 0 && s.n >= s.size {
+		s.evict()
+	}
+
+	record := fingerprint{
+		group:  s.groups.acquire(group),
+		added:  now.UnixNano(),
+		fields: make([]fieldRef, len(fields)),
+	}
+	for i, field := range fields {
+		ref := valueRef{group: record.group, key: s.keys.acquire(field.Key), value: s.values.acquire(field.Value)}
+		record.fields[i] = fieldRef{key: ref.key, value: ref.value}
+		if s.counts[ref]++; s.counts[ref] == 1 {
+			k := keyRef{group: ref.group, key: ref.key}
+			if s.cardinality[k]++; s.cardinality[k] == 1 {
+				keys := s.groupKeys[ref.group]
+				if keys == nil {
+					keys = make(map[int32]struct{})
+					s.groupKeys[ref.group] = keys
+				}
+				keys[ref.key] = struct{}{}
+			}
+		}
+	}
+	s.push(record)
+
+	if s.seen[group] {
+		return false
+	}
+	s.seen[group] = true
+	return true
+}
+
+func (s *Sampler) push(record fingerprint) {
+	if s.n == len(s.ring) {
+		grown := make([]fingerprint, 2*len(s.ring)+1)
+		for i := 0; i < s.n; i++ {
+			grown[i] = s.ring[(s.head+i)%len(s.ring)]
+		}
+		s.ring = grown
+		s.head = 0
+	}
+	s.ring[(s.head+s.n)%len(s.ring)] = record
+	s.n++
+}
+
+// evict drops the oldest record from the window.
+func (s *Sampler) evict() {
+	record := s.ring[s.head]
+	s.ring[s.head] = fingerprint{}
+	s.head = (s.head + 1) % len(s.ring)
+	s.n--
+
+	for _, field := range record.fields {
+		ref := valueRef{group: record.group, key: field.key, value: field.value}
+		if s.counts[ref]--; s.counts[ref] == 0 {
+			delete(s.counts, ref)
+			k := keyRef{group: ref.group, key: ref.key}
+			if s.cardinality[k]--; s.cardinality[k] == 0 {
+				delete(s.cardinality, k)
+				delete(s.groupKeys[ref.group], ref.key)
+			}
+			s.shrunk[s.groups.names[record.group]] = true
+		}
+		s.keys.release(field.key)
+		s.values.release(field.value)
+	}
+	s.groups.release(record.group)
+}
+
+// Len returns the number of records in the window.
+func (s *Sampler) Len() int {
+	return s.n
+}
+
+// Count returns how often value of key is in the window for group.
+func (s *Sampler) Count(group string, key string, value string) int {
+	g, ok := s.groups.lookup(group)
+	if !ok {
+		return 0
+	}
+	k, ok := s.keys.lookup(key)
+	if !ok {
+		return 0
+	}
+	v, ok := s.values.lookup(value)
+	if !ok {
+		return 0
+	}
+	return s.counts[valueRef{group: g, key: k, value: v}]
+}
+
+// Cardinality returns how many distinct values key has in the window for group.
+func (s *Sampler) Cardinality(group string, key string) int {
+	g, ok := s.groups.lookup(group)
+	if !ok {
+		return 0
+	}
+	k, ok := s.keys.lookup(key)
+	if !ok {
+		return 0
+	}
+	return s.cardinality[keyRef{group: g, key: k}]
+}
+
+// Order returns the attributes of group with at most limit distinct values in
+// the window, the ones with fewer values first.
+func (s *Sampler) Order(group string, limit int) []string {
+	type keyCount struct {
+		key   string
+		count int
+	}
+	g, ok := s.groups.lookup(group)
+	if !ok {
+		return []string{}
+	}
+	keys := make([]keyCount, 0, len(s.groupKeys[g]))
+	for k := range s.groupKeys[g] {
+		if count := s.cardinality[keyRef{group: g, key: k}]; count <= limit {
+			keys = append(keys, keyCount{key: s.keys.names[k], count: count})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].count != keys[j].count {
+			return keys[i].count < keys[j].count
+		}
+		return keys[i].key < keys[j].key
+	})
+	order := make([]string, 0, len(keys))
+	for _, k := range keys {
+		order = append(order, k.key)
+	}
+	return order
+}
+
+// Groups returns the groups ever added to the sampler.
+func (s *Sampler) Groups() []string {
+	groups := make([]string, 0, len(s.seen))
+	for group := range s.seen {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Seen marks groups as added before, so that Add does not report them as new. A
+// compressor restored from a snapshot already has their tries.
+func (s *Sampler) Seen(groups ...string) {
+	for _, group := range groups {
+		s.seen[group] = true
+	}
+}
+
+// TakeShrunk returns the groups in which an attribute lost a distinct value since
+// the last call, and forgets them. Their Order may have gained attributes.
+func (s *Sampler) TakeShrunk() []string {
+	groups := make([]string, 0, len(s.shrunk))
+	for group := range s.shrunk {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	s.shrunk = make(map[string]bool)
+	return groups
+}
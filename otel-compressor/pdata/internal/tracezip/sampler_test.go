@@ -0,0 +1,159 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type bruteRecord struct {
+	added  time.Time
+	group  string
+	fields []Field
+}
+
+// bruteWindow recomputes the window of a Sampler from scratch.
+func bruteWindow(records []bruteRecord, size int, maxAge time.Duration) []bruteRecord {
+	last := records[len(records)-1]
+	var window []bruteRecord
+	for _, record := range records {
+		if maxAge > 0 && record.added.Before(last.added.Add(-maxAge)) {
+			continue
+		}
+		window = append(window, record)
+	}
+	if size > 0 && len(window) > size {
+		window = window[len(window)-size:]
+	}
+	return window
+}
+
+// bruteCounts returns the [group][key][value] counts of window.
+func bruteCounts(window []bruteRecord) map[string]map[string]map[string]int {
+	counts := make(map[string]map[string]map[string]int)
+	for _, record := range window {
+		if counts[record.group] == nil {
+			counts[record.group] = make(map[string]map[string]int)
+		}
+		for _, field := range record.fields {
+			if counts[record.group][field.Key] == nil {
+				counts[record.group][field.Key] = make(map[string]int)
+			}
+			counts[record.group][field.Key][field.Value]++
+		}
+	}
+	return counts
+}
+
+func bruteOrder(counts map[string]map[string]int, limit int) []string {
+	order := make([]string, 0)
+	for key, values := range counts {
+		if len(values) <= limit {
+			order = append(order, key)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if len(counts[order[i]]) != len(counts[order[j]]) {
+			return len(counts[order[i]]) < len(counts[order[j]])
+		}
+		return order[i] < order[j]
+	})
+	return order
+}
+
+func randomRecord(rnd *rand.Rand, added time.Time) bruteRecord {
+	record := bruteRecord{
+		added: added,
+		group: fmt.Sprintf("span-%d", rnd.Intn(4)),
+	}
+	for k := 0; k < 5; k++ {
+		if rnd.Intn(4) == 0 {
+			continue
+		}
+		// Keys with few and with many distinct values.
+		record.fields = append(record.fields, Field{
+			Key:   fmt.Sprintf("key-%d", k),
+			Value: fmt.Sprintf("value-%d", rnd.Intn(1+k*k*3)),
+		})
+	}
+	return record
+}
+
+func TestSamplerMatchesBruteForce(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		size   int
+		maxAge time.Duration
+	}{
+		{name: "size", size: 50},
+		{name: "age", maxAge: 30 * time.Millisecond},
+		{name: "size and age", size: 20, maxAge: 40 * time.Millisecond},
+		{name: "one", size: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			s := NewSampler(tt.size, tt.maxAge)
+			now := time.Unix(1700000000, 0)
+			var records []bruteRecord
+			seen := make(map[string]bool)
+			for i := 0; i < 2000; i++ {
+				now = now.Add(time.Duration(rnd.Intn(3)) * time.Millisecond)
+				record := randomRecord(rnd, now)
+				records = append(records, record)
+				assert.Equal(t, !seen[record.group], s.Add(now, record.group, record.fields))
+				seen[record.group] = true
+
+				window := bruteWindow(records, tt.size, tt.maxAge)
+				require.Equal(t, len(window), s.Len())
+				if i%10 != 0 {
+					continue
+				}
+				counts := bruteCounts(window)
+				for g := 0; g < 4; g++ {
+					group := fmt.Sprintf("span-%d", g)
+					for k := 0; k < 5; k++ {
+						key := fmt.Sprintf("key-%d", k)
+						require.Equal(t, len(counts[group][key]), s.Cardinality(group, key), "%s %s", group, key)
+						for v := 0; v <= k*k*3; v++ {
+							value := fmt.Sprintf("value-%d", v)
+							require.Equal(t, counts[group][key][value], s.Count(group, key, value), "%s %s %s", group, key, value)
+						}
+					}
+					require.Equal(t, bruteOrder(counts[group], 4), s.Order(group, 4), group)
+				}
+			}
+		})
+	}
+}
+
+func TestSamplerShrunk(t *testing.T) {
+	s := NewSampler(2, 0)
+	now := time.Unix(1700000000, 0)
+	assert.True(t, s.Add(now, "a", []Field{{Key: "k", Value: "1"}}))
+	assert.True(t, s.Add(now, "b", []Field{{Key: "k", Value: "1"}}))
+	assert.Empty(t, s.TakeShrunk())
+
+	// "a" loses its only value of k.
+	assert.False(t, s.Add(now, "b", []Field{{Key: "k", Value: "2"}}))
+	assert.Equal(t, []string{"a"}, s.TakeShrunk())
+	assert.Empty(t, s.TakeShrunk())
+	assert.Equal(t, []string{"a", "b"}, s.Groups())
+	assert.Empty(t, s.Order("a", 10))
+	assert.Equal(t, []string{"k"}, s.Order("b", 10))
+
+	// "b" loses value 1 of k.
+	assert.False(t, s.Add(now, "b", []Field{{Key: "k", Value: "2"}}))
+	assert.Equal(t, []string{"b"}, s.TakeShrunk())
+	// Only the count of value 2 drops, no value is lost.
+	assert.False(t, s.Add(now, "b", []Field{{Key: "k", Value: "2"}}))
+	assert.Empty(t, s.TakeShrunk())
+	assert.Equal(t, 2, s.Count("b", "k", "2"))
+}
@@ -0,0 +1,108 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DictState is what a compressor snapshot keeps of a Dict: its entries and the
+// next code. When and how often the entries were used is not kept.
+type DictState struct {
+	Values map[string]string `json:"v"`
+	Next   int               `json:"n"`
+}
+
+// State returns the state of the dictionary. It must not be modified.
+func (d *Dict) State() DictState {
+	return DictState{Values: d.values, Next: d.next}
+}
+
+// Restore replaces the entries of the dictionary with the ones of state, which
+// the receiver is expected to have already, so no update is recorded for them.
+func (d *Dict) Restore(state DictState) {
+	d.Clear()
+	for code, value := range state.Values {
+		d.codes[value] = code
+		d.values[code] = value
+		d.size += len(code) + len(value) + dictEntryOverhead
+		if d.budget != nil {
+			d.uses[code] = &dictUse{}
+		}
+	}
+	d.next = state.Next
+}
+
+// TrieState is what a compressor snapshot keeps of its tries: the paths of their
+// PathDict, and the path codes of every trie by its key.
+type TrieState struct {
+	Paths map[string][]string `json:"p"`
+	Tries map[string][]string `json:"t"`
+}
+
+// Codes returns the codes of the paths of t, sorted.
+func (t *Trie) Codes() []string {
+	codes := make([]string, 0)
+	var walk func(node *Trie)
+	walk = func(node *Trie) {
+		if node.code != "" {
+			codes = append(codes, node.code)
+		}
+		for _, child := range node.next {
+			walk(child)
+		}
+	}
+	walk(t)
+	sort.Strings(codes)
+	return codes
+}
+
+// insert adds path to t under code.
+func (t *Trie) insert(path []string, code string) {
+	node := t
+	for _, element := range path {
+		if node.next == nil {
+			node.next = make(map[string]*Trie)
+		}
+		child := node.next[element]
+		if child == nil {
+			child = &Trie{}
+			node.next[element] = child
+		}
+		node = child
+	}
+	node.code = code
+}
+
+// SaveTries returns the state of tries and of their PathDict.
+func SaveTries(tries map[string]*Trie, paths *PathDict) TrieState {
+	state := TrieState{Paths: paths.paths, Tries: make(map[string][]string, len(tries))}
+	for key, trie := range tries {
+		state.Tries[key] = trie.Codes()
+	}
+	return state
+}
+
+// RestoreTries rebuilds the tries of state and their PathDict, without updates.
+func RestoreTries(state TrieState) (map[string]*Trie, *PathDict, error) {
+	paths := NewPathDict()
+	for code, path := range state.Paths {
+		paths.paths[code] = path
+		paths.size += PathSize(code, path)
+	}
+	tries := make(map[string]*Trie, len(state.Tries))
+	for key, codes := range state.Tries {
+		trie := &Trie{}
+		for _, code := range codes {
+			path, ok := paths.paths[code]
+			if !ok {
+				return nil, nil, fmt.Errorf("trie %q refers to unknown path %q", key, code)
+			}
+			trie.insert(path, code)
+		}
+		tries[key] = trie
+	}
+	return tries, paths, nil
+}
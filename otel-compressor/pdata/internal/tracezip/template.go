@@ -0,0 +1,258 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// templateSimilarity is the share of words a string has to have in common with
+	// a template to join it.
+	templateSimilarity = 0.5
+	// templateGroupSize limits the templates of a group, strings that match none
+	// of a full group are sent as they are.
+	templateGroupSize = 64
+	// templateSeparators split strings into words.
+	templateSeparators = "/?&=:;,. \t\n()[]{}\"'<>@#|"
+)
+
+// ParamType is the kind of a template parameter.
+type ParamType uint8
+
+const (
+	ParamAny ParamType = iota
+	// ParamNumber is a decimal number.
+	ParamNumber
+	// ParamUUID is a UUID in its 8-4-4-4-12 form.
+	ParamUUID
+	// ParamHex is a hex string.
+	ParamHex
+	// ParamString is the text between the quotes of a SQL string literal.
+	ParamString
+)
+
+// Template is a string with parameters: Tokens are its literal parts and "" for
+// the parameters, Types are the kinds of the parameters, all ParamAny if empty.
+type Template struct {
+	Tokens []string    `json:"t"`
+	Types  []ParamType `json:"p,omitempty"`
+}
+
+// Miner is an online template miner in the style of Drain. It splits strings into
+// words and separators and groups them by attribute, separators and first word.
+// A string joins the most similar template of its group, and the words the
+// template and the string disagree on become parameters of the template.
+type Miner struct {
+	groups map[string][]*template
+	size   int
+}
+
+type template struct {
+	// tokens alternate between separators and words, "" is a parameter
+	tokens []string
+}
+
+// NewMiner returns an empty miner.
+func NewMiner() *Miner {
+	return &Miner{groups: make(map[string][]*template)}
+}
+
+// Match adds value of the attribute key to the miner and returns the template it
+// belongs to and its parameters. It returns false while the template was not
+// shared by another string yet, such strings are cheaper sent as they are.
+func (m *Miner) Match(key string, value string) (Template, []string, bool) {
+	tokens, words := tokenize(value)
+	if words == 0 {
+		return Template{}, nil, false
+	}
+	group := groupOf(key, tokens)
+	var best *template
+	bestScore := 0.0
+	for _, t := range m.groups[group] {
+		if score := t.similarity(tokens, words); score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+	if best == nil || bestScore < templateSimilarity {
+		if len(m.groups[group]) >= templateGroupSize {
+			return Template{}, nil, false
+		}
+		if len(m.groups[group]) == 0 {
+			m.size += len(group) + dictEntryOverhead
+		}
+		m.groups[group] = append(m.groups[group], &template{tokens: tokens})
+		for _, token := range tokens {
+			m.size += len(token) + 16
+		}
+		return Template{}, nil, false
+	}
+	params := make([]string, 0)
+	for i, token := range tokens {
+		if best.tokens[i] != "" && best.tokens[i] != token {
+			best.tokens[i] = ""
+		}
+		if best.tokens[i] == "" {
+			params = append(params, token)
+		}
+	}
+	return Template{Tokens: append([]string(nil), best.tokens...)}, params, true
+}
+
+// Size estimates the bytes the templates of the miner take.
+func (m *Miner) Size() int {
+	return m.size
+}
+
+// similarity is the share of the words of tokens that t has as they are or as
+// parameters.
+func (t *template) similarity(tokens []string, words int) float64 {
+	same := 0
+	for i, token := range tokens {
+		if isWord(token) && (t.tokens[i] == token || t.tokens[i] == "") {
+			same++
+		}
+	}
+	return float64(same) / float64(words)
+}
+
+// groupOf puts strings with the same separators and first word into a group, so
+// that their tokens line up.
+func groupOf(key string, tokens []string) string {
+	var b strings.Builder
+	b.WriteString(key)
+	first := true
+	for _, token := range tokens {
+		b.WriteByte(0)
+		if !isWord(token) {
+			b.WriteString(token)
+			continue
+		}
+		if first && !isVariable(token) {
+			b.WriteString(token)
+		}
+		first = false
+		b.WriteByte(1)
+	}
+	return b.String()
+}
+
+// tokenize splits s into runs of separators and runs of other characters, and
+// counts the latter.
+func tokenize(s string) ([]string, int) {
+	var tokens []string
+	words := 0
+	start := 0
+	for i := 1; i <= len(s); i++ {
+		if i < len(s) && isSeparator(s[i]) == isSeparator(s[start]) {
+			continue
+		}
+		if !isSeparator(s[start]) {
+			words++
+		}
+		tokens = append(tokens, s[start:i])
+		start = i
+	}
+	return tokens, words
+}
+
+func isSeparator(c byte) bool {
+	return strings.IndexByte(templateSeparators, c) >= 0
+}
+
+func isWord(token string) bool {
+	return token != "" && !isSeparator(token[0])
+}
+
+// isVariable reports whether a word looks like an id: a number, or a hex string or
+// uuid of at least 8 characters with a digit. Such a first word does not group.
+func isVariable(word string) bool {
+	if _, err := strconv.ParseUint(word, 10, 64); err == nil {
+		return true
+	}
+	if len(word) < 8 {
+		return false
+	}
+	digit := false
+	for i := 0; i < len(word); i++ {
+		switch c := word[i]; {
+		case c >= '0' && c <= '9':
+			digit = true
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F', c == '-':
+		default:
+			return false
+		}
+	}
+	return digit
+}
+
+// MarshalTemplate returns the form of a template in the template dictionary.
+func MarshalTemplate(t Template) string {
+	value, _ := json.Marshal(t)
+	return string(value)
+}
+
+// FillTemplate is the inverse of splitting a string into the template returned by
+// MarshalTemplate and its parameters.
+func FillTemplate(value string, params []string) (string, error) {
+	var t Template
+	if err := json.Unmarshal([]byte(value), &t); err != nil {
+		return "", fmt.Errorf("template: %w", err)
+	}
+	var b strings.Builder
+	next := 0
+	for _, token := range t.Tokens {
+		if token == "" {
+			if next == len(params) {
+				return "", fmt.Errorf("template has more than %d parameters", len(params))
+			}
+			if next < len(t.Types) && !t.Types[next].valid(params[next]) {
+				return "", fmt.Errorf("parameter %q is not of type %d", params[next], t.Types[next])
+			}
+			token = params[next]
+			next++
+		}
+		b.WriteString(token)
+	}
+	if next != len(params) {
+		return "", fmt.Errorf("template has %d parameters, not %d", next, len(params))
+	}
+	return b.String(), nil
+}
+
+// templateBuilder builds a template and its parameters from left to right.
+type templateBuilder struct {
+	t       Template
+	params  []string
+	literal strings.Builder
+}
+
+func (b *templateBuilder) lit(s string) {
+	b.literal.WriteString(s)
+}
+
+func (b *templateBuilder) param(typ ParamType, s string) {
+	if b.literal.Len() > 0 {
+		b.t.Tokens = append(b.t.Tokens, b.literal.String())
+		b.literal.Reset()
+	}
+	b.t.Tokens = append(b.t.Tokens, "")
+	b.t.Types = append(b.t.Types, typ)
+	b.params = append(b.params, s)
+}
+
+// done returns the template, or false if it has no parameters.
+func (b *templateBuilder) done() (Template, []string, bool) {
+	if len(b.params) == 0 {
+		return Template{}, nil, false
+	}
+	if b.literal.Len() > 0 {
+		b.t.Tokens = append(b.t.Tokens, b.literal.String())
+		b.literal.Reset()
+	}
+	return b.t, b.params, true
+}
@@ -0,0 +1,49 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinerMatch(t *testing.T) {
+	m := NewMiner()
+	_, _, ok := m.Match("db.statement", "SELECT * FROM orders WHERE id = 17")
+	assert.False(t, ok)
+
+	tmpl, params, ok := m.Match("db.statement", "SELECT * FROM orders WHERE id = 42")
+	require.True(t, ok)
+	assert.Equal(t, []string{"42"}, params)
+	value, err := FillTemplate(MarshalTemplate(tmpl), params)
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM orders WHERE id = 42", value)
+
+	// Another attribute or other separators do not share the template.
+	_, _, ok = m.Match("http.url", "SELECT * FROM orders WHERE id = 43")
+	assert.False(t, ok)
+	_, _, ok = m.Match("db.statement", "SELECT * FROM orders WHERE id IN (43)")
+	assert.False(t, ok)
+
+	// Words that differ later become parameters too.
+	tmpl, params, ok = m.Match("db.statement", "SELECT * FROM users WHERE id = 43")
+	require.True(t, ok)
+	assert.Equal(t, []string{"users", "43"}, params)
+	value, err = FillTemplate(MarshalTemplate(tmpl), params)
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM users WHERE id = 43", value)
+
+	_, err = FillTemplate(MarshalTemplate(tmpl), params[:1])
+	assert.Error(t, err)
+}
+
+func TestMinerSeparatorsOnly(t *testing.T) {
+	m := NewMiner()
+	for i := 0; i < 2; i++ {
+		_, _, ok := m.Match("http.target", "/")
+		assert.False(t, ok)
+	}
+}
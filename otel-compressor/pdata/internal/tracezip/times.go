@@ -0,0 +1,60 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+// OffsetTime returns t relative to offset, for timestamps that are optional: an
+// unset timestamp is 0, a set one is its distance from offset plus one.
+// offset must not be larger than any set timestamp.
+func OffsetTime(t uint64, offset uint64) uint64 {
+	if t == 0 {
+		return 0
+	}
+	return t - offset + 1
+}
+
+// RestoreTime is the inverse of OffsetTime.
+func RestoreTime(v uint64, offset uint64) uint64 {
+	if v == 0 {
+		return 0
+	}
+	return v + offset - 1
+}
+
+// MinTime tracks the smallest set timestamp of a batch.
+type MinTime uint64
+
+// Add takes t into account if it is set.
+func (m *MinTime) Add(t uint64) {
+	if t != 0 && (*m == 0 || t < uint64(*m)) {
+		*m = MinTime(t)
+	}
+}
+
+// DeltaOfDelta encodes a series of timestamps as the change of the distance
+// between neighbours, which is 0 for evenly spaced ones. The arithmetic wraps,
+// so any series, unset timestamps included, is restored exactly.
+type DeltaOfDelta struct {
+	prev  uint64
+	delta int64
+}
+
+// NewDeltaOfDelta returns a series whose first timestamp is relative to start.
+func NewDeltaOfDelta(start uint64) DeltaOfDelta {
+	return DeltaOfDelta{prev: start}
+}
+
+// Encode returns the delta of delta of t and moves the series to t.
+func (d *DeltaOfDelta) Encode(t uint64) int64 {
+	delta := int64(t - d.prev)
+	dod := delta - d.delta
+	d.prev, d.delta = t, delta
+	return dod
+}
+
+// Decode is the inverse of Encode.
+func (d *DeltaOfDelta) Decode(dod int64) uint64 {
+	d.delta += dod
+	d.prev += uint64(d.delta)
+	return d.prev
+}
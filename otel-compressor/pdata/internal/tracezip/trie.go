@@ -0,0 +1,94 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+import (
+	"encoding/json"
+)
+
+// Missing is the path element of an attribute that a record does not have.
+const Missing = "#"
+
+// Trie maps paths of attribute value codes to path codes, like the span
+// retrieve trie of the traces compressor. All tries of a compressor share one
+// PathDict, so that path codes are unique across tries.
+type Trie struct {
+	next map[string]*Trie
+	code string
+}
+
+// trieNodeOverhead estimates the bytes of a trie node besides its path element.
+const trieNodeOverhead = 64
+
+// PathSize estimates the bytes a path takes in a trie and in its dictionary.
+func PathSize(code string, path []string) int {
+	size := len(code) + dictEntryOverhead
+	for _, element := range path {
+		size += 2*len(element) + trieNodeOverhead
+	}
+	return size
+}
+
+// PathDict numbers the paths of a set of tries.
+type PathDict struct {
+	paths   map[string][]string
+	updates []UpdatesEntry
+	size    int
+}
+
+// NewPathDict returns an empty path dictionary.
+func NewPathDict() *PathDict {
+	return &PathDict{
+		paths:   make(map[string][]string),
+		updates: make([]UpdatesEntry, 0),
+	}
+}
+
+// Retrieve returns the code of path in t, adding the path to t and to paths if it is new.
+func (t *Trie) Retrieve(paths *PathDict, path []string) (string, bool) {
+	node := t
+	for _, element := range path {
+		if node.next == nil {
+			node.next = make(map[string]*Trie)
+		}
+		child := node.next[element]
+		if child == nil {
+			child = &Trie{}
+			node.next[element] = child
+		}
+		node = child
+	}
+	if node.code != "" {
+		return node.code, false
+	}
+	node.code = Number2String(len(paths.paths))
+	paths.paths[node.code] = path
+	paths.size += PathSize(node.code, path)
+	data, _ := json.Marshal(path)
+	paths.updates = append(paths.updates, UpdatesEntry{Key: node.code, Value: string(data)})
+	return node.code, true
+}
+
+// Len returns the number of paths.
+func (p *PathDict) Len() int {
+	return len(p.paths)
+}
+
+// Size estimates the bytes the paths and their tries take.
+func (p *PathDict) Size() int {
+	return p.size
+}
+
+// Values returns the dictionary from path code to path, the form of the dictionary
+// in a full update. It must not be modified.
+func (p *PathDict) Values() map[string][]string {
+	return p.paths
+}
+
+// TakeUpdates returns the paths added since the last call and forgets them.
+func (p *PathDict) TakeUpdates() []UpdatesEntry {
+	updates := p.updates
+	p.updates = make([]UpdatesEntry, 0)
+	return updates
+}
@@ -0,0 +1,432 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip // import "go.opentelemetry.io/collector/pdata/internal/tracezip"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	otlpcommon "go.opentelemetry.io/collector/pdata/internal/data/protogen/common/v1"
+	v1_resource "go.opentelemetry.io/collector/pdata/internal/data/protogen/resource/v1"
+	tele_json "go.opentelemetry.io/collector/pdata/internal/json"
+)
+
+// Attribute is an attribute with its value in OTLP/JSON form.
+type Attribute struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// CodedAttribute is an attribute that is not part of a trie, Key is its code in
+// the attribute name dictionary.
+type CodedAttribute struct {
+	Key   string `json:"k"`
+	Value Value  `json:"v"`
+}
+
+// ValueType is the type of a Value, numbered like pcommon.ValueType.
+type ValueType uint8
+
+const (
+	ValueEmpty ValueType = iota
+	ValueStr
+	ValueInt
+	ValueDouble
+	ValueBool
+	ValueMap
+	ValueSlice
+	ValueBytes
+)
+
+// Value is the typed form of an attribute value outside the tries, so that the
+// receiver restores the exact pcommon.Value. Scalars are sent as they are, the
+// elements of a slice are codes of the attribute value dictionary and the
+// entries of a map are coded attributes themselves. A string may instead be the
+// code of its template in the template dictionary and the template parameters,
+// or the codes of its lines in the line dictionary. Ref, if set, is the code of
+// the whole value in the attribute value dictionary.
+type Value struct {
+	Ref      string           `json:"r,omitempty"`
+	Type     ValueType        `json:"t,omitempty"`
+	Str      string           `json:"s,omitempty"`
+	Template string           `json:"p,omitempty"`
+	Params   []string         `json:"q,omitempty"`
+	Lines    []string         `json:"l,omitempty"`
+	Int      int64            `json:"i,omitempty"`
+	Double   Double           `json:"d,omitempty"`
+	Bool     bool             `json:"b,omitempty"`
+	Bytes    []byte           `json:"y,omitempty"`
+	Slice    []string         `json:"a,omitempty"`
+	Map      []CodedAttribute `json:"m,omitempty"`
+}
+
+// MaxValueDepth is how deep Values nest, a Value that is not in a map being at
+// depth 1. EncodeValue sends a map that would nest deeper as the code of the
+// whole value, and the decoders refuse Values that nest deeper.
+const MaxValueDepth = 64
+
+// ValueCoder returns the dictionary codes EncodeValue needs.
+type ValueCoder interface {
+	// NameCode returns the code of an attribute name.
+	NameCode(key string) string
+	// ValueCode returns the code of an attribute value.
+	ValueCode(v *otlpcommon.AnyValue) string
+}
+
+// TemplateCoder is implemented by the ValueCoders that mine templates of string
+// attribute values.
+type TemplateCoder interface {
+	// TemplateCode returns the code of the template of the value of the attribute
+	// key and its parameters, or false to send the value as it is.
+	TemplateCode(key string, value string) (string, []string, bool)
+}
+
+// DictCoder is a ValueCoder on an attribute name and an attribute value Dict.
+type DictCoder struct {
+	Names  *Dict
+	Values *Dict
+}
+
+func (d DictCoder) NameCode(key string) string {
+	code, _ := d.Names.Code(key)
+	return code
+}
+
+func (d DictCoder) ValueCode(v *otlpcommon.AnyValue) string {
+	code, _ := d.Values.Code(string(MarshalAnyValue(v)))
+	return code
+}
+
+// EncodeValue returns the typed form of v.
+func EncodeValue(v *otlpcommon.AnyValue, coder ValueCoder) Value {
+	return encodeValue(v, coder, 1)
+}
+
+func encodeValue(v *otlpcommon.AnyValue, coder ValueCoder, depth int) Value {
+	if _, ok := v.Value.(*otlpcommon.AnyValue_KvlistValue); ok && depth >= MaxValueDepth {
+		return Value{Ref: coder.ValueCode(v)}
+	}
+	switch v := v.Value.(type) {
+	case *otlpcommon.AnyValue_StringValue:
+		return Value{Type: ValueStr, Str: v.StringValue}
+	case *otlpcommon.AnyValue_IntValue:
+		return Value{Type: ValueInt, Int: v.IntValue}
+	case *otlpcommon.AnyValue_DoubleValue:
+		return Value{Type: ValueDouble, Double: Double(v.DoubleValue)}
+	case *otlpcommon.AnyValue_BoolValue:
+		return Value{Type: ValueBool, Bool: v.BoolValue}
+	case *otlpcommon.AnyValue_BytesValue:
+		return Value{Type: ValueBytes, Bytes: v.BytesValue}
+	case *otlpcommon.AnyValue_ArrayValue:
+		value := Value{Type: ValueSlice, Slice: make([]string, 0)}
+		if v.ArrayValue != nil {
+			for i := range v.ArrayValue.Values {
+				value.Slice = append(value.Slice, coder.ValueCode(&v.ArrayValue.Values[i]))
+			}
+		}
+		return value
+	case *otlpcommon.AnyValue_KvlistValue:
+		value := Value{Type: ValueMap, Map: make([]CodedAttribute, 0)}
+		if v.KvlistValue != nil {
+			value.Map = encodeAttributes(v.KvlistValue.Values, coder, depth+1)
+		}
+		return value
+	}
+	return Value{}
+}
+
+// EncodeAttribute returns the coded form of attr.
+func EncodeAttribute(attr *otlpcommon.KeyValue, coder ValueCoder) CodedAttribute {
+	return encodeAttribute(attr, coder, 1)
+}
+
+func encodeAttribute(attr *otlpcommon.KeyValue, coder ValueCoder, depth int) CodedAttribute {
+	coded := CodedAttribute{
+		Key:   coder.NameCode(attr.Key),
+		Value: encodeValue(&attr.Value, coder, depth),
+	}
+	if templates, ok := coder.(TemplateCoder); ok && coded.Value.Type == ValueStr {
+		if code, params, ok := templates.TemplateCode(attr.Key, coded.Value.Str); ok {
+			coded.Value.Str = ""
+			coded.Value.Template = code
+			coded.Value.Params = params
+		}
+	}
+	return coded
+}
+
+// EncodeAttributes returns the coded form of attrs.
+func EncodeAttributes(attrs []otlpcommon.KeyValue, coder ValueCoder) []CodedAttribute {
+	return encodeAttributes(attrs, coder, 1)
+}
+
+func encodeAttributes(attrs []otlpcommon.KeyValue, coder ValueCoder, depth int) []CodedAttribute {
+	ret := make([]CodedAttribute, 0, len(attrs))
+	for i := range attrs {
+		ret = append(ret, encodeAttribute(&attrs[i], coder, depth))
+	}
+	return ret
+}
+
+// ValueDicts are the receiver side dictionaries the codes of a Value refer to.
+type ValueDicts struct {
+	Names     map[string]string
+	Values    map[string]string
+	Templates map[string]string
+	Lines     map[string]string
+}
+
+// DecodeValue is the inverse of EncodeValue.
+func DecodeValue(value *Value, dicts ValueDicts, dest *otlpcommon.AnyValue) error {
+	return decodeValue(value, dicts, dest, 1)
+}
+
+func decodeValue(value *Value, dicts ValueDicts, dest *otlpcommon.AnyValue, depth int) error {
+	if depth > MaxValueDepth {
+		return fmt.Errorf("value nested deeper than %d", MaxValueDepth)
+	}
+	if value.Ref != "" {
+		ref, ok := dicts.Values[value.Ref]
+		if !ok {
+			return fmt.Errorf("no such attribute value %q", value.Ref)
+		}
+		return UnmarshalAnyValue([]byte(ref), dest)
+	}
+	switch value.Type {
+	case ValueEmpty:
+		dest.Value = nil
+	case ValueStr:
+		str := value.Str
+		if len(value.Lines) > 0 {
+			lines := make([]string, len(value.Lines))
+			for i, code := range value.Lines {
+				line, ok := dicts.Lines[code]
+				if !ok {
+					return fmt.Errorf("no such line %q", code)
+				}
+				lines[i] = line
+			}
+			str = strings.Join(lines, "\n")
+		} else if value.Template != "" {
+			pattern, ok := dicts.Templates[value.Template]
+			if !ok {
+				return fmt.Errorf("no such template %q", value.Template)
+			}
+			var err error
+			if str, err = FillTemplate(pattern, value.Params); err != nil {
+				return fmt.Errorf("template %q: %w", value.Template, err)
+			}
+		}
+		dest.Value = &otlpcommon.AnyValue_StringValue{StringValue: str}
+	case ValueInt:
+		dest.Value = &otlpcommon.AnyValue_IntValue{IntValue: value.Int}
+	case ValueDouble:
+		dest.Value = &otlpcommon.AnyValue_DoubleValue{DoubleValue: float64(value.Double)}
+	case ValueBool:
+		dest.Value = &otlpcommon.AnyValue_BoolValue{BoolValue: value.Bool}
+	case ValueBytes:
+		dest.Value = &otlpcommon.AnyValue_BytesValue{BytesValue: value.Bytes}
+	case ValueSlice:
+		array := &otlpcommon.ArrayValue{}
+		if len(value.Slice) > 0 {
+			array.Values = make([]otlpcommon.AnyValue, len(value.Slice))
+		}
+		for i, code := range value.Slice {
+			element, ok := dicts.Values[code]
+			if !ok {
+				return fmt.Errorf("no such attribute value %q", code)
+			}
+			if err := UnmarshalAnyValue([]byte(element), &array.Values[i]); err != nil {
+				return err
+			}
+		}
+		dest.Value = &otlpcommon.AnyValue_ArrayValue{ArrayValue: array}
+	case ValueMap:
+		attrs, err := decodeCodedAttributes(value.Map, dicts, depth+1)
+		if err != nil {
+			return err
+		}
+		dest.Value = &otlpcommon.AnyValue_KvlistValue{KvlistValue: &otlpcommon.KeyValueList{Values: attrs}}
+	default:
+		return fmt.Errorf("unknown value type %d", value.Type)
+	}
+	return nil
+}
+
+// DecodeCodedAttributes is the inverse of EncodeAttributes.
+func DecodeCodedAttributes(attrs []CodedAttribute, dicts ValueDicts) ([]otlpcommon.KeyValue, error) {
+	return decodeCodedAttributes(attrs, dicts, 1)
+}
+
+func decodeCodedAttributes(attrs []CodedAttribute, dicts ValueDicts, depth int) ([]otlpcommon.KeyValue, error) {
+	var ret []otlpcommon.KeyValue
+	for i := range attrs {
+		key, ok := dicts.Names[attrs[i].Key]
+		if !ok {
+			return nil, fmt.Errorf("no such attribute name %q", attrs[i].Key)
+		}
+		kv := otlpcommon.KeyValue{Key: key}
+		if err := decodeValue(&attrs[i].Value, dicts, &kv.Value, depth); err != nil {
+			return nil, fmt.Errorf("attribute %q: %w", key, err)
+		}
+		ret = append(ret, kv)
+	}
+	return ret, nil
+}
+
+type Resource struct {
+	// Set of attributes that describe the resource.
+	// Attribute keys MUST be unique (it is not allowed to have more than one
+	// attribute with the same key).
+	Attributes []Attribute `json:"attributes"`
+	// dropped_attributes_count is the number of dropped attributes. If the value is 0, then
+	// no attributes were dropped.
+	DroppedAttributesCount uint32 `json:"dropped_attributes_count,omitempty"`
+}
+
+type Scope struct {
+	Name                   string      `json:"name,omitempty"`
+	Version                string      `json:"version,omitempty"`
+	Attributes             []Attribute `json:"attributes,omitempty"`
+	DroppedAttributesCount uint32      `json:"dropped_attributes_count,omitempty"`
+}
+
+// MarshalAnyValue returns the OTLP/JSON form of v, which is how attribute values
+// are written to dictionaries and payloads.
+func MarshalAnyValue(v *otlpcommon.AnyValue) json.RawMessage {
+	var buf bytes.Buffer
+	if err := tele_json.Marshal(&buf, v); err != nil {
+		return json.RawMessage(`{}`)
+	}
+	return buf.Bytes()
+}
+
+// UnmarshalAnyValue is the inverse of MarshalAnyValue.
+func UnmarshalAnyValue(data []byte, dest *otlpcommon.AnyValue) error {
+	iter := jsoniter.ConfigFastest.BorrowIterator(data)
+	defer jsoniter.ConfigFastest.ReturnIterator(iter)
+	tele_json.ReadValue(iter, dest)
+	return iter.Error
+}
+
+// MarshalAttributes returns the OTLP/JSON form of attrs, or nil if there are none.
+func MarshalAttributes(attrs []otlpcommon.KeyValue) []Attribute {
+	if len(attrs) == 0 {
+		return nil
+	}
+	ret := make([]Attribute, 0, len(attrs))
+	for i := range attrs {
+		ret = append(ret, Attribute{
+			Key:   attrs[i].Key,
+			Value: MarshalAnyValue(&attrs[i].Value),
+		})
+	}
+	return ret
+}
+
+// DecodeAttributes is the inverse of MarshalAttributes.
+func DecodeAttributes(attrs []Attribute) ([]otlpcommon.KeyValue, error) {
+	var ret []otlpcommon.KeyValue
+	for _, attr := range attrs {
+		kv := otlpcommon.KeyValue{Key: attr.Key}
+		if err := UnmarshalAnyValue(attr.Value, &kv.Value); err != nil {
+			return nil, fmt.Errorf("attribute %q: %w", attr.Key, err)
+		}
+		ret = append(ret, kv)
+	}
+	return ret, nil
+}
+
+// MarshalResource returns the form of resource in the resource dictionary, without
+// its attributes if deleteAttributes is set.
+func MarshalResource(resource *v1_resource.Resource, deleteAttributes bool) string {
+	resource_ := Resource{
+		Attributes:             make([]Attribute, 0),
+		DroppedAttributesCount: resource.DroppedAttributesCount,
+	}
+	if !deleteAttributes {
+		resource_.Attributes = append(resource_.Attributes, MarshalAttributes(resource.Attributes)...)
+	}
+	value, _ := json.Marshal(resource_)
+	return string(value)
+}
+
+// DecodeResource is the inverse of MarshalResource.
+func DecodeResource(value string, resource *v1_resource.Resource) error {
+	var resource_ Resource
+	if err := json.Unmarshal([]byte(value), &resource_); err != nil {
+		return fmt.Errorf("resource: %w", err)
+	}
+	attrs, err := DecodeAttributes(resource_.Attributes)
+	if err != nil {
+		return fmt.Errorf("resource: %w", err)
+	}
+	resource.Attributes = attrs
+	resource.DroppedAttributesCount = resource_.DroppedAttributesCount
+	return nil
+}
+
+// MarshalScope returns the payload form of an instrumentation scope.
+func MarshalScope(scope *otlpcommon.InstrumentationScope) Scope {
+	return Scope{
+		Name:                   scope.Name,
+		Version:                scope.Version,
+		Attributes:             MarshalAttributes(scope.Attributes),
+		DroppedAttributesCount: scope.DroppedAttributesCount,
+	}
+}
+
+// DecodeScope is the inverse of MarshalScope.
+func DecodeScope(scope_ *Scope, scope *otlpcommon.InstrumentationScope) error {
+	scope.Name = scope_.Name
+	scope.Version = scope_.Version
+	scope.DroppedAttributesCount = scope_.DroppedAttributesCount
+	attrs, err := DecodeAttributes(scope_.Attributes)
+	if err != nil {
+		return fmt.Errorf("scope: %w", err)
+	}
+	scope.Attributes = attrs
+	return nil
+}
+
+// Double is a float64 that survives JSON: NaN and the infinities, which
+// encoding/json rejects, are written as strings the way OTLP/JSON does.
+type Double float64
+
+func (d Double) MarshalJSON() ([]byte, error) {
+	switch f := float64(d); {
+	case math.IsNaN(f):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(f, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Infinity"`), nil
+	default:
+		return strconv.AppendFloat(nil, f, 'g', -1, 64), nil
+	}
+}
+
+func (d *Double) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case `"NaN"`:
+		*d = Double(math.NaN())
+	case `"Infinity"`:
+		*d = Double(math.Inf(1))
+	case `"-Infinity"`:
+		*d = Double(math.Inf(-1))
+	default:
+		f, err := strconv.ParseFloat(string(data), 64)
+		if err != nil {
+			return fmt.Errorf("double: %w", err)
+		}
+		*d = Double(f)
+	}
+	return nil
+}
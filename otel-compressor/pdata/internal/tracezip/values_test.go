@@ -0,0 +1,121 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezip
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	otlpcommon "go.opentelemetry.io/collector/pdata/internal/data/protogen/common/v1"
+)
+
+func TestEncodeValueRoundTrip(t *testing.T) {
+	attrs := []otlpcommon.KeyValue{
+		{Key: "str", Value: otlpcommon.AnyValue{Value: &otlpcommon.AnyValue_StringValue{StringValue: "1"}}},
+		{Key: "int", Value: otlpcommon.AnyValue{Value: &otlpcommon.AnyValue_IntValue{IntValue: -1}}},
+		{Key: "double", Value: otlpcommon.AnyValue{Value: &otlpcommon.AnyValue_DoubleValue{DoubleValue: math.NaN()}}},
+		{Key: "bool", Value: otlpcommon.AnyValue{Value: &otlpcommon.AnyValue_BoolValue{BoolValue: true}}},
+		{Key: "bytes", Value: otlpcommon.AnyValue{Value: &otlpcommon.AnyValue_BytesValue{BytesValue: []byte{0, 0xff}}}},
+		{Key: "empty"},
+		{Key: "slice", Value: otlpcommon.AnyValue{Value: &otlpcommon.AnyValue_ArrayValue{ArrayValue: &otlpcommon.ArrayValue{
+			Values: []otlpcommon.AnyValue{
+				{Value: &otlpcommon.AnyValue_StringValue{StringValue: "1"}},
+				{Value: &otlpcommon.AnyValue_IntValue{IntValue: 1}},
+				{Value: &otlpcommon.AnyValue_StringValue{StringValue: "1"}},
+			},
+		}}}},
+		{Key: "map", Value: otlpcommon.AnyValue{Value: &otlpcommon.AnyValue_KvlistValue{KvlistValue: &otlpcommon.KeyValueList{
+			Values: []otlpcommon.KeyValue{
+				{Key: "int", Value: otlpcommon.AnyValue{Value: &otlpcommon.AnyValue_IntValue{IntValue: 7}}},
+			},
+		}}}},
+	}
+	coder := DictCoder{Names: NewDict(), Values: NewDict()}
+	coded := EncodeAttributes(attrs, coder)
+	// Slice elements are dictionary codes, equal elements share one, and the
+	// int and the string "1" do not.
+	slice := coded[6].Value.Slice
+	assert.Equal(t, slice[0], slice[2])
+	assert.NotEqual(t, slice[0], slice[1])
+	assert.Equal(t, 2, coder.Values.Len())
+	// The map key is the code of "int", like the attribute.
+	assert.Equal(t, coded[1].Key, coded[7].Value.Map[0].Key)
+
+	data, err := json.Marshal(coded)
+	require.NoError(t, err)
+	var decoded []CodedAttribute
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	dicts := ValueDicts{Names: coder.Names.Values(), Values: coder.Values.Values()}
+	actual, err := DecodeCodedAttributes(decoded, dicts)
+	require.NoError(t, err)
+	require.Len(t, actual, len(attrs))
+	assert.True(t, math.IsNaN(actual[2].Value.GetDoubleValue()))
+	actual[2].Value.Value = attrs[2].Value.Value
+	assert.Equal(t, attrs, actual)
+
+	_, err = DecodeCodedAttributes(decoded, ValueDicts{Names: dicts.Names})
+	assert.Error(t, err)
+}
+
+func TestEncodeValueDepth(t *testing.T) {
+	// a map of maps 100 deep, with an int at the bottom
+	value := otlpcommon.AnyValue{Value: &otlpcommon.AnyValue_IntValue{IntValue: 1}}
+	for i := 0; i < 100; i++ {
+		value = otlpcommon.AnyValue{Value: &otlpcommon.AnyValue_KvlistValue{KvlistValue: &otlpcommon.KeyValueList{
+			Values: []otlpcommon.KeyValue{{Key: "nested", Value: value}},
+		}}}
+	}
+	coder := DictCoder{Names: NewDict(), Values: NewDict()}
+	coded := EncodeValue(&value, coder)
+
+	// The map at MaxValueDepth is sent as the code of the whole value.
+	depth := 1
+	for inner := &coded; inner.Ref == ""; inner = &inner.Map[0].Value {
+		require.Equal(t, ValueMap, inner.Type)
+		depth++
+	}
+	assert.Equal(t, MaxValueDepth, depth)
+	assert.Equal(t, 1, coder.Values.Len())
+
+	dicts := ValueDicts{Names: coder.Names.Values(), Values: coder.Values.Values()}
+	var actual otlpcommon.AnyValue
+	require.NoError(t, DecodeValue(&coded, dicts, &actual))
+	assert.Equal(t, value, actual)
+}
+
+func TestDecodeValueDepth(t *testing.T) {
+	dicts := ValueDicts{Names: map[string]string{"0": "nested"}}
+	for _, tt := range []struct {
+		name  string
+		depth int
+		err   bool
+	}{
+		{name: "max depth", depth: MaxValueDepth},
+		{name: "too deep", depth: MaxValueDepth + 1, err: true},
+		{name: "far too deep", depth: 1000, err: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// an int in maps, tt.depth Values deep
+			payload := strings.Repeat(`{"t":5,"m":[{"k":"0","v":`, tt.depth-1) + `{"t":2,"i":1}` + strings.Repeat(`}]}`, tt.depth-1)
+			var value Value
+			require.NoError(t, json.Unmarshal([]byte(payload), &value))
+
+			var actual otlpcommon.AnyValue
+			err := DecodeValue(&value, dicts, &actual)
+			_, attrsErr := DecodeCodedAttributes([]CodedAttribute{{Key: "0", Value: value}}, dicts)
+			if tt.err {
+				assert.ErrorContains(t, err, "nested deeper")
+				assert.ErrorContains(t, attrsErr, "nested deeper")
+			} else {
+				assert.NoError(t, err)
+				assert.NoError(t, attrsErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,467 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plogotlp // import "go.opentelemetry.io/collector/pdata/plog/plogotlp"
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"go.opentelemetry.io/collector/pdata/internal/data"
+	otlpcommon "go.opentelemetry.io/collector/pdata/internal/data/protogen/common/v1"
+	v1_logs "go.opentelemetry.io/collector/pdata/internal/data/protogen/logs/v1"
+	"go.opentelemetry.io/collector/pdata/internal/tracezip"
+)
+
+type ScopeLog struct {
+	SchemaUrl string         `json:"schemaUrl,omitempty"`
+	Scope     tracezip.Scope `json:"scope,omitempty"`
+	// OffsetMain is the smallest timestamp of the batch, see LogData.
+	OffsetMain uint64    `json:"to"`
+	Logs       []LogData `json:"logs,omitempty"`
+}
+
+// ExportData is a compressed resource, ResourceId is its code in the resource dictionary.
+type ExportData struct {
+	SchemaUrl  string     `json:"schemaUrl,omitempty"`
+	ResourceId string     `json:"r"`
+	ScopeLogs  []ScopeLog `json:"scopeLogs,omitempty"`
+}
+
+// LogData is a compressed log record. Group is the code of its scope and severity,
+// its attributes that are part of the group's trie are replaced by PathHash, and
+// the remaining ones keep only a shortened key. The timestamps are relative to
+// OffsetMain, and 0 if they are not set.
+type LogData struct {
+	Group                  string                    `json:"g"`
+	PathHash               string                    `json:"_,omitempty"`
+	Time                   uint64                    `json:"0,omitempty"`
+	ObservedTime           uint64                    `json:"1,omitempty"`
+	Attributes             []tracezip.CodedAttribute `json:"2,omitempty"`
+	Body                   string                    `json:"3,omitempty"`
+	BodyValue              json.RawMessage           `json:"4,omitempty"`
+	Flags                  uint32                    `json:"5,omitempty"`
+	TraceId                data.TraceID              `json:"6"`
+	SpanId                 data.SpanID               `json:"7"`
+	DroppedAttributesCount uint32                    `json:"8,omitempty"`
+}
+
+// LogGroup is the key of an attribute trie: log records of one scope with one
+// severity tend to share their attributes.
+type LogGroup struct {
+	Scope          string                 `json:"scope,omitempty"`
+	SeverityNumber v1_logs.SeverityNumber `json:"severityNumber,omitempty"`
+	SeverityText   string                 `json:"severityText,omitempty"`
+}
+
+// EvictionPolicy is ptraceotlp.EvictionPolicy, with the values ptraceotlp.EvictLRU
+// and ptraceotlp.EvictLFU.
+type EvictionPolicy = tracezip.EvictionPolicy
+
+// TraceZipSettings holds the knobs of a TraceZipCompressor.
+type TraceZipSettings struct {
+	// BufferSize is the number of log records kept in the sample buffer.
+	BufferSize int
+	// BufferWindow is how long a log record stays in the sample buffer, 0 for as long
+	// as BufferSize allows.
+	BufferWindow time.Duration
+	// AttrLimit is the largest number of distinct values an attribute may have
+	// in the sample buffer to become a node of a trie.
+	AttrLimit int
+	// ThresholdRate is the number of trie paths after which the tries are rebuilt
+	// and a full dictionary is sent.
+	ThresholdRate int
+	// DeleteResource drops resource attributes, only their dropped count is kept.
+	DeleteResource bool
+	// MemoryLimit and Eviction bound the memory of the compressor like the ones of
+	// ptraceotlp.TraceZipSettings. Bodies and resources are evicted.
+	MemoryLimit int
+	Eviction    EvictionPolicy
+}
+
+// TraceZipCompressor is the logs counterpart of ptraceotlp.TraceZipCompressor.
+// It keeps one attribute trie per scope and severity, and a dictionary of the log
+// bodies that repeat within the sample buffer.
+//
+// Its dictionary updates have the same layout as the traces ones, with the parts
+// attribute names, attribute values, bodies, groups, paths, orders and resources.
+type TraceZipCompressor struct {
+	mu       sync.Mutex
+	settings TraceZipSettings
+
+	dictionaryUuid string
+	sendDictFull   bool
+	// epoch and version number the dictionaries like the ones of the traces
+	// compressor
+	epoch   uint64
+	version uint64
+
+	// budget evicts from the dictionaries that no trie refers to; overBudget drops
+	// all dictionaries with the next payload
+	budget     *tracezip.Budget
+	overBudget bool
+	// holds are the payloads on their way to the receiver, see Hold
+	holds tracezip.Holds
+
+	sampler     *tracezip.Sampler
+	bodySampler *tracezip.Sampler
+
+	attrNames  *tracezip.Dict
+	attrValues *tracezip.Dict
+	bodies     *tracezip.Dict
+	groups     *tracezip.Dict
+	paths      *tracezip.PathDict
+	resources  *tracezip.Dict
+
+	// [group] trie, attribute order and the same order as attribute name codes
+	tries     map[string]*tracezip.Trie
+	orders    map[string]map[string]bool
+	ordersZip map[string][]string
+
+	updateOrders []tracezip.UpdatesEntry
+}
+
+// NewTraceZipCompressor returns a compressor with empty dictionaries and a fresh dictionary uuid.
+func NewTraceZipCompressor(settings TraceZipSettings) *TraceZipCompressor {
+	c := &TraceZipCompressor{settings: settings}
+	c.reset()
+	return c
+}
+
+// Reset drops every trie, buffer and dictionary of the compressor and picks a new
+// dictionary uuid, so that the next call of MarshalWithTraceZip sends a full dictionary.
+func (c *TraceZipCompressor) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+}
+
+func (c *TraceZipCompressor) reset() {
+	c.dictionaryUuid = uuid.NewString()
+	c.sendDictFull = true
+	c.epoch = 0
+	c.version = 0
+	c.sampler = tracezip.NewSampler(c.settings.BufferSize, c.settings.BufferWindow)
+	c.bodySampler = tracezip.NewSampler(c.settings.BufferSize, c.settings.BufferWindow)
+	c.clearDictionaries()
+}
+
+// clearDictionaries drops the tries and every dictionary, but keeps the sample
+// buffers and the dictionary uuid. The next payload must send a full dictionary.
+func (c *TraceZipCompressor) clearDictionaries() {
+	c.budget = tracezip.NewBudget(c.settings.MemoryLimit, c.settings.Eviction)
+	c.overBudget = false
+	c.attrNames = tracezip.NewDict()
+	c.groups = tracezip.NewDict()
+	c.bodies = c.budget.NewDict()
+	c.resources = c.budget.NewDict()
+	c.rebuild()
+}
+
+// pinnedSize estimates the bytes of the state the budget can not evict from.
+func (c *TraceZipCompressor) pinnedSize() int {
+	return c.attrNames.Size() + c.attrValues.Size() + c.groups.Size() + c.paths.Size()
+}
+
+// rebuild drops the tries and the dictionaries that grow with them.
+func (c *TraceZipCompressor) rebuild() {
+	c.attrValues = tracezip.NewDict()
+	c.bodies.Clear()
+	c.paths = tracezip.NewPathDict()
+	c.tries = make(map[string]*tracezip.Trie)
+	c.orders = make(map[string]map[string]bool)
+	c.ordersZip = make(map[string][]string)
+	c.updateOrders = make([]tracezip.UpdatesEntry, 0)
+}
+
+// DictionaryUuid returns the uuid the receiver files this compressor's dictionary under.
+func (c *TraceZipCompressor) DictionaryUuid() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dictionaryUuid
+}
+
+// Version returns the epoch and the version of the dictionary, see
+// ptraceotlp.TraceZipCompressor.Version.
+func (c *TraceZipCompressor) Version() (uint64, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch, c.version
+}
+
+// Hold marks the payload last returned by MarshalWithTraceZip as on its way to the
+// receiver until Release is called with the returned token, see
+// ptraceotlp.TraceZipCompressor.Hold. Later payloads do not evict the entries it
+// uses.
+func (c *TraceZipCompressor) Hold() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.holds.Add(c.epoch, c.version, c.budget.Batch())
+}
+
+// Release ends the hold of token, once its payload was delivered or given up.
+func (c *TraceZipCompressor) Release(token uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.holds.Release(token)
+}
+
+// Floor returns the oldest version of the current epoch a held payload needs, or
+// the current version if none is held, see ptraceotlp.TraceZipCompressor.Floor.
+func (c *TraceZipCompressor) Floor() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, version, ok := c.holds.Oldest(c.epoch); ok {
+		return version
+	}
+	return c.version
+}
+
+// floorBatch returns the oldest budget batch a held payload of the current epoch
+// was compressed in, or the current batch if none is held.
+func (c *TraceZipCompressor) floorBatch() uint64 {
+	if batch, _, ok := c.holds.Oldest(c.epoch); ok {
+		return batch
+	}
+	return c.budget.Batch()
+}
+
+// Resync returns the complete dictionary of the compressor as a full dictionary of
+// a new epoch, for a receiver that lost track of the dictionary.
+func (c *TraceZipCompressor) Resync() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.version = 0
+	return c.sendFull()
+}
+
+// setOrder picks the trie attributes of group from the sample buffer.
+func (c *TraceZipCompressor) setOrder(group string) {
+	code, _ := c.groups.Code(group)
+	orderSet := make(map[string]bool)
+	orderZip := make([]string, 0)
+	for _, key := range c.sampler.Order(group, c.settings.AttrLimit) {
+		orderSet[key] = true
+		keyCode, _ := c.attrNames.Code(key)
+		orderZip = append(orderZip, keyCode)
+	}
+	c.tries[group] = &tracezip.Trie{}
+	c.orders[group] = orderSet
+	c.ordersZip[code] = orderZip
+	value, _ := json.Marshal(orderZip)
+	c.updateOrders = append(c.updateOrders, tracezip.UpdatesEntry{Key: code, Value: string(value)})
+}
+
+func groupOf(scope *otlpcommon.InstrumentationScope, record *v1_logs.LogRecord) string {
+	group, _ := json.Marshal(LogGroup{
+		Scope:          scope.Name,
+		SeverityNumber: record.SeverityNumber,
+		SeverityText:   record.SeverityText,
+	})
+	return string(group)
+}
+
+// MarshalWithTraceZip compresses ms with TraceZip. It returns the dictionary uuid, a full
+// dictionary (only when one must be sent), an incremental dictionary update (only when
+// the dictionaries changed) and the compressed payload.
+func (c *TraceZipCompressor) MarshalWithTraceZip(ms ExportRequest, ExplictReset bool) (string, []interface{}, []interface{}, []ExportData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ExplictReset {
+		c.sendDictFull = true
+	}
+	if c.overBudget {
+		c.clearDictionaries()
+		c.sendDictFull = true
+	}
+	c.budget.Next()
+
+	// Update Buffer
+	now := time.Now()
+	var minTime tracezip.MinTime
+	newGroups := make([]string, 0)
+	for _, resourceLogs := range ms.orig.ResourceLogs {
+		for _, scopeLogs := range resourceLogs.ScopeLogs {
+			for _, record := range scopeLogs.LogRecords {
+				minTime.Add(record.TimeUnixNano)
+				minTime.Add(record.ObservedTimeUnixNano)
+				fields := make([]tracezip.Field, 0, len(record.Attributes))
+				for i := range record.Attributes {
+					c.attrNames.Code(record.Attributes[i].Key)
+					fields = append(fields, tracezip.Field{
+						Key:   record.Attributes[i].Key,
+						Value: string(tracezip.MarshalAnyValue(&record.Attributes[i].Value)),
+					})
+				}
+				group := groupOf(&scopeLogs.Scope, record)
+				if c.sampler.Add(now, group, fields) {
+					newGroups = append(newGroups, group)
+				}
+				if record.Body.Value != nil {
+					c.bodySampler.Add(now, "", []tracezip.Field{{Value: string(tracezip.MarshalAnyValue(&record.Body))}})
+				}
+			}
+		}
+	}
+	if c.sendDictFull {
+		c.rebuild()
+		for _, group := range c.sampler.Groups() {
+			c.setOrder(group)
+		}
+	} else {
+		for _, group := range newGroups {
+			c.setOrder(group)
+		}
+	}
+
+	export := make([]ExportData, 0, len(ms.orig.ResourceLogs))
+	for _, resourceLogs := range ms.orig.ResourceLogs {
+		resourceLogs_ := ExportData{
+			SchemaUrl: resourceLogs.SchemaUrl,
+			ScopeLogs: make([]ScopeLog, 0, len(resourceLogs.ScopeLogs)),
+		}
+		resourceLogs_.ResourceId, _ = c.resources.Code(tracezip.MarshalResource(&resourceLogs.Resource, c.settings.DeleteResource))
+		for _, scopeLogs := range resourceLogs.ScopeLogs {
+			scopeLogs_ := ScopeLog{
+				SchemaUrl:  scopeLogs.SchemaUrl,
+				Scope:      tracezip.MarshalScope(&scopeLogs.Scope),
+				OffsetMain: uint64(minTime),
+				Logs:       make([]LogData, 0, len(scopeLogs.LogRecords)),
+			}
+			for _, record := range scopeLogs.LogRecords {
+				scopeLogs_.Logs = append(scopeLogs_.Logs, c.marshalRecord(&scopeLogs.Scope, record, uint64(minTime)))
+			}
+			resourceLogs_.ScopeLogs = append(resourceLogs_.ScopeLogs, scopeLogs_)
+		}
+		export = append(export, resourceLogs_)
+	}
+
+	// Entries of this payload and of the held ones are not evicted, the receiver
+	// needs them to decode them.
+	if _, ok := c.budget.Evict(c.pinnedSize(), c.floorBatch()); !ok {
+		c.overBudget = true
+	}
+
+	var incrementUpdate []interface{}
+	var fullUpdate []interface{}
+	if c.sendDictFull {
+		fullUpdate = c.sendFull()
+		c.sendDictFull = false
+		c.epoch++
+		c.version = 0
+		c.clearUpdates()
+	} else {
+		update := []interface{}{
+			c.attrNames.TakeUpdates(),
+			c.attrValues.TakeUpdates(),
+			c.bodies.TakeUpdates(),
+			c.groups.TakeUpdates(),
+			c.paths.TakeUpdates(),
+			c.updateOrders,
+			c.resources.TakeUpdates(),
+		}
+		c.updateOrders = make([]tracezip.UpdatesEntry, 0)
+		for _, part := range update {
+			if len(part.([]tracezip.UpdatesEntry)) > 0 {
+				incrementUpdate = update
+				c.version++
+				break
+			}
+		}
+	}
+
+	if c.paths.Len() > c.settings.ThresholdRate {
+		c.sendDictFull = true
+	}
+
+	return c.dictionaryUuid, fullUpdate, incrementUpdate, export
+}
+
+func (c *TraceZipCompressor) marshalRecord(scope *otlpcommon.InstrumentationScope, record *v1_logs.LogRecord, minTime uint64) LogData {
+	group := groupOf(scope, record)
+	groupCode, _ := c.groups.Lookup(group)
+	record_ := LogData{
+		Group:                  groupCode,
+		Time:                   tracezip.OffsetTime(record.TimeUnixNano, minTime),
+		ObservedTime:           tracezip.OffsetTime(record.ObservedTimeUnixNano, minTime),
+		Flags:                  record.Flags,
+		TraceId:                record.TraceId,
+		SpanId:                 record.SpanId,
+		DroppedAttributesCount: record.DroppedAttributesCount,
+	}
+
+	order := c.ordersZip[groupCode]
+	if len(order) > 0 {
+		path := make([]string, len(order))
+		for i := range path {
+			path[i] = tracezip.Missing
+		}
+		for i := range record.Attributes {
+			if !c.orders[group][record.Attributes[i].Key] {
+				continue
+			}
+			keyCode, _ := c.attrNames.Lookup(record.Attributes[i].Key)
+			for j := range order {
+				if order[j] == keyCode {
+					path[j], _ = c.attrValues.Code(string(tracezip.MarshalAnyValue(&record.Attributes[i].Value)))
+				}
+			}
+		}
+		record_.PathHash, _ = c.tries[group].Retrieve(c.paths, path)
+	}
+	for i := range record.Attributes {
+		if c.orders[group][record.Attributes[i].Key] {
+			continue
+		}
+		keyCode, _ := c.attrNames.Lookup(record.Attributes[i].Key)
+		record_.Attributes = append(record_.Attributes, tracezip.CodedAttribute{
+			Key:   keyCode,
+			Value: tracezip.EncodeValue(&record.Attributes[i].Value, tracezip.DictCoder{Names: c.attrNames, Values: c.attrValues}),
+		})
+	}
+
+	if record.Body.Value != nil {
+		body := tracezip.MarshalAnyValue(&record.Body)
+		// Only bodies that repeat within the sample buffer are worth a dictionary entry.
+		if c.bodySampler.Count("", "", string(body)) > 1 {
+			record_.Body, _ = c.bodies.Code(string(body))
+		} else {
+			record_.BodyValue = body
+		}
+	}
+	return record_
+}
+
+func (c *TraceZipCompressor) clearUpdates() {
+	c.attrNames.TakeUpdates()
+	c.attrValues.TakeUpdates()
+	c.bodies.TakeUpdates()
+	c.groups.TakeUpdates()
+	c.paths.TakeUpdates()
+	c.updateOrders = make([]tracezip.UpdatesEntry, 0)
+	c.resources.TakeUpdates()
+}
+
+// SendFull returns the complete dictionary of the compressor, in the layout of a full update.
+func (c *TraceZipCompressor) SendFull() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sendFull()
+}
+
+func (c *TraceZipCompressor) sendFull() []interface{} {
+	return []interface{}{
+		c.attrNames.Values(),
+		c.attrValues.Values(),
+		c.bodies.Values(),
+		c.groups.Values(),
+		c.paths.Values(),
+		c.ordersZip,
+		c.resources.Values(),
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plogotlp // import "go.opentelemetry.io/collector/pdata/plog/plogotlp"
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.opentelemetry.io/collector/pdata/internal"
+	otlpcollectorlog "go.opentelemetry.io/collector/pdata/internal/data/protogen/collector/logs/v1"
+	otlpcommon "go.opentelemetry.io/collector/pdata/internal/data/protogen/common/v1"
+	v1_logs "go.opentelemetry.io/collector/pdata/internal/data/protogen/logs/v1"
+	"go.opentelemetry.io/collector/pdata/internal/tracezip"
+	"go.opentelemetry.io/collector/pdata/plog"
+)
+
+// TraceZipDictionary is the receiver side copy of the dictionaries of one
+// TraceZipCompressor. It is kept up to date with the full and incremental updates
+// returned by MarshalWithTraceZip and is needed to decode the compressor's payloads.
+type TraceZipDictionary struct {
+	AttributeNameDict  map[string]string
+	AttributeValueDict map[string]string
+	BodyDict           map[string]string
+	GroupDict          map[string]string
+	PathDict           map[string][]string
+	Orders             map[string][]string
+	ResourceDict       map[string]string
+}
+
+// NewTraceZipDictionary returns an empty dictionary.
+func NewTraceZipDictionary() *TraceZipDictionary {
+	return &TraceZipDictionary{
+		AttributeNameDict:  make(map[string]string),
+		AttributeValueDict: make(map[string]string),
+		BodyDict:           make(map[string]string),
+		GroupDict:          make(map[string]string),
+		PathDict:           make(map[string][]string),
+		Orders:             make(map[string][]string),
+		ResourceDict:       make(map[string]string),
+	}
+}
+
+// FullUpdate replaces the whole dictionary with a full update, in the layout
+// produced by MarshalWithTraceZip.
+func (cd *TraceZipDictionary) FullUpdate(data []json.RawMessage) error {
+	fresh := NewTraceZipDictionary()
+	err := tracezip.UnmarshalFull(data, []interface{}{
+		&fresh.AttributeNameDict,
+		&fresh.AttributeValueDict,
+		&fresh.BodyDict,
+		&fresh.GroupDict,
+		&fresh.PathDict,
+		&fresh.Orders,
+		&fresh.ResourceDict,
+	}, "logs")
+	if err != nil {
+		return err
+	}
+	*cd = *fresh
+	// A dictionary may be sent as JSON null if it is empty.
+	cd.ensureMaps()
+	return nil
+}
+
+// IncrementUpdate adds the entries of an incremental update, in the layout
+// produced by MarshalWithTraceZip, to the dictionary.
+func (cd *TraceZipDictionary) IncrementUpdate(data []json.RawMessage) error {
+	updates, err := tracezip.UnmarshalUpdates(data, 7, "logs")
+	if err != nil {
+		return err
+	}
+	// Decode the JSON encoded entries before touching the dictionary, so that a
+	// broken update leaves it as it was.
+	paths, err := tracezip.UnmarshalArrays(updates[4])
+	if err != nil {
+		return fmt.Errorf("path: %w", err)
+	}
+	orders, err := tracezip.UnmarshalArrays(updates[5])
+	if err != nil {
+		return fmt.Errorf("order: %w", err)
+	}
+
+	cd.ensureMaps()
+	tracezip.ApplyUpdates(cd.AttributeNameDict, updates[0])
+	tracezip.ApplyUpdates(cd.AttributeValueDict, updates[1])
+	tracezip.ApplyUpdates(cd.BodyDict, updates[2])
+	tracezip.ApplyUpdates(cd.GroupDict, updates[3])
+	for k, v := range paths {
+		cd.PathDict[k] = v
+	}
+	for k, v := range orders {
+		cd.Orders[k] = v
+	}
+	tracezip.ApplyUpdates(cd.ResourceDict, updates[6])
+	return nil
+}
+
+// Size estimates the bytes the dictionary takes in memory, the way the compressor
+// counts its memory_limit.
+func (cd *TraceZipDictionary) Size() int {
+	size := tracezip.MapSize(cd.AttributeNameDict, cd.AttributeValueDict, cd.BodyDict, cd.GroupDict, cd.ResourceDict) + tracezip.PathsSize(cd.PathDict, cd.Orders)
+	return size
+}
+
+func (cd *TraceZipDictionary) ensureMaps() {
+	if cd.AttributeNameDict == nil {
+		cd.AttributeNameDict = make(map[string]string)
+	}
+	if cd.AttributeValueDict == nil {
+		cd.AttributeValueDict = make(map[string]string)
+	}
+	if cd.BodyDict == nil {
+		cd.BodyDict = make(map[string]string)
+	}
+	if cd.GroupDict == nil {
+		cd.GroupDict = make(map[string]string)
+	}
+	if cd.PathDict == nil {
+		cd.PathDict = make(map[string][]string)
+	}
+	if cd.Orders == nil {
+		cd.Orders = make(map[string][]string)
+	}
+	if cd.ResourceDict == nil {
+		cd.ResourceDict = make(map[string]string)
+	}
+}
+
+// UnmarshalWithTraceZip is the inverse of MarshalWithTraceZip: it decodes the JSON
+// form of a compressed payload with the dictionary it was compressed against.
+func UnmarshalWithTraceZip(dict *TraceZipDictionary, data []byte) (plog.Logs, error) {
+	var export []ExportData
+	if err := json.Unmarshal(data, &export); err != nil {
+		return plog.Logs{}, err
+	}
+	return DecodeWithTraceZip(dict, export)
+}
+
+// DecodeWithTraceZip rebuilds the logs of a compressed payload with the dictionary
+// it was compressed against.
+func DecodeWithTraceZip(dict *TraceZipDictionary, export []ExportData) (plog.Logs, error) {
+	if dict == nil {
+		return plog.Logs{}, fmt.Errorf("no dictionary to decode with")
+	}
+	orig := &otlpcollectorlog.ExportLogsServiceRequest{}
+	for _, resourceLogs_ := range export {
+		resourceLogs := &v1_logs.ResourceLogs{SchemaUrl: resourceLogs_.SchemaUrl}
+		resource, ok := dict.ResourceDict[resourceLogs_.ResourceId]
+		if !ok {
+			return plog.Logs{}, fmt.Errorf("no such resource %q", resourceLogs_.ResourceId)
+		}
+		err := tracezip.DecodeResource(resource, &resourceLogs.Resource)
+		if err != nil {
+			return plog.Logs{}, err
+		}
+		for _, scopeLogs_ := range resourceLogs_.ScopeLogs {
+			scopeLogs := &v1_logs.ScopeLogs{SchemaUrl: scopeLogs_.SchemaUrl}
+			if err = tracezip.DecodeScope(&scopeLogs_.Scope, &scopeLogs.Scope); err != nil {
+				return plog.Logs{}, err
+			}
+			for i := range scopeLogs_.Logs {
+				record, err := dict.decodeRecord(&scopeLogs_.Logs[i], scopeLogs_.OffsetMain)
+				if err != nil {
+					return plog.Logs{}, err
+				}
+				scopeLogs.LogRecords = append(scopeLogs.LogRecords, record)
+			}
+			resourceLogs.ScopeLogs = append(resourceLogs.ScopeLogs, scopeLogs)
+		}
+		orig.ResourceLogs = append(orig.ResourceLogs, resourceLogs)
+	}
+	state := internal.StateMutable
+	return plog.Logs(internal.NewLogs(orig, &state)), nil
+}
+
+func (cd *TraceZipDictionary) decodeRecord(record_ *LogData, minTime uint64) (*v1_logs.LogRecord, error) {
+	group_, ok := cd.GroupDict[record_.Group]
+	if !ok {
+		return nil, fmt.Errorf("no such group %q", record_.Group)
+	}
+	var group LogGroup
+	if err := json.Unmarshal([]byte(group_), &group); err != nil {
+		return nil, fmt.Errorf("group %q: %w", record_.Group, err)
+	}
+	record := &v1_logs.LogRecord{
+		TimeUnixNano:           tracezip.RestoreTime(record_.Time, minTime),
+		ObservedTimeUnixNano:   tracezip.RestoreTime(record_.ObservedTime, minTime),
+		SeverityNumber:         group.SeverityNumber,
+		SeverityText:           group.SeverityText,
+		DroppedAttributesCount: record_.DroppedAttributesCount,
+		Flags:                  record_.Flags,
+		TraceId:                record_.TraceId,
+		SpanId:                 record_.SpanId,
+	}
+
+	attrs, err := tracezip.DecodeCodedAttributes(record_.Attributes, tracezip.ValueDicts{Names: cd.AttributeNameDict, Values: cd.AttributeValueDict})
+	if err != nil {
+		return nil, err
+	}
+	record.Attributes = attrs
+
+	if record_.PathHash != "" {
+		pathArray, ok := cd.PathDict[record_.PathHash]
+		if !ok {
+			return nil, fmt.Errorf("no such pathId %q", record_.PathHash)
+		}
+		order := cd.Orders[record_.Group]
+		if len(pathArray) != len(order) {
+			return nil, fmt.Errorf("path %q has %d attributes, but group %q orders %d", record_.PathHash, len(pathArray), record_.Group, len(order))
+		}
+		for index, attr := range order {
+			if pathArray[index] == tracezip.Missing {
+				continue
+			}
+			key, ok := cd.AttributeNameDict[attr]
+			if !ok {
+				return nil, fmt.Errorf("no such attribute name %q", attr)
+			}
+			value, ok := cd.AttributeValueDict[pathArray[index]]
+			if !ok {
+				return nil, fmt.Errorf("no such attribute value %q", pathArray[index])
+			}
+			kv := otlpcommon.KeyValue{Key: key}
+			if err := tracezip.UnmarshalAnyValue([]byte(value), &kv.Value); err != nil {
+				return nil, fmt.Errorf("attribute %q: %w", key, err)
+			}
+			record.Attributes = append(record.Attributes, kv)
+		}
+	}
+
+	body := []byte(record_.BodyValue)
+	if record_.Body != "" {
+		body_, ok := cd.BodyDict[record_.Body]
+		if !ok {
+			return nil, fmt.Errorf("no such body %q", record_.Body)
+		}
+		body = []byte(body_)
+	}
+	if len(body) > 0 {
+		if err := tracezip.UnmarshalAnyValue(body, &record.Body); err != nil {
+			return nil, fmt.Errorf("body: %w", err)
+		}
+	}
+	return record, nil
+}
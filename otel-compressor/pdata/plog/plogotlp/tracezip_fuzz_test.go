@@ -0,0 +1,84 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plogotlp
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// traceZipFuzzSeeds returns the dictionary updates and payloads of a few batches,
+// and a function returning a fresh dictionary with the first full update applied.
+func traceZipFuzzSeeds(f *testing.F) (updates [][]byte, payloads [][]byte, newDict func() *TraceZipDictionary) {
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:    100,
+		AttrLimit:     5,
+		ThresholdRate: 1000,
+	})
+	var first []json.RawMessage
+	for batch := 0; batch < 3; batch++ {
+		_, full, increment, export := c.MarshalWithTraceZip(NewExportRequestFromLogs(newTraceZipTestLogs(batch)), false)
+		update := full
+		if update == nil {
+			update = increment
+		}
+		raw, err := json.Marshal(update)
+		require.NoError(f, err)
+		updates = append(updates, raw)
+		if first == nil {
+			require.NoError(f, json.Unmarshal(raw, &first))
+		}
+		payload, err := json.Marshal(export)
+		require.NoError(f, err)
+		payloads = append(payloads, payload)
+	}
+	require.NoError(f, NewTraceZipDictionary().FullUpdate(first))
+	return updates, payloads, func() *TraceZipDictionary {
+		dict := NewTraceZipDictionary()
+		_ = dict.FullUpdate(first)
+		return dict
+	}
+}
+
+// FuzzTraceZipDecode checks that no payload makes the decoder panic.
+func FuzzTraceZipDecode(f *testing.F) {
+	_, payloads, newDict := traceZipFuzzSeeds(f)
+	for _, payload := range payloads {
+		f.Add(payload)
+	}
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		_, _ = UnmarshalWithTraceZip(newDict(), payload)
+	})
+}
+
+// FuzzTraceZipDictionaryUpdate checks that no dictionary update makes the
+// dictionary or the decoding of a payload with it panic.
+func FuzzTraceZipDictionaryUpdate(f *testing.F) {
+	updates, payloads, newDict := traceZipFuzzSeeds(f)
+	for _, update := range updates {
+		f.Add(update, false)
+		f.Add(update, true)
+	}
+	f.Fuzz(func(t *testing.T, update []byte, full bool) {
+		var parts []json.RawMessage
+		if json.Unmarshal(update, &parts) != nil {
+			return
+		}
+		dict := newDict()
+		var err error
+		if full {
+			err = dict.FullUpdate(parts)
+		} else {
+			err = dict.IncrementUpdate(parts)
+		}
+		if err != nil {
+			return
+		}
+		for _, payload := range payloads {
+			_, _ = UnmarshalWithTraceZip(dict, payload)
+		}
+	})
+}
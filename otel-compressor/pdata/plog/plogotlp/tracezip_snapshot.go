@@ -0,0 +1,107 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plogotlp // import "go.opentelemetry.io/collector/pdata/plog/plogotlp"
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.opentelemetry.io/collector/pdata/internal/tracezip"
+)
+
+// traceZipSnapshot is what Snapshot keeps of a compressor, the sample buffers
+// start empty after Restore.
+type traceZipSnapshot struct {
+	Uuid     string `json:"uuid"`
+	Epoch    uint64 `json:"epoch"`
+	Version  uint64 `json:"version"`
+	SendFull bool   `json:"sendFull,omitempty"`
+
+	AttrNames  tracezip.DictState `json:"attrNames"`
+	AttrValues tracezip.DictState `json:"attrValues"`
+	Bodies     tracezip.DictState `json:"bodies"`
+	Groups     tracezip.DictState `json:"groups"`
+	Resources  tracezip.DictState `json:"resources"`
+
+	// the tries by group, and their orders as attribute name codes by group code
+	Tries  tracezip.TrieState  `json:"tries"`
+	Orders map[string][]string `json:"orders"`
+}
+
+// Snapshot returns the state of the compressor for Restore, see
+// ptraceotlp.TraceZipCompressor.Snapshot.
+func (c *TraceZipCompressor) Snapshot() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(traceZipSnapshot{
+		Uuid:       c.dictionaryUuid,
+		Epoch:      c.epoch,
+		Version:    c.version,
+		SendFull:   c.sendDictFull || c.overBudget,
+		AttrNames:  c.attrNames.State(),
+		AttrValues: c.attrValues.State(),
+		Bodies:     c.bodies.State(),
+		Groups:     c.groups.State(),
+		Resources:  c.resources.State(),
+		Tries:      tracezip.SaveTries(c.tries, c.paths),
+		Orders:     c.ordersZip,
+	})
+}
+
+// Restore replaces the state of the compressor with a snapshot returned by
+// Snapshot. The settings of the compressor stay.
+func (c *TraceZipCompressor) Restore(snapshot []byte) error {
+	var s traceZipSnapshot
+	if err := json.Unmarshal(snapshot, &s); err != nil {
+		return fmt.Errorf("tracezip snapshot: %w", err)
+	}
+	if s.Uuid == "" {
+		return fmt.Errorf("tracezip snapshot: no dictionary uuid")
+	}
+	tries, paths, err := tracezip.RestoreTries(s.Tries)
+	if err != nil {
+		return fmt.Errorf("tracezip snapshot: %w", err)
+	}
+	orders := make(map[string]map[string]bool, len(s.Orders))
+	for groupCode, order := range s.Orders {
+		group, ok := s.Groups.Values[groupCode]
+		if !ok {
+			return fmt.Errorf("tracezip snapshot: order of unknown group %q", groupCode)
+		}
+		orders[group] = make(map[string]bool, len(order))
+		for _, keyCode := range order {
+			key, ok := s.AttrNames.Values[keyCode]
+			if !ok {
+				return fmt.Errorf("tracezip snapshot: order of %q has unknown attribute %q", group, keyCode)
+			}
+			orders[group][key] = true
+		}
+		if tries[group] == nil {
+			tries[group] = &tracezip.Trie{}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+	c.dictionaryUuid = s.Uuid
+	c.epoch = s.Epoch
+	c.version = s.Version
+	c.sendDictFull = s.SendFull || s.Epoch == 0
+	c.attrNames.Restore(s.AttrNames)
+	c.attrValues.Restore(s.AttrValues)
+	c.bodies.Restore(s.Bodies)
+	c.groups.Restore(s.Groups)
+	c.resources.Restore(s.Resources)
+	c.tries = tries
+	c.paths = paths
+	c.orders = orders
+	if s.Orders != nil {
+		c.ordersZip = s.Orders
+	}
+	for group := range tries {
+		c.sampler.Seen(group)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package plogotlp
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/collector/pdata/internal"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+)
+
+func newTraceZipTestLogs(batch int) plog.Logs {
+	ld := plog.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	rl.SetSchemaUrl("https://opentelemetry.io/schemas/1.21.0")
+	rl.Resource().Attributes().PutStr("service.name", "ts-order-service")
+	for s, scopeName := range []string{"org.hibernate.SQL", "io.opentelemetry.spring-webmvc-6.0"} {
+		sl := rl.ScopeLogs().AppendEmpty()
+		sl.Scope().SetName(scopeName)
+		sl.Scope().SetVersion("1.32.0")
+		base := pcommon.Timestamp(1700000000000000000 + uint64(batch)*1000000000)
+		for i := 0; i < 12; i++ {
+			lr := sl.LogRecords().AppendEmpty()
+			if i%6 != 5 {
+				lr.SetTimestamp(base + pcommon.Timestamp(i*1000))
+			}
+			lr.SetObservedTimestamp(base + pcommon.Timestamp(i*1000+s))
+			lr.SetSeverityNumber([]plog.SeverityNumber{plog.SeverityNumberInfo, plog.SeverityNumberError}[i%2])
+			lr.SetSeverityText([]string{"INFO", "ERROR"}[i%2])
+			if i%4 == 3 {
+				lr.SetTraceID(pcommon.TraceID{1, 2, byte(batch), byte(i)})
+				lr.SetSpanID(pcommon.SpanID{3, byte(i)})
+				lr.SetFlags(plog.DefaultLogRecordFlags.WithIsSampled(true))
+			}
+			lr.SetDroppedAttributesCount(uint32(i % 3))
+			switch i % 3 {
+			case 0:
+				lr.Body().SetStr("connection pool exhausted")
+			case 1:
+				lr.Body().SetStr("order " + string(rune('a'+s)) + string(rune('a'+i)) + " created")
+			}
+			lr.Attributes().PutStr("thread.name", []string{"main", "worker-1"}[i%2])
+			lr.Attributes().PutInt("code.lineno", int64(100+i%3))
+			lr.Attributes().PutStr("order.id", "id-"+string(rune('a'+batch))+string(rune('a'+i)))
+		}
+	}
+	return ld
+}
+
+// normalizeLogs sorts log attributes by key, since the decoder returns the
+// attributes of the trie after the remaining ones.
+func normalizeLogs(ld plog.Logs) plog.Logs {
+	orig := internal.GetOrigLogs(internal.Logs(ld))
+	for _, rl := range orig.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				sort.SliceStable(lr.Attributes, func(i, j int) bool {
+					return lr.Attributes[i].Key < lr.Attributes[j].Key
+				})
+			}
+		}
+	}
+	return ld
+}
+
+// applyTraceZipUpdate sends the dictionary updates through JSON, the way the exporter does.
+func applyTraceZipUpdate(t *testing.T, dict *TraceZipDictionary, full []interface{}, increment []interface{}) {
+	var parts []json.RawMessage
+	if full != nil {
+		raw, err := json.Marshal(full)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(raw, &parts))
+		require.NoError(t, dict.FullUpdate(parts))
+	} else if increment != nil {
+		raw, err := json.Marshal(increment)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(raw, &parts))
+		require.NoError(t, dict.IncrementUpdate(parts))
+	}
+}
+
+func TestTraceZipRoundTrip(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:    100,
+		AttrLimit:     5,
+		ThresholdRate: 1000,
+	})
+	dict := NewTraceZipDictionary()
+
+	for batch := 0; batch < 5; batch++ {
+		ld := newTraceZipTestLogs(batch)
+		expected := plog.NewLogs()
+		ld.CopyTo(expected)
+
+		_, full, increment, export := c.MarshalWithTraceZip(NewExportRequestFromLogs(ld), batch == 3)
+		if batch == 0 || batch == 3 {
+			assert.NotNil(t, full)
+		} else {
+			assert.Nil(t, full)
+		}
+		applyTraceZipUpdate(t, dict, full, increment)
+		payload, err := json.Marshal(export)
+		require.NoError(t, err)
+
+		// MarshalWithTraceZip must not touch its input.
+		assert.Equal(t, expected, ld)
+
+		actual, err := UnmarshalWithTraceZip(dict, payload)
+		require.NoError(t, err)
+		assert.Equal(t, normalizeLogs(expected), normalizeLogs(actual))
+	}
+}
+
+func TestTraceZipTriesAndBodies(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:    100,
+		AttrLimit:     5,
+		ThresholdRate: 1000,
+	})
+	_, full, _, export := c.MarshalWithTraceZip(NewExportRequestFromLogs(newTraceZipTestLogs(0)), false)
+	require.NotNil(t, full)
+
+	// One group per scope and severity.
+	assert.Len(t, full[3], 4)
+	for _, scopeLogs := range export[0].ScopeLogs {
+		for i, record := range scopeLogs.Logs {
+			// thread.name and code.lineno are in the trie, order.id is not.
+			assert.NotEmpty(t, record.PathHash)
+			require.Len(t, record.Attributes, 1)
+			switch i % 3 {
+			case 0:
+				assert.NotEmpty(t, record.Body, "a repeated body goes to the dictionary")
+				assert.Empty(t, record.BodyValue)
+			case 1:
+				assert.Empty(t, record.Body)
+				assert.NotEmpty(t, record.BodyValue)
+			default:
+				assert.Empty(t, record.Body)
+				assert.Empty(t, record.BodyValue)
+			}
+		}
+	}
+
+	// Known groups and paths, only the new bodies are sent.
+	_, full, increment, _ := c.MarshalWithTraceZip(NewExportRequestFromLogs(newTraceZipTestLogs(0)), false)
+	assert.Nil(t, full)
+	require.NotNil(t, increment)
+	assert.NotEmpty(t, increment[2])
+	assert.Empty(t, increment[3])
+	assert.Empty(t, increment[4])
+
+	_, full, increment, _ = c.MarshalWithTraceZip(NewExportRequestFromLogs(newTraceZipTestLogs(0)), false)
+	assert.Nil(t, full)
+	assert.Nil(t, increment)
+}
+
+func TestTraceZipDecodeUnknownGroup(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{BufferSize: 10, AttrLimit: 5, ThresholdRate: 1000})
+	_, _, _, export := c.MarshalWithTraceZip(NewExportRequestFromLogs(newTraceZipTestLogs(0)), false)
+
+	// The full dictionary was never applied.
+	_, err := DecodeWithTraceZip(NewTraceZipDictionary(), export)
+	assert.Error(t, err)
+	_, err = DecodeWithTraceZip(nil, export)
+	assert.Error(t, err)
+}
+
+func TestTraceZipSnapshot(t *testing.T) {
+	settings := TraceZipSettings{
+		BufferSize:    100,
+		AttrLimit:     5,
+		ThresholdRate: 1000,
+	}
+	c := NewTraceZipCompressor(settings)
+	dict := NewTraceZipDictionary()
+	for batch := 0; batch < 3; batch++ {
+		_, full, increment, _ := c.MarshalWithTraceZip(NewExportRequestFromLogs(newTraceZipTestLogs(batch)), false)
+		applyTraceZipUpdate(t, dict, full, increment)
+	}
+	snapshot, err := c.Snapshot()
+	require.NoError(t, err)
+
+	restored := NewTraceZipCompressor(settings)
+	require.NoError(t, restored.Restore(snapshot))
+	epoch, version := c.Version()
+	restoredEpoch, restoredVersion := restored.Version()
+	assert.Equal(t, epoch, restoredEpoch)
+	assert.Equal(t, version, restoredVersion)
+	for batch := 3; batch < 6; batch++ {
+		data := newTraceZipTestLogs(batch)
+		expected := plog.NewLogs()
+		data.CopyTo(expected)
+
+		uuid, full, increment, export := restored.MarshalWithTraceZip(NewExportRequestFromLogs(data), false)
+		assert.Equal(t, c.DictionaryUuid(), uuid)
+		assert.Nil(t, full)
+		applyTraceZipUpdate(t, dict, full, increment)
+		payload, err := json.Marshal(export)
+		require.NoError(t, err)
+		actual, err := UnmarshalWithTraceZip(dict, payload)
+		require.NoError(t, err)
+		assert.Equal(t, normalizeLogs(expected), normalizeLogs(actual))
+	}
+
+	other := NewTraceZipDictionary()
+	applyTraceZipUpdate(t, other, restored.Resync(), nil)
+	assert.Equal(t, dict, other)
+	assert.Error(t, restored.Restore([]byte(`{"uuid":"x","orders":{"0":["0"]}}`)))
+}
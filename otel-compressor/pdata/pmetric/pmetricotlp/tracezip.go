@@ -0,0 +1,704 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package pmetricotlp // import "go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"go.opentelemetry.io/collector/pdata/internal/data"
+	otlpcommon "go.opentelemetry.io/collector/pdata/internal/data/protogen/common/v1"
+	v1_metrics "go.opentelemetry.io/collector/pdata/internal/data/protogen/metrics/v1"
+	"go.opentelemetry.io/collector/pdata/internal/tracezip"
+)
+
+type ScopeMetric struct {
+	SchemaUrl string         `json:"schemaUrl,omitempty"`
+	Scope     tracezip.Scope `json:"scope,omitempty"`
+	// OffsetMain is the smallest timestamp of the batch, the series of data point
+	// timestamps start from it, see PointData.
+	OffsetMain uint64       `json:"to"`
+	Metrics    []MetricData `json:"metrics,omitempty"`
+}
+
+// ExportData is a compressed resource, ResourceId is its code in the resource dictionary.
+type ExportData struct {
+	SchemaUrl    string        `json:"schemaUrl,omitempty"`
+	ResourceId   string        `json:"r"`
+	ScopeMetrics []ScopeMetric `json:"scopeMetrics,omitempty"`
+}
+
+// MetricType tells which kind of data a MetricData carries.
+type MetricType int32
+
+const (
+	MetricTypeEmpty MetricType = iota
+	MetricTypeGauge
+	MetricTypeSum
+	MetricTypeHistogram
+	MetricTypeExponentialHistogram
+	MetricTypeSummary
+)
+
+// MetricData is a compressed metric. Metric is the code of its name, description
+// and unit in the metric dictionary.
+type MetricData struct {
+	Metric      string                            `json:"m"`
+	Type        MetricType                        `json:"t,omitempty"`
+	Temporality v1_metrics.AggregationTemporality `json:"a,omitempty"`
+	Monotonic   bool                              `json:"o,omitempty"`
+	Points      []PointData                       `json:"p,omitempty"`
+}
+
+// MetricDescriptor is the dictionary entry of a metric, and the key of its attribute trie.
+type MetricDescriptor struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+}
+
+// PointData is a compressed data point of any metric type. Its attributes that are
+// part of the metric's trie are replaced by PathHash, and the remaining ones keep
+// only a shortened key.
+//
+// StartTime and Time are delta of delta encoded: each of them is a series over the
+// data points of a scope, in order, starting from OffsetMain.
+type PointData struct {
+	PathHash   string                    `json:"_,omitempty"`
+	Attributes []tracezip.CodedAttribute `json:"0,omitempty"`
+	StartTime  int64                     `json:"1,omitempty"`
+	Time       int64                     `json:"2,omitempty"`
+	Flags      uint32                    `json:"3,omitempty"`
+	Exemplars  []ExemplarData            `json:"4,omitempty"`
+
+	// Value of gauge and sum data points.
+	Double *tracezip.Double `json:"d,omitempty"`
+	Int    *int64           `json:"i,omitempty"`
+
+	// Histograms and summaries.
+	Count        uint64           `json:"c,omitempty"`
+	Sum          *tracezip.Double `json:"s,omitempty"`
+	Min          *tracezip.Double `json:"n,omitempty"`
+	Max          *tracezip.Double `json:"x,omitempty"`
+	BucketCounts []uint64         `json:"b,omitempty"`
+	// Bounds is the code of the explicit bounds in the bounds dictionary.
+	Bounds        string          `json:"e,omitempty"`
+	Scale         int32           `json:"l,omitempty"`
+	ZeroCount     uint64          `json:"z,omitempty"`
+	ZeroThreshold tracezip.Double `json:"zt,omitempty"`
+	Positive      *Buckets        `json:"bp,omitempty"`
+	Negative      *Buckets        `json:"bn,omitempty"`
+	Quantiles     []Quantile      `json:"q,omitempty"`
+}
+
+type Buckets struct {
+	Offset       int32    `json:"o,omitempty"`
+	BucketCounts []uint64 `json:"b,omitempty"`
+}
+
+type Quantile struct {
+	Quantile tracezip.Double `json:"q"`
+	Value    tracezip.Double `json:"v"`
+}
+
+// ExemplarData is an exemplar, its Time is relative to the time of its data point.
+type ExemplarData struct {
+	Attributes []tracezip.Attribute `json:"a,omitempty"`
+	Time       int64                `json:"t,omitempty"`
+	Double     *tracezip.Double     `json:"d,omitempty"`
+	Int        *int64               `json:"i,omitempty"`
+	SpanId     data.SpanID          `json:"s"`
+	TraceId    data.TraceID         `json:"r"`
+}
+
+// EvictionPolicy is ptraceotlp.EvictionPolicy, with the values ptraceotlp.EvictLRU
+// and ptraceotlp.EvictLFU.
+type EvictionPolicy = tracezip.EvictionPolicy
+
+// TraceZipSettings holds the knobs of a TraceZipCompressor.
+type TraceZipSettings struct {
+	// BufferSize is the number of data points kept in the sample buffer.
+	BufferSize int
+	// BufferWindow is how long a data point stays in the sample buffer, 0 for as long
+	// as BufferSize allows.
+	BufferWindow time.Duration
+	// AttrLimit is the largest number of distinct values an attribute may have
+	// in the sample buffer to become a node of a trie.
+	AttrLimit int
+	// ThresholdRate is the number of trie paths after which the tries are rebuilt
+	// and a full dictionary is sent.
+	ThresholdRate int
+	// DeleteResource drops resource attributes, only their dropped count is kept.
+	DeleteResource bool
+	// MemoryLimit and Eviction bound the memory of the compressor like the ones of
+	// ptraceotlp.TraceZipSettings. Histogram bounds and resources are evicted.
+	MemoryLimit int
+	Eviction    EvictionPolicy
+}
+
+// TraceZipCompressor is the metrics counterpart of ptraceotlp.TraceZipCompressor.
+// It keeps one attribute trie per metric, so that the attribute set of a data point
+// is mostly a single path id, and dictionaries of the metric descriptors and of the
+// explicit bounds of histograms.
+//
+// Its dictionary updates have the same layout as the traces ones, with the parts
+// attribute names, attribute values, metrics, bounds, paths, orders and resources.
+type TraceZipCompressor struct {
+	mu       sync.Mutex
+	settings TraceZipSettings
+
+	dictionaryUuid string
+	sendDictFull   bool
+	// epoch and version number the dictionaries like the ones of the traces
+	// compressor
+	epoch   uint64
+	version uint64
+
+	// budget evicts from the dictionaries that no trie refers to; overBudget drops
+	// all dictionaries with the next payload
+	budget     *tracezip.Budget
+	overBudget bool
+	// holds are the payloads on their way to the receiver, see Hold
+	holds tracezip.Holds
+
+	sampler *tracezip.Sampler
+
+	attrNames  *tracezip.Dict
+	attrValues *tracezip.Dict
+	metrics    *tracezip.Dict
+	bounds     *tracezip.Dict
+	paths      *tracezip.PathDict
+	resources  *tracezip.Dict
+
+	// [metric] trie, attribute order and the same order as attribute name codes
+	tries     map[string]*tracezip.Trie
+	orders    map[string]map[string]bool
+	ordersZip map[string][]string
+
+	updateOrders []tracezip.UpdatesEntry
+}
+
+// NewTraceZipCompressor returns a compressor with empty dictionaries and a fresh dictionary uuid.
+func NewTraceZipCompressor(settings TraceZipSettings) *TraceZipCompressor {
+	c := &TraceZipCompressor{settings: settings}
+	c.reset()
+	return c
+}
+
+// Reset drops every trie, buffer and dictionary of the compressor and picks a new
+// dictionary uuid, so that the next call of MarshalWithTraceZip sends a full dictionary.
+func (c *TraceZipCompressor) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+}
+
+func (c *TraceZipCompressor) reset() {
+	c.dictionaryUuid = uuid.NewString()
+	c.sendDictFull = true
+	c.epoch = 0
+	c.version = 0
+	c.sampler = tracezip.NewSampler(c.settings.BufferSize, c.settings.BufferWindow)
+	c.clearDictionaries()
+}
+
+// clearDictionaries drops the tries and every dictionary, but keeps the sample
+// buffer and the dictionary uuid. The next payload must send a full dictionary.
+func (c *TraceZipCompressor) clearDictionaries() {
+	c.budget = tracezip.NewBudget(c.settings.MemoryLimit, c.settings.Eviction)
+	c.overBudget = false
+	c.attrNames = tracezip.NewDict()
+	c.metrics = tracezip.NewDict()
+	c.bounds = c.budget.NewDict()
+	c.resources = c.budget.NewDict()
+	c.rebuild()
+}
+
+// pinnedSize estimates the bytes of the state the budget can not evict from.
+func (c *TraceZipCompressor) pinnedSize() int {
+	return c.attrNames.Size() + c.attrValues.Size() + c.metrics.Size() + c.paths.Size()
+}
+
+// rebuild drops the tries and the dictionaries that grow with them.
+func (c *TraceZipCompressor) rebuild() {
+	c.attrValues = tracezip.NewDict()
+	c.paths = tracezip.NewPathDict()
+	c.tries = make(map[string]*tracezip.Trie)
+	c.orders = make(map[string]map[string]bool)
+	c.ordersZip = make(map[string][]string)
+	c.updateOrders = make([]tracezip.UpdatesEntry, 0)
+}
+
+// DictionaryUuid returns the uuid the receiver files this compressor's dictionary under.
+func (c *TraceZipCompressor) DictionaryUuid() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dictionaryUuid
+}
+
+// Version returns the epoch and the version of the dictionary, see
+// ptraceotlp.TraceZipCompressor.Version.
+func (c *TraceZipCompressor) Version() (uint64, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch, c.version
+}
+
+// Hold marks the payload last returned by MarshalWithTraceZip as on its way to the
+// receiver until Release is called with the returned token, see
+// ptraceotlp.TraceZipCompressor.Hold. Later payloads do not evict the entries it
+// uses.
+func (c *TraceZipCompressor) Hold() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.holds.Add(c.epoch, c.version, c.budget.Batch())
+}
+
+// Release ends the hold of token, once its payload was delivered or given up.
+func (c *TraceZipCompressor) Release(token uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.holds.Release(token)
+}
+
+// Floor returns the oldest version of the current epoch a held payload needs, or
+// the current version if none is held, see ptraceotlp.TraceZipCompressor.Floor.
+func (c *TraceZipCompressor) Floor() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, version, ok := c.holds.Oldest(c.epoch); ok {
+		return version
+	}
+	return c.version
+}
+
+// floorBatch returns the oldest budget batch a held payload of the current epoch
+// was compressed in, or the current batch if none is held.
+func (c *TraceZipCompressor) floorBatch() uint64 {
+	if batch, _, ok := c.holds.Oldest(c.epoch); ok {
+		return batch
+	}
+	return c.budget.Batch()
+}
+
+// Resync returns the complete dictionary of the compressor as a full dictionary of
+// a new epoch, for a receiver that lost track of the dictionary.
+func (c *TraceZipCompressor) Resync() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.version = 0
+	return c.sendFull()
+}
+
+// setOrder picks the trie attributes of metric from the sample buffer.
+func (c *TraceZipCompressor) setOrder(metric string) {
+	code, _ := c.metrics.Code(metric)
+	orderSet := make(map[string]bool)
+	orderZip := make([]string, 0)
+	for _, key := range c.sampler.Order(metric, c.settings.AttrLimit) {
+		orderSet[key] = true
+		keyCode, _ := c.attrNames.Code(key)
+		orderZip = append(orderZip, keyCode)
+	}
+	c.tries[metric] = &tracezip.Trie{}
+	c.orders[metric] = orderSet
+	c.ordersZip[code] = orderZip
+	value, _ := json.Marshal(orderZip)
+	c.updateOrders = append(c.updateOrders, tracezip.UpdatesEntry{Key: code, Value: string(value)})
+}
+
+func descriptorOf(metric *v1_metrics.Metric) string {
+	descriptor, _ := json.Marshal(MetricDescriptor{
+		Name:        metric.Name,
+		Description: metric.Description,
+		Unit:        metric.Unit,
+	})
+	return string(descriptor)
+}
+
+// dataPoint is what the data points of every metric type have in common.
+type dataPoint struct {
+	attributes []otlpcommon.KeyValue
+	startTime  uint64
+	time       uint64
+	flags      uint32
+	exemplars  []v1_metrics.Exemplar
+}
+
+// dataPoints returns the common part of the data points of metric.
+func dataPoints(metric *v1_metrics.Metric) []dataPoint {
+	var points []dataPoint
+	switch d := metric.Data.(type) {
+	case *v1_metrics.Metric_Gauge:
+		if d.Gauge != nil {
+			for _, p := range d.Gauge.DataPoints {
+				points = append(points, dataPoint{p.Attributes, p.StartTimeUnixNano, p.TimeUnixNano, p.Flags, p.Exemplars})
+			}
+		}
+	case *v1_metrics.Metric_Sum:
+		if d.Sum != nil {
+			for _, p := range d.Sum.DataPoints {
+				points = append(points, dataPoint{p.Attributes, p.StartTimeUnixNano, p.TimeUnixNano, p.Flags, p.Exemplars})
+			}
+		}
+	case *v1_metrics.Metric_Histogram:
+		if d.Histogram != nil {
+			for _, p := range d.Histogram.DataPoints {
+				points = append(points, dataPoint{p.Attributes, p.StartTimeUnixNano, p.TimeUnixNano, p.Flags, p.Exemplars})
+			}
+		}
+	case *v1_metrics.Metric_ExponentialHistogram:
+		if d.ExponentialHistogram != nil {
+			for _, p := range d.ExponentialHistogram.DataPoints {
+				points = append(points, dataPoint{p.Attributes, p.StartTimeUnixNano, p.TimeUnixNano, p.Flags, p.Exemplars})
+			}
+		}
+	case *v1_metrics.Metric_Summary:
+		if d.Summary != nil {
+			for _, p := range d.Summary.DataPoints {
+				points = append(points, dataPoint{p.Attributes, p.StartTimeUnixNano, p.TimeUnixNano, p.Flags, nil})
+			}
+		}
+	}
+	return points
+}
+
+// MarshalWithTraceZip compresses ms with TraceZip. It returns the dictionary uuid, a full
+// dictionary (only when one must be sent), an incremental dictionary update (only when
+// the dictionaries changed) and the compressed payload.
+func (c *TraceZipCompressor) MarshalWithTraceZip(ms ExportRequest, ExplictReset bool) (string, []interface{}, []interface{}, []ExportData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ExplictReset {
+		c.sendDictFull = true
+	}
+	if c.overBudget {
+		c.clearDictionaries()
+		c.sendDictFull = true
+	}
+	c.budget.Next()
+
+	// Update Buffer
+	now := time.Now()
+	var minTime tracezip.MinTime
+	newMetrics := make([]string, 0)
+	for _, resourceMetrics := range ms.orig.ResourceMetrics {
+		for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+			for _, metric := range scopeMetrics.Metrics {
+				descriptor := descriptorOf(metric)
+				c.metrics.Code(descriptor)
+				for _, point := range dataPoints(metric) {
+					minTime.Add(point.startTime)
+					minTime.Add(point.time)
+					fields := make([]tracezip.Field, 0, len(point.attributes))
+					for i := range point.attributes {
+						c.attrNames.Code(point.attributes[i].Key)
+						fields = append(fields, tracezip.Field{
+							Key:   point.attributes[i].Key,
+							Value: string(tracezip.MarshalAnyValue(&point.attributes[i].Value)),
+						})
+					}
+					if c.sampler.Add(now, descriptor, fields) {
+						newMetrics = append(newMetrics, descriptor)
+					}
+				}
+			}
+		}
+	}
+	if c.sendDictFull {
+		c.rebuild()
+		for _, metric := range c.sampler.Groups() {
+			c.setOrder(metric)
+		}
+	} else {
+		for _, metric := range newMetrics {
+			c.setOrder(metric)
+		}
+	}
+
+	export := make([]ExportData, 0, len(ms.orig.ResourceMetrics))
+	for _, resourceMetrics := range ms.orig.ResourceMetrics {
+		resourceMetrics_ := ExportData{
+			SchemaUrl:    resourceMetrics.SchemaUrl,
+			ScopeMetrics: make([]ScopeMetric, 0, len(resourceMetrics.ScopeMetrics)),
+		}
+		resourceMetrics_.ResourceId, _ = c.resources.Code(tracezip.MarshalResource(&resourceMetrics.Resource, c.settings.DeleteResource))
+		for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+			scopeMetrics_ := ScopeMetric{
+				SchemaUrl:  scopeMetrics.SchemaUrl,
+				Scope:      tracezip.MarshalScope(&scopeMetrics.Scope),
+				OffsetMain: uint64(minTime),
+				Metrics:    make([]MetricData, 0, len(scopeMetrics.Metrics)),
+			}
+			startTimes := tracezip.NewDeltaOfDelta(uint64(minTime))
+			times := tracezip.NewDeltaOfDelta(uint64(minTime))
+			for _, metric := range scopeMetrics.Metrics {
+				scopeMetrics_.Metrics = append(scopeMetrics_.Metrics, c.marshalMetric(metric, &startTimes, &times))
+			}
+			resourceMetrics_.ScopeMetrics = append(resourceMetrics_.ScopeMetrics, scopeMetrics_)
+		}
+		export = append(export, resourceMetrics_)
+	}
+
+	// Entries of this payload and of the held ones are not evicted, the receiver
+	// needs them to decode them.
+	if _, ok := c.budget.Evict(c.pinnedSize(), c.floorBatch()); !ok {
+		c.overBudget = true
+	}
+
+	var incrementUpdate []interface{}
+	var fullUpdate []interface{}
+	if c.sendDictFull {
+		fullUpdate = c.sendFull()
+		c.sendDictFull = false
+		c.epoch++
+		c.version = 0
+		c.clearUpdates()
+	} else {
+		update := []interface{}{
+			c.attrNames.TakeUpdates(),
+			c.attrValues.TakeUpdates(),
+			c.metrics.TakeUpdates(),
+			c.bounds.TakeUpdates(),
+			c.paths.TakeUpdates(),
+			c.updateOrders,
+			c.resources.TakeUpdates(),
+		}
+		c.updateOrders = make([]tracezip.UpdatesEntry, 0)
+		for _, part := range update {
+			if len(part.([]tracezip.UpdatesEntry)) > 0 {
+				incrementUpdate = update
+				c.version++
+				break
+			}
+		}
+	}
+
+	if c.paths.Len() > c.settings.ThresholdRate {
+		c.sendDictFull = true
+	}
+
+	return c.dictionaryUuid, fullUpdate, incrementUpdate, export
+}
+
+func (c *TraceZipCompressor) marshalMetric(metric *v1_metrics.Metric, startTimes, times *tracezip.DeltaOfDelta) MetricData {
+	descriptor := descriptorOf(metric)
+	metricCode, _ := c.metrics.Lookup(descriptor)
+	metric_ := MetricData{Metric: metricCode}
+
+	points := dataPoints(metric)
+	for i := range points {
+		point_ := PointData{
+			StartTime: startTimes.Encode(points[i].startTime),
+			Time:      times.Encode(points[i].time),
+			Flags:     points[i].flags,
+		}
+		c.marshalAttributes(descriptor, metricCode, points[i].attributes, &point_)
+		for j := range points[i].exemplars {
+			point_.Exemplars = append(point_.Exemplars, marshalExemplar(&points[i].exemplars[j], points[i].time))
+		}
+		metric_.Points = append(metric_.Points, point_)
+	}
+
+	// The type specific fields of the data points.
+	switch d := metric.Data.(type) {
+	case *v1_metrics.Metric_Gauge:
+		metric_.Type = MetricTypeGauge
+		if d.Gauge != nil {
+			for i, p := range d.Gauge.DataPoints {
+				marshalNumber(p, &metric_.Points[i])
+			}
+		}
+	case *v1_metrics.Metric_Sum:
+		metric_.Type = MetricTypeSum
+		if d.Sum != nil {
+			metric_.Temporality = d.Sum.AggregationTemporality
+			metric_.Monotonic = d.Sum.IsMonotonic
+			for i, p := range d.Sum.DataPoints {
+				marshalNumber(p, &metric_.Points[i])
+			}
+		}
+	case *v1_metrics.Metric_Histogram:
+		metric_.Type = MetricTypeHistogram
+		if d.Histogram != nil {
+			metric_.Temporality = d.Histogram.AggregationTemporality
+			for i, p := range d.Histogram.DataPoints {
+				c.marshalHistogram(p, &metric_.Points[i])
+			}
+		}
+	case *v1_metrics.Metric_ExponentialHistogram:
+		metric_.Type = MetricTypeExponentialHistogram
+		if d.ExponentialHistogram != nil {
+			metric_.Temporality = d.ExponentialHistogram.AggregationTemporality
+			for i, p := range d.ExponentialHistogram.DataPoints {
+				marshalExponentialHistogram(p, &metric_.Points[i])
+			}
+		}
+	case *v1_metrics.Metric_Summary:
+		metric_.Type = MetricTypeSummary
+		if d.Summary != nil {
+			for i, p := range d.Summary.DataPoints {
+				marshalSummary(p, &metric_.Points[i])
+			}
+		}
+	}
+	return metric_
+}
+
+func (c *TraceZipCompressor) marshalAttributes(metric string, metricCode string, attributes []otlpcommon.KeyValue, point_ *PointData) {
+	order := c.ordersZip[metricCode]
+	if len(order) > 0 {
+		path := make([]string, len(order))
+		for i := range path {
+			path[i] = tracezip.Missing
+		}
+		for i := range attributes {
+			if !c.orders[metric][attributes[i].Key] {
+				continue
+			}
+			keyCode, _ := c.attrNames.Lookup(attributes[i].Key)
+			for j := range order {
+				if order[j] == keyCode {
+					path[j], _ = c.attrValues.Code(string(tracezip.MarshalAnyValue(&attributes[i].Value)))
+				}
+			}
+		}
+		point_.PathHash, _ = c.tries[metric].Retrieve(c.paths, path)
+	}
+	for i := range attributes {
+		if c.orders[metric][attributes[i].Key] {
+			continue
+		}
+		keyCode, _ := c.attrNames.Lookup(attributes[i].Key)
+		point_.Attributes = append(point_.Attributes, tracezip.CodedAttribute{
+			Key:   keyCode,
+			Value: tracezip.EncodeValue(&attributes[i].Value, tracezip.DictCoder{Names: c.attrNames, Values: c.attrValues}),
+		})
+	}
+}
+
+func double(v float64) *tracezip.Double {
+	d := tracezip.Double(v)
+	return &d
+}
+
+func marshalNumber(p *v1_metrics.NumberDataPoint, point_ *PointData) {
+	switch v := p.Value.(type) {
+	case *v1_metrics.NumberDataPoint_AsDouble:
+		point_.Double = double(v.AsDouble)
+	case *v1_metrics.NumberDataPoint_AsInt:
+		asInt := v.AsInt
+		point_.Int = &asInt
+	}
+}
+
+func (c *TraceZipCompressor) marshalHistogram(p *v1_metrics.HistogramDataPoint, point_ *PointData) {
+	point_.Count = p.Count
+	if sum, ok := p.Sum_.(*v1_metrics.HistogramDataPoint_Sum); ok {
+		point_.Sum = double(sum.Sum)
+	}
+	if min, ok := p.Min_.(*v1_metrics.HistogramDataPoint_Min); ok {
+		point_.Min = double(min.Min)
+	}
+	if max, ok := p.Max_.(*v1_metrics.HistogramDataPoint_Max); ok {
+		point_.Max = double(max.Max)
+	}
+	point_.BucketCounts = p.BucketCounts
+	// Every data point of a histogram usually has the same bounds.
+	if len(p.ExplicitBounds) > 0 {
+		bounds := make([]tracezip.Double, len(p.ExplicitBounds))
+		for i, bound := range p.ExplicitBounds {
+			bounds[i] = tracezip.Double(bound)
+		}
+		value, _ := json.Marshal(bounds)
+		point_.Bounds, _ = c.bounds.Code(string(value))
+	}
+}
+
+func marshalExponentialHistogram(p *v1_metrics.ExponentialHistogramDataPoint, point_ *PointData) {
+	point_.Count = p.Count
+	if sum, ok := p.Sum_.(*v1_metrics.ExponentialHistogramDataPoint_Sum); ok {
+		point_.Sum = double(sum.Sum)
+	}
+	if min, ok := p.Min_.(*v1_metrics.ExponentialHistogramDataPoint_Min); ok {
+		point_.Min = double(min.Min)
+	}
+	if max, ok := p.Max_.(*v1_metrics.ExponentialHistogramDataPoint_Max); ok {
+		point_.Max = double(max.Max)
+	}
+	point_.Scale = p.Scale
+	point_.ZeroCount = p.ZeroCount
+	point_.ZeroThreshold = tracezip.Double(p.ZeroThreshold)
+	point_.Positive = marshalBuckets(&p.Positive)
+	point_.Negative = marshalBuckets(&p.Negative)
+}
+
+func marshalBuckets(b *v1_metrics.ExponentialHistogramDataPoint_Buckets) *Buckets {
+	if b.Offset == 0 && len(b.BucketCounts) == 0 {
+		return nil
+	}
+	return &Buckets{Offset: b.Offset, BucketCounts: b.BucketCounts}
+}
+
+func marshalSummary(p *v1_metrics.SummaryDataPoint, point_ *PointData) {
+	point_.Count = p.Count
+	point_.Sum = double(p.Sum)
+	for _, q := range p.QuantileValues {
+		point_.Quantiles = append(point_.Quantiles, Quantile{
+			Quantile: tracezip.Double(q.Quantile),
+			Value:    tracezip.Double(q.Value),
+		})
+	}
+}
+
+func marshalExemplar(e *v1_metrics.Exemplar, pointTime uint64) ExemplarData {
+	e_ := ExemplarData{
+		Attributes: tracezip.MarshalAttributes(e.FilteredAttributes),
+		Time:       int64(e.TimeUnixNano - pointTime),
+		SpanId:     e.SpanId,
+		TraceId:    e.TraceId,
+	}
+	switch v := e.Value.(type) {
+	case *v1_metrics.Exemplar_AsDouble:
+		e_.Double = double(v.AsDouble)
+	case *v1_metrics.Exemplar_AsInt:
+		asInt := v.AsInt
+		e_.Int = &asInt
+	}
+	return e_
+}
+
+func (c *TraceZipCompressor) clearUpdates() {
+	c.attrNames.TakeUpdates()
+	c.attrValues.TakeUpdates()
+	c.metrics.TakeUpdates()
+	c.bounds.TakeUpdates()
+	c.paths.TakeUpdates()
+	c.updateOrders = make([]tracezip.UpdatesEntry, 0)
+	c.resources.TakeUpdates()
+}
+
+// SendFull returns the complete dictionary of the compressor, in the layout of a full update.
+func (c *TraceZipCompressor) SendFull() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sendFull()
+}
+
+func (c *TraceZipCompressor) sendFull() []interface{} {
+	return []interface{}{
+		c.attrNames.Values(),
+		c.attrValues.Values(),
+		c.metrics.Values(),
+		c.bounds.Values(),
+		c.paths.Values(),
+		c.ordersZip,
+		c.resources.Values(),
+	}
+}
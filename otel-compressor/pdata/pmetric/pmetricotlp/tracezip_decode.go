@@ -0,0 +1,420 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package pmetricotlp // import "go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.opentelemetry.io/collector/pdata/internal"
+	otlpcollectormetrics "go.opentelemetry.io/collector/pdata/internal/data/protogen/collector/metrics/v1"
+	otlpcommon "go.opentelemetry.io/collector/pdata/internal/data/protogen/common/v1"
+	v1_metrics "go.opentelemetry.io/collector/pdata/internal/data/protogen/metrics/v1"
+	"go.opentelemetry.io/collector/pdata/internal/tracezip"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// TraceZipDictionary is the receiver side copy of the dictionaries of one
+// TraceZipCompressor. It is kept up to date with the full and incremental updates
+// returned by MarshalWithTraceZip and is needed to decode the compressor's payloads.
+type TraceZipDictionary struct {
+	AttributeNameDict  map[string]string
+	AttributeValueDict map[string]string
+	MetricDict         map[string]string
+	BoundsDict         map[string]string
+	PathDict           map[string][]string
+	Orders             map[string][]string
+	ResourceDict       map[string]string
+}
+
+// NewTraceZipDictionary returns an empty dictionary.
+func NewTraceZipDictionary() *TraceZipDictionary {
+	return &TraceZipDictionary{
+		AttributeNameDict:  make(map[string]string),
+		AttributeValueDict: make(map[string]string),
+		MetricDict:         make(map[string]string),
+		BoundsDict:         make(map[string]string),
+		PathDict:           make(map[string][]string),
+		Orders:             make(map[string][]string),
+		ResourceDict:       make(map[string]string),
+	}
+}
+
+// FullUpdate replaces the whole dictionary with a full update, in the layout
+// produced by MarshalWithTraceZip.
+func (cd *TraceZipDictionary) FullUpdate(data []json.RawMessage) error {
+	fresh := NewTraceZipDictionary()
+	err := tracezip.UnmarshalFull(data, []interface{}{
+		&fresh.AttributeNameDict,
+		&fresh.AttributeValueDict,
+		&fresh.MetricDict,
+		&fresh.BoundsDict,
+		&fresh.PathDict,
+		&fresh.Orders,
+		&fresh.ResourceDict,
+	}, "metrics")
+	if err != nil {
+		return err
+	}
+	*cd = *fresh
+	// A dictionary may be sent as JSON null if it is empty.
+	cd.ensureMaps()
+	return nil
+}
+
+// IncrementUpdate adds the entries of an incremental update, in the layout
+// produced by MarshalWithTraceZip, to the dictionary.
+func (cd *TraceZipDictionary) IncrementUpdate(data []json.RawMessage) error {
+	updates, err := tracezip.UnmarshalUpdates(data, 7, "metrics")
+	if err != nil {
+		return err
+	}
+	// Decode the JSON encoded entries before touching the dictionary, so that a
+	// broken update leaves it as it was.
+	paths, err := tracezip.UnmarshalArrays(updates[4])
+	if err != nil {
+		return fmt.Errorf("path: %w", err)
+	}
+	orders, err := tracezip.UnmarshalArrays(updates[5])
+	if err != nil {
+		return fmt.Errorf("order: %w", err)
+	}
+
+	cd.ensureMaps()
+	tracezip.ApplyUpdates(cd.AttributeNameDict, updates[0])
+	tracezip.ApplyUpdates(cd.AttributeValueDict, updates[1])
+	tracezip.ApplyUpdates(cd.MetricDict, updates[2])
+	tracezip.ApplyUpdates(cd.BoundsDict, updates[3])
+	for k, v := range paths {
+		cd.PathDict[k] = v
+	}
+	for k, v := range orders {
+		cd.Orders[k] = v
+	}
+	tracezip.ApplyUpdates(cd.ResourceDict, updates[6])
+	return nil
+}
+
+// Size estimates the bytes the dictionary takes in memory, the way the compressor
+// counts its memory_limit.
+func (cd *TraceZipDictionary) Size() int {
+	size := tracezip.MapSize(cd.AttributeNameDict, cd.AttributeValueDict, cd.MetricDict, cd.BoundsDict, cd.ResourceDict) + tracezip.PathsSize(cd.PathDict, cd.Orders)
+	return size
+}
+
+func (cd *TraceZipDictionary) ensureMaps() {
+	if cd.AttributeNameDict == nil {
+		cd.AttributeNameDict = make(map[string]string)
+	}
+	if cd.AttributeValueDict == nil {
+		cd.AttributeValueDict = make(map[string]string)
+	}
+	if cd.MetricDict == nil {
+		cd.MetricDict = make(map[string]string)
+	}
+	if cd.BoundsDict == nil {
+		cd.BoundsDict = make(map[string]string)
+	}
+	if cd.PathDict == nil {
+		cd.PathDict = make(map[string][]string)
+	}
+	if cd.Orders == nil {
+		cd.Orders = make(map[string][]string)
+	}
+	if cd.ResourceDict == nil {
+		cd.ResourceDict = make(map[string]string)
+	}
+}
+
+// UnmarshalWithTraceZip is the inverse of MarshalWithTraceZip: it decodes the JSON
+// form of a compressed payload with the dictionary it was compressed against.
+func UnmarshalWithTraceZip(dict *TraceZipDictionary, data []byte) (pmetric.Metrics, error) {
+	var export []ExportData
+	if err := json.Unmarshal(data, &export); err != nil {
+		return pmetric.Metrics{}, err
+	}
+	return DecodeWithTraceZip(dict, export)
+}
+
+// DecodeWithTraceZip rebuilds the metrics of a compressed payload with the dictionary
+// it was compressed against.
+func DecodeWithTraceZip(dict *TraceZipDictionary, export []ExportData) (pmetric.Metrics, error) {
+	if dict == nil {
+		return pmetric.Metrics{}, fmt.Errorf("no dictionary to decode with")
+	}
+	orig := &otlpcollectormetrics.ExportMetricsServiceRequest{}
+	for _, resourceMetrics_ := range export {
+		resourceMetrics := &v1_metrics.ResourceMetrics{SchemaUrl: resourceMetrics_.SchemaUrl}
+		resource, ok := dict.ResourceDict[resourceMetrics_.ResourceId]
+		if !ok {
+			return pmetric.Metrics{}, fmt.Errorf("no such resource %q", resourceMetrics_.ResourceId)
+		}
+		err := tracezip.DecodeResource(resource, &resourceMetrics.Resource)
+		if err != nil {
+			return pmetric.Metrics{}, err
+		}
+		for _, scopeMetrics_ := range resourceMetrics_.ScopeMetrics {
+			scopeMetrics := &v1_metrics.ScopeMetrics{SchemaUrl: scopeMetrics_.SchemaUrl}
+			if err = tracezip.DecodeScope(&scopeMetrics_.Scope, &scopeMetrics.Scope); err != nil {
+				return pmetric.Metrics{}, err
+			}
+			startTimes := tracezip.NewDeltaOfDelta(scopeMetrics_.OffsetMain)
+			times := tracezip.NewDeltaOfDelta(scopeMetrics_.OffsetMain)
+			for i := range scopeMetrics_.Metrics {
+				metric, err := dict.decodeMetric(&scopeMetrics_.Metrics[i], &startTimes, &times)
+				if err != nil {
+					return pmetric.Metrics{}, err
+				}
+				scopeMetrics.Metrics = append(scopeMetrics.Metrics, metric)
+			}
+			resourceMetrics.ScopeMetrics = append(resourceMetrics.ScopeMetrics, scopeMetrics)
+		}
+		orig.ResourceMetrics = append(orig.ResourceMetrics, resourceMetrics)
+	}
+	state := internal.StateMutable
+	return pmetric.Metrics(internal.NewMetrics(orig, &state)), nil
+}
+
+func (cd *TraceZipDictionary) decodeMetric(metric_ *MetricData, startTimes, times *tracezip.DeltaOfDelta) (*v1_metrics.Metric, error) {
+	descriptor_, ok := cd.MetricDict[metric_.Metric]
+	if !ok {
+		return nil, fmt.Errorf("no such metric %q", metric_.Metric)
+	}
+	var descriptor MetricDescriptor
+	if err := json.Unmarshal([]byte(descriptor_), &descriptor); err != nil {
+		return nil, fmt.Errorf("metric %q: %w", metric_.Metric, err)
+	}
+	metric := &v1_metrics.Metric{
+		Name:        descriptor.Name,
+		Description: descriptor.Description,
+		Unit:        descriptor.Unit,
+	}
+
+	points := make([]dataPoint, len(metric_.Points))
+	for i := range metric_.Points {
+		point_ := &metric_.Points[i]
+		points[i].startTime = startTimes.Decode(point_.StartTime)
+		points[i].time = times.Decode(point_.Time)
+		points[i].flags = point_.Flags
+		attrs, err := cd.decodeAttributes(metric_.Metric, point_)
+		if err != nil {
+			return nil, err
+		}
+		points[i].attributes = attrs
+		for j := range point_.Exemplars {
+			exemplar, err := decodeExemplar(&point_.Exemplars[j], points[i].time)
+			if err != nil {
+				return nil, err
+			}
+			points[i].exemplars = append(points[i].exemplars, exemplar)
+		}
+	}
+
+	switch metric_.Type {
+	case MetricTypeEmpty:
+		if len(points) > 0 {
+			return nil, fmt.Errorf("metric %q has no type, but %d data points", descriptor.Name, len(points))
+		}
+	case MetricTypeGauge:
+		gauge := &v1_metrics.Gauge{}
+		for i := range points {
+			gauge.DataPoints = append(gauge.DataPoints, decodeNumber(&points[i], &metric_.Points[i]))
+		}
+		metric.Data = &v1_metrics.Metric_Gauge{Gauge: gauge}
+	case MetricTypeSum:
+		sum := &v1_metrics.Sum{
+			AggregationTemporality: metric_.Temporality,
+			IsMonotonic:            metric_.Monotonic,
+		}
+		for i := range points {
+			sum.DataPoints = append(sum.DataPoints, decodeNumber(&points[i], &metric_.Points[i]))
+		}
+		metric.Data = &v1_metrics.Metric_Sum{Sum: sum}
+	case MetricTypeHistogram:
+		histogram := &v1_metrics.Histogram{AggregationTemporality: metric_.Temporality}
+		for i := range points {
+			p, err := cd.decodeHistogram(&points[i], &metric_.Points[i])
+			if err != nil {
+				return nil, err
+			}
+			histogram.DataPoints = append(histogram.DataPoints, p)
+		}
+		metric.Data = &v1_metrics.Metric_Histogram{Histogram: histogram}
+	case MetricTypeExponentialHistogram:
+		histogram := &v1_metrics.ExponentialHistogram{AggregationTemporality: metric_.Temporality}
+		for i := range points {
+			histogram.DataPoints = append(histogram.DataPoints, decodeExponentialHistogram(&points[i], &metric_.Points[i]))
+		}
+		metric.Data = &v1_metrics.Metric_ExponentialHistogram{ExponentialHistogram: histogram}
+	case MetricTypeSummary:
+		summary := &v1_metrics.Summary{}
+		for i := range points {
+			summary.DataPoints = append(summary.DataPoints, decodeSummary(&points[i], &metric_.Points[i]))
+		}
+		metric.Data = &v1_metrics.Metric_Summary{Summary: summary}
+	default:
+		return nil, fmt.Errorf("metric %q has unknown type %d", descriptor.Name, metric_.Type)
+	}
+	return metric, nil
+}
+
+func (cd *TraceZipDictionary) decodeAttributes(metricCode string, point_ *PointData) ([]otlpcommon.KeyValue, error) {
+	attrs, err := tracezip.DecodeCodedAttributes(point_.Attributes, tracezip.ValueDicts{Names: cd.AttributeNameDict, Values: cd.AttributeValueDict})
+	if err != nil {
+		return nil, err
+	}
+
+	if point_.PathHash != "" {
+		pathArray, ok := cd.PathDict[point_.PathHash]
+		if !ok {
+			return nil, fmt.Errorf("no such pathId %q", point_.PathHash)
+		}
+		order := cd.Orders[metricCode]
+		if len(pathArray) != len(order) {
+			return nil, fmt.Errorf("path %q has %d attributes, but metric %q orders %d", point_.PathHash, len(pathArray), metricCode, len(order))
+		}
+		for index, attr := range order {
+			if pathArray[index] == tracezip.Missing {
+				continue
+			}
+			key, ok := cd.AttributeNameDict[attr]
+			if !ok {
+				return nil, fmt.Errorf("no such attribute name %q", attr)
+			}
+			value, ok := cd.AttributeValueDict[pathArray[index]]
+			if !ok {
+				return nil, fmt.Errorf("no such attribute value %q", pathArray[index])
+			}
+			kv := otlpcommon.KeyValue{Key: key}
+			if err := tracezip.UnmarshalAnyValue([]byte(value), &kv.Value); err != nil {
+				return nil, fmt.Errorf("attribute %q: %w", key, err)
+			}
+			attrs = append(attrs, kv)
+		}
+	}
+	return attrs, nil
+}
+
+func decodeExemplar(e_ *ExemplarData, pointTime uint64) (v1_metrics.Exemplar, error) {
+	e := v1_metrics.Exemplar{
+		TimeUnixNano: pointTime + uint64(e_.Time),
+		SpanId:       e_.SpanId,
+		TraceId:      e_.TraceId,
+	}
+	attrs, err := tracezip.DecodeAttributes(e_.Attributes)
+	if err != nil {
+		return e, fmt.Errorf("exemplar: %w", err)
+	}
+	e.FilteredAttributes = attrs
+	switch {
+	case e_.Double != nil:
+		e.Value = &v1_metrics.Exemplar_AsDouble{AsDouble: float64(*e_.Double)}
+	case e_.Int != nil:
+		e.Value = &v1_metrics.Exemplar_AsInt{AsInt: *e_.Int}
+	}
+	return e, nil
+}
+
+func decodeNumber(point *dataPoint, point_ *PointData) *v1_metrics.NumberDataPoint {
+	p := &v1_metrics.NumberDataPoint{
+		Attributes:        point.attributes,
+		StartTimeUnixNano: point.startTime,
+		TimeUnixNano:      point.time,
+		Exemplars:         point.exemplars,
+		Flags:             point.flags,
+	}
+	switch {
+	case point_.Double != nil:
+		p.Value = &v1_metrics.NumberDataPoint_AsDouble{AsDouble: float64(*point_.Double)}
+	case point_.Int != nil:
+		p.Value = &v1_metrics.NumberDataPoint_AsInt{AsInt: *point_.Int}
+	}
+	return p
+}
+
+func (cd *TraceZipDictionary) decodeHistogram(point *dataPoint, point_ *PointData) (*v1_metrics.HistogramDataPoint, error) {
+	p := &v1_metrics.HistogramDataPoint{
+		Attributes:        point.attributes,
+		StartTimeUnixNano: point.startTime,
+		TimeUnixNano:      point.time,
+		Count:             point_.Count,
+		BucketCounts:      point_.BucketCounts,
+		Exemplars:         point.exemplars,
+		Flags:             point.flags,
+	}
+	if point_.Sum != nil {
+		p.Sum_ = &v1_metrics.HistogramDataPoint_Sum{Sum: float64(*point_.Sum)}
+	}
+	if point_.Min != nil {
+		p.Min_ = &v1_metrics.HistogramDataPoint_Min{Min: float64(*point_.Min)}
+	}
+	if point_.Max != nil {
+		p.Max_ = &v1_metrics.HistogramDataPoint_Max{Max: float64(*point_.Max)}
+	}
+	if point_.Bounds != "" {
+		bounds_, ok := cd.BoundsDict[point_.Bounds]
+		if !ok {
+			return nil, fmt.Errorf("no such bounds %q", point_.Bounds)
+		}
+		var bounds []tracezip.Double
+		if err := json.Unmarshal([]byte(bounds_), &bounds); err != nil {
+			return nil, fmt.Errorf("bounds %q: %w", point_.Bounds, err)
+		}
+		p.ExplicitBounds = make([]float64, len(bounds))
+		for i, bound := range bounds {
+			p.ExplicitBounds[i] = float64(bound)
+		}
+	}
+	return p, nil
+}
+
+func decodeExponentialHistogram(point *dataPoint, point_ *PointData) *v1_metrics.ExponentialHistogramDataPoint {
+	p := &v1_metrics.ExponentialHistogramDataPoint{
+		Attributes:        point.attributes,
+		StartTimeUnixNano: point.startTime,
+		TimeUnixNano:      point.time,
+		Count:             point_.Count,
+		Scale:             point_.Scale,
+		ZeroCount:         point_.ZeroCount,
+		Flags:             point.flags,
+		Exemplars:         point.exemplars,
+		ZeroThreshold:     float64(point_.ZeroThreshold),
+	}
+	if point_.Sum != nil {
+		p.Sum_ = &v1_metrics.ExponentialHistogramDataPoint_Sum{Sum: float64(*point_.Sum)}
+	}
+	if point_.Min != nil {
+		p.Min_ = &v1_metrics.ExponentialHistogramDataPoint_Min{Min: float64(*point_.Min)}
+	}
+	if point_.Max != nil {
+		p.Max_ = &v1_metrics.ExponentialHistogramDataPoint_Max{Max: float64(*point_.Max)}
+	}
+	if point_.Positive != nil {
+		p.Positive = v1_metrics.ExponentialHistogramDataPoint_Buckets{Offset: point_.Positive.Offset, BucketCounts: point_.Positive.BucketCounts}
+	}
+	if point_.Negative != nil {
+		p.Negative = v1_metrics.ExponentialHistogramDataPoint_Buckets{Offset: point_.Negative.Offset, BucketCounts: point_.Negative.BucketCounts}
+	}
+	return p
+}
+
+func decodeSummary(point *dataPoint, point_ *PointData) *v1_metrics.SummaryDataPoint {
+	p := &v1_metrics.SummaryDataPoint{
+		Attributes:        point.attributes,
+		StartTimeUnixNano: point.startTime,
+		TimeUnixNano:      point.time,
+		Count:             point_.Count,
+		Flags:             point.flags,
+	}
+	if point_.Sum != nil {
+		p.Sum = float64(*point_.Sum)
+	}
+	for _, q := range point_.Quantiles {
+		p.QuantileValues = append(p.QuantileValues, &v1_metrics.SummaryDataPoint_ValueAtQuantile{
+			Quantile: float64(q.Quantile),
+			Value:    float64(q.Value),
+		})
+	}
+	return p
+}
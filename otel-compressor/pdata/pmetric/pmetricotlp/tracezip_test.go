@@ -0,0 +1,295 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package pmetricotlp
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/collector/pdata/internal"
+	otlpcommon "go.opentelemetry.io/collector/pdata/internal/data/protogen/common/v1"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func newTraceZipTestMetrics(batch int) pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.SetSchemaUrl("https://opentelemetry.io/schemas/1.21.0")
+	rm.Resource().Attributes().PutStr("service.name", "ts-order-service")
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName("io.opentelemetry.runtime-telemetry-java8")
+	sm.Scope().SetVersion("1.32.0")
+
+	start := pcommon.Timestamp(1700000000000000000)
+	now := start + pcommon.Timestamp(batch)*10000000000
+
+	requests := sm.Metrics().AppendEmpty()
+	requests.SetName("http.server.requests")
+	requests.SetDescription("The number of requests")
+	requests.SetUnit("{request}")
+	sum := requests.SetEmptySum()
+	sum.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	sum.SetIsMonotonic(true)
+	for i := 0; i < 6; i++ {
+		dp := sum.DataPoints().AppendEmpty()
+		dp.SetStartTimestamp(start)
+		dp.SetTimestamp(now)
+		dp.SetIntValue(int64(batch*100 + i))
+		dp.Attributes().PutStr("http.method", []string{"GET", "POST"}[i%2])
+		dp.Attributes().PutInt("http.status_code", int64([]int{200, 404, 500}[i%3]))
+		dp.Attributes().PutStr("pod.uid", "uid-"+string(rune('a'+batch))+string(rune('a'+i)))
+	}
+
+	memory := sm.Metrics().AppendEmpty()
+	memory.SetName("process.runtime.jvm.memory.usage")
+	memory.SetUnit("By")
+	gauge := memory.SetEmptyGauge()
+	for i := 0; i < 4; i++ {
+		dp := gauge.DataPoints().AppendEmpty()
+		// Unset and uneven timestamps must survive the delta of delta encoding.
+		if i != 2 {
+			dp.SetTimestamp(now + pcommon.Timestamp(i*i))
+		}
+		dp.SetDoubleValue([]float64{0.5, math.Inf(1), -0.25, 1e300}[i])
+		dp.Attributes().PutStr("pool", []string{"G1 Eden Space", "G1 Old Gen"}[i%2])
+		if i == 1 {
+			dp.SetFlags(pmetric.DefaultDataPointFlags.WithNoRecordedValue(true))
+			ex := dp.Exemplars().AppendEmpty()
+			ex.SetTimestamp(now - 5)
+			ex.SetDoubleValue(3.25)
+			ex.SetTraceID(pcommon.TraceID{1, 2, byte(batch)})
+			ex.SetSpanID(pcommon.SpanID{3, 4})
+			ex.FilteredAttributes().PutStr("thread.name", "main")
+		}
+	}
+
+	duration := sm.Metrics().AppendEmpty()
+	duration.SetName("http.server.duration")
+	duration.SetUnit("ms")
+	histogram := duration.SetEmptyHistogram()
+	histogram.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	for i := 0; i < 3; i++ {
+		dp := histogram.DataPoints().AppendEmpty()
+		dp.SetStartTimestamp(now - 10000000000)
+		dp.SetTimestamp(now)
+		dp.SetCount(uint64(10 + i))
+		dp.SetSum(float64(i) * 1.5)
+		if i != 0 {
+			dp.SetMin(0.1)
+			dp.SetMax(9.9)
+		}
+		dp.ExplicitBounds().FromRaw([]float64{0, 5, 10, 25})
+		dp.BucketCounts().FromRaw([]uint64{1, 2, 3, uint64(4 + i), 0})
+		dp.Attributes().PutStr("http.route", []string{"/order", "/pay", "/order"}[i])
+	}
+
+	latency := sm.Metrics().AppendEmpty()
+	latency.SetName("rpc.latency")
+	exponential := latency.SetEmptyExponentialHistogram()
+	exponential.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	edp := exponential.DataPoints().AppendEmpty()
+	edp.SetTimestamp(now)
+	edp.SetCount(7)
+	edp.SetSum(12)
+	edp.SetScale(-2)
+	edp.SetZeroCount(1)
+	edp.SetZeroThreshold(1e-9)
+	edp.Positive().SetOffset(-3)
+	edp.Positive().BucketCounts().FromRaw([]uint64{1, 0, 5})
+
+	gc := sm.Metrics().AppendEmpty()
+	gc.SetName("jvm.gc.pause")
+	summary := gc.SetEmptySummary()
+	sdp := summary.DataPoints().AppendEmpty()
+	sdp.SetStartTimestamp(start)
+	sdp.SetTimestamp(now)
+	sdp.SetCount(3)
+	sdp.SetSum(math.NaN())
+	q := sdp.QuantileValues().AppendEmpty()
+	q.SetQuantile(0.99)
+	q.SetValue(12.5)
+
+	sm.Metrics().AppendEmpty().SetName("empty")
+	return md
+}
+
+// normalizeMetrics sorts data point attributes by key, since the decoder returns the
+// attributes of the trie after the remaining ones, and turns NaN into a value that
+// compares equal to itself.
+func normalizeMetrics(md pmetric.Metrics) pmetric.Metrics {
+	sortAttributes := func(attrs []otlpcommon.KeyValue) {
+		sort.SliceStable(attrs, func(i, j int) bool {
+			return attrs[i].Key < attrs[j].Key
+		})
+	}
+	orig := internal.GetOrigMetrics(internal.Metrics(md))
+	for _, rm := range orig.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				switch {
+				case m.GetSum() != nil:
+					for _, dp := range m.GetSum().DataPoints {
+						sortAttributes(dp.Attributes)
+					}
+				case m.GetGauge() != nil:
+					for _, dp := range m.GetGauge().DataPoints {
+						sortAttributes(dp.Attributes)
+					}
+				case m.GetHistogram() != nil:
+					for _, dp := range m.GetHistogram().DataPoints {
+						sortAttributes(dp.Attributes)
+					}
+				case m.GetSummary() != nil:
+					for _, dp := range m.GetSummary().DataPoints {
+						if math.IsNaN(dp.Sum) {
+							dp.Sum = -1
+						}
+					}
+				}
+			}
+		}
+	}
+	return md
+}
+
+// applyTraceZipUpdate sends the dictionary updates through JSON, the way the exporter does.
+func applyTraceZipUpdate(t *testing.T, dict *TraceZipDictionary, full []interface{}, increment []interface{}) {
+	var parts []json.RawMessage
+	if full != nil {
+		raw, err := json.Marshal(full)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(raw, &parts))
+		require.NoError(t, dict.FullUpdate(parts))
+	} else if increment != nil {
+		raw, err := json.Marshal(increment)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(raw, &parts))
+		require.NoError(t, dict.IncrementUpdate(parts))
+	}
+}
+
+func TestTraceZipRoundTrip(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:    100,
+		AttrLimit:     5,
+		ThresholdRate: 1000,
+	})
+	dict := NewTraceZipDictionary()
+
+	for batch := 0; batch < 5; batch++ {
+		md := newTraceZipTestMetrics(batch)
+		expected := pmetric.NewMetrics()
+		md.CopyTo(expected)
+
+		_, full, increment, export := c.MarshalWithTraceZip(NewExportRequestFromMetrics(md), batch == 3)
+		if batch == 0 || batch == 3 {
+			assert.NotNil(t, full)
+		} else {
+			assert.Nil(t, full)
+		}
+		applyTraceZipUpdate(t, dict, full, increment)
+		payload, err := json.Marshal(export)
+		require.NoError(t, err)
+
+		// MarshalWithTraceZip must not touch its input.
+		assert.Equal(t, normalizeMetrics(expected), normalizeMetrics(md))
+
+		actual, err := UnmarshalWithTraceZip(dict, payload)
+		require.NoError(t, err)
+		assert.Equal(t, normalizeMetrics(expected), normalizeMetrics(actual))
+	}
+}
+
+func TestTraceZipPathsAndTimestamps(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:    100,
+		AttrLimit:     5,
+		ThresholdRate: 1000,
+	})
+	_, full, _, export := c.MarshalWithTraceZip(NewExportRequestFromMetrics(newTraceZipTestMetrics(0)), false)
+	require.NotNil(t, full)
+
+	// One descriptor per metric, and the histogram bounds once.
+	assert.Len(t, full[2], 6)
+	assert.Len(t, full[3], 1)
+
+	requests := export[0].ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, MetricTypeSum, requests.Type)
+	for i, point := range requests.Points {
+		// http.method and http.status_code are in the trie, pod.uid is not.
+		assert.NotEmpty(t, point.PathHash)
+		require.Len(t, point.Attributes, 1)
+		if i > 1 {
+			// Every data point has the same timestamps, so past the first two the
+			// delta of delta is 0.
+			assert.Zero(t, point.StartTime)
+			assert.Zero(t, point.Time)
+		}
+	}
+
+	// Known metrics and paths, nothing is sent.
+	_, full, increment, _ := c.MarshalWithTraceZip(NewExportRequestFromMetrics(newTraceZipTestMetrics(0)), false)
+	assert.Nil(t, full)
+	assert.Nil(t, increment)
+}
+
+func TestTraceZipDecodeUnknownMetric(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{BufferSize: 10, AttrLimit: 5, ThresholdRate: 1000})
+	_, _, _, export := c.MarshalWithTraceZip(NewExportRequestFromMetrics(newTraceZipTestMetrics(0)), false)
+
+	// The full dictionary was never applied.
+	_, err := DecodeWithTraceZip(NewTraceZipDictionary(), export)
+	assert.Error(t, err)
+	_, err = DecodeWithTraceZip(nil, export)
+	assert.Error(t, err)
+}
+
+func TestTraceZipSnapshot(t *testing.T) {
+	settings := TraceZipSettings{
+		BufferSize:    100,
+		AttrLimit:     5,
+		ThresholdRate: 1000,
+	}
+	c := NewTraceZipCompressor(settings)
+	dict := NewTraceZipDictionary()
+	for batch := 0; batch < 3; batch++ {
+		_, full, increment, _ := c.MarshalWithTraceZip(NewExportRequestFromMetrics(newTraceZipTestMetrics(batch)), false)
+		applyTraceZipUpdate(t, dict, full, increment)
+	}
+	snapshot, err := c.Snapshot()
+	require.NoError(t, err)
+
+	restored := NewTraceZipCompressor(settings)
+	require.NoError(t, restored.Restore(snapshot))
+	epoch, version := c.Version()
+	restoredEpoch, restoredVersion := restored.Version()
+	assert.Equal(t, epoch, restoredEpoch)
+	assert.Equal(t, version, restoredVersion)
+	for batch := 3; batch < 6; batch++ {
+		data := newTraceZipTestMetrics(batch)
+		expected := pmetric.NewMetrics()
+		data.CopyTo(expected)
+
+		uuid, full, increment, export := restored.MarshalWithTraceZip(NewExportRequestFromMetrics(data), false)
+		assert.Equal(t, c.DictionaryUuid(), uuid)
+		assert.Nil(t, full)
+		applyTraceZipUpdate(t, dict, full, increment)
+		payload, err := json.Marshal(export)
+		require.NoError(t, err)
+		actual, err := UnmarshalWithTraceZip(dict, payload)
+		require.NoError(t, err)
+		assert.Equal(t, normalizeMetrics(expected), normalizeMetrics(actual))
+	}
+
+	other := NewTraceZipDictionary()
+	applyTraceZipUpdate(t, other, restored.Resync(), nil)
+	assert.Equal(t, dict, other)
+	assert.Error(t, restored.Restore([]byte(`{"uuid":"x","orders":{"0":["0"]}}`)))
+}
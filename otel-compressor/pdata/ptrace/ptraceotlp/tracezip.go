@@ -0,0 +1,1072 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ptraceotlp // import "go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+
+import (
+	"container/list"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/collector/pdata/internal/data"
+	otlpcommon "go.opentelemetry.io/collector/pdata/internal/data/protogen/common/v1"
+	v1_trace "go.opentelemetry.io/collector/pdata/internal/data/protogen/trace/v1"
+	"go.opentelemetry.io/collector/pdata/internal/tracezip"
+)
+
+const NO_PATH_EXIST = "NO_PATH_EXIST"
+
+// Resource__, Scope__, Attributes__ and UpdatesEntry are shared with the logs and
+// metrics compressors.
+type (
+	Resource__   = tracezip.Resource
+	Scope__      = tracezip.Scope
+	Attributes__ = tracezip.Attribute
+	UpdatesEntry = tracezip.UpdatesEntry
+)
+
+// ScopeSpan is a compressed scope. ScopeId is the code of the instrumentation
+// scope in the scope dictionary, and SchemaUrl the code of the schema URL in the
+// schema URL dictionary, empty if there is none.
+type ScopeSpan struct {
+	SchemaUrl  string         `json:"schemaUrl,omitempty"`
+	ScopeId    string         `json:"s"`
+	OffsetMain uint64         `json:"to"`
+	EOffset    uint64         `json:"eo,omitempty"`
+	Timestamps TimestampCodec `json:"tc,omitempty"`
+	Spans      []SpanData     `json:"spans,omitempty"`
+}
+
+// TimestampCodec is how the timestamps of the spans of a ScopeSpan are encoded.
+// All arithmetic wraps, so that any timestamp is restored exactly.
+type TimestampCodec uint8
+
+const (
+	// TimestampOffset sends span start and end times relative to OffsetMain and
+	// event times relative to EOffset.
+	TimestampOffset TimestampCodec = iota
+	// TimestampRelative sends the start time of a span relative to the start time
+	// of its parent if that comes earlier in the payload, else of the previous span
+	// of its trace, else to OffsetMain. The end time is sent as the duration of the
+	// span and event times relative to the start time of their span.
+	TimestampRelative
+)
+
+// relativeStarts keeps the start times of the spans of a payload, in payload
+// order, to find the start time a span is relative to with TimestampRelative.
+type relativeStarts struct {
+	starts []uint64
+	// the index of the last span of every trace
+	last map[data.TraceID]int
+}
+
+func newRelativeStarts() *relativeStarts {
+	return &relativeStarts{last: make(map[data.TraceID]int)}
+}
+
+// reference returns the start time the next span is relative to, for a span with
+// parent (as in SpanData.Parent) in the trace traceId.
+func (r *relativeStarts) reference(parent int, traceId data.TraceID, offset uint64) uint64 {
+	if parent > 0 && parent <= len(r.starts) {
+		return r.starts[parent-1]
+	}
+	if index, ok := r.last[traceId]; ok {
+		return r.starts[index]
+	}
+	return offset
+}
+
+// add records the start time of the next span.
+func (r *relativeStarts) add(traceId data.TraceID, start uint64) {
+	r.last[traceId] = len(r.starts)
+	r.starts = append(r.starts, start)
+}
+
+// ExportData is a compressed resource. ResourceId is the code of the resource in
+// the resource dictionary, SchemaUrl is coded as in ScopeSpan. TraceIds is its
+// trace ID table: the trace IDs of its spans and links, each listed once, as the
+// window handle of the ones earlier batches used, else in hex.
+type ExportData struct {
+	SchemaUrl  string      `json:"schemaUrl,omitempty"`
+	ResourceId string      `json:"r"`
+	TraceIds   []string    `json:"t,omitempty"`
+	ScopeSpans []ScopeSpan `json:"scopeSpans,omitempty"`
+}
+
+// SpanData is a compressed span. Its attributes that are part of the span retrieve
+// trie are replaced by PathHash, the remaining ones keep only a shortened key.
+//
+// TraceId is one plus the index of the trace ID in the trace ID table of the
+// resource, 0 for an empty trace ID. Parent is one plus the index of the parent
+// span among all spans of the payload, counted across resources and scopes in
+// order; a parent that is not in the payload is sent as ParentSpanId instead.
+type SpanData struct {
+	PathHash               string                 `json:"_,omitempty"`
+	TraceId                int                    `json:"0,omitempty"`
+	SpanId                 data.SpanID            `json:"1"`
+	Parent                 int                    `json:"2,omitempty"`
+	ParentSpanId           *data.SpanID           `json:"p,omitempty"`
+	Flags                  uint32                 `json:"3"`
+	Name                   string                 `json:"4"`
+	StartTimeUnixNano      uint64                 `json:"5"`
+	EndTimeUnixNano        uint64                 `json:"6"`
+	Attributes             []SpanAttribute        `json:"7"`
+	Status                 v1_trace.Status        `json:"8"`
+	TraceState             string                 `json:"9,omitempty"`
+	Links                  []SpanLink             `json:"a,omitempty"`
+	DroppedAttributesCount uint32                 `json:"b,omitempty"`
+	DroppedEventsCount     uint32                 `json:"c,omitempty"`
+	DroppedLinksCount      uint32                 `json:"d,omitempty"`
+	Events                 []SpanEvent            `json:"e,omitempty"`
+	Kind                   v1_trace.Span_SpanKind `json:"f"`
+}
+
+// SpanAttribute is a span attribute that is not part of the trie, Key is its
+// code in the attribute name dictionary.
+type SpanAttribute = tracezip.CodedAttribute
+
+// SpanLink is a compressed link. TraceId refers to the trace ID table like the one
+// of SpanData.
+type SpanLink struct {
+	TraceId                int             `json:"0,omitempty"`
+	SpanId                 data.SpanID     `json:"1"`
+	TraceState             string          `json:"2,omitempty"`
+	Attributes             []LinkAttribute `json:"3,omitempty"`
+	DroppedAttributesCount uint32          `json:"4,omitempty"`
+	Flags                  uint32          `json:"5,omitempty"`
+}
+
+// LinkAttribute is a link attribute as its codes in the attribute name and the
+// attribute value dictionaries.
+type LinkAttribute struct {
+	Key   string `json:"k"`
+	Value string `json:"v"`
+}
+
+type SpanRetrieveTrieBranch struct {
+	AttrHash   string
+	NextBranch map[string]*SpanRetrieveTrieBranch
+	NextLeaf   map[string]*SpanRetrieveTrieLeaf
+}
+
+type SpanRetrieveTrieLeaf struct {
+	PathHash string
+}
+
+// SpanEvent is a compressed event, its attributes are coded one by one.
+type SpanEvent struct {
+	EventName              string          `json:"n"`
+	Time                   uint64          `json:"t,omitempty"`
+	DroppedAttributesCount uint32          `json:"d,omitempty"`
+	Attributes             []SpanAttribute `json:"a,omitempty"`
+}
+
+// Number2String returns the base62 dictionary code of number.
+func Number2String(number int) string {
+	return tracezip.Number2String(number)
+}
+
+// String2Number is the inverse of Number2String.
+func String2Number(code string) (int, error) {
+	return tracezip.String2Number(code)
+}
+
+// EvictionPolicy picks the dictionary entries evicted first when the memory of a
+// compressor exceeds TraceZipSettings.MemoryLimit.
+type EvictionPolicy = tracezip.EvictionPolicy
+
+const (
+	// EvictLRU evicts the least recently used entries first.
+	EvictLRU = tracezip.EvictLRU
+	// EvictLFU evicts the least frequently used entries first.
+	EvictLFU = tracezip.EvictLFU
+)
+
+// OrderStrategy picks the attributes of the span retrieve trie of a span name and
+// their order, among the ones with at most AttrLimit distinct values.
+type OrderStrategy = tracezip.OrderStrategy
+
+const (
+	// OrderCardinality takes all of them, the ones with fewer distinct values in
+	// the sample buffer first.
+	OrderCardinality = tracezip.OrderCardinality
+	// OrderEntropy takes the one with the lowest entropy conditional on the ones
+	// before it next, so that correlated attributes share trie nodes, as long as
+	// the sampled spans share the paths it adds.
+	OrderEntropy = tracezip.OrderEntropy
+)
+
+// traceIdHandleSize estimates the bytes of a trace ID in the window.
+const traceIdHandleSize = 128
+
+// rawTraceIdLen is the length of a trace ID sent in hex in a trace ID table, which
+// no window handle reaches.
+const rawTraceIdLen = 2 * len(data.TraceID{})
+
+// traceIdHandle is an element of the trace ID window, with the budget batch
+// that last used it. The trace IDs sent in hex have no handle.
+type traceIdHandle struct {
+	traceId data.TraceID
+	handle  string
+	batch   uint64
+}
+
+// TraceZipSettings holds the knobs of a TraceZipCompressor.
+type TraceZipSettings struct {
+	// BufferSize is the number of spans kept in the sample buffer.
+	BufferSize int
+	// BufferWindow is how long a span stays in the sample buffer, 0 for as long
+	// as BufferSize allows.
+	BufferWindow time.Duration
+	// AttrLimit is the largest number of distinct values an attribute may have
+	// in the sample buffer to become a node of the span retrieve trie.
+	AttrLimit int
+	// AttrOrder picks and orders the trie attributes of a span name.
+	AttrOrder OrderStrategy
+	// ThresholdRate is the number of trie paths after which the trie is rebuilt
+	// and a full dictionary is sent.
+	ThresholdRate int
+	// DeleteResource drops resource attributes, only their dropped count is kept.
+	DeleteResource bool
+	// Timestamps is how span and event timestamps are encoded.
+	Timestamps TimestampCodec
+	// TraceIdWindow is the number of trace IDs of earlier payloads the receiver
+	// keeps, so that later spans of their traces refer to them by handle. A trace
+	// ID is sent in hex in the payload that first uses it, and gets a handle in
+	// the next one. With 0 trace IDs are always sent in hex.
+	TraceIdWindow int
+	// TemplateMining splits the string attributes that stay out of the trie into
+	// a template, sent through the dictionary, and parameters.
+	TemplateMining bool
+	// StructuredCodecs splits URLs (http.url, url.full) and SQL statements
+	// (db.statement) kept out of the trie with codecs that know their structure.
+	StructuredCodecs bool
+	// MemoryLimit bounds the bytes of the dictionaries, tries and templates, 0 for
+	// no bound. Beyond it, span names, event names, stack trace lines, resources,
+	// scopes, schema URLs and templates not used by the current payload are evicted
+	// by Eviction, and the receiver drops them with the next update. If that is not
+	// enough, all dictionaries are dropped with the next payload, which then sends a
+	// full dictionary.
+	MemoryLimit int
+	// Eviction is the eviction policy of MemoryLimit.
+	Eviction EvictionPolicy
+}
+
+// TraceZipCompressor holds the span retrieve trie (SRT), the sliding sample buffer
+// and the dictionaries of one TraceZip stream. A compressor is not shared: every
+// exporter creates its own, so that its dictionary uuid identifies exactly one
+// dictionary on the receiver side.
+type TraceZipCompressor struct {
+	mu       sync.Mutex
+	settings TraceZipSettings
+
+	dictionaryUuid string
+	sendDictFull   bool
+	// epoch counts the full dictionaries of the uuid, version the incremental
+	// updates since the last one
+	epoch   uint64
+	version uint64
+
+	rootSRT   *SpanRetrieveTrieBranch
+	pathCount int
+	// path number to []string
+	pathDict  map[string][]string
+	pathBytes int
+
+	// budget evicts from the dictionaries of span names, event names, lines,
+	// resources, scopes, schema URLs and templates; overBudget drops all
+	// dictionaries with the next payload
+	budget     *tracezip.Budget
+	overBudget bool
+	// holds are the payloads on their way to the receiver, see Hold
+	holds tracezip.Holds
+
+	attrNames  *tracezip.Dict
+	spanNames  *tracezip.Dict
+	eventNames *tracezip.Dict
+	// line dictionary of stack traces
+	lines *tracezip.Dict
+
+	// resource dictionary, the values are tracezip.MarshalResource
+	resources *tracezip.Dict
+	// scope dictionary, the values are the JSON form of Scope__
+	scopes     *tracezip.Dict
+	schemaUrls *tracezip.Dict
+	// template dictionary of the miner and the structured codecs, the values are
+	// tracezip.MarshalTemplate
+	templates *tracezip.Dict
+	miner     *tracezip.Miner
+
+	// sampler is the sliding sample buffer, grouped by span name
+	sampler *tracezip.Sampler
+
+	// [attr value], used to map attrvalue to a short one. Trie paths refer to its
+	// codes, a value that was evicted gets a new code and so a new path.
+	attrValues *tracezip.Dict
+
+	orders    map[string][]string
+	ordersZip map[string][]string
+	ordersMap map[string]map[string]bool
+	// the attributes that qualified for the trie when the order was picked
+	candidates map[string]map[string]bool
+
+	// traceIdWindow holds the trace IDs with a handle, least recently used first
+	traceIdWindow  *list.List
+	traceIdHandles map[data.TraceID]*list.Element
+	traceIdDict    map[string]data.TraceID
+	traceIdCount   int
+	// traceIdSeen holds the trace IDs sent in hex, least recently used first
+	traceIdSeen  *list.List
+	traceIdsSeen map[data.TraceID]*list.Element
+
+	updatePathDict    []UpdatesEntry
+	updateOrders      []UpdatesEntry
+	updateTraceIdDict []UpdatesEntry
+}
+
+// NewTraceZipCompressor returns a compressor with empty dictionaries and a fresh dictionary uuid.
+func NewTraceZipCompressor(settings TraceZipSettings) *TraceZipCompressor {
+	c := &TraceZipCompressor{settings: settings}
+	c.reset()
+	return c
+}
+
+// Reset drops every trie, buffer and dictionary of the compressor and picks a new
+// dictionary uuid, so that the next call of MarshalWithTraceZip sends a full dictionary.
+func (c *TraceZipCompressor) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+}
+
+func (c *TraceZipCompressor) reset() {
+	c.dictionaryUuid = uuid.NewString()
+	c.sendDictFull = true
+	c.epoch = 0
+	c.version = 0
+	c.sampler = tracezip.NewSampler(c.settings.BufferSize, c.settings.BufferWindow)
+	c.traceIdCount = 0
+	c.clearDictionaries()
+}
+
+// clearDictionaries drops the trie and every dictionary, but keeps the sample
+// buffer and the dictionary uuid. The next payload must send a full dictionary.
+func (c *TraceZipCompressor) clearDictionaries() {
+	c.rootSRT = nil
+	c.pathCount = 0
+	c.pathDict = make(map[string][]string)
+	c.pathBytes = 0
+
+	c.budget = tracezip.NewBudget(c.settings.MemoryLimit, c.settings.Eviction)
+	c.overBudget = false
+
+	c.attrNames = tracezip.NewDict()
+	c.attrValues = c.budget.NewDict()
+	c.spanNames = c.budget.NewDict()
+	c.eventNames = c.budget.NewDict()
+	c.lines = c.budget.NewDict()
+
+	c.resources = c.budget.NewDict()
+	c.scopes = c.budget.NewDict()
+	c.schemaUrls = c.budget.NewDict()
+	c.templates = c.budget.NewDict()
+	c.miner = tracezip.NewMiner()
+
+	c.orders = make(map[string][]string)
+	c.ordersZip = make(map[string][]string)
+	c.ordersMap = make(map[string]map[string]bool)
+	c.candidates = make(map[string]map[string]bool)
+
+	c.clearTraceIds()
+
+	c.clearUpdates()
+}
+
+// pinnedSize estimates the bytes of the state the budget can not evict from.
+func (c *TraceZipCompressor) pinnedSize() int {
+	return c.attrNames.Size() + c.pathBytes + c.miner.Size() +
+		(c.traceIdWindow.Len()+c.traceIdSeen.Len())*traceIdHandleSize
+}
+
+func (c *TraceZipCompressor) clearUpdates() {
+	c.updatePathDict = make([]UpdatesEntry, 0)
+	c.updateOrders = make([]UpdatesEntry, 0)
+	c.updateTraceIdDict = make([]UpdatesEntry, 0)
+	c.attrNames.TakeUpdates()
+	c.attrValues.TakeUpdates()
+	c.spanNames.TakeUpdates()
+	c.eventNames.TakeUpdates()
+	c.lines.TakeUpdates()
+	c.resources.TakeUpdates()
+	c.scopes.TakeUpdates()
+	c.schemaUrls.TakeUpdates()
+	c.templates.TakeUpdates()
+}
+
+// attrNameCode returns the code of an attribute name, adding it to the attribute
+// name dictionary if it is new.
+func (c *TraceZipCompressor) attrNameCode(key string) (string, bool) {
+	return c.attrNames.Code(key)
+}
+
+// attrValueCode returns the code of an attribute value, adding it to the attribute
+// value dictionary if it is new.
+func (c *TraceZipCompressor) attrValueCode(v *otlpcommon.AnyValue) (string, bool) {
+	// The OTLP/JSON form carries the type, so 1 and "1" get different codes.
+	return c.attrValues.Code(string(tracezip.MarshalAnyValue(v)))
+}
+
+// valueCoder is the tracezip.ValueCoder of a compressor, it remembers whether
+// coding added dictionary entries.
+type valueCoder struct {
+	c     *TraceZipCompressor
+	added bool
+}
+
+func (v *valueCoder) NameCode(key string) string {
+	code, added := v.c.attrNameCode(key)
+	v.added = v.added || added
+	return code
+}
+
+func (v *valueCoder) ValueCode(value *otlpcommon.AnyValue) string {
+	code, added := v.c.attrValueCode(value)
+	v.added = v.added || added
+	return code
+}
+
+func (v *valueCoder) TemplateCode(key string, value string) (string, []string, bool) {
+	template, params, ok := tracezip.Template{}, []string(nil), false
+	if v.c.settings.StructuredCodecs {
+		template, params, ok = tracezip.SplitStructured(key, value)
+	}
+	if !ok && v.c.settings.TemplateMining {
+		template, params, ok = v.c.miner.Match(key, value)
+	}
+	if !ok {
+		return "", nil, false
+	}
+	code, added := v.c.templates.Code(tracezip.MarshalTemplate(template))
+	v.added = v.added || added
+	return code, params, true
+}
+
+// stackTraceKeys are the event attributes that hold stack traces.
+var stackTraceKeys = map[string]bool{
+	"exception.stacktrace": true,
+}
+
+// eventAttribute codes an event attribute with the dictionaries of the span
+// attributes. Stack traces are sent as the codes of their lines in the line
+// dictionary, so that the frames they share are sent once. Other strings are sent
+// as templates if they have one, all other values with their type like the span
+// attributes outside the trie, so that values seen once do not grow the
+// dictionary.
+func (c *TraceZipCompressor) eventAttribute(attr *otlpcommon.KeyValue, coder *valueCoder) SpanAttribute {
+	attr_ := SpanAttribute{Key: coder.NameCode(attr.Key)}
+	if str, ok := attr.Value.Value.(*otlpcommon.AnyValue_StringValue); ok {
+		if stackTraceKeys[attr.Key] {
+			attr_.Value.Type = tracezip.ValueStr
+			for _, line := range strings.Split(str.StringValue, "\n") {
+				code, added := c.lines.Code(line)
+				coder.added = coder.added || added
+				attr_.Value.Lines = append(attr_.Value.Lines, code)
+			}
+			return attr_
+		}
+		if code, params, ok := coder.TemplateCode(attr.Key, str.StringValue); ok {
+			attr_.Value = tracezip.Value{Type: tracezip.ValueStr, Template: code, Params: params}
+			return attr_
+		}
+	}
+	attr_.Value = tracezip.EncodeValue(&attr.Value, coder)
+	return attr_
+}
+
+// schemaUrlCode returns the code of a schema URL, empty for no schema URL.
+func (c *TraceZipCompressor) schemaUrlCode(schemaUrl string) (string, bool) {
+	if schemaUrl == "" {
+		return "", false
+	}
+	return c.schemaUrls.Code(schemaUrl)
+}
+
+// clearTraceIds empties the trace ID window and forgets the trace IDs sent in hex.
+// Handles are never reused, so the receiver can not mistake a new handle for an
+// old one.
+func (c *TraceZipCompressor) clearTraceIds() {
+	c.traceIdWindow = list.New()
+	c.traceIdHandles = make(map[data.TraceID]*list.Element)
+	c.traceIdDict = make(map[string]data.TraceID)
+	c.traceIdSeen = list.New()
+	c.traceIdsSeen = make(map[data.TraceID]*list.Element)
+}
+
+// shrinkTraceIds drops the least recently used trace IDs beyond the window. The
+// receiver is told with an entry without value, which rides along with the next
+// dictionary update. It is called before a payload is compressed, so the window
+// never drops a trace ID the payload uses, and it keeps the trace IDs held
+// payloads use until they are released. The trace IDs sent in hex are kept up to
+// the size of the window too.
+func (c *TraceZipCompressor) shrinkTraceIds() {
+	for c.traceIdSeen.Len() > c.settings.TraceIdWindow {
+		delete(c.traceIdsSeen, c.traceIdSeen.Remove(c.traceIdSeen.Front()).(traceIdHandle).traceId)
+	}
+	floor := c.floorBatch()
+	for c.traceIdWindow.Len() > c.settings.TraceIdWindow {
+		if c.traceIdWindow.Front().Value.(traceIdHandle).batch >= floor {
+			break
+		}
+		entry := c.traceIdWindow.Remove(c.traceIdWindow.Front()).(traceIdHandle)
+		delete(c.traceIdHandles, entry.traceId)
+		delete(c.traceIdDict, entry.handle)
+		c.updateTraceIdDict = append(c.updateTraceIdDict, UpdatesEntry{Key: entry.handle})
+	}
+}
+
+// traceIdRef returns the reference of traceId in the trace ID table of resource,
+// adding it to the table. tables maps the trace IDs of the table to their
+// reference. A trace ID of the window goes in the table by handle. One that an
+// earlier payload sent in hex gets a handle, and it reports that it created it;
+// the others go in hex, so that a payload of new traces needs no dictionary
+// update.
+func (c *TraceZipCompressor) traceIdRef(resource *ExportData, tables map[data.TraceID]int, traceId data.TraceID) (int, bool) {
+	if traceId.IsEmpty() {
+		return 0, false
+	}
+	if ref, ok := tables[traceId]; ok {
+		return ref, false
+	}
+	batch := c.budget.Batch()
+	var ref string
+	created := false
+	if elem, ok := c.traceIdHandles[traceId]; ok {
+		entry := elem.Value.(traceIdHandle)
+		entry.batch = batch
+		elem.Value = entry
+		c.traceIdWindow.MoveToBack(elem)
+		ref = entry.handle
+	} else if elem, ok := c.traceIdsSeen[traceId]; ok && elem.Value.(traceIdHandle).batch < batch {
+		c.traceIdSeen.Remove(elem)
+		delete(c.traceIdsSeen, traceId)
+		ref = Number2String(c.traceIdCount)
+		c.traceIdCount++
+		c.traceIdHandles[traceId] = c.traceIdWindow.PushBack(traceIdHandle{traceId: traceId, handle: ref, batch: batch})
+		c.traceIdDict[ref] = traceId
+		c.updateTraceIdDict = append(c.updateTraceIdDict, UpdatesEntry{
+			Key:   ref,
+			Value: hex.EncodeToString(traceId[:]),
+		})
+		created = true
+	} else {
+		if !ok && c.settings.TraceIdWindow > 0 {
+			c.traceIdsSeen[traceId] = c.traceIdSeen.PushBack(traceIdHandle{traceId: traceId, batch: batch})
+		}
+		ref = hex.EncodeToString(traceId[:])
+	}
+	resource.TraceIds = append(resource.TraceIds, ref)
+	tables[traceId] = len(resource.TraceIds)
+	return len(resource.TraceIds), created
+}
+
+// DictionaryUuid returns the uuid the receiver files this compressor's dictionary under.
+func (c *TraceZipCompressor) DictionaryUuid() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dictionaryUuid
+}
+
+// Version returns the epoch of the dictionary, the number of full dictionaries
+// returned by MarshalWithTraceZip under its uuid, and its version, the number of
+// incremental updates since the last full dictionary. A receiver that applied all
+// of them has the same epoch and version.
+func (c *TraceZipCompressor) Version() (uint64, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch, c.version
+}
+
+// Hold marks the payload last returned by MarshalWithTraceZip as on its way to the
+// receiver until Release is called with the returned token. Later payloads then
+// neither evict the entries nor drop the trace ID handles it uses, nor pick the
+// orders of its span names again, so that the receiver can decode it with any
+// later version of the dictionary. Payloads of an earlier epoch are not protected,
+// the receiver refuses them anyway.
+func (c *TraceZipCompressor) Hold() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.holds.Add(c.epoch, c.version, c.budget.Batch())
+}
+
+// Release ends the hold of token, once its payload was delivered or given up.
+func (c *TraceZipCompressor) Release(token uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.holds.Release(token)
+}
+
+// Floor returns the oldest version of the current epoch a held payload needs, or
+// the current version if none is held. Entries dropped by later updates are only
+// the ones no payload of this version or a later one uses, so the receiver may
+// refuse payloads of older versions.
+func (c *TraceZipCompressor) Floor() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, version, ok := c.holds.Oldest(c.epoch); ok {
+		return version
+	}
+	return c.version
+}
+
+// floorBatch returns the oldest budget batch a held payload of the current epoch
+// was compressed in, or the current batch if none is held.
+func (c *TraceZipCompressor) floorBatch() uint64 {
+	if batch, _, ok := c.holds.Oldest(c.epoch); ok {
+		return batch
+	}
+	return c.budget.Batch()
+}
+
+// Resync returns the complete dictionary of the compressor as a full dictionary of
+// a new epoch, for a receiver that lost track of the dictionary. It covers the
+// payloads returned so far.
+func (c *TraceZipCompressor) Resync() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.version = 0
+	return c.sendFull()
+}
+
+func (c *TraceZipCompressor) setAllOrders(limited int) {
+	c.rootSRT = &SpanRetrieveTrieBranch{
+		NextBranch: make(map[string]*SpanRetrieveTrieBranch),
+	}
+	c.orders = make(map[string][]string)
+	c.ordersZip = make(map[string][]string)
+	c.ordersMap = make(map[string]map[string]bool)
+	c.candidates = make(map[string]map[string]bool)
+	c.pathDict = make(map[string][]string)
+	c.pathBytes = 0
+	c.attrValues.Clear()
+	c.pathCount = 0
+	for _, spanName := range c.sampler.Groups() {
+		c.rootSRT.NextBranch[spanName] = &SpanRetrieveTrieBranch{}
+		c.pickOrder(limited, spanName)
+	}
+}
+
+func (c *TraceZipCompressor) setOrder(limited int, spanNames []string) {
+	for _, spanName := range spanNames {
+		c.rootSRT.NextBranch[spanName] = &SpanRetrieveTrieBranch{}
+		c.pickOrder(limited, spanName)
+		val_, _ := json.Marshal(c.ordersZip[spanName])
+		c.updateOrders = append(c.updateOrders, UpdatesEntry{
+			Key:   spanName,
+			Value: string(val_),
+		})
+	}
+}
+
+// pickOrder sets the trie attributes of spanName: the ones with at most limited
+// distinct values in the sample buffer, in the order of the AttrOrder strategy.
+func (c *TraceZipCompressor) pickOrder(limited int, spanName string) {
+	c.orders[spanName] = make([]string, 0)
+	c.ordersZip[spanName] = make([]string, 0)
+	c.ordersMap[spanName] = make(map[string]bool)
+	candidates := c.sampler.Order(spanName, limited)
+	c.candidates[spanName] = make(map[string]bool, len(candidates))
+	for _, attrName := range candidates {
+		c.candidates[spanName][attrName] = true
+	}
+	for _, attrName := range c.sampler.OrderBy(c.settings.AttrOrder, spanName, candidates) {
+		c.orders[spanName] = append(c.orders[spanName], attrName)
+		code, _ := c.attrNameCode(attrName)
+		c.ordersZip[spanName] = append(c.ordersZip[spanName], code)
+		c.ordersMap[spanName][attrName] = true
+	}
+}
+
+// shrunkOrders returns the span names that lost attribute values in the sample
+// buffer and now have an attribute that qualifies for the trie but did not when
+// their order was picked.
+func (c *TraceZipCompressor) shrunkOrders(limited int, skip []string) []string {
+	spanNames := make([]string, 0)
+	for _, spanName := range c.sampler.TakeShrunk() {
+		skipped := false
+		for _, name := range skip {
+			if name == spanName {
+				skipped = true
+				break
+			}
+		}
+		if skipped {
+			continue
+		}
+		for _, attrName := range c.sampler.Order(spanName, limited) {
+			if !c.candidates[spanName][attrName] {
+				spanNames = append(spanNames, spanName)
+				break
+			}
+		}
+	}
+	return spanNames
+}
+
+// RetrieveSRT walks the trie along pathArray and returns the hash of the path,
+// creating the path (and recording it for the next dictionary update) if it is new.
+func (c *TraceZipCompressor) RetrieveSRT(node *SpanRetrieveTrieBranch, pathArray []string, depth int) (string, bool) {
+	if len(pathArray) == 0 {
+		return NO_PATH_EXIST, false
+	}
+	nowAttr := pathArray[depth]
+	if len(pathArray)-1 == depth {
+		update_ := false
+		if node.NextLeaf == nil {
+			node.NextLeaf = make(map[string]*SpanRetrieveTrieLeaf)
+		}
+		if node.NextLeaf[nowAttr] == nil {
+			update_ = true
+			pathHash := Number2String(c.pathCount)
+			c.pathCount++
+			c.pathDict[pathHash] = pathArray
+			c.pathBytes += tracezip.PathSize(pathHash, pathArray)
+			data_, _ := json.Marshal(pathArray)
+			c.updatePathDict = append(c.updatePathDict, UpdatesEntry{
+				Key:   pathHash,
+				Value: string(data_),
+			})
+			node.NextLeaf[nowAttr] = &SpanRetrieveTrieLeaf{PathHash: pathHash}
+		}
+		return node.NextLeaf[nowAttr].PathHash, update_
+	}
+	if node == nil {
+		panic("SRT Damage, Check Function RetrieveSTR@pdata/ptrace/ptraceotlp/tracezip.go")
+	}
+	if node.NextBranch == nil {
+		node.NextBranch = make(map[string]*SpanRetrieveTrieBranch)
+	}
+	if node.NextBranch[nowAttr] == nil {
+		node.NextBranch[nowAttr] = &SpanRetrieveTrieBranch{
+			AttrHash: nowAttr,
+		}
+	}
+	return c.RetrieveSRT(node.NextBranch[nowAttr], pathArray, depth+1)
+}
+
+// MarshalWithTraceZip compresses ms with TraceZip. It returns the dictionary uuid, a full
+// dictionary (only when one must be sent), an incremental dictionary update (only when
+// the dictionaries changed) and the compressed payload.
+func (c *TraceZipCompressor) MarshalWithTraceZip(ms ExportRequest, ExplictReset bool) (string, []interface{}, []interface{}, []ExportData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	AttrLimited := c.settings.AttrLimit
+
+	var needUpdate = false
+
+	var emergeNewSpanName = make([]string, 0)
+
+	var minTime uint64 = 1 << 63
+	var minEvtTime uint64 = 1 << 53
+
+	if ExplictReset {
+		c.sendDictFull = true
+	}
+	if c.overBudget {
+		c.clearDictionaries()
+		c.sendDictFull = true
+	}
+	c.budget.Next()
+	if c.sendDictFull {
+		c.clearTraceIds()
+	} else {
+		c.shrinkTraceIds()
+	}
+
+	relative := c.settings.Timestamps == TimestampRelative
+	starts := newRelativeStarts()
+
+	// Update Buffer
+	now := time.Now()
+	// the position of the first span of every span id among all spans of the
+	// payload, the ones with a duplicate or an empty span id count too
+	spanIndex := make(map[data.SpanID]int)
+	position := 0
+	for _, resourcesSpans := range ms.orig.ResourceSpans {
+		for _, scopeSpans := range resourcesSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				if _, ok := spanIndex[span.SpanId]; !ok && !span.SpanId.IsEmpty() {
+					spanIndex[span.SpanId] = position
+				}
+				position++
+				// Unset timestamps do not count, they are restored by the wrapping offset too.
+				if span.StartTimeUnixNano != 0 && span.StartTimeUnixNano < minTime {
+					minTime = span.StartTimeUnixNano
+				}
+				if span.Events != nil {
+					for _, event := range span.Events {
+						if event.TimeUnixNano != 0 && event.TimeUnixNano < minEvtTime {
+							minEvtTime = event.TimeUnixNano
+						}
+					}
+				}
+				fields := make([]tracezip.Field, 0, len(span.Attributes))
+				for _, attribute := range span.Attributes {
+					if _, added := c.attrNameCode(attribute.Key); added {
+						needUpdate = true
+					}
+					fields = append(fields, tracezip.Field{
+						Key:   attribute.Key,
+						Value: string(tracezip.MarshalAnyValue(&attribute.Value)),
+					})
+				}
+				if c.sampler.Add(now, span.Name, fields) {
+					emergeNewSpanName = append(emergeNewSpanName, span.Name)
+					needUpdate = true
+				}
+			}
+		}
+	}
+	if c.sendDictFull {
+		needUpdate = true
+		c.sampler.TakeShrunk()
+		c.setAllOrders(AttrLimited)
+	} else {
+		// Every span name gets at most one new order per batch, however many
+		// attribute values left the sample buffer. A held payload may use the
+		// current orders, so span names are only ordered again once none is held.
+		reordered := emergeNewSpanName
+		if _, _, held := c.holds.Oldest(c.epoch); !held {
+			reordered = append(reordered, c.shrunkOrders(AttrLimited, emergeNewSpanName)...)
+		}
+		if len(reordered) != 0 {
+			needUpdate = true
+			c.setOrder(AttrLimited, reordered)
+		}
+	}
+	export := make([]ExportData, 0)
+	for _, resourcesSpan := range ms.orig.ResourceSpans {
+		resourcesSpan_ := ExportData{}
+		var newResource bool
+		resourcesSpan_.ResourceId, newResource = c.resources.Code(tracezip.MarshalResource(&resourcesSpan.Resource, c.settings.DeleteResource))
+		if newResource {
+			needUpdate = true
+		}
+		var added bool
+		if resourcesSpan_.SchemaUrl, added = c.schemaUrlCode(resourcesSpan.SchemaUrl); added {
+			needUpdate = true
+		}
+		traceIds := make(map[data.TraceID]int)
+		scopeSpans_ := make([]ScopeSpan, 0)
+		for _, scopeSpan := range resourcesSpan.ScopeSpans {
+			scopeSpan_ := ScopeSpan{}
+			if scopeSpan_.SchemaUrl, added = c.schemaUrlCode(scopeSpan.SchemaUrl); added {
+				needUpdate = true
+			}
+			scope, _ := json.Marshal(tracezip.MarshalScope(&scopeSpan.Scope))
+			if scopeSpan_.ScopeId, added = c.scopes.Code(string(scope)); added {
+				needUpdate = true
+			}
+			scopeSpan_.OffsetMain = minTime
+			if relative {
+				scopeSpan_.Timestamps = TimestampRelative
+			} else {
+				scopeSpan_.EOffset = minEvtTime
+			}
+			spans := make([]SpanData, 0)
+			for _, span := range scopeSpan.Spans {
+				pathArray := make([]string, 0)
+				for _, order := range c.orders[span.Name] {
+					found := false
+					for _, attribute := range span.Attributes {
+						if order != attribute.Key {
+							continue
+						}
+						valueCode, added := c.attrValueCode(&attribute.Value)
+						if added {
+							needUpdate = true
+						}
+						pathArray = append(pathArray, valueCode)
+						found = true
+					}
+					if !found {
+						pathArray = append(pathArray, "#")
+					}
+				}
+				var pathHash string
+				var temp bool
+				pathHash, temp = c.RetrieveSRT(c.rootSRT.NextBranch[span.Name], pathArray, 0)
+				if temp {
+					needUpdate = temp
+				}
+				traceId, newHandle := c.traceIdRef(&resourcesSpan_, traceIds, span.TraceId)
+				if newHandle {
+					needUpdate = true
+				}
+				spanName, added := c.spanNames.Code(span.Name)
+				if added {
+					needUpdate = true
+				}
+				span_ := SpanData{
+					TraceId:                traceId,
+					SpanId:                 span.SpanId,
+					Flags:                  span.Flags,
+					Kind:                   span.Kind,
+					Name:                   spanName,
+					Attributes:             make([]SpanAttribute, 0),
+					Status:                 span.Status,
+					TraceState:             span.TraceState,
+					DroppedAttributesCount: span.DroppedAttributesCount,
+					DroppedEventsCount:     span.DroppedEventsCount,
+					DroppedLinksCount:      span.DroppedLinksCount,
+				}
+				if pathHash != NO_PATH_EXIST {
+					span_.PathHash = pathHash
+				}
+				if !span.ParentSpanId.IsEmpty() {
+					if index, ok := spanIndex[span.ParentSpanId]; ok {
+						span_.Parent = index + 1
+					} else {
+						parentSpanId := span.ParentSpanId
+						span_.ParentSpanId = &parentSpanId
+					}
+				}
+				eventOffset := minEvtTime
+				if relative {
+					span_.StartTimeUnixNano = span.StartTimeUnixNano - starts.reference(span_.Parent, span.TraceId, minTime)
+					span_.EndTimeUnixNano = span.EndTimeUnixNano - span.StartTimeUnixNano
+					starts.add(span.TraceId, span.StartTimeUnixNano)
+					eventOffset = span.StartTimeUnixNano
+				} else {
+					span_.StartTimeUnixNano = span.StartTimeUnixNano - minTime
+					span_.EndTimeUnixNano = span.EndTimeUnixNano - minTime
+				}
+				coder := &valueCoder{c: c}
+				for i := range span.Attributes {
+					if !c.ordersMap[span.Name][span.Attributes[i].Key] {
+						span_.Attributes = append(span_.Attributes, tracezip.EncodeAttribute(&span.Attributes[i], coder))
+					}
+				}
+				if coder.added {
+					needUpdate = true
+				}
+				for _, link := range span.Links {
+					link_ := SpanLink{
+						SpanId:                 link.SpanId,
+						TraceState:             link.TraceState,
+						DroppedAttributesCount: link.DroppedAttributesCount,
+						Flags:                  link.Flags,
+					}
+					if link_.TraceId, added = c.traceIdRef(&resourcesSpan_, traceIds, link.TraceId); added {
+						needUpdate = true
+					}
+					for i := range link.Attributes {
+						var attr LinkAttribute
+						var keyAdded, valueAdded bool
+						attr.Key, keyAdded = c.attrNameCode(link.Attributes[i].Key)
+						attr.Value, valueAdded = c.attrValueCode(&link.Attributes[i].Value)
+						if keyAdded || valueAdded {
+							needUpdate = true
+						}
+						link_.Attributes = append(link_.Attributes, attr)
+					}
+					span_.Links = append(span_.Links, link_)
+				}
+				if span.Events != nil {
+					span_.Events = make([]SpanEvent, 0)
+					for _, event := range span.Events {
+						event_ := SpanEvent{}
+						if event_.EventName, added = c.eventNames.Code(event.Name); added {
+							needUpdate = true
+						}
+						event_.DroppedAttributesCount = event.DroppedAttributesCount
+						event_.Time = event.TimeUnixNano - eventOffset
+						for i := range event.Attributes {
+							event_.Attributes = append(event_.Attributes, c.eventAttribute(&event.Attributes[i], coder))
+						}
+						if coder.added {
+							needUpdate = true
+						}
+						span_.Events = append(span_.Events, event_)
+					}
+				}
+				spans = append(spans, span_)
+			}
+			scopeSpan_.Spans = spans
+			scopeSpans_ = append(scopeSpans_, scopeSpan_)
+		}
+		resourcesSpan_.ScopeSpans = scopeSpans_
+		export = append(export, resourcesSpan_)
+	}
+
+	// Entries of this payload and of the held ones are not evicted, the receiver
+	// needs them to decode them.
+	evicted, ok := c.budget.Evict(c.pinnedSize(), c.floorBatch())
+	if evicted > 0 {
+		needUpdate = true
+	}
+	c.overBudget = !ok
+
+	var incrementUpdate []interface{} = nil
+	var fullUpdate []interface{} = nil
+	if c.sendDictFull {
+		fullUpdate = c.sendFull()
+		c.sendDictFull = false
+		c.epoch++
+		c.version = 0
+		needUpdate = false
+		c.clearUpdates()
+	} else if needUpdate {
+		c.version++
+		incrementUpdate = make([]interface{}, 0)
+		incrementUpdate = append(incrementUpdate, c.attrNames.TakeUpdates())
+		incrementUpdate = append(incrementUpdate, c.attrValues.TakeUpdates())
+		incrementUpdate = append(incrementUpdate, c.lines.TakeUpdates())
+		incrementUpdate = append(incrementUpdate, c.eventNames.TakeUpdates())
+		incrementUpdate = append(incrementUpdate, c.updatePathDict)
+		incrementUpdate = append(incrementUpdate, c.spanNames.TakeUpdates())
+		incrementUpdate = append(incrementUpdate, c.updateOrders)
+		incrementUpdate = append(incrementUpdate, c.updateTraceIdDict)
+		incrementUpdate = append(incrementUpdate, c.resources.TakeUpdates())
+		incrementUpdate = append(incrementUpdate, c.scopes.TakeUpdates())
+		incrementUpdate = append(incrementUpdate, c.schemaUrls.TakeUpdates())
+		incrementUpdate = append(incrementUpdate, c.templates.TakeUpdates())
+		needUpdate = false
+		c.clearUpdates()
+	}
+
+	if c.pathCount > c.settings.ThresholdRate {
+		c.sendDictFull = true
+	}
+
+	return c.dictionaryUuid, fullUpdate, incrementUpdate, export
+}
+
+// SendFull returns the complete dictionary of the compressor, in the layout of a full update.
+func (c *TraceZipCompressor) SendFull() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sendFull()
+}
+
+func (c *TraceZipCompressor) sendFull() []interface{} {
+	fullUpdate := make([]interface{}, 0)
+	fullUpdate = append(fullUpdate, c.attrNames.Values())
+	fullUpdate = append(fullUpdate, c.attrValues.Values())
+	fullUpdate = append(fullUpdate, c.lines.Values())
+	fullUpdate = append(fullUpdate, c.eventNames.Values())
+	fullUpdate = append(fullUpdate, c.pathDict)
+	fullUpdate = append(fullUpdate, c.ordersZip)
+	fullUpdate = append(fullUpdate, c.spanNames.Values())
+	fullUpdate = append(fullUpdate, c.traceIdDict)
+	fullUpdate = append(fullUpdate, c.resources.Values())
+	fullUpdate = append(fullUpdate, c.scopes.Values())
+	fullUpdate = append(fullUpdate, c.schemaUrls.Values())
+	fullUpdate = append(fullUpdate, c.templates.Values())
+	return fullUpdate
+}
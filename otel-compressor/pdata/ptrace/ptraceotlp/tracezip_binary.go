@@ -0,0 +1,778 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ptraceotlp // import "go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+
+	"go.opentelemetry.io/collector/pdata/internal/data"
+	v1_trace "go.opentelemetry.io/collector/pdata/internal/data/protogen/trace/v1"
+	"go.opentelemetry.io/collector/pdata/internal/tracezip"
+)
+
+// The binary TraceZip format carries the same information as the JSON form of
+// []ExportData, laid out column by column:
+//
+//	message  = magic version uuid resource-count resource*
+//	resource = schemaUrl resource-id trace-id-count trace-id* scope-count scope*
+//	scope    = schemaUrl scope-id offset event-offset timestamp-codec
+//	           span-count column-count column*
+//	column   = column-id length payload
+//
+// Integers are varints and strings are length prefixed. Attribute values are a
+// value code, or their type followed by a string, a template code and its
+// parameters or line codes, a zigzag varint, the 8 bytes of a double, a
+// bool byte, length prefixed bytes, the value codes of a slice or the coded
+// attributes of a map. The trace ids of the trace id table are window handles,
+// as codes shifted by one, or 0 and the 16 bytes of a trace id, schema URLs are
+// codes of the schema URL dictionary, 0 for none. Every column holds one
+// field of all the spans of its scope, so that a reader can skip the columns it
+// does not know.
+const (
+	traceZipBinaryMagic   = "TZB"
+	traceZipBinaryVersion = 1
+)
+
+// TraceZipBinaryContentType is the Content-Type of the binary TraceZip format.
+const TraceZipBinaryContentType = "application/x-tracezip"
+
+// Forms of attribute values in the binary format: binaryValueRef takes the place
+// of the type of a value that is a code of the attribute value dictionary, and a
+// string is raw, a template code and parameters or line codes.
+const (
+	binaryValueRef    = 255
+	binaryStrRaw      = 0
+	binaryStrTemplate = 1
+	binaryStrLines    = 2
+)
+
+// Column ids of the binary format.
+const (
+	columnPath uint64 = iota
+	columnTraceID
+	columnSpanID
+	columnParentSpanID
+	columnFlags
+	columnName
+	columnStart
+	columnEnd
+	columnKind
+	columnStatus
+	columnTraceState
+	columnDropped
+	columnAttributes
+	columnLinks
+	columnEvents
+	columnCount
+)
+
+var errTraceZipBinaryShort = errors.New("binary tracezip payload is truncated")
+
+// MarshalTraceZipBinary writes the dictionary uuid and the payload returned by
+// MarshalWithTraceZip in the binary TraceZip format.
+func MarshalTraceZipBinary(dictionaryUuid string, export []ExportData) ([]byte, error) {
+	w := &binaryWriter{}
+	w.buf = append(w.buf, traceZipBinaryMagic...)
+	w.buf = append(w.buf, traceZipBinaryVersion)
+	w.string(dictionaryUuid)
+	w.uvarint(uint64(len(export)))
+	for i := range export {
+		resource := &export[i]
+		if err := w.optionalCode(resource.SchemaUrl); err != nil {
+			return nil, fmt.Errorf("schema url: %w", err)
+		}
+		if err := w.code(resource.ResourceId); err != nil {
+			return nil, fmt.Errorf("resource: %w", err)
+		}
+		w.uvarint(uint64(len(resource.TraceIds)))
+		for _, ref := range resource.TraceIds {
+			if err := w.traceId(ref); err != nil {
+				return nil, fmt.Errorf("trace id: %w", err)
+			}
+		}
+		w.uvarint(uint64(len(resource.ScopeSpans)))
+		for j := range resource.ScopeSpans {
+			if err := w.scopeSpan(&resource.ScopeSpans[j]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return w.buf, nil
+}
+
+// UnmarshalTraceZipBinary is the inverse of MarshalTraceZipBinary.
+func UnmarshalTraceZipBinary(data []byte) (string, []ExportData, error) {
+	if len(data) < len(traceZipBinaryMagic)+1 || string(data[:len(traceZipBinaryMagic)]) != traceZipBinaryMagic {
+		return "", nil, errors.New("not a binary tracezip payload")
+	}
+	if version := data[len(traceZipBinaryMagic)]; version != traceZipBinaryVersion {
+		return "", nil, fmt.Errorf("unsupported binary tracezip version %d", version)
+	}
+	r := &binaryReader{buf: data[len(traceZipBinaryMagic)+1:]}
+	dictionaryUuid := r.string()
+	export := make([]ExportData, r.count())
+	for i := range export {
+		resource := &export[i]
+		resource.SchemaUrl = r.optionalCode()
+		resource.ResourceId = r.code()
+		if n := r.count(); n > 0 {
+			resource.TraceIds = make([]string, n)
+			for k := range resource.TraceIds {
+				resource.TraceIds[k] = r.traceId()
+			}
+		}
+		resource.ScopeSpans = make([]ScopeSpan, r.count())
+		for j := range resource.ScopeSpans {
+			r.scopeSpan(&resource.ScopeSpans[j])
+		}
+	}
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return dictionaryUuid, export, nil
+}
+
+type binaryWriter struct {
+	buf []byte
+}
+
+func (w *binaryWriter) uvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *binaryWriter) varint(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *binaryWriter) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *binaryWriter) string(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// code writes a base62 dictionary code as a varint.
+func (w *binaryWriter) code(code string) error {
+	number, err := String2Number(code)
+	if err != nil {
+		return err
+	}
+	w.uvarint(uint64(number))
+	return nil
+}
+
+// optionalCode writes a code that may be empty, shifted by one so that zero means none.
+func (w *binaryWriter) optionalCode(code string) error {
+	if code == "" {
+		w.uvarint(0)
+		return nil
+	}
+	number, err := String2Number(code)
+	if err != nil {
+		return err
+	}
+	w.uvarint(uint64(number) + 1)
+	return nil
+}
+
+// traceId writes an entry of a trace id table, a window handle or a trace id in hex.
+func (w *binaryWriter) traceId(ref string) error {
+	if len(ref) != rawTraceIdLen {
+		return w.optionalCode(ref)
+	}
+	var traceId data.TraceID
+	if _, err := hex.Decode(traceId[:], []byte(ref)); err != nil {
+		return err
+	}
+	w.uvarint(0)
+	w.buf = append(w.buf, traceId[:]...)
+	return nil
+}
+
+// value writes value, at depth as in tracezip.MaxValueDepth.
+func (w *binaryWriter) value(value *tracezip.Value, depth int) error {
+	if depth > tracezip.MaxValueDepth {
+		return fmt.Errorf("value nested deeper than %d", tracezip.MaxValueDepth)
+	}
+	if value.Ref != "" {
+		w.uvarint(binaryValueRef)
+		return w.code(value.Ref)
+	}
+	w.uvarint(uint64(value.Type))
+	switch value.Type {
+	case tracezip.ValueEmpty:
+	case tracezip.ValueStr:
+		switch {
+		case len(value.Lines) > 0:
+			w.uvarint(binaryStrLines)
+			w.uvarint(uint64(len(value.Lines)))
+			for _, code := range value.Lines {
+				if err := w.code(code); err != nil {
+					return err
+				}
+			}
+		case value.Template != "":
+			w.uvarint(binaryStrTemplate)
+			if err := w.code(value.Template); err != nil {
+				return err
+			}
+			w.uvarint(uint64(len(value.Params)))
+			for _, param := range value.Params {
+				w.string(param)
+			}
+		default:
+			w.uvarint(binaryStrRaw)
+			w.string(value.Str)
+		}
+	case tracezip.ValueInt:
+		w.varint(value.Int)
+	case tracezip.ValueDouble:
+		w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(float64(value.Double)))
+	case tracezip.ValueBool:
+		if value.Bool {
+			w.buf = append(w.buf, 1)
+		} else {
+			w.buf = append(w.buf, 0)
+		}
+	case tracezip.ValueBytes:
+		w.bytes(value.Bytes)
+	case tracezip.ValueSlice:
+		w.uvarint(uint64(len(value.Slice)))
+		for _, code := range value.Slice {
+			if err := w.code(code); err != nil {
+				return err
+			}
+		}
+	case tracezip.ValueMap:
+		return w.codedAttributes(value.Map, depth+1)
+	default:
+		return fmt.Errorf("unknown value type %d", value.Type)
+	}
+	return nil
+}
+
+// codedAttributes writes attrs, whose values are at depth.
+func (w *binaryWriter) codedAttributes(attrs []tracezip.CodedAttribute, depth int) error {
+	w.uvarint(uint64(len(attrs)))
+	for i := range attrs {
+		if err := w.code(attrs[i].Key); err != nil {
+			return err
+		}
+		if err := w.value(&attrs[i].Value, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// column writes the column produced by fill, prefixed with its id and length.
+func (w *binaryWriter) column(id uint64, fill func(c *binaryWriter) error) error {
+	c := &binaryWriter{}
+	if err := fill(c); err != nil {
+		return fmt.Errorf("column %d: %w", id, err)
+	}
+	w.uvarint(id)
+	w.bytes(c.buf)
+	return nil
+}
+
+func (w *binaryWriter) scopeSpan(scopeSpan *ScopeSpan) error {
+	if err := w.optionalCode(scopeSpan.SchemaUrl); err != nil {
+		return fmt.Errorf("schema url: %w", err)
+	}
+	if err := w.code(scopeSpan.ScopeId); err != nil {
+		return fmt.Errorf("scope: %w", err)
+	}
+	w.uvarint(scopeSpan.OffsetMain)
+	w.uvarint(scopeSpan.EOffset)
+	w.uvarint(uint64(scopeSpan.Timestamps))
+	relative := scopeSpan.Timestamps == TimestampRelative
+
+	spans := scopeSpan.Spans
+	w.uvarint(uint64(len(spans)))
+	w.uvarint(columnCount)
+	for id, fill := range spanColumns(spans, relative) {
+		if err := w.column(uint64(id), fill); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spanColumns returns the writers of the columns of spans, by column id.
+func spanColumns(spans []SpanData, relative bool) []func(c *binaryWriter) error {
+	return []func(c *binaryWriter) error{
+		columnPath: func(c *binaryWriter) error {
+			for i := range spans {
+				if err := c.optionalCode(spans[i].PathHash); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		columnTraceID: func(c *binaryWriter) error {
+			for i := range spans {
+				c.uvarint(uint64(spans[i].TraceId))
+			}
+			return nil
+		},
+		columnSpanID: func(c *binaryWriter) error {
+			for i := range spans {
+				c.buf = append(c.buf, spans[i].SpanId[:]...)
+			}
+			return nil
+		},
+		// A parent is 0 for none, 1 followed by the raw span id for a parent
+		// outside the payload, and one more than Parent otherwise.
+		columnParentSpanID: func(c *binaryWriter) error {
+			for i := range spans {
+				switch {
+				case spans[i].ParentSpanId != nil:
+					c.uvarint(1)
+					c.buf = append(c.buf, spans[i].ParentSpanId[:]...)
+				case spans[i].Parent > 0:
+					c.uvarint(uint64(spans[i].Parent) + 1)
+				default:
+					c.uvarint(0)
+				}
+			}
+			return nil
+		},
+		columnFlags: func(c *binaryWriter) error {
+			for i := range spans {
+				c.uvarint(uint64(spans[i].Flags))
+			}
+			return nil
+		},
+		columnName: func(c *binaryWriter) error {
+			for i := range spans {
+				if err := c.code(spans[i].Name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		// With TimestampOffset, start times are deltas from the previous span and
+		// end times from the start. TimestampRelative times are deltas already.
+		columnStart: func(c *binaryWriter) error {
+			var prev uint64
+			for i := range spans {
+				c.varint(int64(spans[i].StartTimeUnixNano - prev))
+				if !relative {
+					prev = spans[i].StartTimeUnixNano
+				}
+			}
+			return nil
+		},
+		columnEnd: func(c *binaryWriter) error {
+			for i := range spans {
+				if relative {
+					c.varint(int64(spans[i].EndTimeUnixNano))
+				} else {
+					c.varint(int64(spans[i].EndTimeUnixNano - spans[i].StartTimeUnixNano))
+				}
+			}
+			return nil
+		},
+		columnKind: func(c *binaryWriter) error {
+			for i := range spans {
+				c.varint(int64(spans[i].Kind))
+			}
+			return nil
+		},
+		columnStatus: func(c *binaryWriter) error {
+			for i := range spans {
+				c.varint(int64(spans[i].Status.Code))
+				c.string(spans[i].Status.Message)
+			}
+			return nil
+		},
+		columnTraceState: func(c *binaryWriter) error {
+			for i := range spans {
+				c.string(spans[i].TraceState)
+			}
+			return nil
+		},
+		columnDropped: func(c *binaryWriter) error {
+			for i := range spans {
+				c.uvarint(uint64(spans[i].DroppedAttributesCount))
+				c.uvarint(uint64(spans[i].DroppedEventsCount))
+				c.uvarint(uint64(spans[i].DroppedLinksCount))
+			}
+			return nil
+		},
+		columnAttributes: func(c *binaryWriter) error {
+			for i := range spans {
+				if err := c.codedAttributes(spans[i].Attributes, 1); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		columnLinks: func(c *binaryWriter) error {
+			for i := range spans {
+				c.uvarint(uint64(len(spans[i].Links)))
+				for _, link := range spans[i].Links {
+					c.uvarint(uint64(link.TraceId))
+					c.buf = append(c.buf, link.SpanId[:]...)
+					c.string(link.TraceState)
+					c.uvarint(uint64(len(link.Attributes)))
+					for _, attr := range link.Attributes {
+						if err := c.code(attr.Key); err != nil {
+							return err
+						}
+						if err := c.code(attr.Value); err != nil {
+							return err
+						}
+					}
+					c.uvarint(uint64(link.DroppedAttributesCount))
+					c.uvarint(uint64(link.Flags))
+				}
+			}
+			return nil
+		},
+		// With TimestampOffset, event times are deltas from the previous event.
+		columnEvents: func(c *binaryWriter) error {
+			var prev uint64
+			for i := range spans {
+				c.uvarint(uint64(len(spans[i].Events)))
+				for _, event := range spans[i].Events {
+					if err := c.code(event.EventName); err != nil {
+						return err
+					}
+					c.varint(int64(event.Time - prev))
+					if !relative {
+						prev = event.Time
+					}
+					c.uvarint(uint64(event.DroppedAttributesCount))
+					if err := c.codedAttributes(event.Attributes, 1); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// binaryReader reads a binary TraceZip payload. The first error sticks, and
+// every later read returns zero values.
+type binaryReader struct {
+	buf []byte
+	err error
+}
+
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.buf = nil
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.fail(errTraceZipBinaryShort)
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.fail(errTraceZipBinaryShort)
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+// count reads a length, which can not be larger than the bytes that are left.
+func (r *binaryReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.buf)) {
+		r.fail(errTraceZipBinaryShort)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *binaryReader) raw(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.buf) {
+		r.fail(errTraceZipBinaryShort)
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *binaryReader) bytes() []byte {
+	return r.raw(r.count())
+}
+
+func (r *binaryReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *binaryReader) code() string {
+	return Number2String(int(r.uvarint()))
+}
+
+func (r *binaryReader) optionalCode() string {
+	number := r.uvarint()
+	if number == 0 {
+		return ""
+	}
+	return Number2String(int(number - 1))
+}
+
+func (r *binaryReader) traceId() string {
+	if ref := r.optionalCode(); ref != "" {
+		return ref
+	}
+	return hex.EncodeToString(r.raw(len(data.TraceID{})))
+}
+
+func (r *binaryReader) value(depth int) tracezip.Value {
+	if depth > tracezip.MaxValueDepth {
+		r.fail(fmt.Errorf("value nested deeper than %d", tracezip.MaxValueDepth))
+		return tracezip.Value{}
+	}
+	typ := r.uvarint()
+	if typ == binaryValueRef {
+		return tracezip.Value{Ref: r.code()}
+	}
+	value := tracezip.Value{Type: tracezip.ValueType(typ)}
+	switch value.Type {
+	case tracezip.ValueEmpty:
+	case tracezip.ValueStr:
+		switch form := r.uvarint(); form {
+		case binaryStrLines:
+			n := r.count()
+			value.Lines = make([]string, 0, n)
+			for i := 0; i < n && r.err == nil; i++ {
+				value.Lines = append(value.Lines, r.code())
+			}
+		case binaryStrTemplate:
+			value.Template = r.code()
+			n := r.count()
+			value.Params = make([]string, 0, n)
+			for i := 0; i < n && r.err == nil; i++ {
+				value.Params = append(value.Params, r.string())
+			}
+		case binaryStrRaw:
+			value.Str = r.string()
+		default:
+			r.fail(fmt.Errorf("unknown string form %d", form))
+		}
+	case tracezip.ValueInt:
+		value.Int = r.varint()
+	case tracezip.ValueDouble:
+		if b := r.raw(8); b != nil {
+			value.Double = tracezip.Double(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		}
+	case tracezip.ValueBool:
+		if b := r.raw(1); b != nil {
+			value.Bool = b[0] != 0
+		}
+	case tracezip.ValueBytes:
+		if b := r.bytes(); len(b) > 0 {
+			value.Bytes = append([]byte(nil), b...)
+		}
+	case tracezip.ValueSlice:
+		n := r.count()
+		value.Slice = make([]string, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			value.Slice = append(value.Slice, r.code())
+		}
+	case tracezip.ValueMap:
+		value.Map = r.codedAttributes(depth + 1)
+	default:
+		r.fail(fmt.Errorf("unknown value type %d", value.Type))
+	}
+	return value
+}
+
+func (r *binaryReader) codedAttributes(depth int) []tracezip.CodedAttribute {
+	n := r.count()
+	attrs := make([]tracezip.CodedAttribute, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		key := r.code()
+		attrs = append(attrs, tracezip.CodedAttribute{Key: key, Value: r.value(depth)})
+	}
+	return attrs
+}
+
+func (r *binaryReader) scopeSpan(scopeSpan *ScopeSpan) {
+	scopeSpan.SchemaUrl = r.optionalCode()
+	scopeSpan.ScopeId = r.code()
+	scopeSpan.OffsetMain = r.uvarint()
+	scopeSpan.EOffset = r.uvarint()
+	scopeSpan.Timestamps = TimestampCodec(r.uvarint())
+	relative := scopeSpan.Timestamps == TimestampRelative
+
+	spans := make([]SpanData, r.count())
+	for i := range spans {
+		spans[i].Attributes = make([]SpanAttribute, 0)
+	}
+	scopeSpan.Spans = spans
+	// Columns may come in any order, so end times, which are offsets from the
+	// start times, are resolved once all columns are read.
+	var ends []uint64
+	seen := make(map[uint64]bool)
+	columns := r.uvarint()
+	for col := uint64(0); col < columns && r.err == nil; col++ {
+		id := r.uvarint()
+		c := &binaryReader{buf: r.bytes()}
+		if r.err != nil {
+			return
+		}
+		if seen[id] {
+			r.fail(fmt.Errorf("column %d appears twice", id))
+			return
+		}
+		seen[id] = true
+		switch id {
+		case columnPath:
+			for i := range spans {
+				spans[i].PathHash = c.optionalCode()
+			}
+		case columnTraceID:
+			for i := range spans {
+				spans[i].TraceId = int(c.uvarint())
+			}
+		case columnSpanID:
+			for i := range spans {
+				copy(spans[i].SpanId[:], c.raw(len(spans[i].SpanId)))
+			}
+		case columnParentSpanID:
+			for i := range spans {
+				switch parent := c.uvarint(); parent {
+				case 0:
+				case 1:
+					var parentSpanId data.SpanID
+					copy(parentSpanId[:], c.raw(len(parentSpanId)))
+					spans[i].ParentSpanId = &parentSpanId
+				default:
+					spans[i].Parent = int(parent - 1)
+				}
+			}
+		case columnFlags:
+			for i := range spans {
+				spans[i].Flags = uint32(c.uvarint())
+			}
+		case columnName:
+			for i := range spans {
+				spans[i].Name = c.code()
+			}
+		case columnStart:
+			var prev uint64
+			for i := range spans {
+				spans[i].StartTimeUnixNano = prev + uint64(c.varint())
+				if !relative {
+					prev = spans[i].StartTimeUnixNano
+				}
+			}
+		case columnEnd:
+			ends = make([]uint64, len(spans))
+			for i := range ends {
+				ends[i] = uint64(c.varint())
+			}
+		case columnKind:
+			for i := range spans {
+				spans[i].Kind = v1_trace.Span_SpanKind(c.varint())
+			}
+		case columnStatus:
+			for i := range spans {
+				spans[i].Status.Code = v1_trace.Status_StatusCode(c.varint())
+				spans[i].Status.Message = c.string()
+			}
+		case columnTraceState:
+			for i := range spans {
+				spans[i].TraceState = c.string()
+			}
+		case columnDropped:
+			for i := range spans {
+				spans[i].DroppedAttributesCount = uint32(c.uvarint())
+				spans[i].DroppedEventsCount = uint32(c.uvarint())
+				spans[i].DroppedLinksCount = uint32(c.uvarint())
+			}
+		case columnAttributes:
+			for i := range spans {
+				spans[i].Attributes = c.codedAttributes(1)
+			}
+		case columnLinks:
+			for i := range spans {
+				n := c.count()
+				for j := 0; j < n && c.err == nil; j++ {
+					var link SpanLink
+					link.TraceId = int(c.uvarint())
+					copy(link.SpanId[:], c.raw(len(link.SpanId)))
+					link.TraceState = c.string()
+					if n := c.count(); n > 0 {
+						link.Attributes = make([]LinkAttribute, n)
+						for k := range link.Attributes {
+							link.Attributes[k].Key = c.code()
+							link.Attributes[k].Value = c.code()
+						}
+					}
+					link.DroppedAttributesCount = uint32(c.uvarint())
+					link.Flags = uint32(c.uvarint())
+					spans[i].Links = append(spans[i].Links, link)
+				}
+			}
+		case columnEvents:
+			var prev uint64
+			for i := range spans {
+				n := c.count()
+				if n > 0 {
+					spans[i].Events = make([]SpanEvent, 0, n)
+				}
+				for j := 0; j < n && c.err == nil; j++ {
+					var event SpanEvent
+					event.EventName = c.code()
+					event.Time = prev + uint64(c.varint())
+					if !relative {
+						prev = event.Time
+					}
+					event.DroppedAttributesCount = uint32(c.uvarint())
+					if attrs := c.codedAttributes(1); len(attrs) > 0 {
+						event.Attributes = attrs
+					}
+					spans[i].Events = append(spans[i].Events, event)
+				}
+			}
+		default:
+			// A column of a newer writer, skip it.
+		}
+		if c.err != nil {
+			r.fail(fmt.Errorf("column %d: %w", id, c.err))
+		}
+	}
+	for i, end := range ends {
+		spans[i].EndTimeUnixNano = end
+		if !relative {
+			spans[i].EndTimeUnixNano += spans[i].StartTimeUnixNano
+		}
+	}
+}
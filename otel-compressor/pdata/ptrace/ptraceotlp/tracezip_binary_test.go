@@ -0,0 +1,165 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ptraceotlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/collector/pdata/internal/tracezip"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestString2Number(t *testing.T) {
+	for _, number := range []int{0, 1, 61, 62, 3843, 3844, 1 << 40} {
+		got, err := String2Number(Number2String(number))
+		require.NoError(t, err)
+		assert.Equal(t, number, got)
+	}
+	_, err := String2Number("")
+	assert.Error(t, err)
+	_, err = String2Number("#")
+	assert.Error(t, err)
+}
+
+func TestTraceZipBinaryRoundTrip(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:       64,
+		AttrLimit:        5,
+		ThresholdRate:    1000,
+		TemplateMining:   true,
+		StructuredCodecs: true,
+	})
+	dict := NewTraceZipDictionary()
+
+	for batch := 0; batch < 5; batch++ {
+		td := newTraceZipRoundTripTraces(batch)
+		expected := ptrace.NewTraces()
+		td.CopyTo(expected)
+
+		dictionaryUuid, full, increment, export := c.MarshalWithTraceZip(NewExportRequestFromTraces(td), false)
+		applyTraceZipUpdate(t, dict, full, increment)
+		payload, err := MarshalTraceZipBinary(dictionaryUuid, export)
+		require.NoError(t, err)
+		jsonPayload, err := json.Marshal(export)
+		require.NoError(t, err)
+		assert.Less(t, len(payload), len(jsonPayload))
+
+		gotUuid, gotExport, err := UnmarshalTraceZipBinary(payload)
+		require.NoError(t, err)
+		assert.Equal(t, dictionaryUuid, gotUuid)
+		actual, err := DecodeWithTraceZip(dict, gotExport)
+		require.NoError(t, err)
+		assert.Equal(t, normalizeTraces(expected), normalizeTraces(actual))
+	}
+}
+
+func TestTraceZipBinaryBroken(t *testing.T) {
+	c := NewTraceZipCompressor(testTraceZipSettings)
+	dictionaryUuid, _, _, export := c.MarshalWithTraceZip(newTraceZipTestRequest("a"), false)
+	payload, err := MarshalTraceZipBinary(dictionaryUuid, export)
+	require.NoError(t, err)
+
+	for n := 0; n < len(payload); n++ {
+		_, _, err = UnmarshalTraceZipBinary(payload[:n])
+		assert.Error(t, err, "truncated to %d bytes", n)
+	}
+	_, _, err = UnmarshalTraceZipBinary([]byte(`{"_":"x"}`))
+	assert.Error(t, err)
+
+	export[0].ScopeSpans[0].Spans[0].Name = "#"
+	_, err = MarshalTraceZipBinary(dictionaryUuid, export)
+	assert.Error(t, err)
+}
+
+func TestTraceZipBinaryColumnOrder(t *testing.T) {
+	scopeSpan := ScopeSpan{
+		ScopeId:    "1",
+		Timestamps: TimestampOffset,
+		Spans: []SpanData{
+			{Name: "2", StartTimeUnixNano: 1000, EndTimeUnixNano: 1500, Attributes: []SpanAttribute{}},
+			{Name: "3", StartTimeUnixNano: 1200, EndTimeUnixNano: 1900, Parent: 1, Attributes: []SpanAttribute{}},
+		},
+	}
+	// write the columns of scopeSpan in the order of ids
+	write := func(ids []int) []byte {
+		w := &binaryWriter{}
+		require.NoError(t, w.optionalCode(scopeSpan.SchemaUrl))
+		require.NoError(t, w.code(scopeSpan.ScopeId))
+		w.uvarint(scopeSpan.OffsetMain)
+		w.uvarint(scopeSpan.EOffset)
+		w.uvarint(uint64(scopeSpan.Timestamps))
+		w.uvarint(uint64(len(scopeSpan.Spans)))
+		w.uvarint(uint64(len(ids)))
+		columns := spanColumns(scopeSpan.Spans, false)
+		for _, id := range ids {
+			require.NoError(t, w.column(uint64(id), columns[id]))
+		}
+		return w.buf
+	}
+	var ids []int
+	for id := int(columnCount) - 1; id >= 0; id-- {
+		ids = append(ids, id)
+	}
+
+	r := &binaryReader{buf: write(ids)}
+	var got ScopeSpan
+	r.scopeSpan(&got)
+	require.NoError(t, r.err)
+	assert.Equal(t, scopeSpan, got)
+
+	r = &binaryReader{buf: write(append(ids, int(columnStart)))}
+	r.scopeSpan(&got)
+	assert.ErrorContains(t, r.err, "appears twice")
+}
+
+// nestedValue returns an int in maps, depth Values deep.
+func nestedValue(depth int) tracezip.Value {
+	value := tracezip.Value{Type: tracezip.ValueInt, Int: 1}
+	for i := 1; i < depth; i++ {
+		value = tracezip.Value{Type: tracezip.ValueMap, Map: []tracezip.CodedAttribute{{Key: "A", Value: value}}}
+	}
+	return value
+}
+
+func TestTraceZipBinaryValueDepth(t *testing.T) {
+	c := NewTraceZipCompressor(testTraceZipSettings)
+	dictionaryUuid, _, _, export := c.MarshalWithTraceZip(newTraceZipTestRequest("a"), false)
+	span := &export[0].ScopeSpans[0].Spans[0]
+
+	span.Attributes = []SpanAttribute{{Key: "0", Value: nestedValue(tracezip.MaxValueDepth)}}
+	_, err := MarshalTraceZipBinary(dictionaryUuid, export)
+	require.NoError(t, err)
+
+	span.Attributes = []SpanAttribute{{Key: "0", Value: nestedValue(tracezip.MaxValueDepth + 1)}}
+	_, err = MarshalTraceZipBinary(dictionaryUuid, export)
+	assert.Error(t, err)
+}
+
+func TestTraceZipBinaryReadValueDepth(t *testing.T) {
+	// an int in maps, depth Values deep, which the writer refuses to write past
+	// MaxValueDepth
+	nested := func(depth int) []byte {
+		buf := bytes.Repeat([]byte{byte(tracezip.ValueMap), 1, 0}, depth-1)
+		return append(buf, byte(tracezip.ValueInt), 2)
+	}
+
+	expected := nestedValue(tracezip.MaxValueDepth)
+	w := &binaryWriter{}
+	require.NoError(t, w.value(&expected, 1))
+	require.Equal(t, w.buf, nested(tracezip.MaxValueDepth))
+	r := &binaryReader{buf: w.buf}
+	assert.Equal(t, expected, r.value(1))
+	require.NoError(t, r.err)
+
+	for _, depth := range []int{tracezip.MaxValueDepth + 1, 100000} {
+		r = &binaryReader{buf: nested(depth)}
+		r.value(1)
+		assert.ErrorContains(t, r.err, "nested deeper", "depth %d", depth)
+	}
+}
@@ -0,0 +1,460 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ptraceotlp // import "go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"go.opentelemetry.io/collector/pdata/internal"
+	"go.opentelemetry.io/collector/pdata/internal/data"
+	otlpcollectortrace "go.opentelemetry.io/collector/pdata/internal/data/protogen/collector/trace/v1"
+	otlpcommon "go.opentelemetry.io/collector/pdata/internal/data/protogen/common/v1"
+	v1_trace "go.opentelemetry.io/collector/pdata/internal/data/protogen/trace/v1"
+	"go.opentelemetry.io/collector/pdata/internal/tracezip"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// TraceZipDictionary is the receiver side copy of the dictionaries of one
+// TraceZipCompressor. It is kept up to date with the full and incremental updates
+// returned by MarshalWithTraceZip and is needed to decode the compressor's payloads.
+type TraceZipDictionary struct {
+	AttributeNameDict  map[string]string
+	AttributeValueDict map[string]string
+	LineDict           map[string]string
+	EventNameDict      map[string]string
+	PathDict           map[string][]string
+	Orders             map[string][]string
+	SpanNameDict       map[string]string
+	// TraceIdDict maps the window handles of the compressor to trace IDs.
+	TraceIdDict   map[string]data.TraceID
+	ResourceDict  map[string]string
+	ScopeDict     map[string]string
+	SchemaUrlDict map[string]string
+	TemplateDict  map[string]string
+}
+
+// NewTraceZipDictionary returns an empty dictionary.
+func NewTraceZipDictionary() *TraceZipDictionary {
+	return &TraceZipDictionary{
+		AttributeNameDict:  make(map[string]string),
+		AttributeValueDict: make(map[string]string),
+		LineDict:           make(map[string]string),
+		EventNameDict:      make(map[string]string),
+		PathDict:           make(map[string][]string),
+		Orders:             make(map[string][]string),
+		SpanNameDict:       make(map[string]string),
+		TraceIdDict:        make(map[string]data.TraceID),
+		ResourceDict:       make(map[string]string),
+		ScopeDict:          make(map[string]string),
+		SchemaUrlDict:      make(map[string]string),
+		TemplateDict:       make(map[string]string),
+	}
+}
+
+// FullUpdate replaces the whole dictionary with a full update, in the layout
+// produced by MarshalWithTraceZip.
+func (cd *TraceZipDictionary) FullUpdate(parts []json.RawMessage) error {
+	fresh := NewTraceZipDictionary()
+	targets := []interface{}{
+		&fresh.AttributeNameDict,
+		&fresh.AttributeValueDict,
+		&fresh.LineDict,
+		&fresh.EventNameDict,
+		&fresh.PathDict,
+		&fresh.Orders,
+		&fresh.SpanNameDict,
+		&fresh.TraceIdDict,
+		&fresh.ResourceDict,
+		&fresh.ScopeDict,
+		&fresh.SchemaUrlDict,
+		&fresh.TemplateDict,
+	}
+	if len(parts) != len(targets) {
+		return fmt.Errorf("full dictionary update has %d parts, want %d", len(parts), len(targets))
+	}
+	for i, target := range targets {
+		if err := json.Unmarshal(parts[i], target); err != nil {
+			return fmt.Errorf("full dictionary update, part %d: %w", i, err)
+		}
+	}
+	*cd = *fresh
+	// A dictionary may be sent as JSON null if it is empty.
+	cd.ensureMaps()
+	return nil
+}
+
+// IncrementUpdate adds the entries of an incremental update, in the layout
+// produced by MarshalWithTraceZip, to the dictionary.
+func (cd *TraceZipDictionary) IncrementUpdate(parts []json.RawMessage) error {
+	var updates [12][]UpdatesEntry
+	if len(parts) != len(updates) {
+		return fmt.Errorf("incremental dictionary update has %d parts, want %d", len(parts), len(updates))
+	}
+	for i := range updates {
+		if err := json.Unmarshal(parts[i], &updates[i]); err != nil {
+			return fmt.Errorf("incremental dictionary update, part %d: %w", i, err)
+		}
+	}
+	// Decode the JSON encoded entries before touching the dictionary, so that a
+	// broken update leaves it as it was.
+	paths := make(map[string][]string, len(updates[4]))
+	for _, entry := range updates[4] {
+		var arr []string
+		if err := json.Unmarshal([]byte(entry.Value), &arr); err != nil {
+			return fmt.Errorf("path %q: %w", entry.Key, err)
+		}
+		paths[entry.Key] = arr
+	}
+	orders := make(map[string][]string, len(updates[6]))
+	for _, entry := range updates[6] {
+		var order []string
+		if err := json.Unmarshal([]byte(entry.Value), &order); err != nil {
+			return fmt.Errorf("order of %q: %w", entry.Key, err)
+		}
+		orders[entry.Key] = order
+	}
+	// An entry without value drops the handle from the window.
+	traceIds := make(map[string]data.TraceID, len(updates[7]))
+	for _, entry := range updates[7] {
+		if entry.Value == "" {
+			continue
+		}
+		var traceId data.TraceID
+		b, err := hex.DecodeString(entry.Value)
+		if err != nil || len(b) != len(traceId) {
+			return fmt.Errorf("trace id %q: not %d hex encoded bytes", entry.Key, len(traceId))
+		}
+		copy(traceId[:], b)
+		traceIds[entry.Key] = traceId
+	}
+
+	cd.ensureMaps()
+	tracezip.ApplyUpdates(cd.AttributeNameDict, updates[0])
+	tracezip.ApplyUpdates(cd.AttributeValueDict, updates[1])
+	tracezip.ApplyUpdates(cd.LineDict, updates[2])
+	tracezip.ApplyUpdates(cd.EventNameDict, updates[3])
+	for k, v := range paths {
+		cd.PathDict[k] = v
+	}
+	tracezip.ApplyUpdates(cd.SpanNameDict, updates[5])
+	for k, v := range orders {
+		cd.Orders[k] = v
+	}
+	for _, entry := range updates[7] {
+		if entry.Value == "" {
+			delete(cd.TraceIdDict, entry.Key)
+		}
+	}
+	for k, v := range traceIds {
+		cd.TraceIdDict[k] = v
+	}
+	tracezip.ApplyUpdates(cd.ResourceDict, updates[8])
+	tracezip.ApplyUpdates(cd.ScopeDict, updates[9])
+	tracezip.ApplyUpdates(cd.SchemaUrlDict, updates[10])
+	tracezip.ApplyUpdates(cd.TemplateDict, updates[11])
+	return nil
+}
+
+// Size estimates the bytes the dictionary takes in memory, the way the compressor
+// counts its memory_limit.
+func (cd *TraceZipDictionary) Size() int {
+	size := tracezip.MapSize(cd.AttributeNameDict, cd.AttributeValueDict, cd.LineDict, cd.EventNameDict, cd.SpanNameDict, cd.ResourceDict, cd.ScopeDict, cd.SchemaUrlDict, cd.TemplateDict) + tracezip.PathsSize(cd.PathDict, cd.Orders)
+	for handle := range cd.TraceIdDict {
+		size += tracezip.EntrySize(handle, len(data.TraceID{}))
+	}
+	return size
+}
+
+func (cd *TraceZipDictionary) ensureMaps() {
+	if cd.AttributeNameDict == nil {
+		cd.AttributeNameDict = make(map[string]string)
+	}
+	if cd.AttributeValueDict == nil {
+		cd.AttributeValueDict = make(map[string]string)
+	}
+	if cd.LineDict == nil {
+		cd.LineDict = make(map[string]string)
+	}
+	if cd.EventNameDict == nil {
+		cd.EventNameDict = make(map[string]string)
+	}
+	if cd.PathDict == nil {
+		cd.PathDict = make(map[string][]string)
+	}
+	if cd.Orders == nil {
+		cd.Orders = make(map[string][]string)
+	}
+	if cd.SpanNameDict == nil {
+		cd.SpanNameDict = make(map[string]string)
+	}
+	if cd.TraceIdDict == nil {
+		cd.TraceIdDict = make(map[string]data.TraceID)
+	}
+	if cd.ResourceDict == nil {
+		cd.ResourceDict = make(map[string]string)
+	}
+	if cd.ScopeDict == nil {
+		cd.ScopeDict = make(map[string]string)
+	}
+	if cd.SchemaUrlDict == nil {
+		cd.SchemaUrlDict = make(map[string]string)
+	}
+	if cd.TemplateDict == nil {
+		cd.TemplateDict = make(map[string]string)
+	}
+}
+
+// UnmarshalWithTraceZip is the inverse of MarshalWithTraceZip: it decodes the JSON
+// form of a compressed payload with the dictionary it was compressed against.
+func UnmarshalWithTraceZip(dict *TraceZipDictionary, data []byte) (ptrace.Traces, error) {
+	var export []ExportData
+	if err := json.Unmarshal(data, &export); err != nil {
+		return ptrace.Traces{}, err
+	}
+	return DecodeWithTraceZip(dict, export)
+}
+
+// DecodeWithTraceZip rebuilds the traces of a compressed payload with the dictionary
+// it was compressed against.
+func DecodeWithTraceZip(dict *TraceZipDictionary, export []ExportData) (ptrace.Traces, error) {
+	if dict == nil {
+		return ptrace.Traces{}, fmt.Errorf("no dictionary to decode with")
+	}
+	orig := &otlpcollectortrace.ExportTraceServiceRequest{}
+	// every span of the payload in order, to resolve the parents
+	var spans []*v1_trace.Span
+	var parents []int
+	starts := newRelativeStarts()
+	for _, resourceSpan_ := range export {
+		schemaUrl, err := dict.schemaUrl(resourceSpan_.SchemaUrl)
+		if err != nil {
+			return ptrace.Traces{}, err
+		}
+		resourceSpan := &v1_trace.ResourceSpans{SchemaUrl: schemaUrl}
+		resource, ok := dict.ResourceDict[resourceSpan_.ResourceId]
+		if !ok {
+			return ptrace.Traces{}, fmt.Errorf("no such resource %q", resourceSpan_.ResourceId)
+		}
+		if err = tracezip.DecodeResource(resource, &resourceSpan.Resource); err != nil {
+			return ptrace.Traces{}, err
+		}
+		traceIds := make([]data.TraceID, len(resourceSpan_.TraceIds))
+		for i, ref := range resourceSpan_.TraceIds {
+			if traceIds[i], err = dict.traceId(ref); err != nil {
+				return ptrace.Traces{}, err
+			}
+		}
+		for _, scopeSpan_ := range resourceSpan_.ScopeSpans {
+			if schemaUrl, err = dict.schemaUrl(scopeSpan_.SchemaUrl); err != nil {
+				return ptrace.Traces{}, err
+			}
+			scopeSpan := &v1_trace.ScopeSpans{SchemaUrl: schemaUrl}
+			scope, ok := dict.ScopeDict[scopeSpan_.ScopeId]
+			if !ok {
+				return ptrace.Traces{}, fmt.Errorf("no such scope %q", scopeSpan_.ScopeId)
+			}
+			var scope_ Scope__
+			if err = json.Unmarshal([]byte(scope), &scope_); err != nil {
+				return ptrace.Traces{}, fmt.Errorf("scope %q: %w", scopeSpan_.ScopeId, err)
+			}
+			if err = tracezip.DecodeScope(&scope_, &scopeSpan.Scope); err != nil {
+				return ptrace.Traces{}, err
+			}
+			for i := range scopeSpan_.Spans {
+				span_ := &scopeSpan_.Spans[i]
+				traceId, err := traceIdOf(traceIds, span_.TraceId)
+				if err != nil {
+					return ptrace.Traces{}, err
+				}
+				var start, end, eventOffset uint64
+				switch scopeSpan_.Timestamps {
+				case TimestampOffset:
+					start = span_.StartTimeUnixNano + scopeSpan_.OffsetMain
+					end = span_.EndTimeUnixNano + scopeSpan_.OffsetMain
+					eventOffset = scopeSpan_.EOffset
+				case TimestampRelative:
+					start = span_.StartTimeUnixNano + starts.reference(span_.Parent, traceId, scopeSpan_.OffsetMain)
+					end = span_.EndTimeUnixNano + start
+					eventOffset = start
+					starts.add(traceId, start)
+				default:
+					return ptrace.Traces{}, fmt.Errorf("unknown timestamp codec %d", scopeSpan_.Timestamps)
+				}
+				span, err := dict.decodeSpan(span_, traceIds, start, end, eventOffset)
+				if err != nil {
+					return ptrace.Traces{}, err
+				}
+				span.TraceId = traceId
+				scopeSpan.Spans = append(scopeSpan.Spans, span)
+				spans = append(spans, span)
+				parents = append(parents, span_.Parent)
+			}
+			resourceSpan.ScopeSpans = append(resourceSpan.ScopeSpans, scopeSpan)
+		}
+		orig.ResourceSpans = append(orig.ResourceSpans, resourceSpan)
+	}
+	for i, parent := range parents {
+		if parent < 0 || parent > len(spans) {
+			return ptrace.Traces{}, fmt.Errorf("parent %d is not one of the %d spans", parent, len(spans))
+		}
+		if parent > 0 {
+			spans[i].ParentSpanId = spans[parent-1].SpanId
+		}
+	}
+	state := internal.StateMutable
+	return ptrace.Traces(internal.NewTraces(orig, &state)), nil
+}
+
+// traceId resolves an entry of the trace ID table of a resource, a window handle
+// or a trace ID in hex.
+func (cd *TraceZipDictionary) traceId(ref string) (data.TraceID, error) {
+	var traceId data.TraceID
+	if len(ref) == rawTraceIdLen {
+		if _, err := hex.Decode(traceId[:], []byte(ref)); err != nil {
+			return traceId, fmt.Errorf("trace id %q: %w", ref, err)
+		}
+		return traceId, nil
+	}
+	traceId, ok := cd.TraceIdDict[ref]
+	if !ok {
+		return traceId, fmt.Errorf("no such trace id handle %q", ref)
+	}
+	return traceId, nil
+}
+
+// traceIdOf resolves a reference into the trace ID table of a resource.
+func traceIdOf(traceIds []data.TraceID, ref int) (data.TraceID, error) {
+	if ref < 0 || ref > len(traceIds) {
+		return data.TraceID{}, fmt.Errorf("trace id %d is not in the trace id table of %d", ref, len(traceIds))
+	}
+	if ref == 0 {
+		return data.TraceID{}, nil
+	}
+	return traceIds[ref-1], nil
+}
+
+// schemaUrl resolves the code of a schema URL, empty for no schema URL.
+func (cd *TraceZipDictionary) schemaUrl(code string) (string, error) {
+	if code == "" {
+		return "", nil
+	}
+	schemaUrl, ok := cd.SchemaUrlDict[code]
+	if !ok {
+		return "", fmt.Errorf("no such schema url %q", code)
+	}
+	return schemaUrl, nil
+}
+
+func (cd *TraceZipDictionary) decodeSpan(span_ *SpanData, traceIds []data.TraceID, start uint64, end uint64, eventOffset uint64) (*v1_trace.Span, error) {
+	name, ok := cd.SpanNameDict[span_.Name]
+	if !ok {
+		return nil, fmt.Errorf("no such span name %q", span_.Name)
+	}
+	span := &v1_trace.Span{
+		SpanId:                 span_.SpanId,
+		TraceState:             span_.TraceState,
+		Flags:                  span_.Flags,
+		Name:                   name,
+		Kind:                   span_.Kind,
+		StartTimeUnixNano:      start,
+		EndTimeUnixNano:        end,
+		DroppedAttributesCount: span_.DroppedAttributesCount,
+		DroppedEventsCount:     span_.DroppedEventsCount,
+		DroppedLinksCount:      span_.DroppedLinksCount,
+		Status:                 span_.Status,
+	}
+	if span_.ParentSpanId != nil {
+		span.ParentSpanId = *span_.ParentSpanId
+	}
+
+	dicts := tracezip.ValueDicts{
+		Names:     cd.AttributeNameDict,
+		Values:    cd.AttributeValueDict,
+		Templates: cd.TemplateDict,
+		Lines:     cd.LineDict,
+	}
+	attrs, err := tracezip.DecodeCodedAttributes(span_.Attributes, dicts)
+	if err != nil {
+		return nil, err
+	}
+	span.Attributes = attrs
+
+	if span_.PathHash != "" {
+		pathArray, ok := cd.PathDict[span_.PathHash]
+		if !ok {
+			return nil, fmt.Errorf("no such pathId %q", span_.PathHash)
+		}
+		order := cd.Orders[name]
+		if len(pathArray) != len(order) {
+			return nil, fmt.Errorf("path %q has %d attributes, but span %q orders %d", span_.PathHash, len(pathArray), name, len(order))
+		}
+		for index, attr := range order {
+			if pathArray[index] == "#" {
+				continue
+			}
+			key, ok := cd.AttributeNameDict[attr]
+			if !ok {
+				return nil, fmt.Errorf("no such attribute name %q", attr)
+			}
+			value, ok := cd.AttributeValueDict[pathArray[index]]
+			if !ok {
+				return nil, fmt.Errorf("no such attribute value %q", pathArray[index])
+			}
+			kv := otlpcommon.KeyValue{Key: key}
+			if err := tracezip.UnmarshalAnyValue([]byte(value), &kv.Value); err != nil {
+				return nil, fmt.Errorf("attribute %q: %w", key, err)
+			}
+			span.Attributes = append(span.Attributes, kv)
+		}
+	}
+
+	for _, link_ := range span_.Links {
+		traceId, err := traceIdOf(traceIds, link_.TraceId)
+		if err != nil {
+			return nil, fmt.Errorf("link: %w", err)
+		}
+		link := &v1_trace.Span_Link{
+			TraceId:                traceId,
+			SpanId:                 link_.SpanId,
+			TraceState:             link_.TraceState,
+			DroppedAttributesCount: link_.DroppedAttributesCount,
+			Flags:                  link_.Flags,
+		}
+		for _, attr := range link_.Attributes {
+			key, ok := cd.AttributeNameDict[attr.Key]
+			if !ok {
+				return nil, fmt.Errorf("link: no such attribute name %q", attr.Key)
+			}
+			value, ok := cd.AttributeValueDict[attr.Value]
+			if !ok {
+				return nil, fmt.Errorf("link: no such attribute value %q", attr.Value)
+			}
+			kv := otlpcommon.KeyValue{Key: key}
+			if err := tracezip.UnmarshalAnyValue([]byte(value), &kv.Value); err != nil {
+				return nil, fmt.Errorf("link attribute %q: %w", key, err)
+			}
+			link.Attributes = append(link.Attributes, kv)
+		}
+		span.Links = append(span.Links, link)
+	}
+
+	for _, event_ := range span_.Events {
+		eventName, ok := cd.EventNameDict[event_.EventName]
+		if !ok {
+			return nil, fmt.Errorf("no such event name %q", event_.EventName)
+		}
+		event := &v1_trace.Span_Event{
+			Name:                   eventName,
+			TimeUnixNano:           event_.Time + eventOffset,
+			DroppedAttributesCount: event_.DroppedAttributesCount,
+		}
+		if event.Attributes, err = tracezip.DecodeCodedAttributes(event_.Attributes, dicts); err != nil {
+			return nil, fmt.Errorf("event %q: %w", eventName, err)
+		}
+		span.Events = append(span.Events, event)
+	}
+	return span, nil
+}
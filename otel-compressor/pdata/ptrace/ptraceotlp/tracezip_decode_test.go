@@ -0,0 +1,626 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ptraceotlp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/collector/pdata/internal"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// newTraceZipRoundTripTraces returns a batch touching every span field, with
+// attributes that do and do not make it into the trie.
+func newTraceZipRoundTripTraces(batch int) ptrace.Traces {
+	td := ptrace.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.SetSchemaUrl("https://opentelemetry.io/schemas/1.21.0")
+	rs.Resource().Attributes().PutStr("service.name", "ts-basic-service")
+	rs.Resource().Attributes().PutStr("host.name", "node-1")
+	ss := rs.ScopeSpans().AppendEmpty()
+	ss.Scope().SetName("io.opentelemetry.spring-webmvc-6.0")
+	ss.Scope().SetVersion("1.32.0")
+	ss.Scope().Attributes().PutBool("scope.flag", true)
+
+	base := pcommon.Timestamp(1700000000000000000 + uint64(batch)*1000000000)
+	for i := 0; i < 20; i++ {
+		span := ss.Spans().AppendEmpty()
+		span.SetTraceID(pcommon.TraceID{1, 2, 3, byte(batch), byte(i % 4)})
+		span.SetSpanID(pcommon.SpanID{4, 5, byte(batch), byte(i)})
+		if i > 0 {
+			span.SetParentSpanID(pcommon.SpanID{4, 5, byte(batch), byte(i - 1)})
+		}
+		span.SetName([]string{"GET /api/v1/stations", "SELECT ts.station", "POST /api/v1/orders"}[i%3])
+		span.SetKind(ptrace.SpanKind(i%5 + 1))
+		span.SetStartTimestamp(base + pcommon.Timestamp(i*1000))
+		span.SetEndTimestamp(base + pcommon.Timestamp(i*1000+537))
+		span.SetDroppedAttributesCount(uint32(i % 2))
+		span.TraceState().FromRaw("rojo=00f067aa0ba902b7")
+		span.Status().SetCode(ptrace.StatusCode(i % 3))
+		span.Status().SetMessage("status")
+
+		attrs := span.Attributes()
+		attrs.PutStr("http.method", []string{"GET", "POST"}[i%2])
+		attrs.PutInt("http.status_code", int64(200+i%2))
+		attrs.PutDouble("ratio", 0.5)
+		attrs.PutBool("error", i%2 == 0)
+		attrs.PutEmptyBytes("payload").FromRaw([]byte{0xff, 0x00, byte(i)})
+		arr := attrs.PutEmptySlice("tags")
+		arr.AppendEmpty().SetStr("a")
+		arr.AppendEmpty().SetInt(int64(i))
+		attrs.PutEmptyMap("nested").PutStr("k", "v")
+		// High cardinality, stays out of the trie.
+		attrs.PutStr("http.url", "http://ts-station-service:12345/api/v1/stations/"+string(rune('a'+i)))
+		attrs.PutStr("db.statement", "SELECT * FROM station WHERE id = "+strconv.Itoa(i)+" AND name = 'st''"+string(rune('a'+i))+"'")
+		attrs.PutInt("http.response_content_length", int64(1000+i*37))
+		attrs.PutDouble("db.latency_ratio", float64(i)/3)
+		headers := attrs.PutEmptyMap("http.request.header")
+		headers.PutEmptySlice("x-request-id").AppendEmpty().SetStr("req-" + string(rune('a'+i)))
+		headers.PutInt("content-length", int64(i))
+
+		if i%4 == 0 {
+			event := span.Events().AppendEmpty()
+			event.SetName("exception")
+			event.SetTimestamp(base + pcommon.Timestamp(i*1000+10))
+			event.SetDroppedAttributesCount(1)
+			event.Attributes().PutStr("exception.type", "java.lang.IllegalStateException")
+			event.Attributes().PutInt("exception.line", int64(i))
+			event.Attributes().PutStr("exception.message", "station "+strconv.Itoa(i)+" not found")
+			event.Attributes().PutStr("exception.stacktrace", "java.lang.IllegalStateException\n\tat station.Service.find(Service.java:"+strconv.Itoa(40+i%2)+")\n\tat station.Controller.get(Controller.java:17)\n")
+		}
+		if i%5 == 0 {
+			link := span.Links().AppendEmpty()
+			link.SetTraceID(pcommon.TraceID{9, byte(i)})
+			link.SetSpanID(pcommon.SpanID{8, byte(i)})
+			link.TraceState().FromRaw("link=1")
+			link.Attributes().PutStr("messaging.operation", "receive")
+			link.SetDroppedAttributesCount(2)
+		}
+	}
+	return td
+}
+
+// normalizeTraces sorts span attributes by key, since the decoder returns the
+// attributes of the trie after the remaining ones.
+func normalizeTraces(td ptrace.Traces) ptrace.Traces {
+	orig := internal.GetOrigTraces(internal.Traces(td))
+	for _, rs := range orig.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				sort.SliceStable(span.Attributes, func(i, j int) bool {
+					return span.Attributes[i].Key < span.Attributes[j].Key
+				})
+			}
+		}
+	}
+	return td
+}
+
+// applyTraceZipUpdate sends the dictionary updates through JSON, the way the exporter does.
+func applyTraceZipUpdate(t *testing.T, dict *TraceZipDictionary, full []interface{}, increment []interface{}) {
+	var parts []json.RawMessage
+	if full != nil {
+		raw, err := json.Marshal(full)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(raw, &parts))
+		require.NoError(t, dict.FullUpdate(parts))
+	} else if increment != nil {
+		raw, err := json.Marshal(increment)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(raw, &parts))
+		require.NoError(t, dict.IncrementUpdate(parts))
+	}
+}
+
+func TestTraceZipRoundTrip(t *testing.T) {
+	for _, timestamps := range []TimestampCodec{TimestampOffset, TimestampRelative} {
+		c := NewTraceZipCompressor(TraceZipSettings{
+			BufferSize:       64,
+			AttrLimit:        5,
+			ThresholdRate:    1000,
+			Timestamps:       timestamps,
+			TemplateMining:   true,
+			StructuredCodecs: true,
+		})
+		dict := NewTraceZipDictionary()
+
+		for batch := 0; batch < 5; batch++ {
+			td := newTraceZipRoundTripTraces(batch)
+			expected := ptrace.NewTraces()
+			td.CopyTo(expected)
+
+			_, full, increment, export := c.MarshalWithTraceZip(NewExportRequestFromTraces(td), false)
+			applyTraceZipUpdate(t, dict, full, increment)
+			payload, err := json.Marshal(export)
+			require.NoError(t, err)
+
+			// MarshalWithTraceZip must not touch its input.
+			assert.Equal(t, expected, td)
+
+			actual, err := UnmarshalWithTraceZip(dict, payload)
+			require.NoError(t, err)
+			assert.Equal(t, normalizeTraces(expected), normalizeTraces(actual))
+		}
+	}
+}
+
+func TestTraceZipRelativeTimestamps(t *testing.T) {
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	base := pcommon.Timestamp(1700000000000000000)
+	for i, times := range [][2]pcommon.Timestamp{
+		{base + 100, base + 900},
+		{base + 150, base + 400},
+		// Starts before its parent and ends before it starts.
+		{base + 50, base + 10},
+		// Unset timestamps.
+		{0, 0},
+		{base + 500, 0},
+	} {
+		span := spans.AppendEmpty()
+		span.SetName("GET /order")
+		span.SetTraceID(pcommon.TraceID{1})
+		span.SetSpanID(pcommon.SpanID{byte(i + 1)})
+		if i > 0 {
+			span.SetParentSpanID(pcommon.SpanID{1})
+		}
+		span.SetStartTimestamp(times[0])
+		span.SetEndTimestamp(times[1])
+		event := span.Events().AppendEmpty()
+		event.SetName("retry")
+		event.SetTimestamp(times[0] + 7)
+	}
+	// The parent comes after its child.
+	child := spans.AppendEmpty()
+	spans.At(0).CopyTo(child)
+	child.SetSpanID(pcommon.SpanID{9})
+	child.SetParentSpanID(pcommon.SpanID{10})
+	parent := spans.AppendEmpty()
+	spans.At(0).CopyTo(parent)
+	parent.SetSpanID(pcommon.SpanID{10})
+	parent.SetStartTimestamp(base + 20)
+
+	expected := ptrace.NewTraces()
+	td.CopyTo(expected)
+	c := NewTraceZipCompressor(TraceZipSettings{BufferSize: 10, AttrLimit: 5, ThresholdRate: 1000, Timestamps: TimestampRelative})
+	dictionaryUuid, full, _, export := c.MarshalWithTraceZip(NewExportRequestFromTraces(td), false)
+	dict := NewTraceZipDictionary()
+	applyTraceZipUpdate(t, dict, full, nil)
+
+	compressed := export[0].ScopeSpans[0].Spans
+	assert.Equal(t, TimestampRelative, export[0].ScopeSpans[0].Timestamps)
+	// Relative to the start of the batch, of the parent and of the span.
+	assert.Equal(t, uint64(80), compressed[0].StartTimeUnixNano)
+	assert.Equal(t, uint64(50), compressed[1].StartTimeUnixNano)
+	assert.Equal(t, uint64(800), compressed[0].EndTimeUnixNano)
+	assert.Equal(t, uint64(7), compressed[1].Events[0].Time)
+
+	payload, err := json.Marshal(export)
+	require.NoError(t, err)
+	actual, err := UnmarshalWithTraceZip(dict, payload)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	binaryPayload, err := MarshalTraceZipBinary(dictionaryUuid, export)
+	require.NoError(t, err)
+	_, binaryExport, err := UnmarshalTraceZipBinary(binaryPayload)
+	require.NoError(t, err)
+	actual, err = DecodeWithTraceZip(dict, binaryExport)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestTraceZipDuplicateSpanIds(t *testing.T) {
+	td := ptrace.NewTraces()
+	for _, ids := range [][][2]pcommon.SpanID{
+		// span id and parent span id
+		{{{9}, {}}, {{9}, {}}, {{}, {9}}},
+		{{{2}, {}}, {{3}, {2}}, {{}, {}}, {{4}, {9}}},
+	} {
+		spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+		for _, id := range ids {
+			span := spans.AppendEmpty()
+			span.SetName("GET /order")
+			span.SetTraceID(pcommon.TraceID{1})
+			span.SetSpanID(id[0])
+			span.SetParentSpanID(id[1])
+			span.SetStartTimestamp(1700000000000000000)
+			span.SetEndTimestamp(1700000000000000100)
+		}
+	}
+	for _, timestamps := range []TimestampCodec{TimestampOffset, TimestampRelative} {
+		expected := ptrace.NewTraces()
+		td.CopyTo(expected)
+		c := NewTraceZipCompressor(TraceZipSettings{BufferSize: 10, AttrLimit: 5, ThresholdRate: 1000, Timestamps: timestamps})
+		dictionaryUuid, full, _, export := c.MarshalWithTraceZip(NewExportRequestFromTraces(td), false)
+		dict := NewTraceZipDictionary()
+		applyTraceZipUpdate(t, dict, full, nil)
+
+		payload, err := json.Marshal(export)
+		require.NoError(t, err)
+		actual, err := UnmarshalWithTraceZip(dict, payload)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+
+		binaryPayload, err := MarshalTraceZipBinary(dictionaryUuid, export)
+		require.NoError(t, err)
+		_, binaryExport, err := UnmarshalTraceZipBinary(binaryPayload)
+		require.NoError(t, err)
+		actual, err = DecodeWithTraceZip(dict, binaryExport)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func TestTraceZipDecodeUnknownPath(t *testing.T) {
+	c := NewTraceZipCompressor(testTraceZipSettings)
+	_, _, _, export := c.MarshalWithTraceZip(newTraceZipTestRequest("a"), false)
+
+	// The full dictionary was never applied.
+	_, err := DecodeWithTraceZip(NewTraceZipDictionary(), export)
+	assert.Error(t, err)
+	_, err = DecodeWithTraceZip(nil, export)
+	assert.Error(t, err)
+}
+
+func TestTraceZipTraceIdsAcrossBatches(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:    64,
+		AttrLimit:     5,
+		ThresholdRate: 1000,
+		TraceIdWindow: 2,
+	})
+	dict := NewTraceZipDictionary()
+	// Every batch continues trace 1 and starts trace batch+2. The first span of
+	// a batch has its parent in the previous batch, the second one in this batch.
+	batch := func(n byte) ptrace.Traces {
+		td := ptrace.NewTraces()
+		spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+		for i, traceId := range []byte{1, n + 2, 1} {
+			span := spans.AppendEmpty()
+			span.SetName("GET /order")
+			span.SetTraceID(pcommon.TraceID{traceId})
+			span.SetSpanID(pcommon.SpanID{n + 1, byte(i)})
+			if i == 0 {
+				span.SetParentSpanID(pcommon.SpanID{n, 2})
+			} else if i == 2 {
+				span.SetParentSpanID(pcommon.SpanID{n + 1, 0})
+			}
+		}
+		return td
+	}
+
+	var handle string
+	for n := byte(0); n < 5; n++ {
+		td := batch(n)
+		expected := ptrace.NewTraces()
+		td.CopyTo(expected)
+		_, full, increment, export := c.MarshalWithTraceZip(NewExportRequestFromTraces(td), false)
+		applyTraceZipUpdate(t, dict, full, increment)
+
+		spans := export[0].ScopeSpans[0].Spans
+		assert.Equal(t, []int{1, 2, 1}, []int{spans[0].TraceId, spans[1].TraceId, spans[2].TraceId})
+		require.NotNil(t, spans[0].ParentSpanId)
+		assert.Zero(t, spans[0].Parent)
+		assert.Zero(t, spans[1].Parent)
+		assert.Nil(t, spans[2].ParentSpanId)
+		assert.Equal(t, 1, spans[2].Parent)
+		// The new trace is sent in hex, without a dictionary update. Trace 1
+		// gets a handle in the second batch and keeps it.
+		traceOne, newTrace := pcommon.TraceID{1}, pcommon.TraceID{n + 2}
+		assert.Equal(t, hex.EncodeToString(newTrace[:]), export[0].TraceIds[1])
+		switch n {
+		case 0:
+			assert.Equal(t, hex.EncodeToString(traceOne[:]), export[0].TraceIds[0])
+		case 1:
+			require.NotNil(t, increment)
+			assert.Equal(t, []UpdatesEntry{{Key: export[0].TraceIds[0], Value: hex.EncodeToString(traceOne[:])}}, increment[7])
+			handle = export[0].TraceIds[0]
+		default:
+			assert.Equal(t, handle, export[0].TraceIds[0])
+			assert.Nil(t, increment)
+		}
+		assert.LessOrEqual(t, len(dict.TraceIdDict), 3)
+
+		payload, err := json.Marshal(export)
+		require.NoError(t, err)
+		actual, err := UnmarshalWithTraceZip(dict, payload)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+
+	// A handle the receiver does not have can not be decoded.
+	_, _, _, export := c.MarshalWithTraceZip(NewExportRequestFromTraces(batch(9)), false)
+	export[0].TraceIds[1] = Number2String(1)
+	_, err := DecodeWithTraceZip(dict, export)
+	assert.Error(t, err)
+}
+
+func TestTraceZipMemoryLimit(t *testing.T) {
+	for _, eviction := range []EvictionPolicy{EvictLRU, EvictLFU} {
+		c := NewTraceZipCompressor(TraceZipSettings{
+			BufferSize:    16,
+			AttrLimit:     5,
+			ThresholdRate: 1000,
+			MemoryLimit:   16 << 10,
+			Eviction:      eviction,
+		})
+		dict := NewTraceZipDictionary()
+		fulls := 0
+		for batch := 0; batch < 200; batch++ {
+			// Every batch brings new span and event names, and repeats a hot one.
+			td := ptrace.NewTraces()
+			spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+			for i, name := range []string{"GET /health", fmt.Sprintf("GET /order/%d", batch)} {
+				span := spans.AppendEmpty()
+				span.SetName(name)
+				span.SetSpanID(pcommon.SpanID{byte(batch), byte(i + 1)})
+				span.Attributes().PutStr("http.method", "GET")
+				span.Events().AppendEmpty().SetName(fmt.Sprintf("event %d", batch))
+			}
+			expected := ptrace.NewTraces()
+			td.CopyTo(expected)
+
+			_, full, increment, export := c.MarshalWithTraceZip(NewExportRequestFromTraces(td), false)
+			if full != nil {
+				fulls++
+			}
+			applyTraceZipUpdate(t, dict, full, increment)
+			payload, err := json.Marshal(export)
+			require.NoError(t, err)
+			actual, err := UnmarshalWithTraceZip(dict, payload)
+			require.NoError(t, err)
+			assert.Equal(t, normalizeTraces(expected), normalizeTraces(actual))
+
+			// The receiver drops what the compressor evicts.
+			assert.Equal(t, c.spanNames.Len(), len(dict.SpanNameDict))
+			assert.Equal(t, c.eventNames.Len(), len(dict.EventNameDict))
+		}
+		assert.Less(t, c.spanNames.Len(), 100)
+		// Evicting names keeps the memory in the limit for long, only the trie
+		// paths of the new span names take a full dictionary now and then.
+		assert.Less(t, fulls, 10)
+		assert.LessOrEqual(t, c.budget.Size(c.pinnedSize()), 16<<10)
+		if eviction == EvictLFU {
+			_, ok := c.spanNames.Lookup("GET /health")
+			assert.True(t, ok)
+		}
+	}
+}
+
+func TestTraceZipMemoryLimitEventValues(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:    16,
+		AttrLimit:     5,
+		ThresholdRate: 1000,
+		MemoryLimit:   16 << 10,
+	})
+	dict := NewTraceZipDictionary()
+	for batch := 0; batch < 200; batch++ {
+		// Every event has values of its own, as strings and in slices, whose
+		// elements go through the attribute value dictionary.
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.SetName("GET /order")
+		span.SetSpanID(pcommon.SpanID{byte(batch), 1})
+		for i := 0; i < 4; i++ {
+			event := span.Events().AppendEmpty()
+			event.SetName("retry")
+			event.Attributes().PutStr("order.id", fmt.Sprintf("order-%d-%d", batch, i))
+			items := event.Attributes().PutEmptySlice("order.items")
+			items.AppendEmpty().SetStr(fmt.Sprintf("item-%d-%d", batch, i))
+			items.AppendEmpty().SetInt(int64(batch*4 + i))
+		}
+		expected := ptrace.NewTraces()
+		td.CopyTo(expected)
+
+		_, full, increment, export := c.MarshalWithTraceZip(NewExportRequestFromTraces(td), false)
+		require.True(t, batch == 0 || full == nil, "batch %d sent a full dictionary", batch)
+		applyTraceZipUpdate(t, dict, full, increment)
+		payload, err := json.Marshal(export)
+		require.NoError(t, err)
+		actual, err := UnmarshalWithTraceZip(dict, payload)
+		require.NoError(t, err)
+		assert.Equal(t, normalizeTraces(expected), normalizeTraces(actual))
+		assert.Equal(t, c.attrValues.Len(), len(dict.AttributeValueDict))
+	}
+	assert.LessOrEqual(t, c.budget.Size(c.pinnedSize()), 16<<10)
+	assert.Less(t, c.attrValues.Len(), 800)
+}
+
+func TestTraceZipHeldPayload(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:    16,
+		AttrLimit:     5,
+		ThresholdRate: 1000,
+		TraceIdWindow: 1,
+		// the fifth batch evicts
+		MemoryLimit: 1800,
+	})
+	dict := NewTraceZipDictionary()
+	// Every batch brings a new trace, new span and event names, and links to the
+	// trace of the batch before, which gets a handle.
+	marshal := func(n int) ([]byte, ptrace.Traces) {
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.SetName(fmt.Sprintf("GET /order/%d", n))
+		span.SetTraceID(pcommon.TraceID{byte(n + 1)})
+		span.SetSpanID(pcommon.SpanID{byte(n + 1)})
+		if n > 0 {
+			link := span.Links().AppendEmpty()
+			link.SetTraceID(pcommon.TraceID{byte(n)})
+			link.SetSpanID(pcommon.SpanID{byte(n)})
+		}
+		span.Events().AppendEmpty().SetName(fmt.Sprintf("event %d", n))
+		expected := ptrace.NewTraces()
+		td.CopyTo(expected)
+		_, full, increment, export := c.MarshalWithTraceZip(NewExportRequestFromTraces(td), false)
+		require.True(t, n == 0 || full == nil, "batch %d sent a full dictionary", n)
+		applyTraceZipUpdate(t, dict, full, increment)
+		payload, err := json.Marshal(export)
+		require.NoError(t, err)
+		return payload, expected
+	}
+
+	marshal(0)
+	held, expected := marshal(1)
+	token := c.Hold()
+	_, version := c.Version()
+	for n := 2; n < 5; n++ {
+		marshal(n)
+		assert.Equal(t, version, c.Floor())
+	}
+	// The receiver applied the updates of the later batches first. They evicted
+	// the names of the first batch and dropped its trace id, but kept the ones
+	// of the held payload.
+	_, ok := c.spanNames.Lookup("GET /order/0")
+	assert.False(t, ok)
+	assert.Equal(t, 4, c.traceIdWindow.Len())
+	actual, err := UnmarshalWithTraceZip(dict, held)
+	require.NoError(t, err)
+	assert.Equal(t, normalizeTraces(expected), normalizeTraces(actual))
+
+	c.Release(token)
+	marshal(5)
+	_, version = c.Version()
+	assert.Equal(t, version, c.Floor())
+	assert.Equal(t, 2, c.traceIdWindow.Len())
+	_, err = UnmarshalWithTraceZip(dict, held)
+	assert.Error(t, err)
+}
+
+func TestTraceZipMemoryLimitExceeded(t *testing.T) {
+	// The attribute values of the trie alone exceed the limit.
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:    64,
+		AttrLimit:     64,
+		ThresholdRate: 1000,
+		MemoryLimit:   1 << 10,
+	})
+	dict := NewTraceZipDictionary()
+	for batch := 0; batch < 3; batch++ {
+		td := ptrace.NewTraces()
+		spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+		for i := 0; i < 16; i++ {
+			span := spans.AppendEmpty()
+			span.SetName("GET /order")
+			span.Attributes().PutStr("order.id", strconv.Itoa(batch*16+i))
+		}
+		expected := ptrace.NewTraces()
+		td.CopyTo(expected)
+
+		// Every payload drops the dictionaries of the one before.
+		_, full, _, export := c.MarshalWithTraceZip(NewExportRequestFromTraces(td), false)
+		require.NotNil(t, full)
+		applyTraceZipUpdate(t, dict, full, nil)
+		assert.LessOrEqual(t, len(dict.AttributeValueDict), 16)
+		payload, err := json.Marshal(export)
+		require.NoError(t, err)
+		actual, err := UnmarshalWithTraceZip(dict, payload)
+		require.NoError(t, err)
+		assert.Equal(t, normalizeTraces(expected), normalizeTraces(actual))
+	}
+}
+
+func TestTraceZipSnapshot(t *testing.T) {
+	settings := TraceZipSettings{
+		BufferSize:       64,
+		AttrLimit:        5,
+		ThresholdRate:    1000,
+		TraceIdWindow:    16,
+		TemplateMining:   true,
+		StructuredCodecs: true,
+		MemoryLimit:      1 << 20,
+	}
+	c := NewTraceZipCompressor(settings)
+	dict := NewTraceZipDictionary()
+	for batch := 0; batch < 3; batch++ {
+		_, full, increment, _ := c.MarshalWithTraceZip(NewExportRequestFromTraces(newTraceZipRoundTripTraces(batch)), false)
+		applyTraceZipUpdate(t, dict, full, increment)
+	}
+	snapshot, err := c.Snapshot()
+	require.NoError(t, err)
+	epoch, version := c.Version()
+	assert.Equal(t, uint64(1), epoch)
+
+	// The restored compressor goes on with the dictionary of the receiver.
+	restored := NewTraceZipCompressor(settings)
+	require.NoError(t, restored.Restore(snapshot))
+	assert.Equal(t, c.DictionaryUuid(), restored.DictionaryUuid())
+	restoredEpoch, restoredVersion := restored.Version()
+	assert.Equal(t, epoch, restoredEpoch)
+	assert.Equal(t, version, restoredVersion)
+	for batch := 0; batch < 5; batch++ {
+		td := newTraceZipRoundTripTraces(batch)
+		expected := ptrace.NewTraces()
+		td.CopyTo(expected)
+
+		uuid, full, increment, export := restored.MarshalWithTraceZip(NewExportRequestFromTraces(td), false)
+		assert.Equal(t, c.DictionaryUuid(), uuid)
+		assert.Nil(t, full)
+		applyTraceZipUpdate(t, dict, full, increment)
+		payload, err := json.Marshal(export)
+		require.NoError(t, err)
+		actual, err := UnmarshalWithTraceZip(dict, payload)
+		require.NoError(t, err)
+		assert.Equal(t, normalizeTraces(expected), normalizeTraces(actual))
+	}
+	// Known batches add no dictionary entries, so nothing but trace IDs is sent.
+	_, _, increment, _ := restored.MarshalWithTraceZip(NewExportRequestFromTraces(newTraceZipRoundTripTraces(0)), false)
+	applyTraceZipUpdate(t, dict, nil, increment)
+	if increment != nil {
+		for i, part := range increment {
+			if i != 7 {
+				assert.Empty(t, part, "part %d", i)
+			}
+		}
+	}
+
+	// Resync sends the whole dictionary under a new epoch.
+	full := restored.Resync()
+	resyncEpoch, resyncVersion := restored.Version()
+	assert.Equal(t, epoch+1, resyncEpoch)
+	assert.Zero(t, resyncVersion)
+	other := NewTraceZipDictionary()
+	applyTraceZipUpdate(t, other, full, nil)
+	assert.Equal(t, dict, other)
+
+	assert.Error(t, restored.Restore([]byte(`{}`)))
+	assert.Error(t, restored.Restore([]byte(`{"uuid":"x","tries":{"GET":["0"]}}`)))
+}
+
+func TestTraceZipDictionarySize(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:       64,
+		AttrLimit:        5,
+		ThresholdRate:    1000,
+		TraceIdWindow:    16,
+		TemplateMining:   true,
+		StructuredCodecs: true,
+	})
+	dict := NewTraceZipDictionary()
+	assert.Zero(t, dict.Size())
+	size := 0
+	for batch := 0; batch < 3; batch++ {
+		_, full, increment, _ := c.MarshalWithTraceZip(NewExportRequestFromTraces(newTraceZipRoundTripTraces(batch)), false)
+		applyTraceZipUpdate(t, dict, full, increment)
+		assert.GreaterOrEqual(t, dict.Size(), size)
+		size = dict.Size()
+	}
+	assert.Positive(t, size)
+
+	// The same entries take the same size, however they were sent.
+	other := NewTraceZipDictionary()
+	applyTraceZipUpdate(t, other, c.Resync(), nil)
+	assert.Equal(t, size, other.Size())
+}
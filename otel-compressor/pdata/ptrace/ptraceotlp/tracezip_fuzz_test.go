@@ -0,0 +1,106 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ptraceotlp
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// traceZipFuzzSeeds returns the dictionary updates and payloads of a few batches,
+// and a function returning a fresh dictionary with the first full update applied.
+func traceZipFuzzSeeds(f *testing.F) (updates [][]byte, payloads [][]byte, binaryPayloads [][]byte, newDict func() *TraceZipDictionary) {
+	c := NewTraceZipCompressor(TraceZipSettings{
+		BufferSize:       64,
+		AttrLimit:        5,
+		ThresholdRate:    1000,
+		Timestamps:       TimestampRelative,
+		TemplateMining:   true,
+		StructuredCodecs: true,
+	})
+	var first []json.RawMessage
+	for batch := 0; batch < 3; batch++ {
+		dictionaryUuid, full, increment, export := c.MarshalWithTraceZip(NewExportRequestFromTraces(newTraceZipRoundTripTraces(batch)), false)
+		update := full
+		if update == nil {
+			update = increment
+		}
+		raw, err := json.Marshal(update)
+		require.NoError(f, err)
+		updates = append(updates, raw)
+		if first == nil {
+			require.NoError(f, json.Unmarshal(raw, &first))
+		}
+		payload, err := json.Marshal(export)
+		require.NoError(f, err)
+		payloads = append(payloads, payload)
+		binaryPayload, err := MarshalTraceZipBinary(dictionaryUuid, export)
+		require.NoError(f, err)
+		binaryPayloads = append(binaryPayloads, binaryPayload)
+	}
+	require.NoError(f, NewTraceZipDictionary().FullUpdate(first))
+	return updates, payloads, binaryPayloads, func() *TraceZipDictionary {
+		dict := NewTraceZipDictionary()
+		_ = dict.FullUpdate(first)
+		return dict
+	}
+}
+
+// FuzzTraceZipDecode checks that no JSON payload makes the decoder panic.
+func FuzzTraceZipDecode(f *testing.F) {
+	_, payloads, _, newDict := traceZipFuzzSeeds(f)
+	for _, payload := range payloads {
+		f.Add(payload)
+	}
+	f.Add([]byte(`[{"r":"#","s":[{"s":[{"n":"#","p":"#"}]}]}]`))
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		_, _ = UnmarshalWithTraceZip(newDict(), payload)
+	})
+}
+
+// FuzzTraceZipDecodeBinary checks that no binary payload makes the decoder panic.
+func FuzzTraceZipDecodeBinary(f *testing.F) {
+	_, _, binaryPayloads, newDict := traceZipFuzzSeeds(f)
+	for _, payload := range binaryPayloads {
+		f.Add(payload)
+	}
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		_, export, err := UnmarshalTraceZipBinary(payload)
+		if err != nil {
+			return
+		}
+		_, _ = DecodeWithTraceZip(newDict(), export)
+	})
+}
+
+// FuzzTraceZipDictionaryUpdate checks that no dictionary update makes the
+// dictionary or the decoding of a payload with it panic.
+func FuzzTraceZipDictionaryUpdate(f *testing.F) {
+	updates, payloads, _, newDict := traceZipFuzzSeeds(f)
+	for _, update := range updates {
+		f.Add(update, false)
+		f.Add(update, true)
+	}
+	f.Fuzz(func(t *testing.T, update []byte, full bool) {
+		var parts []json.RawMessage
+		if json.Unmarshal(update, &parts) != nil {
+			return
+		}
+		dict := newDict()
+		var err error
+		if full {
+			err = dict.FullUpdate(parts)
+		} else {
+			err = dict.IncrementUpdate(parts)
+		}
+		if err != nil {
+			return
+		}
+		for _, payload := range payloads {
+			_, _ = UnmarshalWithTraceZip(dict, payload)
+		}
+	})
+}
@@ -0,0 +1,224 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ptraceotlp // import "go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"go.opentelemetry.io/collector/pdata/internal/data"
+	"go.opentelemetry.io/collector/pdata/internal/tracezip"
+)
+
+// traceZipSnapshot is what Snapshot keeps of a compressor: the dictionaries the
+// receiver has, and what the compressor needs to go on with incremental updates.
+// The sample buffer and the template miner start empty after Restore, they fill
+// up again with the next payloads.
+type traceZipSnapshot struct {
+	Uuid     string `json:"uuid"`
+	Epoch    uint64 `json:"epoch"`
+	Version  uint64 `json:"version"`
+	SendFull bool   `json:"sendFull,omitempty"`
+
+	AttrNames  tracezip.DictState `json:"attrNames"`
+	AttrValues tracezip.DictState `json:"attrValues"`
+	SpanNames  tracezip.DictState `json:"spanNames"`
+	EventNames tracezip.DictState `json:"eventNames"`
+	Lines      tracezip.DictState `json:"lines"`
+	Resources  tracezip.DictState `json:"resources"`
+	Scopes     tracezip.DictState `json:"scopes"`
+	SchemaUrls tracezip.DictState `json:"schemaUrls"`
+	Templates  tracezip.DictState `json:"templates"`
+
+	// the trie paths, and the path codes of the trie of every span name
+	Paths     map[string][]string `json:"paths"`
+	PathCount int                 `json:"pathCount"`
+	Tries     map[string][]string `json:"tries"`
+	// the trie attributes of every span name
+	Orders map[string][]string `json:"orders"`
+
+	// the trace ID window, least recently used first
+	TraceIds     []traceIdSnapshot `json:"traceIds"`
+	TraceIdCount int               `json:"traceIdCount"`
+}
+
+type traceIdSnapshot struct {
+	Handle  string       `json:"h"`
+	TraceId data.TraceID `json:"t"`
+}
+
+// Snapshot returns the state of the compressor for Restore, to go on with the
+// same dictionary after a restart. A snapshot taken after the receiver applied
+// the last update returned by MarshalWithTraceZip matches the receiver's copy.
+func (c *TraceZipCompressor) Snapshot() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := traceZipSnapshot{
+		Uuid:         c.dictionaryUuid,
+		Epoch:        c.epoch,
+		Version:      c.version,
+		SendFull:     c.sendDictFull || c.overBudget,
+		AttrNames:    c.attrNames.State(),
+		AttrValues:   c.attrValues.State(),
+		SpanNames:    c.spanNames.State(),
+		EventNames:   c.eventNames.State(),
+		Lines:        c.lines.State(),
+		Resources:    c.resources.State(),
+		Scopes:       c.scopes.State(),
+		SchemaUrls:   c.schemaUrls.State(),
+		Templates:    c.templates.State(),
+		Paths:        c.pathDict,
+		PathCount:    c.pathCount,
+		Tries:        make(map[string][]string),
+		Orders:       c.orders,
+		TraceIds:     make([]traceIdSnapshot, 0, c.traceIdWindow.Len()),
+		TraceIdCount: c.traceIdCount,
+	}
+	if c.rootSRT != nil {
+		for spanName, branch := range c.rootSRT.NextBranch {
+			s.Tries[spanName] = srtCodes(branch)
+		}
+	}
+	for elem := c.traceIdWindow.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(traceIdHandle)
+		s.TraceIds = append(s.TraceIds, traceIdSnapshot{Handle: entry.handle, TraceId: entry.traceId})
+	}
+	return json.Marshal(s)
+}
+
+// Restore replaces the state of the compressor with a snapshot returned by
+// Snapshot. The settings of the compressor stay, a snapshot taken with other
+// settings only takes effect with the next full dictionary.
+func (c *TraceZipCompressor) Restore(snapshot []byte) error {
+	var s traceZipSnapshot
+	if err := json.Unmarshal(snapshot, &s); err != nil {
+		return fmt.Errorf("tracezip snapshot: %w", err)
+	}
+	if s.Uuid == "" {
+		return fmt.Errorf("tracezip snapshot: no dictionary uuid")
+	}
+	for spanName, codes := range s.Tries {
+		for _, code := range codes {
+			if _, ok := s.Paths[code]; !ok {
+				return fmt.Errorf("tracezip snapshot: trie of %q refers to unknown path %q", spanName, code)
+			}
+		}
+	}
+	names := make(map[string]string, len(s.AttrNames.Values))
+	for code, name := range s.AttrNames.Values {
+		names[name] = code
+	}
+	for spanName, order := range s.Orders {
+		for _, attrName := range order {
+			if _, ok := names[attrName]; !ok {
+				return fmt.Errorf("tracezip snapshot: order of %q has unknown attribute %q", spanName, attrName)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+	c.dictionaryUuid = s.Uuid
+	c.epoch = s.Epoch
+	c.version = s.Version
+	c.sendDictFull = s.SendFull || s.Epoch == 0
+
+	c.attrNames.Restore(s.AttrNames)
+	c.attrValues.Restore(s.AttrValues)
+	c.spanNames.Restore(s.SpanNames)
+	c.eventNames.Restore(s.EventNames)
+	c.lines.Restore(s.Lines)
+	c.resources.Restore(s.Resources)
+	c.scopes.Restore(s.Scopes)
+	c.schemaUrls.Restore(s.SchemaUrls)
+	c.templates.Restore(s.Templates)
+
+	c.rootSRT = &SpanRetrieveTrieBranch{NextBranch: make(map[string]*SpanRetrieveTrieBranch)}
+	if s.Paths != nil {
+		c.pathDict = s.Paths
+	}
+	c.pathCount = s.PathCount
+	for code, path := range c.pathDict {
+		c.pathBytes += tracezip.PathSize(code, path)
+	}
+	for spanName, codes := range s.Tries {
+		branch := &SpanRetrieveTrieBranch{}
+		for _, code := range codes {
+			insertSRT(branch, c.pathDict[code], code)
+		}
+		c.rootSRT.NextBranch[spanName] = branch
+	}
+	for spanName, order := range s.Orders {
+		if c.rootSRT.NextBranch[spanName] == nil {
+			c.rootSRT.NextBranch[spanName] = &SpanRetrieveTrieBranch{}
+		}
+		c.orders[spanName] = order
+		c.ordersZip[spanName] = make([]string, 0, len(order))
+		c.ordersMap[spanName] = make(map[string]bool, len(order))
+		c.candidates[spanName] = make(map[string]bool, len(order))
+		for _, attrName := range order {
+			c.ordersZip[spanName] = append(c.ordersZip[spanName], names[attrName])
+			c.ordersMap[spanName][attrName] = true
+			c.candidates[spanName][attrName] = true
+		}
+	}
+
+	spanNames := make([]string, 0, len(c.rootSRT.NextBranch))
+	for spanName := range c.rootSRT.NextBranch {
+		spanNames = append(spanNames, spanName)
+	}
+	c.sampler.Seen(spanNames...)
+
+	c.traceIdWindow = list.New()
+	for _, entry := range s.TraceIds {
+		elem := c.traceIdWindow.PushBack(traceIdHandle{traceId: entry.TraceId, handle: entry.Handle})
+		c.traceIdHandles[entry.TraceId] = elem
+		c.traceIdDict[entry.Handle] = entry.TraceId
+	}
+	c.traceIdCount = s.TraceIdCount
+	return nil
+}
+
+// srtCodes returns the path hashes of the trie of a span name, sorted.
+func srtCodes(branch *SpanRetrieveTrieBranch) []string {
+	codes := make([]string, 0)
+	var walk func(node *SpanRetrieveTrieBranch)
+	walk = func(node *SpanRetrieveTrieBranch) {
+		for _, leaf := range node.NextLeaf {
+			codes = append(codes, leaf.PathHash)
+		}
+		for _, next := range node.NextBranch {
+			walk(next)
+		}
+	}
+	walk(branch)
+	sort.Strings(codes)
+	return codes
+}
+
+// insertSRT adds path to the trie of a span name under code, like RetrieveSRT
+// without recording an update.
+func insertSRT(node *SpanRetrieveTrieBranch, path []string, code string) {
+	if len(path) == 0 {
+		return
+	}
+	for _, element := range path[:len(path)-1] {
+		if node.NextBranch == nil {
+			node.NextBranch = make(map[string]*SpanRetrieveTrieBranch)
+		}
+		next := node.NextBranch[element]
+		if next == nil {
+			next = &SpanRetrieveTrieBranch{AttrHash: element}
+			node.NextBranch[element] = next
+		}
+		node = next
+	}
+	if node.NextLeaf == nil {
+		node.NextLeaf = make(map[string]*SpanRetrieveTrieLeaf)
+	}
+	node.NextLeaf[path[len(path)-1]] = &SpanRetrieveTrieLeaf{PathHash: code}
+}
@@ -0,0 +1,267 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package ptraceotlp
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/collector/pdata/internal/tracezip"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+var testTraceZipSettings = TraceZipSettings{
+	BufferSize:     100,
+	AttrLimit:      10,
+	ThresholdRate:  1000,
+	DeleteResource: true,
+}
+
+func newTraceZipTestRequest(spanName string) ExportRequest {
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	for i := 0; i < 3; i++ {
+		span := spans.AppendEmpty()
+		span.SetName(spanName)
+		span.Attributes().PutStr("http.method", "GET")
+		span.Attributes().PutInt("http.status_code", 200)
+	}
+	return NewExportRequestFromTraces(td)
+}
+
+func TestTraceZipCompressorsAreIndependent(t *testing.T) {
+	a := NewTraceZipCompressor(testTraceZipSettings)
+	b := NewTraceZipCompressor(testTraceZipSettings)
+	assert.NotEqual(t, a.DictionaryUuid(), b.DictionaryUuid())
+
+	uuidA, full, increment, _ := a.MarshalWithTraceZip(newTraceZipTestRequest("a"), false)
+	assert.Equal(t, a.DictionaryUuid(), uuidA)
+	assert.NotNil(t, full)
+	assert.Nil(t, increment)
+
+	// A second batch of known spans needs no dictionary update at all.
+	_, full, increment, _ = a.MarshalWithTraceZip(newTraceZipTestRequest("a"), false)
+	assert.Nil(t, full)
+	assert.Nil(t, increment)
+
+	// b has not seen anything yet, so it still sends its own full dictionary.
+	uuidB, full, _, _ := b.MarshalWithTraceZip(newTraceZipTestRequest("b"), false)
+	assert.Equal(t, b.DictionaryUuid(), uuidB)
+	assert.NotNil(t, full)
+	assert.Equal(t, map[string]string{"A": "b"}, full[6])
+	assert.Equal(t, map[string]string{"A": "a"}, a.SendFull()[6])
+}
+
+func TestTraceZipCompressorReset(t *testing.T) {
+	c := NewTraceZipCompressor(testTraceZipSettings)
+	before, _, _, _ := c.MarshalWithTraceZip(newTraceZipTestRequest("a"), false)
+
+	c.Reset()
+	after, full, _, _ := c.MarshalWithTraceZip(newTraceZipTestRequest("b"), false)
+	assert.NotEqual(t, before, after)
+	assert.NotNil(t, full)
+	assert.Equal(t, map[string]string{"A": "b"}, full[6])
+}
+
+func TestTraceZipSampleBufferReorder(t *testing.T) {
+	c := NewTraceZipCompressor(TraceZipSettings{BufferSize: 4, AttrLimit: 2, ThresholdRate: 1000})
+	request := func(values ...string) ExportRequest {
+		td := ptrace.NewTraces()
+		spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+		for _, value := range values {
+			span := spans.AppendEmpty()
+			span.SetName("GET /order")
+			span.Attributes().PutStr("order.id", value)
+		}
+		return NewExportRequestFromTraces(td)
+	}
+
+	// Too many distinct values for the trie.
+	_, full, _, _ := c.MarshalWithTraceZip(request("1", "2", "3", "4"), false)
+	assert.NotNil(t, full)
+	assert.Empty(t, c.orders["GET /order"])
+
+	// The old values leave the sample buffer, order.id qualifies and the span
+	// name is reordered exactly once.
+	_, _, increment, export := c.MarshalWithTraceZip(request("1", "1", "1", "1"), false)
+	if assert.NotNil(t, increment) {
+		assert.Len(t, increment[6], 1)
+	}
+	assert.Equal(t, []string{"order.id"}, c.orders["GET /order"])
+	assert.NotEmpty(t, export[0].ScopeSpans[0].Spans[0].PathHash)
+
+	_, _, increment, _ = c.MarshalWithTraceZip(request("1", "1", "1", "1"), false)
+	assert.Nil(t, increment)
+}
+
+func TestTraceZipResourceDictionary(t *testing.T) {
+	request := func(services ...string) ExportRequest {
+		td := ptrace.NewTraces()
+		for _, service := range services {
+			rs := td.ResourceSpans().AppendEmpty()
+			rs.Resource().Attributes().PutStr("service.name", service)
+			rs.Resource().Attributes().PutStr("k8s.pod.name", service+"-7d4b9")
+			rs.Resource().SetDroppedAttributesCount(1)
+			rs.ScopeSpans().AppendEmpty().Spans().AppendEmpty().SetName("GET /order")
+		}
+		return NewExportRequestFromTraces(td)
+	}
+
+	c := NewTraceZipCompressor(testTraceZipSettings)
+	_, full, _, export := c.MarshalWithTraceZip(request("ts-order-service", "ts-order-service"), false)
+	// DeleteResource keeps only the dropped count.
+	assert.Equal(t, map[string]string{export[0].ResourceId: `{"attributes":[],"dropped_attributes_count":1}`}, full[8])
+
+	c = NewTraceZipCompressor(TraceZipSettings{BufferSize: 100, AttrLimit: 10, ThresholdRate: 1000})
+	dict := NewTraceZipDictionary()
+	_, full, _, export = c.MarshalWithTraceZip(request("ts-order-service", "ts-order-service"), false)
+	applyTraceZipUpdate(t, dict, full, nil)
+	assert.Equal(t, export[0].ResourceId, export[1].ResourceId)
+	assert.Len(t, full[8], 1)
+
+	// A known resource needs no update, a new one is sent once.
+	_, _, increment, _ := c.MarshalWithTraceZip(request("ts-order-service"), false)
+	assert.Nil(t, increment)
+	td := request("ts-order-service", "ts-travel-service")
+	_, _, increment, export = c.MarshalWithTraceZip(td, false)
+	if assert.NotNil(t, increment) {
+		assert.Len(t, increment[8], 1)
+	}
+	applyTraceZipUpdate(t, dict, nil, increment)
+	actual, err := DecodeWithTraceZip(dict, export)
+	require.NoError(t, err)
+	assert.Equal(t, td.Traces(), actual)
+}
+
+func TestTraceZipScopesAndLinks(t *testing.T) {
+	request := func(linkTo pcommon.TraceID) ExportRequest {
+		td := ptrace.NewTraces()
+		rs := td.ResourceSpans().AppendEmpty()
+		rs.SetSchemaUrl("https://opentelemetry.io/schemas/1.21.0")
+		for i := 0; i < 2; i++ {
+			ss := rs.ScopeSpans().AppendEmpty()
+			ss.SetSchemaUrl("https://opentelemetry.io/schemas/1.21.0")
+			ss.Scope().SetName("io.opentelemetry.kafka-clients-2.6")
+			ss.Scope().SetVersion("1.32.0")
+			span := ss.Spans().AppendEmpty()
+			span.SetName("orders receive")
+			span.SetTraceID(pcommon.TraceID{1})
+			link := span.Links().AppendEmpty()
+			link.SetTraceID(linkTo)
+			link.SetSpanID(pcommon.SpanID{2})
+			link.Attributes().PutStr("messaging.operation", "receive")
+		}
+		return NewExportRequestFromTraces(td)
+	}
+
+	c := NewTraceZipCompressor(TraceZipSettings{BufferSize: 100, AttrLimit: 10, ThresholdRate: 1000, TraceIdWindow: 16})
+	dict := NewTraceZipDictionary()
+	_, full, _, export := c.MarshalWithTraceZip(request(pcommon.TraceID{3}), false)
+	applyTraceZipUpdate(t, dict, full, nil)
+	assert.Len(t, full[9], 1)
+	assert.Len(t, full[10], 1)
+	scopes := export[0].ScopeSpans
+	assert.Equal(t, scopes[0].ScopeId, scopes[1].ScopeId)
+	assert.Equal(t, export[0].SchemaUrl, scopes[0].SchemaUrl)
+	// The linked trace is in the trace ID table like the trace of the span.
+	assert.Len(t, export[0].TraceIds, 2)
+	assert.Equal(t, scopes[0].Spans[0].Links, scopes[1].Spans[0].Links)
+
+	// The trace IDs of the first batch get handles in the second one.
+	_, _, increment, _ := c.MarshalWithTraceZip(request(pcommon.TraceID{3}), false)
+	require.NotNil(t, increment)
+	assert.Len(t, increment[7], 2)
+	applyTraceZipUpdate(t, dict, nil, increment)
+
+	// Known scopes, schema URLs, link attributes and trace IDs need no update.
+	td := request(pcommon.TraceID{3})
+	_, _, increment, export = c.MarshalWithTraceZip(td, false)
+	assert.Nil(t, increment)
+	actual, err := DecodeWithTraceZip(dict, export)
+	require.NoError(t, err)
+	assert.Equal(t, td.Traces(), actual)
+
+	delete(dict.ScopeDict, export[0].ScopeSpans[0].ScopeId)
+	_, err = DecodeWithTraceZip(dict, export)
+	assert.Error(t, err)
+}
+
+func TestTraceZipTemplateMining(t *testing.T) {
+	request := func(orders ...int) ExportRequest {
+		td := ptrace.NewTraces()
+		spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+		for _, order := range orders {
+			span := spans.AppendEmpty()
+			span.SetName("GET /api/v1/orders")
+			span.Attributes().PutStr("http.url", fmt.Sprintf("http://ts-order-service:12031/api/v1/orders/%d?user=u%d", order, order*7))
+		}
+		return NewExportRequestFromTraces(td)
+	}
+
+	c := NewTraceZipCompressor(TraceZipSettings{BufferSize: 100, AttrLimit: 1, ThresholdRate: 1000, TemplateMining: true})
+	dict := NewTraceZipDictionary()
+	_, full, _, export := c.MarshalWithTraceZip(request(1, 2, 3), false)
+	applyTraceZipUpdate(t, dict, full, nil)
+	spans := export[0].ScopeSpans[0].Spans
+	// The first URL is sent as it is, the others share its template.
+	assert.NotEmpty(t, spans[0].Attributes[0].Value.Str)
+	assert.Empty(t, spans[1].Attributes[0].Value.Str)
+	assert.Equal(t, []string{"2", "u14"}, spans[1].Attributes[0].Value.Params)
+	assert.Equal(t, spans[1].Attributes[0].Value.Template, spans[2].Attributes[0].Value.Template)
+	assert.Len(t, full[11], 1)
+
+	// A known template needs no update.
+	td := request(4, 5)
+	_, _, increment, export := c.MarshalWithTraceZip(td, false)
+	assert.Nil(t, increment)
+	actual, err := DecodeWithTraceZip(dict, export)
+	require.NoError(t, err)
+	assert.Equal(t, td.Traces(), actual)
+}
+
+func TestTraceZipExceptionEvents(t *testing.T) {
+	request := func(messages ...string) ExportRequest {
+		td := ptrace.NewTraces()
+		spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+		for i, message := range messages {
+			span := spans.AppendEmpty()
+			span.SetName("GET /api/v1/orders")
+			event := span.Events().AppendEmpty()
+			event.SetName("exception")
+			event.Attributes().PutStr("exception.type", "java.lang.IllegalStateException")
+			event.Attributes().PutStr("exception.message", message)
+			event.Attributes().PutStr("exception.stacktrace", fmt.Sprintf(
+				"java.lang.IllegalStateException: %s\n\tat order.Service.find(Service.java:%d)\n\tat order.Controller.get(Controller.java:17)", message, 40+i%2))
+			event.Attributes().PutInt("exception.escaped", 1)
+		}
+		return NewExportRequestFromTraces(td)
+	}
+
+	c := NewTraceZipCompressor(TraceZipSettings{BufferSize: 100, AttrLimit: 10, ThresholdRate: 1000, TemplateMining: true})
+	dict := NewTraceZipDictionary()
+	_, full, _, _ := c.MarshalWithTraceZip(request("order 1 not found", "order 2 not found"), false)
+	applyTraceZipUpdate(t, dict, full, nil)
+	// Two first lines and two frames of Service, the Controller frame is shared.
+	assert.Len(t, full[2], 5)
+
+	// Only the new lines are sent, the other attributes reuse their codes or are
+	// sent as they are.
+	td := request("order 3 not found", "order 4 not found")
+	_, _, increment, export := c.MarshalWithTraceZip(td, false)
+	require.NotNil(t, increment)
+	assert.Len(t, increment[2], 2)
+	assert.Empty(t, increment[0])
+	assert.Empty(t, increment[1])
+	applyTraceZipUpdate(t, dict, nil, increment)
+	event := export[0].ScopeSpans[0].Spans[1].Events[0]
+	assert.NotEmpty(t, event.Attributes[1].Value.Template)
+	assert.Equal(t, tracezip.Value{Type: tracezip.ValueInt, Int: 1}, event.Attributes[3].Value)
+	actual, err := DecodeWithTraceZip(dict, export)
+	require.NoError(t, err)
+	assert.Equal(t, td.Traces(), actual)
+}
@@ -0,0 +1,265 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezipotlp // import "go.opentelemetry.io/collector/pdata/tracezipotlp"
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// The codecs of the bodies of TraceZip requests. Each but CodecNone is sent as the
+// Content-Encoding of its name.
+const (
+	CodecNone   = "none"
+	CodecGzip   = "gzip"
+	CodecZstd   = "zstd"
+	CodecSnappy = "snappy"
+	CodecLz4    = "lz4"
+)
+
+// ErrUnknownDictionary is returned for a zstd body compressed with a dictionary the
+// Decoder does not have.
+var ErrUnknownDictionary = errors.New("unknown zstd dictionary")
+
+// Encoder compresses the bodies of TraceZip requests with a codec. It is safe for
+// concurrent use.
+type Encoder struct {
+	codec string
+	level int
+	zstd  *zstd.Encoder
+}
+
+// NewEncoder returns an Encoder of codec at level, 0 for the default level of the
+// codec. gzip has the levels 1 to 9 and zstd the levels 1 to 22 of the zstd
+// command, snappy and lz4 have none. A zstd Encoder compresses with dictionary, a
+// dictionary of TrainZstdDictionary, unless it is nil.
+func NewEncoder(codec string, level int, dictionary []byte) (*Encoder, error) {
+	e := &Encoder{codec: codec, level: level}
+	switch codec {
+	case CodecGzip:
+		if level < 0 || level > gzip.BestCompression {
+			return nil, fmt.Errorf("invalid gzip level %d, it must be between 1 and %d", level, gzip.BestCompression)
+		}
+		if level == 0 {
+			e.level = gzip.DefaultCompression
+		}
+	case CodecZstd:
+		if level < 0 || level > 22 {
+			return nil, fmt.Errorf("invalid zstd level %d, it must be between 1 and 22", level)
+		}
+		var options []zstd.EOption
+		if level > 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		if dictionary != nil {
+			options = append(options, zstd.WithEncoderDict(dictionary))
+		}
+		var err error
+		if e.zstd, err = zstd.NewWriter(nil, options...); err != nil {
+			return nil, err
+		}
+	case CodecNone, CodecSnappy, CodecLz4:
+		if level != 0 {
+			return nil, fmt.Errorf("codec %s has no levels", codec)
+		}
+	default:
+		return nil, fmt.Errorf("unknown codec %q", codec)
+	}
+	if dictionary != nil && codec != CodecZstd {
+		return nil, fmt.Errorf("codec %s takes no dictionary", codec)
+	}
+	return e, nil
+}
+
+// ContentEncoding returns the Content-Encoding of the bodies e compresses, "" for
+// CodecNone.
+func (e *Encoder) ContentEncoding() string {
+	if e.codec == CodecNone {
+		return ""
+	}
+	return e.codec
+}
+
+// Encode returns src compressed.
+func (e *Encoder) Encode(src []byte) ([]byte, error) {
+	switch e.codec {
+	case CodecGzip:
+		var buf bytes.Buffer
+		gz, err := gzip.NewWriterLevel(&buf, e.level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = gz.Write(src); err != nil {
+			return nil, err
+		}
+		if err = gz.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CodecZstd:
+		return e.zstd.EncodeAll(src, nil), nil
+	case CodecSnappy, CodecLz4:
+		// the framed formats, like confighttp
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		if e.codec == CodecSnappy {
+			w = snappy.NewBufferedWriter(&buf)
+		} else {
+			w = lz4.NewWriter(&buf)
+		}
+		if _, err := w.Write(src); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return src, nil
+}
+
+// Decoder decompresses the bodies of TraceZip requests by their Content-Encoding,
+// with the zstd dictionaries added to it. It is safe for concurrent use.
+type Decoder struct {
+	mu           sync.RWMutex
+	dictionaries map[uint32][]byte
+	// zstd knows the dictionaries, it is made again when they change
+	zstd *zstd.Decoder
+}
+
+// NewDecoder returns a Decoder without dictionaries.
+func NewDecoder() *Decoder {
+	return &Decoder{dictionaries: make(map[uint32][]byte)}
+}
+
+// AddZstdDictionary adds a dictionary of TrainZstdDictionary, and returns its ID.
+func (d *Decoder) AddZstdDictionary(dictionary []byte) (uint32, error) {
+	id, err := ZstdDictionaryID(dictionary)
+	if err != nil {
+		return 0, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !bytes.Equal(d.dictionaries[id], dictionary) {
+		d.dictionaries[id] = dictionary
+		d.resetZstd()
+	}
+	return id, nil
+}
+
+// RemoveZstdDictionary removes the dictionary of id.
+func (d *Decoder) RemoveZstdDictionary(id uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.dictionaries[id]; ok {
+		delete(d.dictionaries, id)
+		d.resetZstd()
+	}
+}
+
+// resetZstd drops the zstd decoder. It must be called with mu held.
+func (d *Decoder) resetZstd() {
+	if d.zstd != nil {
+		d.zstd.Close()
+		d.zstd = nil
+	}
+}
+
+// Decode returns src, which has encoding as its Content-Encoding, decompressed. An
+// empty encoding or "identity" returns src.
+func (d *Decoder) Decode(encoding string, src []byte) ([]byte, error) {
+	switch encoding {
+	case "", "identity":
+		return src, nil
+	case CodecGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return io.ReadAll(gz)
+	case CodecZstd:
+		return d.decodeZstd(src)
+	case CodecSnappy:
+		return io.ReadAll(snappy.NewReader(bytes.NewReader(src)))
+	case CodecLz4:
+		return io.ReadAll(lz4.NewReader(bytes.NewReader(src)))
+	}
+	return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+}
+
+func (d *Decoder) decodeZstd(src []byte) ([]byte, error) {
+	d.mu.RLock()
+	for d.zstd == nil {
+		d.mu.RUnlock()
+		if err := d.makeZstd(); err != nil {
+			return nil, err
+		}
+		d.mu.RLock()
+	}
+	defer d.mu.RUnlock()
+	body, err := d.zstd.DecodeAll(src, nil)
+	if errors.Is(err, zstd.ErrUnknownDictionary) {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownDictionary, err)
+	}
+	return body, err
+}
+
+// makeZstd makes the zstd decoder with the dictionaries of d, if there is none.
+func (d *Decoder) makeZstd() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.zstd != nil {
+		return nil
+	}
+	dictionaries := make([][]byte, 0, len(d.dictionaries))
+	for _, dictionary := range d.dictionaries {
+		dictionaries = append(dictionaries, dictionary)
+	}
+	var err error
+	d.zstd, err = zstd.NewReader(nil, zstd.WithDecoderDicts(dictionaries...))
+	return err
+}
+
+// NewReader returns the body of a request, which has encoding as its
+// Content-Encoding, decompressed. The body is read and decompressed with its first
+// Read, so that a failure is one of reading the body, like
+// confighttp.WithDecoder expects.
+func (d *Decoder) NewReader(encoding string, body io.ReadCloser) io.ReadCloser {
+	return &decodingReader{decoder: d, encoding: encoding, body: body}
+}
+
+type decodingReader struct {
+	decoder  *Decoder
+	encoding string
+	body     io.ReadCloser
+	decoded  *bytes.Reader
+	err      error
+}
+
+func (r *decodingReader) Read(p []byte) (int, error) {
+	if r.decoded == nil && r.err == nil {
+		var src, decoded []byte
+		if src, r.err = io.ReadAll(r.body); r.err == nil {
+			decoded, r.err = r.decoder.Decode(r.encoding, src)
+		}
+		r.decoded = bytes.NewReader(decoded)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.decoded.Read(p)
+}
+
+func (r *decodingReader) Close() error {
+	return r.body.Close()
+}
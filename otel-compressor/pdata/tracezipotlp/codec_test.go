@@ -0,0 +1,254 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezipotlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func spanSamples() []byte {
+	r := rand.New(rand.NewSource(1))
+	var spans bytes.Buffer
+	for i := 0; spans.Len() < 300000; i++ {
+		fmt.Fprintf(&spans, `{"name":"GET /api/v1/orders/%d","status":%d,"duration":%d},`, i%17, 200+i%3, r.Intn(1000))
+	}
+	return spans.Bytes()
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	data := spanSamples()
+	tests := []struct {
+		codec string
+		level int
+	}{
+		{codec: CodecNone},
+		{codec: CodecGzip},
+		{codec: CodecGzip, level: 1},
+		{codec: CodecGzip, level: 9},
+		{codec: CodecZstd},
+		{codec: CodecZstd, level: 1},
+		{codec: CodecZstd, level: 19},
+		{codec: CodecSnappy},
+		{codec: CodecLz4},
+	}
+	decoder := NewDecoder()
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s-%d", tt.codec, tt.level), func(t *testing.T) {
+			encoder, err := NewEncoder(tt.codec, tt.level, nil)
+			require.NoError(t, err)
+			encoded, err := encoder.Encode(data)
+			require.NoError(t, err)
+			if tt.codec != CodecNone {
+				assert.Equal(t, tt.codec, encoder.ContentEncoding())
+				assert.Less(t, len(encoded), len(data)/2)
+			} else {
+				assert.Empty(t, encoder.ContentEncoding())
+			}
+			decoded, err := decoder.Decode(encoder.ContentEncoding(), encoded)
+			require.NoError(t, err)
+			assert.Equal(t, data, decoded)
+		})
+	}
+}
+
+func TestCodecUpstreamDecoders(t *testing.T) {
+	data := spanSamples()
+	for codec, newReader := range map[string]func(io.Reader) (io.Reader, error){
+		CodecGzip: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		CodecZstd: func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+		CodecSnappy: func(r io.Reader) (io.Reader, error) {
+			return snappy.NewReader(r), nil
+		},
+		CodecLz4: func(r io.Reader) (io.Reader, error) {
+			return lz4.NewReader(r), nil
+		},
+	} {
+		t.Run(codec, func(t *testing.T) {
+			encoder, err := NewEncoder(codec, 0, nil)
+			require.NoError(t, err)
+			encoded, err := encoder.Encode(data)
+			require.NoError(t, err)
+			r, err := newReader(bytes.NewReader(encoded))
+			require.NoError(t, err)
+			decoded, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, decoded)
+		})
+	}
+}
+
+func TestDecodeLz4Reference(t *testing.T) {
+	var expected bytes.Buffer
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(&expected, `{"name":"GET /api/v1/orders/%d","status":200},`, i%7)
+	}
+	// written by the lz4 command with dependent blocks, block checksums and the
+	// content size
+	frame, err := hex.DecodeString("04224d187c401c02000000000000685a000000ff1e7b226e616d65223a22474554202f6170692f76312f6f72646572732f30222c22737461747573223a3230307d2c2d00091f312d00191f322d00191f332d00191f342d00191f352d00191f362d00190f3b01ad503230307d2c93593b8100000000d124c8e8")
+	require.NoError(t, err)
+	actual, err := NewDecoder().Decode(CodecLz4, frame)
+	require.NoError(t, err)
+	assert.Equal(t, expected.String(), string(actual))
+}
+
+func TestNewEncoderInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		codec string
+		level int
+	}{
+		{codec: CodecGzip, level: 10},
+		{codec: CodecGzip, level: -1},
+		{codec: CodecZstd, level: 23},
+		{codec: CodecSnappy, level: 1},
+		{codec: CodecLz4, level: 1},
+		{codec: CodecNone, level: 1},
+		{codec: "brotli"},
+	} {
+		_, err := NewEncoder(tt.codec, tt.level, nil)
+		assert.Error(t, err, "%s-%d", tt.codec, tt.level)
+	}
+	_, err := NewEncoder(CodecGzip, 0, []byte("dictionary"))
+	assert.Error(t, err)
+}
+
+func zstdSamples() [][]byte {
+	var samples [][]byte
+	for i := 0; i < 64; i++ {
+		var sample strings.Builder
+		for j := 0; j < 20; j++ {
+			fmt.Fprintf(&sample, `{"_":"2b1c9e1e","a":[{"n":%d,"s":"%x","d":%d,"k":[0,4,7,%d]}]}`, (i+j)%9, i*j, 1000+i*j, j)
+		}
+		samples = append(samples, []byte(sample.String()))
+	}
+	return samples
+}
+
+func TestZstdDictionary(t *testing.T) {
+	samples := zstdSamples()
+	dictionary, err := TrainZstdDictionary(samples[:48], 4096, 3)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(dictionary), 4096+1024)
+	id, err := ZstdDictionaryID(dictionary)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, id, uint32(zstdMinDictionaryID))
+
+	plain, err := NewEncoder(CodecZstd, 3, nil)
+	require.NoError(t, err)
+	trained, err := NewEncoder(CodecZstd, 3, dictionary)
+	require.NoError(t, err)
+	sample := samples[60]
+	withoutDictionary, err := plain.Encode(sample)
+	require.NoError(t, err)
+	withDictionary, err := trained.Encode(sample)
+	require.NoError(t, err)
+	assert.Less(t, len(withDictionary), len(withoutDictionary))
+
+	decoder := NewDecoder()
+	_, err = decoder.Decode(CodecZstd, withDictionary)
+	assert.ErrorIs(t, err, ErrUnknownDictionary)
+	added, err := decoder.AddZstdDictionary(dictionary)
+	require.NoError(t, err)
+	assert.Equal(t, id, added)
+	decoded, err := decoder.Decode(CodecZstd, withDictionary)
+	require.NoError(t, err)
+	assert.Equal(t, sample, decoded)
+	decoded, err = decoder.Decode(CodecZstd, withoutDictionary)
+	require.NoError(t, err)
+	assert.Equal(t, sample, decoded)
+
+	upstream, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dictionary))
+	require.NoError(t, err)
+	defer upstream.Close()
+	decoded, err = upstream.DecodeAll(withDictionary, nil)
+	require.NoError(t, err)
+	assert.Equal(t, sample, decoded)
+
+	decoder.RemoveZstdDictionary(id)
+	_, err = decoder.Decode(CodecZstd, withDictionary)
+	assert.ErrorIs(t, err, ErrUnknownDictionary)
+}
+
+func TestTrainZstdDictionarySmall(t *testing.T) {
+	// samples that start with a repeated random block have a repeat offset longer
+	// than the dictionary
+	r := rand.New(rand.NewSource(1))
+	var samples [][]byte
+	for i, sample := range zstdSamples() {
+		block := make([]byte, 1500)
+		r.Read(block)
+		samples = append(samples, append(append(append(block, block...), sample...), byte(i)))
+	}
+	for _, size := range []int{256, 1024} {
+		dictionary, err := TrainZstdDictionary(samples, size, 0)
+		require.NoError(t, err)
+		encoder, err := NewEncoder(CodecZstd, 0, dictionary)
+		require.NoError(t, err, "size %d", size)
+		encoded, err := encoder.Encode(samples[0])
+		require.NoError(t, err)
+		decoder := NewDecoder()
+		_, err = decoder.AddZstdDictionary(dictionary)
+		require.NoError(t, err)
+		decoded, err := decoder.Decode(CodecZstd, encoded)
+		require.NoError(t, err)
+		assert.Equal(t, samples[0], decoded)
+	}
+
+	// a few samples give a dictionary too
+	dictionary, err := TrainZstdDictionary(zstdSamples()[:2], 4096, 0)
+	require.NoError(t, err)
+	encoder, err := NewEncoder(CodecZstd, 0, dictionary)
+	require.NoError(t, err)
+	encoded, err := encoder.Encode(zstdSamples()[2])
+	require.NoError(t, err)
+	decoder := NewDecoder()
+	_, err = decoder.AddZstdDictionary(dictionary)
+	require.NoError(t, err)
+	decoded, err := decoder.Decode(CodecZstd, encoded)
+	require.NoError(t, err)
+	assert.Equal(t, zstdSamples()[2], decoded)
+}
+
+func TestTrainZstdDictionaryInvalid(t *testing.T) {
+	_, err := TrainZstdDictionary(nil, 4096, 0)
+	assert.Error(t, err)
+	_, err = TrainZstdDictionary(zstdSamples(), 10, 0)
+	assert.Error(t, err)
+	_, err = TrainZstdDictionary([][]byte{[]byte("abc")}, 4096, 0)
+	assert.Error(t, err)
+	_, err = ZstdDictionaryID([]byte("not a dictionary"))
+	assert.Error(t, err)
+}
+
+func TestDecoderNewReader(t *testing.T) {
+	encoder, err := NewEncoder(CodecLz4, 0, nil)
+	require.NoError(t, err)
+	encoded, err := encoder.Encode([]byte("a body"))
+	require.NoError(t, err)
+	decoder := NewDecoder()
+	body, err := io.ReadAll(decoder.NewReader(CodecLz4, io.NopCloser(strings.NewReader(string(encoded)))))
+	require.NoError(t, err)
+	assert.Equal(t, "a body", string(body))
+
+	_, err = io.ReadAll(decoder.NewReader(CodecLz4, io.NopCloser(strings.NewReader("not lz4"))))
+	assert.Error(t, err)
+	_, err = decoder.Decode("br", encoded)
+	assert.Error(t, err)
+}
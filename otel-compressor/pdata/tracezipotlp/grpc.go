@@ -0,0 +1,177 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezipotlp // import "go.opentelemetry.io/collector/pdata/tracezipotlp"
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// CodecName is the gRPC content subtype of the messages of the TraceZip stream.
+const CodecName = "tracezip"
+
+const (
+	serviceName = "opentelemetry.tracezip.v1.TraceZipService"
+	streamName  = "Stream"
+)
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// message is StreamRequest or StreamResponse.
+type message interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+// codec marshals the messages of the TraceZip stream, which encode themselves.
+type codec struct{}
+
+func (codec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("unexpected TraceZip stream message %T", v)
+	}
+	return m.Marshal()
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(message)
+	if !ok {
+		return fmt.Errorf("unexpected TraceZip stream message %T", v)
+	}
+	return m.Unmarshal(data)
+}
+
+func (codec) Name() string {
+	return CodecName
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*GRPCServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    streamName,
+			Handler:       streamHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+// GRPCClient is the client API of the TraceZip stream service.
+type GRPCClient interface {
+	// Stream opens a TraceZip stream, which lives until ctx is done or the
+	// stream fails.
+	Stream(ctx context.Context, opts ...grpc.CallOption) (ClientStream, error)
+
+	// unexported disallow implementation of the GRPCClient.
+	unexported()
+}
+
+// ClientStream is the exporter end of a TraceZip stream. Send and Recv may be
+// called from different goroutines, but neither from several at once.
+type ClientStream interface {
+	Send(*StreamRequest) error
+	Recv() (*StreamResponse, error)
+	CloseSend() error
+}
+
+// NewGRPCClient returns a new GRPCClient connected using the given connection.
+func NewGRPCClient(cc *grpc.ClientConn) GRPCClient {
+	return &grpcClient{cc: cc}
+}
+
+type grpcClient struct {
+	cc *grpc.ClientConn
+}
+
+// Stream implements the GRPCClient interface.
+func (c *grpcClient) Stream(ctx context.Context, opts ...grpc.CallOption) (ClientStream, error) {
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(CodecName)}, opts...)
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/"+streamName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &clientStream{stream}, nil
+}
+
+func (c *grpcClient) unexported() {}
+
+type clientStream struct {
+	grpc.ClientStream
+}
+
+func (s *clientStream) Send(request *StreamRequest) error {
+	return s.ClientStream.SendMsg(request)
+}
+
+func (s *clientStream) Recv() (*StreamResponse, error) {
+	response := &StreamResponse{}
+	if err := s.ClientStream.RecvMsg(response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// GRPCServer is the server API of the TraceZip stream service.
+// Implementations MUST embed UnimplementedGRPCServer.
+type GRPCServer interface {
+	// Stream is called for every stream an exporter opens, and serves it until
+	// it ends.
+	Stream(ServerStream) error
+
+	// unexported disallow implementation of the GRPCServer.
+	unexported()
+}
+
+// ServerStream is the receiver end of a TraceZip stream.
+type ServerStream interface {
+	Send(*StreamResponse) error
+	Recv() (*StreamRequest, error)
+	Context() context.Context
+}
+
+var _ GRPCServer = (*UnimplementedGRPCServer)(nil)
+
+// UnimplementedGRPCServer MUST be embedded to have forward compatible implementations.
+type UnimplementedGRPCServer struct{}
+
+func (*UnimplementedGRPCServer) Stream(ServerStream) error {
+	return status.Errorf(codes.Unimplemented, "method Stream not implemented")
+}
+
+func (*UnimplementedGRPCServer) unexported() {}
+
+// RegisterGRPCServer registers the GRPCServer to the grpc.Server.
+func RegisterGRPCServer(s *grpc.Server, srv GRPCServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+func streamHandler(srv any, stream grpc.ServerStream) error {
+	return srv.(GRPCServer).Stream(&serverStream{stream})
+}
+
+type serverStream struct {
+	grpc.ServerStream
+}
+
+func (s *serverStream) Send(response *StreamResponse) error {
+	return s.ServerStream.SendMsg(response)
+}
+
+func (s *serverStream) Recv() (*StreamRequest, error) {
+	request := &StreamRequest{}
+	if err := s.ServerStream.RecvMsg(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
@@ -0,0 +1,97 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezipotlp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newStreamClient(t *testing.T, srv GRPCServer) GRPCClient {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	RegisterGRPCServer(s, srv)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, s.Serve(lis))
+	}()
+	t.Cleanup(func() {
+		s.Stop()
+		wg.Wait()
+	})
+
+	cc, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, cc.Close())
+	})
+	return NewGRPCClient(cc)
+}
+
+func TestGrpcStream(t *testing.T) {
+	client := newStreamClient(t, &fakeStreamServer{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Stream(ctx)
+	require.NoError(t, err)
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		require.NoError(t, stream.Send(&StreamRequest{Seq: seq, Signal: "traces", Uuid: "uuid", Payload: []byte("payload"), Epoch: 1, Version: seq}))
+	}
+	for seq := uint64(1); seq <= 3; seq++ {
+		response, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, &StreamResponse{Seq: seq, Uuid: "uuid", Epoch: 1, Version: seq}, response)
+	}
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestGrpcStreamUnimplemented(t *testing.T) {
+	client := newStreamClient(t, &UnimplementedGRPCServer{})
+	stream, err := client.Stream(context.Background())
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// fakeStreamServer acknowledges every request with the version it needs.
+type fakeStreamServer struct {
+	UnimplementedGRPCServer
+}
+
+func (f fakeStreamServer) Stream(stream ServerStream) error {
+	for {
+		request, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(&StreamResponse{Seq: request.Seq, Uuid: request.Uuid, Epoch: request.Epoch, Version: request.Version}); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package tracezipotlp defines the TraceZip stream, a bidirectional gRPC stream
+// on which an exporter sends the dictionary updates and the payloads of one
+// signal in the order it compressed them, and the receiver answers each of them
+// with the version of the dictionary it has.
+package tracezipotlp // import "go.opentelemetry.io/collector/pdata/tracezipotlp"
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// StreamRequest is a request of the TraceZip stream. It carries a dictionary
+// request, a payload or both, in which case the receiver applies the dictionary
+// request first. Its protobuf layout is
+//
+//	message StreamRequest {
+//	  uint64 seq = 1;
+//	  string signal = 2;
+//	  string uuid = 3;
+//	  bytes dictionary = 4;
+//	  bytes payload = 5;
+//	  string content_type = 6;
+//	  uint64 epoch = 7;
+//	  uint64 version = 8;
+//	}
+type StreamRequest struct {
+	// Seq numbers the requests of a stream, the response of a request has its Seq.
+	Seq uint64
+	// Signal is "traces", "metrics" or "logs".
+	Signal string
+	// Uuid is the dictionary the request belongs to.
+	Uuid string
+	// Dictionary is a dictionary request, in the JSON layout of the dictionary
+	// requests of the HTTP receiver.
+	Dictionary []byte
+	// Payload is a TraceZip payload, the body of a request to the HTTP receiver
+	// with ContentType.
+	Payload     []byte
+	ContentType string
+	// Epoch and Version are the version of the dictionary Payload needs.
+	Epoch   uint64
+	Version uint64
+}
+
+// StreamResponse is the answer of the receiver to the StreamRequest of Seq: the
+// version of the dictionary of Uuid it has after the request, and a gRPC status
+// code. Aborted means that the request needs a version of the dictionary the
+// receiver does not have. Its protobuf layout is
+//
+//	message StreamResponse {
+//	  uint64 seq = 1;
+//	  string uuid = 2;
+//	  uint64 epoch = 3;
+//	  uint64 version = 4;
+//	  uint32 code = 5;
+//	  string message = 6;
+//	}
+type StreamResponse struct {
+	Seq     uint64
+	Uuid    string
+	Epoch   uint64
+	Version uint64
+	Code    uint32
+	Message string
+}
+
+// Marshal returns the protobuf encoding of r.
+func (r *StreamRequest) Marshal() ([]byte, error) {
+	b := appendUint64(nil, 1, r.Seq)
+	b = appendBytes(b, 2, []byte(r.Signal))
+	b = appendBytes(b, 3, []byte(r.Uuid))
+	b = appendBytes(b, 4, r.Dictionary)
+	b = appendBytes(b, 5, r.Payload)
+	b = appendBytes(b, 6, []byte(r.ContentType))
+	b = appendUint64(b, 7, r.Epoch)
+	b = appendUint64(b, 8, r.Version)
+	return b, nil
+}
+
+// Unmarshal decodes the protobuf encoding of a StreamRequest into r.
+func (r *StreamRequest) Unmarshal(data []byte) error {
+	*r = StreamRequest{}
+	return unmarshalFields(data, func(num protowire.Number, value uint64, bytes []byte) {
+		switch num {
+		case 1:
+			r.Seq = value
+		case 2:
+			r.Signal = string(bytes)
+		case 3:
+			r.Uuid = string(bytes)
+		case 4:
+			r.Dictionary = append([]byte(nil), bytes...)
+		case 5:
+			r.Payload = append([]byte(nil), bytes...)
+		case 6:
+			r.ContentType = string(bytes)
+		case 7:
+			r.Epoch = value
+		case 8:
+			r.Version = value
+		}
+	})
+}
+
+// Marshal returns the protobuf encoding of r.
+func (r *StreamResponse) Marshal() ([]byte, error) {
+	b := appendUint64(nil, 1, r.Seq)
+	b = appendBytes(b, 2, []byte(r.Uuid))
+	b = appendUint64(b, 3, r.Epoch)
+	b = appendUint64(b, 4, r.Version)
+	b = appendUint64(b, 5, uint64(r.Code))
+	b = appendBytes(b, 6, []byte(r.Message))
+	return b, nil
+}
+
+// Unmarshal decodes the protobuf encoding of a StreamResponse into r.
+func (r *StreamResponse) Unmarshal(data []byte) error {
+	*r = StreamResponse{}
+	return unmarshalFields(data, func(num protowire.Number, value uint64, bytes []byte) {
+		switch num {
+		case 1:
+			r.Seq = value
+		case 2:
+			r.Uuid = string(bytes)
+		case 3:
+			r.Epoch = value
+		case 4:
+			r.Version = value
+		case 5:
+			r.Code = uint32(value)
+		case 6:
+			r.Message = string(bytes)
+		}
+	})
+}
+
+// appendUint64 appends a varint field, unless value is 0, like proto3 does.
+func appendUint64(b []byte, num protowire.Number, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, value)
+}
+
+// appendBytes appends a length delimited field, unless value is empty.
+func appendBytes(b []byte, num protowire.Number, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+var errMalformed = errors.New("malformed TraceZip stream message")
+
+// unmarshalFields calls field with the varint and length delimited fields of a
+// protobuf message, and skips the fields of other types.
+func unmarshalFields(data []byte, field func(num protowire.Number, value uint64, bytes []byte)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", errMalformed, protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch typ {
+		case protowire.VarintType:
+			var value uint64
+			value, n = protowire.ConsumeVarint(data)
+			if n >= 0 {
+				field(num, value, nil)
+			}
+		case protowire.BytesType:
+			var bytes []byte
+			bytes, n = protowire.ConsumeBytes(data)
+			if n >= 0 {
+				field(num, 0, bytes)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("%w: %v", errMalformed, protowire.ParseError(n))
+		}
+		data = data[n:]
+	}
+	return nil
+}
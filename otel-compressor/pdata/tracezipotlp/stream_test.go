@@ -0,0 +1,51 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezipotlp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestStreamRequestMarshal(t *testing.T) {
+	request := &StreamRequest{
+		Seq:         7,
+		Signal:      "traces",
+		Uuid:        "uuid",
+		Dictionary:  []byte(`{"_":"uuid","e":1,"s":2,"t":"i","n":[]}`),
+		Payload:     []byte{0, 1, 2},
+		ContentType: "application/json",
+		Epoch:       1,
+		Version:     2,
+	}
+	data, err := request.Marshal()
+	require.NoError(t, err)
+	actual := &StreamRequest{}
+	require.NoError(t, actual.Unmarshal(data))
+	assert.Equal(t, request, actual)
+
+	// Empty fields are left out.
+	data, err = (&StreamRequest{}).Marshal()
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestStreamResponseMarshal(t *testing.T) {
+	response := &StreamResponse{Seq: 1, Uuid: "uuid", Epoch: 3, Version: 4, Code: 10, Message: "conflict"}
+	data, err := response.Marshal()
+	require.NoError(t, err)
+
+	// Unknown fields are skipped.
+	data = protowire.AppendTag(data, 99, protowire.Fixed32Type)
+	data = protowire.AppendFixed32(data, 5)
+	actual := &StreamResponse{}
+	require.NoError(t, actual.Unmarshal(data))
+	assert.Equal(t, response, actual)
+
+	assert.Error(t, actual.Unmarshal([]byte{0x0a, 0x05, 'u'}))
+	assert.Error(t, actual.Unmarshal([]byte{0x08}))
+}
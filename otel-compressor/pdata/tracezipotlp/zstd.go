@@ -0,0 +1,130 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracezipotlp // import "go.opentelemetry.io/collector/pdata/tracezipotlp"
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// zstdDictionaryMagic starts a zstd dictionary, its ID follows.
+	zstdDictionaryMagic = 0xEC30A437
+	// IDs below are reserved by the zstd format.
+	zstdMinDictionaryID = 1 << 15
+
+	// dictionaries are put together from segments of the samples, and segments are
+	// scored by the samples their kmers occur in
+	zstdSegmentSize = 64
+	zstdKmerSize    = 8
+)
+
+// ZstdDictionaryID returns the ID of a zstd dictionary.
+func ZstdDictionaryID(dictionary []byte) (uint32, error) {
+	if len(dictionary) < 8 || binary.LittleEndian.Uint32(dictionary) != zstdDictionaryMagic {
+		return 0, errors.New("not a zstd dictionary")
+	}
+	return binary.LittleEndian.Uint32(dictionary[4:]), nil
+}
+
+// TrainZstdDictionary returns a zstd dictionary of about size bytes for bodies like
+// samples, under a random ID. Its content are the segments of the samples whose
+// kmers occur in the most samples, the best ones last, where zstd finds them at
+// the shortest offsets, after the latest samples; its entropy tables are the ones
+// of the samples compressed at level, a level of the zstd command, 0 for the
+// default one.
+func TrainZstdDictionary(samples [][]byte, size int, level int) ([]byte, error) {
+	if len(samples) == 0 {
+		return nil, errors.New("no samples to train a zstd dictionary")
+	}
+	if size < zstdSegmentSize {
+		return nil, fmt.Errorf("zstd dictionary size %d is less than %d", size, zstdSegmentSize)
+	}
+
+	// the number of samples each kmer occurs in
+	occurrences := make(map[uint64]int)
+	seen := make(map[uint64]bool)
+	for _, sample := range samples {
+		clear(seen)
+		for i := 0; i+zstdKmerSize <= len(sample); i++ {
+			kmer := binary.LittleEndian.Uint64(sample[i:])
+			if !seen[kmer] {
+				seen[kmer] = true
+				occurrences[kmer]++
+			}
+		}
+	}
+
+	type segment struct {
+		data  []byte
+		score int
+	}
+	var segments []segment
+	for _, sample := range samples {
+		for i := 0; i+zstdSegmentSize <= len(sample); i += zstdSegmentSize {
+			data := sample[i : i+zstdSegmentSize]
+			segments = append(segments, segment{data: data, score: zstdSegmentScore(data, occurrences)})
+		}
+	}
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].score > segments[j].score
+	})
+
+	// Segments are taken greedily. The kmers of a segment taken score no more, so
+	// that segments that repeat the ones taken are left out.
+	var picked [][]byte
+	total := 0
+	for _, s := range segments {
+		if total+zstdSegmentSize > size {
+			break
+		}
+		// kmers occurring in one sample only do not pay for their place
+		if zstdSegmentScore(s.data, occurrences) < 2*(zstdSegmentSize-zstdKmerSize+1) {
+			continue
+		}
+		for i := 0; i+zstdKmerSize <= len(s.data); i++ {
+			occurrences[binary.LittleEndian.Uint64(s.data[i:])] = 0
+		}
+		picked = append(picked, s.data)
+		total += len(s.data)
+	}
+	history := make([]byte, 0, total)
+	for i := len(picked) - 1; i >= 0; i-- {
+		history = append(history, picked[i]...)
+	}
+	// The rest is filled with the latest samples, in front of the segments, for what
+	// the segments miss.
+	for i := len(samples) - 1; i >= 0 && len(history) < size; i-- {
+		sample := samples[i][max(0, len(samples[i])-(size-len(history))):]
+		history = append(append([]byte(nil), sample...), history...)
+	}
+	if len(history) < 8 {
+		return nil, errors.New("the samples are too small to train a zstd dictionary")
+	}
+
+	options := zstd.BuildDictOptions{
+		ID:       zstdMinDictionaryID + uint32(rand.Int63n(1<<31-zstdMinDictionaryID)),
+		Contents: samples,
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+	}
+	if level > 0 {
+		options.Level = zstd.EncoderLevelFromZstd(level)
+	}
+	return zstd.BuildDict(options)
+}
+
+// zstdSegmentScore returns the sum of the occurrences of the kmers of a segment.
+func zstdSegmentScore(data []byte, occurrences map[uint64]int) int {
+	score := 0
+	for i := 0; i+zstdKmerSize <= len(data); i++ {
+		score += occurrences[binary.LittleEndian.Uint64(data[i:])]
+	}
+	return score
+}
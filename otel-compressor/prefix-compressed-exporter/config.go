@@ -7,11 +7,14 @@ import (
 	"encoding"
 	"errors"
 	"fmt"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configgrpc"
 	"go.opentelemetry.io/collector/config/confighttp"
 	"go.opentelemetry.io/collector/config/configretry"
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
+	"go.opentelemetry.io/collector/pdata/tracezipotlp"
 )
 
 // EncodingType defines the type for content encoding
@@ -43,6 +46,160 @@ func (e *EncodingType) UnmarshalText(text []byte) error {
 	return nil
 }
 
+// TraceZipFormat defines the wire format of TraceZip payloads
+type TraceZipFormat string
+
+const (
+	TraceZipFormatJSON   TraceZipFormat = "json"
+	TraceZipFormatBinary TraceZipFormat = "binary"
+)
+
+var _ encoding.TextUnmarshaler = (*TraceZipFormat)(nil)
+
+// UnmarshalText unmarshalls text to a TraceZipFormat.
+func (f *TraceZipFormat) UnmarshalText(text []byte) error {
+	if f == nil {
+		return errors.New("cannot unmarshal to a nil *TraceZipFormat")
+	}
+
+	str := string(text)
+	switch str {
+	case string(TraceZipFormatJSON):
+		*f = TraceZipFormatJSON
+	case string(TraceZipFormatBinary):
+		*f = TraceZipFormatBinary
+	default:
+		return fmt.Errorf("invalid tracezip format: %s", str)
+	}
+
+	return nil
+}
+
+// TimestampCodec defines how TraceZip encodes span and event timestamps
+type TimestampCodec string
+
+const (
+	TimestampCodecOffset   TimestampCodec = "offset"
+	TimestampCodecRelative TimestampCodec = "relative"
+)
+
+var _ encoding.TextUnmarshaler = (*TimestampCodec)(nil)
+
+// UnmarshalText unmarshalls text to a TimestampCodec.
+func (t *TimestampCodec) UnmarshalText(text []byte) error {
+	if t == nil {
+		return errors.New("cannot unmarshal to a nil *TimestampCodec")
+	}
+
+	str := string(text)
+	switch str {
+	case string(TimestampCodecOffset):
+		*t = TimestampCodecOffset
+	case string(TimestampCodecRelative):
+		*t = TimestampCodecRelative
+	default:
+		return fmt.Errorf("invalid timestamp codec: %s", str)
+	}
+
+	return nil
+}
+
+// AttrOrder defines how the attributes of the span retrieve trie are picked and ordered
+type AttrOrder string
+
+const (
+	AttrOrderCardinality AttrOrder = "cardinality"
+	AttrOrderEntropy     AttrOrder = "entropy"
+)
+
+var _ encoding.TextUnmarshaler = (*AttrOrder)(nil)
+
+// UnmarshalText unmarshalls text to an AttrOrder.
+func (o *AttrOrder) UnmarshalText(text []byte) error {
+	if o == nil {
+		return errors.New("cannot unmarshal to a nil *AttrOrder")
+	}
+
+	str := string(text)
+	switch str {
+	case string(AttrOrderCardinality):
+		*o = AttrOrderCardinality
+	case string(AttrOrderEntropy):
+		*o = AttrOrderEntropy
+	default:
+		return fmt.Errorf("invalid attribute order: %s", str)
+	}
+
+	return nil
+}
+
+// EvictionPolicy defines which dictionary entries are evicted first under memory_limit
+type EvictionPolicy string
+
+const (
+	EvictionPolicyLRU EvictionPolicy = "lru"
+	EvictionPolicyLFU EvictionPolicy = "lfu"
+)
+
+var _ encoding.TextUnmarshaler = (*EvictionPolicy)(nil)
+
+// UnmarshalText unmarshalls text to an EvictionPolicy.
+func (p *EvictionPolicy) UnmarshalText(text []byte) error {
+	if p == nil {
+		return errors.New("cannot unmarshal to a nil *EvictionPolicy")
+	}
+
+	str := string(text)
+	switch str {
+	case string(EvictionPolicyLRU):
+		*p = EvictionPolicyLRU
+	case string(EvictionPolicyLFU):
+		*p = EvictionPolicyLFU
+	default:
+		return fmt.Errorf("invalid eviction policy: %s", str)
+	}
+
+	return nil
+}
+
+// CodecType defines the codec the bodies of requests are compressed with after TraceZip
+type CodecType string
+
+const (
+	CodecNone   CodecType = tracezipotlp.CodecNone
+	CodecGzip   CodecType = tracezipotlp.CodecGzip
+	CodecZstd   CodecType = tracezipotlp.CodecZstd
+	CodecSnappy CodecType = tracezipotlp.CodecSnappy
+	CodecLz4    CodecType = tracezipotlp.CodecLz4
+)
+
+var _ encoding.TextUnmarshaler = (*CodecType)(nil)
+
+// UnmarshalText unmarshalls text to a CodecType.
+func (c *CodecType) UnmarshalText(text []byte) error {
+	if c == nil {
+		return errors.New("cannot unmarshal to a nil *CodecType")
+	}
+
+	str := string(text)
+	switch str {
+	case string(CodecNone):
+		*c = CodecNone
+	case string(CodecGzip):
+		*c = CodecGzip
+	case string(CodecZstd):
+		*c = CodecZstd
+	case string(CodecSnappy):
+		*c = CodecSnappy
+	case string(CodecLz4):
+		*c = CodecLz4
+	default:
+		return fmt.Errorf("invalid codec: %s", str)
+	}
+
+	return nil
+}
+
 // Config defines configuration for OTLP/HTTP exporter.
 type Config struct {
 	confighttp.ClientConfig `mapstructure:",squash"`     // squash ensures fields are correctly decoded in embedded struct.
@@ -63,17 +220,88 @@ type Config struct {
 
 	TrieBuffer int `mapstructure:"sample_buffer"`
 
+	// How long a span stays in the sample buffer, 0 for as long as sample_buffer allows.
+	SampleWindow time.Duration `mapstructure:"sample_window"`
+
 	ThresholdRate int `mapstructure:"srt_threshold"`
 
 	AttrLimit int `mapstructure:"attr_limit"`
 
+	// How the trie attributes of a span name are picked and ordered, "cardinality" takes all
+	// of them, fewer values first, "entropy" by conditional entropy (default: "cardinality")
+	AttrOrder AttrOrder `mapstructure:"attr_order"`
+
 	CalcZipRate bool `mapstructure:"calc_zip_rate"`
 
+	// Same as codec "gzip".
 	EnableGzip bool `mapstructure:"enable_gzip"`
 
+	// The codec the bodies of requests are compressed with, "none", "gzip", "zstd", "snappy"
+	// or "lz4" (default: "none")
+	Codec CodecType `mapstructure:"codec"`
+
+	// The level of codec, 1 to 9 for gzip and 1 to 22 for zstd, 0 for the default one.
+	CodecLevel int `mapstructure:"codec_level"`
+
+	// The size of the zstd dictionary trained from TraceZip payloads and sent to the receiver
+	// over the dictionary channel, 0 for none. Requires codec "zstd".
+	ZstdDictionarySize int `mapstructure:"zstd_dictionary_size"`
+
+	// How many TraceZip payloads the zstd dictionary is trained from (default: 128)
+	ZstdDictionarySamples int `mapstructure:"zstd_dictionary_samples"`
+
 	DeleteResource bool `mapstructure:"delete_resource"`
 
+	// How many trace IDs of earlier batches the receiver keeps to refer to them by handle.
+	TraceIdWindow int `mapstructure:"trace_id_window"`
+
 	NoTraceZip bool `mapstructure:"no_tracezip"`
+
+	// Whether logs are compressed with TraceZip too, which only a prefix_compressed_receiver
+	// decodes. Off by default, logs are sent as OTLP then.
+	LogsTraceZip bool `mapstructure:"logs_tracezip"`
+
+	// Whether metrics are compressed with TraceZip too, which only a prefix_compressed_receiver
+	// decodes. Off by default, metrics are sent as OTLP then.
+	MetricsTraceZip bool `mapstructure:"metrics_tracezip"`
+
+	// The wire format of TraceZip payloads, "json" or the columnar "binary" (default: "json")
+	TraceZipFormat TraceZipFormat `mapstructure:"tracezip_format"`
+
+	// Whether the dictionary update of a batch is sent in the request of its payload instead of
+	// a dictionary request of its own. Requires tracezip_format "json".
+	InlineDictionary bool `mapstructure:"inline_dictionary"`
+
+	// How span and event timestamps are encoded, "offset" from the start of the batch or
+	// "relative" to the parent or previous span of the trace (default: "offset")
+	TimestampCodec TimestampCodec `mapstructure:"timestamp_codec"`
+
+	// Whether span attributes kept out of the trie are split into mined templates and parameters.
+	TemplateMining bool `mapstructure:"template_mining"`
+
+	// Whether URLs and SQL statements kept out of the trie are split by codecs that know their structure.
+	StructuredCodecs bool `mapstructure:"structured_codecs"`
+
+	// The bytes the dictionaries, tries and templates of a signal may take, 0 for no limit.
+	MemoryLimit int `mapstructure:"memory_limit"`
+
+	// Which dictionary entries memory_limit evicts first, the least recently used ("lru")
+	// or the least frequently used ("lfu") ones (default: "lru")
+	EvictionPolicy EvictionPolicy `mapstructure:"eviction_policy"`
+
+	// The directory the TraceZip dictionaries are saved to, so that a restarted exporter goes on
+	// with the dictionaries the receiver has instead of sending full ones. Empty for none.
+	DictionaryDirectory string `mapstructure:"dictionary_directory"`
+
+	// The storage extension the TraceZip dictionaries are saved through instead of dictionary_directory.
+	DictionaryStorage *component.ID `mapstructure:"dictionary_storage"`
+
+	// How often changed dictionaries are saved, 0 to save them on shutdown only (default: 10s)
+	DictionarySaveInterval time.Duration `mapstructure:"dictionary_save_interval"`
+
+	// The gRPC connection to the receiver to send TraceZip dictionary updates and payloads
+	// on, in order on one stream. Nil to send them over HTTP.
+	GRPC *configgrpc.ClientConfig `mapstructure:"grpc"`
 }
 
 var _ component.Config = (*Config)(nil)
@@ -83,9 +311,42 @@ func (cfg *Config) Validate() error {
 	if cfg.Endpoint == "" && cfg.TracesEndpoint == "" && cfg.MetricsEndpoint == "" && cfg.LogsEndpoint == "" {
 		return errors.New("at least one endpoint must be specified")
 	}
+	if cfg.GRPC != nil && cfg.GRPC.Endpoint == "" {
+		return errors.New("grpc::endpoint must be specified")
+	}
+	if cfg.InlineDictionary && cfg.TraceZipFormat == TraceZipFormatBinary {
+		return errors.New("inline_dictionary requires tracezip_format json")
+	}
+	if cfg.EnableGzip && cfg.Codec != CodecNone && cfg.Codec != CodecGzip {
+		return fmt.Errorf("enable_gzip conflicts with codec %s", cfg.Codec)
+	}
+	if _, err := tracezipotlp.NewEncoder(string(cfg.codec()), cfg.CodecLevel, nil); err != nil {
+		return err
+	}
+	if cfg.ZstdDictionarySize < 0 {
+		return errors.New("zstd_dictionary_size must not be negative")
+	}
+	if cfg.ZstdDictionarySize > 0 && cfg.codec() != CodecZstd {
+		return errors.New("zstd_dictionary_size requires codec zstd")
+	}
+	if cfg.ZstdDictionarySize > 0 && cfg.ZstdDictionarySamples <= 0 {
+		return errors.New("zstd_dictionary_samples must be greater than 0")
+	}
 	if cfg.TrieBuffer <= 0 {
 		return errors.New("trie_buffer must greater than 0")
 	}
+	if cfg.SampleWindow < 0 {
+		return errors.New("sample_window must not be negative")
+	}
+	if cfg.TraceIdWindow < 0 {
+		return errors.New("trace_id_window must not be negative")
+	}
+	if cfg.MemoryLimit < 0 {
+		return errors.New("memory_limit must not be negative")
+	}
+	if cfg.DictionarySaveInterval < 0 {
+		return errors.New("dictionary_save_interval must not be negative")
+	}
 	if cfg.ThresholdRate <= 0 {
 		return errors.New("srt_threshold must be greater than 0")
 	}
@@ -94,3 +355,14 @@ func (cfg *Config) Validate() error {
 	}
 	return nil
 }
+
+// codec returns the codec of the bodies of requests, gzip for enable_gzip.
+func (cfg *Config) codec() CodecType {
+	if cfg.EnableGzip && (cfg.Codec == "" || cfg.Codec == CodecNone) {
+		return CodecGzip
+	}
+	if cfg.Codec == "" {
+		return CodecNone
+	}
+	return cfg.Codec
+}
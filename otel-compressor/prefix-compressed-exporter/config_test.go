@@ -0,0 +1,35 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package prefix_compressed_exporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateInlineDictionary(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		inline bool
+		format TraceZipFormat
+		err    string
+	}{
+		{name: "json", inline: true, format: TraceZipFormatJSON},
+		{name: "binary", inline: true, format: TraceZipFormatBinary, err: "inline_dictionary requires tracezip_format json"},
+		{name: "binary without inline", format: TraceZipFormatBinary},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = "http://localhost:4318"
+			cfg.InlineDictionary = tt.inline
+			cfg.TraceZipFormat = tt.format
+			if tt.err == "" {
+				assert.NoError(t, cfg.Validate())
+			} else {
+				assert.EqualError(t, cfg.Validate(), tt.err)
+			}
+		})
+	}
+}
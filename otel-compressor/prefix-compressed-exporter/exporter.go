@@ -19,8 +19,11 @@ import (
 	"github.com/ulikunitz/xz/lzma"
 	"go.uber.org/zap"
 	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/proto"
 
+	dictionarystore "angrychow/otel/dictionary-store"
+
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer/consumererror"
 	"go.opentelemetry.io/collector/exporter"
@@ -31,22 +34,143 @@ import (
 	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
 	"go.opentelemetry.io/collector/pdata/ptrace"
 	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"go.opentelemetry.io/collector/pdata/tracezipotlp"
 )
 
 type baseExporter struct {
 	// Input configuration.
-	config        *Config
-	client        *http.Client
-	tracesURL     string
-	tracesdictURL string
-	metricsURL    string
-	logsURL       string
-	logger        *zap.Logger
-	settings      component.TelemetrySettings
+	config         *Config
+	client         *http.Client
+	tracesURL      string
+	tracesdictURL  string
+	metricsURL     string
+	metricsdictURL string
+	logsURL        string
+	logsdictURL    string
+	logger         *zap.Logger
+	settings       component.TelemetrySettings
 	// Default user-agent header.
 	userAgent string
+
+	// traceZip, metricZip and logZip hold the TraceZip dictionaries of this exporter instance.
+	// metricZip is nil without metrics_tracezip, logZip without logs_tracezip.
+	traceZip  *ptraceotlp.TraceZipCompressor
+	metricZip *pmetricotlp.TraceZipCompressor
+	logZip    *plogotlp.TraceZipCompressor
+	dictMu    sync.Mutex
+
+	// zip is the one of traceZip, metricZip and logZip the exporter has, signal the
+	// name of its signal
+	zip    traceZipCompressor
+	signal string
+	id     component.ID
+	// store keeps the dictionary of zip across restarts, nil if it is not saved.
+	// dictionaryChanged is set when the receiver applied an update that is not
+	// saved yet, verifyDictionary until the receiver confirmed it has a restored
+	// dictionary.
+	store             dictionarystore.Store
+	dictionaryChanged bool
+	verifyDictionary  bool
+	stopSaving        func()
+	// history are the incremental updates of the current epoch the receiver has
+	// not acknowledged yet, oldest first. A receiver that missed some of them gets
+	// them again from the version it has.
+	history []dictionaryRequest
+	// recoverMu serializes the recoveries of the receiver's dictionary, which run
+	// without dictMu so that batches are compressed meanwhile.
+	recoverMu sync.Mutex
+
+	// grpcConn and stream carry the TraceZip requests when grpc is configured.
+	grpcConn *grpc.ClientConn
+	stream   *traceZipStream
+
+	// encoder compresses the bodies of requests with codec. With
+	// zstd_dictionary_size, the zstd dictionary is trained from zstdSamples, and
+	// zstdEncoder compresses TraceZip payloads with it once the receiver has it.
+	encoder        *tracezipotlp.Encoder
+	zstdDictionary []byte
+	zstdEncoder    *tracezipotlp.Encoder
+	zstdSamples    [][]byte
+	// zstdTraining is set while a zstd dictionary is trained and sent
+	zstdTraining bool
+}
+
+// traceZipCompressor is the TraceZip compressor of any signal.
+type traceZipCompressor interface {
+	DictionaryUuid() string
+	Version() (uint64, uint64)
+	Resync() []interface{}
+	Hold() uint64
+	Release(token uint64)
+	Floor() uint64
+	Snapshot() ([]byte, error)
+	Restore(snapshot []byte) error
+}
+
+// dictionaryVersion is the version of a dictionary, see
+// ptraceotlp.TraceZipCompressor.Version.
+type dictionaryVersion struct {
+	Epoch   uint64 `json:"e"`
+	Version uint64 `json:"s"`
+}
+
+func (v dictionaryVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Epoch, v.Version)
+}
+
+// dictionaryRequest is the body of a dictionary request. The version is the one of
+// the dictionary with the update. Type is "a" for a full update, "i" for an
+// incremental one, and "v" asks the receiver to verify that it has the dictionary
+// at that version. "z" verifies the same and sends Zstd, the zstd dictionary of
+// the payloads, which a full update carries too. Floor is the oldest version of
+// the epoch a payload on its way may still need when an incremental update is
+// made; the entries the update drops are used by none of them, and the receiver
+// refuses payloads of older versions.
+type dictionaryRequest struct {
+	Uuid string `json:"_"`
+	dictionaryVersion
+	Type   string        `json:"t"`
+	Update []interface{} `json:"n,omitempty"`
+	Zstd   []byte        `json:"z,omitempty"`
+	Floor  uint64        `json:"f,omitempty"`
+}
+
+// dictionaryAck is the answer of the receiver to a dictionary request, and to a
+// payload that needs a version of the dictionary it does not have: the version
+// it has applied, 0.0 if it has none.
+type dictionaryAck struct {
+	Uuid string `json:"_"`
+	dictionaryVersion
+}
+
+// dictionaryConflictError is returned when the receiver answers 409 Conflict to a
+// dictionary update or a payload, since it does not have the version of the
+// dictionary they need. have is the version it has.
+type dictionaryConflictError struct {
+	have dictionaryVersion
+}
+
+func (e *dictionaryConflictError) Error() string {
+	return fmt.Sprintf("the receiver has version %v of the dictionary", e.have)
 }
 
+// recompressError is returned for a payload the receiver refused with a conflict,
+// once the receiver has the dictionary of zip again. The payload is not sent
+// again as it is, since later batches may have dropped or redefined entries it
+// uses: the error is retryable, and the batch is compressed again.
+func recompressError(conflict *dictionaryConflictError) error {
+	return fmt.Errorf("the TraceZip payload is compressed again with the current dictionary: %w", conflict)
+}
+
+const (
+	// headerDictionaryVersion is the header of a TraceZip payload with the version
+	// of the dictionary it needs, as "epoch.version".
+	headerDictionaryVersion = "Tracezip-Dictionary-Version"
+	// maxDictionaryHistory is the number of unacknowledged updates kept to resend,
+	// a receiver that missed older ones gets the whole dictionary.
+	maxDictionaryHistory = 64
+)
+
 type CompressorStat struct {
 	gzipOnlyTotal      int
 	mergingNoGzipTotal int
@@ -56,6 +180,23 @@ type CompressorStat struct {
 	bzipTotal          int
 	mergingLzmaTotal   int
 	mergingBzipTotal   int
+	// TraceZip payloads without the dictionaries, in both wire formats.
+	traceZipJSONTotal   int
+	traceZipBinaryTotal int
+}
+
+// add adds the sizes of other to s.
+func (s *CompressorStat) add(other CompressorStat) {
+	s.gzipOnlyTotal += other.gzipOnlyTotal
+	s.mergingNoGzipTotal += other.mergingNoGzipTotal
+	s.mergingTotal += other.mergingTotal
+	s.originTotal += other.originTotal
+	s.lzmaTotal += other.lzmaTotal
+	s.bzipTotal += other.bzipTotal
+	s.mergingLzmaTotal += other.mergingLzmaTotal
+	s.mergingBzipTotal += other.mergingBzipTotal
+	s.traceZipJSONTotal += other.traceZipJSONTotal
+	s.traceZipBinaryTotal += other.traceZipBinaryTotal
 }
 
 func CompressLZMA(data []byte) (int, error) {
@@ -95,8 +236,6 @@ func CompressBZIP2(data []byte) (int, error) {
 
 var compressorStat CompressorStat
 
-var needResetOrder = false
-
 var CompressionTotalTime time.Duration
 
 var GzipTotalTime time.Duration
@@ -126,6 +265,11 @@ func newExporter(cfg component.Config, set exporter.CreateSettings) (*baseExport
 		}
 	}
 
+	encoder, err := tracezipotlp.NewEncoder(string(oCfg.codec()), oCfg.CodecLevel, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	userAgent := fmt.Sprintf("%s/%s (%s/%s)",
 		set.BuildInfo.Description, set.BuildInfo.Version, runtime.GOOS, runtime.GOARCH)
 
@@ -135,237 +279,257 @@ func newExporter(cfg component.Config, set exporter.CreateSettings) (*baseExport
 		logger:    set.Logger,
 		userAgent: userAgent,
 		settings:  set.TelemetrySettings,
+		id:        set.ID,
+		encoder:   encoder,
 	}, nil
 }
 
 // start actually creates the HTTP client. The client construction is deferred till this point as this
 // is the only place we get hold of Extensions which are required to construct auth round tripper.
-func (e *baseExporter) start(_ context.Context, host component.Host) error {
+// With grpc, it also creates the connection the TraceZip stream is opened on.
+func (e *baseExporter) start(ctx context.Context, host component.Host) error {
 	client, err := e.config.ClientConfig.ToClient(host, e.settings)
 	if err != nil {
 		return err
 	}
 	e.client = client
-	return nil
+	switch {
+	case e.traceZip != nil:
+		e.zip, e.signal = e.traceZip, "traces"
+	case e.metricZip != nil:
+		e.zip, e.signal = e.metricZip, "metrics"
+	case e.logZip != nil:
+		e.zip, e.signal = e.logZip, "logs"
+	}
+	if e.zip != nil && e.config.GRPC != nil && !e.config.NoTraceZip && e.config.Encoding == EncodingJSON {
+		if e.grpcConn, err = e.config.GRPC.ToClientConn(ctx, host, e.settings); err != nil {
+			return err
+		}
+		e.stream = newTraceZipStream(tracezipotlp.NewGRPCClient(e.grpcConn))
+	}
+	return e.restoreDictionary(ctx, host)
 }
 
-var DictRWM sync.RWMutex
-
-var SerilizeLock sync.Mutex
+// shutdown closes the TraceZip stream and saves the dictionary a last time.
+func (e *baseExporter) shutdown(ctx context.Context) error {
+	var err error
+	if e.stream != nil {
+		e.stream.close()
+		err = e.grpcConn.Close()
+	}
+	if e.store == nil {
+		return err
+	}
+	if e.stopSaving != nil {
+		e.stopSaving()
+	}
+	e.saveDictionary(ctx)
+	return errors.Join(err, e.store.Close(ctx))
+}
 
-func (e *baseExporter) pushTraces(ctx context.Context, td ptrace.Traces) error {
+// restoreDictionary opens the dictionary store, restores the compressor from it
+// and starts saving it every dictionary_save_interval. A restored dictionary is
+// verified with the receiver before the first payload; if the receiver does not
+// have it at the same version, it gets the whole dictionary.
+func (e *baseExporter) restoreDictionary(ctx context.Context, host component.Host) error {
+	if e.zip == nil || e.config.NoTraceZip {
+		return nil
+	}
+	store, err := dictionarystore.New(ctx, host, component.KindExporter, e.id, e.config.DictionaryDirectory, e.config.DictionaryStorage)
+	if err != nil || store == nil {
+		return err
+	}
+	e.store = store
 
-	if e.config.CalcZipRate {
-		SerilizeLock.Lock()
-		defer SerilizeLock.Unlock()
+	snapshot, err := store.Get(ctx, e.signal)
+	if err != nil {
+		e.logger.Warn("Failed to read the saved TraceZip dictionary", zap.Error(err))
+	} else if snapshot != nil {
+		if err = e.zip.Restore(snapshot); err != nil {
+			e.logger.Warn("Dropping the saved TraceZip dictionary", zap.Error(err))
+		} else {
+			e.verifyDictionary = true
+			e.logger.Info("Restored the TraceZip dictionary", zap.String("uuid", e.zip.DictionaryUuid()))
+		}
 	}
 
-	tr := ptraceotlp.NewExportRequestFromTraces(td)
+	if e.config.DictionarySaveInterval > 0 {
+		e.stopSaving = dictionarystore.RunEvery(e.config.DictionarySaveInterval, func(time.Time) {
+			e.saveDictionary(context.Background())
+		})
+	}
+	return nil
+}
 
-	if e.config.NoTraceZip && e.config.EnableGzip {
-		var buf bytes.Buffer
-		orig, _ := tr.MarshalJSON()
-		gz := gzip.NewWriter(&buf)
-		if _, err := gz.Write(orig); err != nil {
-			return err
-		}
-		if err := gz.Close(); err != nil {
-			return err
-		}
-		return e.export(ctx, e.tracesURL, buf.Bytes(), e.logsPartialSuccessHandler)
+// saveDictionary saves the compressor if the receiver applied an update since it
+// was last saved.
+func (e *baseExporter) saveDictionary(ctx context.Context) {
+	e.dictMu.Lock()
+	defer e.dictMu.Unlock()
+	if !e.dictionaryChanged {
+		return
+	}
+	snapshot, err := e.zip.Snapshot()
+	if err == nil {
+		err = e.store.Set(ctx, e.signal, snapshot)
 	}
+	if err != nil {
+		e.logger.Warn("Failed to save the TraceZip dictionary", zap.Error(err))
+		return
+	}
+	e.dictionaryChanged = false
+}
+
+// SerilizeLock guards compressorStat, DictSizeNow and the total times, which the
+// concurrent exports of all exporters add to.
+var SerilizeLock sync.Mutex
+
+func (e *baseExporter) pushTraces(ctx context.Context, td ptrace.Traces) error {
+	tr := ptraceotlp.NewExportRequestFromTraces(td)
 
 	if e.config.NoTraceZip {
 		orig, _ := tr.MarshalJSON()
 		return e.export(ctx, e.tracesURL, orig, e.logsPartialSuccessHandler)
 	}
 
-	var err error
-	var request []byte
-	isUpdate := false
-	UpdateWrapper := make(map[string]interface{})
-	ExportWrapper := make(map[string]interface{})
-	var export interface{}
-	var subeteUpdate, incrementUpdate []interface{}
-	var dictionaryUuid string
-	switch e.config.Encoding {
-	case EncodingJSON:
-		start := time.Now()
-		DictRWM.Lock()
-		dictionaryUuid, subeteUpdate, incrementUpdate, export = tr.MarshalWithTraceZip(e.config.TrieBuffer, e.config.AttrLimit, e.config.ThresholdRate, needResetOrder, e.config.DeleteResource)
-		CompressionTotalTime += time.Since(start)
-		needResetOrder = false
-	case EncodingProto:
-		request, err = tr.MarshalProto()
-	default:
-		err = fmt.Errorf("invalid encoding: %s", e.config.Encoding)
-	}
-	if e.config.Encoding == EncodingJSON {
-		UpdateWrapper["_"] = dictionaryUuid
-		ExportWrapper["_"] = dictionaryUuid
-		ExportWrapper["a"] = export
-		request, _ = json.Marshal(ExportWrapper)
-		if len(subeteUpdate) > 0 {
-			UpdateWrapper["t"] = "a"
-			UpdateWrapper["n"] = subeteUpdate
-			isUpdate = true
-		} else if len(incrementUpdate) > 0 {
-			UpdateWrapper["t"] = "i"
-			UpdateWrapper["n"] = incrementUpdate
-			isUpdate = true
+	if e.config.Encoding != EncodingJSON {
+		var request []byte
+		var err error
+		switch e.config.Encoding {
+		case EncodingProto:
+			request, err = tr.MarshalProto()
+		default:
+			err = fmt.Errorf("invalid encoding: %s", e.config.Encoding)
 		}
-
 		if err != nil {
 			return consumererror.NewPermanent(err)
 		}
+		return e.export(ctx, e.tracesURL, request, e.tracesPartialSuccessHandler)
+	}
 
-		if isUpdate {
-			// Update
-			dictJson, err := json.Marshal(UpdateWrapper)
-			if err != nil {
-				return err
-			}
-
-			if len(subeteUpdate) > 0 {
-				DictSizeNow = len(dictJson)
-			} else {
-				DictSizeNow += len(dictJson)
-			}
-
-			var reqBody *bytes.Buffer
-			var contentType string
-
-			if e.config.EnableGzip {
-				var buf bytes.Buffer
-				gz := gzip.NewWriter(&buf)
-				start := time.Now()
-				if _, err := gz.Write(dictJson); err != nil {
-					return err
-				}
-				if err := gz.Close(); err != nil {
-					return err
-				}
-				GzipTotalTime += time.Since(start)
-				reqBody = &buf
-				contentType = "application/json"
-			} else {
-				reqBody = bytes.NewBuffer(dictJson)
-				contentType = "application/json"
-			}
-
-			if e.config.CalcZipRate {
-				var buf bytes.Buffer
-				start := time.Now()
-				orig, _ := tr.MarshalJSON__(e.config.DeleteResource)
-				GzipOnlyMarshalTime += time.Since(start)
-				gz := gzip.NewWriter(&buf)
-				if _, err := gz.Write(orig); err != nil {
-					return err
-				}
-				if err := gz.Close(); err != nil {
-					return err
-				}
-				GzipOnlyTotalTime += time.Since(start)
-				compressorStat.mergingTotal += reqBody.Len()
-				compressorStat.mergingNoGzipTotal += len(dictJson)
-				compressorStat.originTotal += len(orig)
-				compressorStat.gzipOnlyTotal += buf.Len()
-				bzip, _ := CompressBZIP2(orig)
-				compressorStat.bzipTotal += bzip
-				lzma, _ := CompressLZMA(orig)
-				compressorStat.lzmaTotal += lzma
-				bzip, _ = CompressBZIP2(dictJson)
-				compressorStat.mergingBzipTotal += bzip
-				lzma, _ = CompressLZMA(dictJson)
-				compressorStat.mergingLzmaTotal += lzma
-			}
-
-			if e.config.EnableGzip && e.config.CalcZipRate {
-				ratio := float64(compressorStat.mergingTotal) / float64(compressorStat.originTotal)
-				traceZipCost := float64(CompressionTotalTime.Seconds()) - (float64(GzipOnlyMarshalTime.Seconds()) * ratio)
-				fmt.Printf("[TraceZip + Gzip Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(GzipTotalTime.Seconds()+CompressionTotalTime.Seconds())/1024/1024)
-				fmt.Printf("[TraceZip        Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(CompressionTotalTime.Seconds())/1024/1024)
-				fmt.Printf("[Gzip            Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(GzipOnlyTotalTime.Seconds())/1024/1024)
-				fmt.Printf("[NoZip           Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(GzipOnlyMarshalTime.Seconds())/1024/1024)
-				fmt.Printf("[TraceZip         Cost] %f MB/s\n", float64(compressorStat.originTotal)/float64(traceZipCost)/1024/1024)
-			}
-
-			req, err := http.NewRequest("POST", e.tracesdictURL, reqBody)
-			if err != nil {
-				needResetOrder = true
-				DictRWM.Unlock()
-				return errors.New("failed to create request")
-			}
-			req.Header.Set("Content-Type", contentType)
-			if e.config.EnableGzip {
-				req.Header.Set("Content-Encoding", "gzip")
-			}
+	start := time.Now()
+	e.dictMu.Lock()
+	dictionaryUuid, subeteUpdate, incrementUpdate, export := e.traceZip.MarshalWithTraceZip(tr, false)
+	compressionTime := time.Since(start)
+	SerilizeLock.Lock()
+	CompressionTotalTime += compressionTime
+	SerilizeLock.Unlock()
+	update := e.newDictionaryRequest(dictionaryUuid, subeteUpdate, incrementUpdate)
+	var request []byte
+	var err error
+	if e.config.TraceZipFormat == TraceZipFormatBinary {
+		request, err = ptraceotlp.MarshalTraceZipBinary(dictionaryUuid, export)
+	} else {
+		request, err = e.marshalTraceZip(update, export)
+	}
+	if err != nil {
+		e.dictMu.Unlock()
+		return consumererror.NewPermanent(err)
+	}
+	if e.config.CalcZipRate {
+		e.recordZipRate(tr, update, export, request)
+	}
+	return e.exportWithTraceZip(ctx, e.tracesURL, e.tracesdictURL, update, request, e.traceZipContentType(), e.tracesPartialSuccessHandler)
+}
 
-			client := &http.Client{}
-			rsp, err := client.Do(req)
-			if err != nil {
-				needResetOrder = true
-				DictRWM.Unlock()
-				return errors.New("synchronize dictionary failed")
-			}
-			defer rsp.Body.Close()
-			if rsp.StatusCode < 400 && rsp.StatusCode >= 200 {
-				DictRWM.Unlock()
-				content, err := io.ReadAll(rsp.Body)
-				if err != nil {
-					return err
-				}
-				fmt.Println(string(content))
-				return e.export(ctx, e.tracesURL, request, e.tracesPartialSuccessHandler)
-			} else {
-				needResetOrder = true
-				DictRWM.Unlock()
-				return errors.New("synchronize dictionary failed")
-			}
+// recordZipRate adds a batch of traces to compressorStat and prints the totals, see
+// calc_zip_rate. The dictionary update of the batch and its payload request are
+// counted once, as they are sent, however often the exporter has to send them, and
+// compressed with the codec of their payloads. It must be called with dictMu held.
+// The batch is measured first and added to the totals with SerilizeLock held, as
+// every traces exporter adds to them.
+func (e *baseExporter) recordZipRate(tr ptraceotlp.ExportRequest, update dictionaryRequest, export []ptraceotlp.ExportData, request []byte) {
+	var stat CompressorStat
+	start := time.Now()
+	orig, _ := tr.MarshalJSON__(e.config.DeleteResource)
+	marshalTime := time.Since(start)
+	stat.gzipOnlyTotal = gzipSize(orig)
+	gzipOnlyTime := time.Since(start)
+	stat.originTotal = len(orig)
+	stat.bzipTotal, _ = CompressBZIP2(orig)
+	stat.lzmaTotal, _ = CompressLZMA(orig)
+
+	jsonPayload, _ := json.Marshal(export)
+	binaryPayload, _ := ptraceotlp.MarshalTraceZipBinary(update.Uuid, export)
+	stat.traceZipJSONTotal = len(jsonPayload)
+	stat.traceZipBinaryTotal = len(binaryPayload)
+
+	sent := [][]byte{request}
+	var dictJson []byte
+	if update.Type != "" {
+		dictJson, _ = json.Marshal(update)
+		// an inline update is part of request
+		if !e.inlineDictionary() {
+			sent = append(sent, dictJson)
+		}
+	}
+	for _, body := range sent {
+		stat.mergingNoGzipTotal += len(body)
+		if e.encoder.ContentEncoding() != "" {
+			encoded, _ := e.payloadEncoder().Encode(body)
+			stat.mergingTotal += len(encoded)
 		} else {
-			DictRWM.Unlock()
-			// No Update
-			if e.config.CalcZipRate {
-				var buf bytes.Buffer
-				start := time.Now()
-				orig, _ := tr.MarshalJSON__(e.config.DeleteResource)
-				GzipOnlyMarshalTime += time.Since(start)
-				gz, _ := gzip.NewWriterLevel(&buf, 6)
-				if _, err := gz.Write(orig); err != nil {
-					return err
-				}
-				if err := gz.Close(); err != nil {
-					return err
-				}
-				GzipOnlyTotalTime += time.Since(start)
-				compressorStat.originTotal += len(orig)
-				compressorStat.gzipOnlyTotal += buf.Len()
-				bzip, _ := CompressBZIP2(orig)
-				compressorStat.bzipTotal += bzip
-				lzma, _ := CompressLZMA(orig)
-				compressorStat.lzmaTotal += lzma
-
-				if e.config.EnableGzip && e.config.CalcZipRate {
-					ratio := float64(compressorStat.mergingTotal) / float64(compressorStat.originTotal)
-					traceZipCost := float64(CompressionTotalTime.Seconds()) - (float64(GzipOnlyMarshalTime.Seconds()) * ratio)
-					fmt.Printf("[TraceZip + Gzip Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(GzipTotalTime.Seconds()+CompressionTotalTime.Seconds())/1024/1024)
-					fmt.Printf("[TraceZip        Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(CompressionTotalTime.Seconds())/1024/1024)
-					fmt.Printf("[Gzip            Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(GzipOnlyTotalTime.Seconds())/1024/1024)
-					fmt.Printf("[NoZip           Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(GzipOnlyMarshalTime.Seconds())/1024/1024)
-					fmt.Printf("[TraceZip         Cost] %f MB/s\n", float64(compressorStat.originTotal)/float64(traceZipCost)/1024/1024)
-				}
-			}
+			stat.mergingTotal += len(body)
 		}
-		return e.export(ctx, e.tracesURL, request, e.tracesPartialSuccessHandler)
+		bzip, _ := CompressBZIP2(body)
+		stat.mergingBzipTotal += bzip
+		lzma, _ := CompressLZMA(body)
+		stat.mergingLzmaTotal += lzma
+	}
+
+	SerilizeLock.Lock()
+	defer SerilizeLock.Unlock()
+	compressorStat.add(stat)
+	GzipOnlyMarshalTime += marshalTime
+	GzipOnlyTotalTime += gzipOnlyTime
+	if update.Type == "a" {
+		DictSizeNow = len(dictJson)
 	} else {
-		return e.export(ctx, e.tracesURL, request, e.tracesPartialSuccessHandler)
+		DictSizeNow += len(dictJson)
+	}
+	if e.config.EnableGzip {
+		ratio := float64(compressorStat.mergingTotal) / float64(compressorStat.originTotal)
+		traceZipCost := float64(CompressionTotalTime.Seconds()) - (float64(GzipOnlyMarshalTime.Seconds()) * ratio)
+		fmt.Printf("[TraceZip + Gzip Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(GzipTotalTime.Seconds()+CompressionTotalTime.Seconds())/1024/1024)
+		fmt.Printf("[TraceZip        Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(CompressionTotalTime.Seconds())/1024/1024)
+		fmt.Printf("[Gzip            Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(GzipOnlyTotalTime.Seconds())/1024/1024)
+		fmt.Printf("[NoZip           Speed] %f MB/s\n", float64(compressorStat.originTotal)/float64(GzipOnlyMarshalTime.Seconds())/1024/1024)
+		fmt.Printf("[TraceZip         Cost] %f MB/s\n", float64(compressorStat.originTotal)/float64(traceZipCost)/1024/1024)
 	}
+	fmt.Printf("[Origin] %f KB\n", float32(compressorStat.originTotal)/1024)
+	fmt.Printf("[NoGzipMerging] %f KB\n", float32(compressorStat.mergingNoGzipTotal)/1024)
+	fmt.Printf("[LZMA] %f KB\n", float32(compressorStat.lzmaTotal)/1024)
+	fmt.Printf("[BZIP] %f KB\n", float32(compressorStat.bzipTotal)/1024)
+	fmt.Printf("[Gzip] %f KB\n", float32(compressorStat.gzipOnlyTotal)/1024)
+	fmt.Printf("[MergingGZIP] %f KB\n", float32(compressorStat.mergingTotal)/1024)
+	fmt.Printf("[MergingBZIP] %f KB\n", float32(compressorStat.mergingBzipTotal)/1024)
+	fmt.Printf("[MergingLZMA] %f KB\n", float32(compressorStat.mergingLzmaTotal)/1024)
+	fmt.Printf("[Now Dict Size] %d KB \n", DictSizeNow/1024)
+	fmt.Printf("[TraceZip JSON Payload] %f KB\n", float32(compressorStat.traceZipJSONTotal)/1024)
+	fmt.Printf("[TraceZip Binary Payload] %f KB\n", float32(compressorStat.traceZipBinaryTotal)/1024)
+	fmt.Printf("[TraceZip JSON Overhead] %f%%\n", 100*(1-float32(compressorStat.traceZipBinaryTotal)/float32(compressorStat.traceZipJSONTotal)))
+}
 
+// gzipSize returns the size of data compressed with gzip.
+func gzipSize(data []byte) int {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return 0
+	}
+	if err := gz.Close(); err != nil {
+		return 0
+	}
+	return buf.Len()
 }
 
 func (e *baseExporter) pushMetrics(ctx context.Context, md pmetric.Metrics) error {
 	tr := pmetricotlp.NewExportRequestFromMetrics(md)
 
+	if e.metricZip != nil && !e.config.NoTraceZip && e.config.Encoding == EncodingJSON {
+		return e.pushMetricsWithTraceZip(ctx, tr)
+	}
+
 	var err error
 	var request []byte
 	switch e.config.Encoding {
@@ -386,6 +550,10 @@ func (e *baseExporter) pushMetrics(ctx context.Context, md pmetric.Metrics) erro
 func (e *baseExporter) pushLogs(ctx context.Context, ld plog.Logs) error {
 	tr := plogotlp.NewExportRequestFromLogs(ld)
 
+	if e.logZip != nil && !e.config.NoTraceZip && e.config.Encoding == EncodingJSON {
+		return e.pushLogsWithTraceZip(ctx, tr)
+	}
+
 	var err error
 	var request []byte
 	switch e.config.Encoding {
@@ -404,68 +572,463 @@ func (e *baseExporter) pushLogs(ctx context.Context, ld plog.Logs) error {
 	return e.export(ctx, e.logsURL, request, e.logsPartialSuccessHandler)
 }
 
-func (e *baseExporter) export(ctx context.Context, url string, request []byte, partialSuccessHandler partialSuccessHandler) error {
-	e.logger.Debug("Preparing to make HTTP request", zap.String("url", url))
+// traceZipContentType returns the content type of the TraceZip traces payloads.
+func (e *baseExporter) traceZipContentType() string {
+	if e.config.TraceZipFormat == TraceZipFormatBinary {
+		return ptraceotlp.TraceZipBinaryContentType
+	}
+	return jsonContentType
+}
+
+func (e *baseExporter) pushLogsWithTraceZip(ctx context.Context, tr plogotlp.ExportRequest) error {
+	return e.pushWithTraceZip(ctx, e.logsURL, e.logsdictURL, func(reset bool) (string, []interface{}, []interface{}, interface{}) {
+		return e.logZip.MarshalWithTraceZip(tr, reset)
+	}, e.logsPartialSuccessHandler)
+}
 
-	var bodyReader *bytes.Reader
+func (e *baseExporter) pushMetricsWithTraceZip(ctx context.Context, tr pmetricotlp.ExportRequest) error {
+	return e.pushWithTraceZip(ctx, e.metricsURL, e.metricsdictURL, func(reset bool) (string, []interface{}, []interface{}, interface{}) {
+		return e.metricZip.MarshalWithTraceZip(tr, reset)
+	}, e.metricsPartialSuccessHandler)
+}
+
+// pushWithTraceZip compresses a request of the logs or metrics compressor with
+// marshal, and sends the payload to url and the dictionary update to dictURL, see
+// exportWithTraceZip.
+func (e *baseExporter) pushWithTraceZip(ctx context.Context, url string, dictURL string, marshal func(reset bool) (string, []interface{}, []interface{}, interface{}), partialSuccessHandler partialSuccessHandler) error {
+	e.dictMu.Lock()
+	dictionaryUuid, fullUpdate, incrementUpdate, export := marshal(false)
+	update := e.newDictionaryRequest(dictionaryUuid, fullUpdate, incrementUpdate)
+	request, err := e.marshalTraceZip(update, export)
+	if err != nil {
+		e.dictMu.Unlock()
+		return consumererror.NewPermanent(err)
+	}
+	return e.exportWithTraceZip(ctx, url, dictURL, update, request, jsonContentType, partialSuccessHandler)
+}
+
+// marshalTraceZip returns the JSON body of a TraceZip payload: the uuid of its
+// dictionary and the payload and, with inline_dictionary, the dictionary request
+// update of its batch if it has a Type, whose fields sit next to them.
+func (e *baseExporter) marshalTraceZip(update dictionaryRequest, export interface{}) ([]byte, error) {
+	wrapper := map[string]interface{}{
+		"_": update.Uuid,
+		"a": export,
+	}
+	if e.inlineDictionary() && update.Type != "" {
+		wrapper["e"] = update.Epoch
+		wrapper["s"] = update.Version
+		wrapper["t"] = update.Type
+		if len(update.Update) > 0 {
+			wrapper["n"] = update.Update
+		}
+		if update.Zstd != nil {
+			wrapper["z"] = update.Zstd
+		}
+		if update.Floor != 0 {
+			wrapper["f"] = update.Floor
+		}
+	}
+	return json.Marshal(wrapper)
+}
+
+// inlineDictionary tells whether dictionary updates are sent in the requests of
+// their payloads. The TraceZip stream carries them next to the payloads anyway.
+func (e *baseExporter) inlineDictionary() bool {
+	return e.config.InlineDictionary && e.stream == nil
+}
+
+// exportWithTraceZip sends a TraceZip payload, request, and the dictionary request
+// update of its batch. It must be called with dictMu held, and releases it once
+// the batch is compressed, so that the batches of concurrent exports are sent
+// concurrently; each payload names the version of the dictionary it needs, and
+// the receiver parks the payloads and updates that overtook the updates they
+// need. On the TraceZip stream, both go in one stream request. With
+// inline_dictionary, update is part of request, see exportInline. Else update is
+// synced to dictURL first and request is sent to url after. The payload is held in
+// zip until it is sent, so that later batches keep the entries it uses.
+func (e *baseExporter) exportWithTraceZip(ctx context.Context, url string, dictURL string, update dictionaryRequest, request []byte, contentType string, partialSuccessHandler partialSuccessHandler) error {
+	hold := e.zip.Hold()
+	defer e.zip.Release(hold)
+	if e.stream != nil {
+		return e.exportStream(ctx, update, request, contentType)
+	}
+	encoder := e.payloadEncoder()
+	e.dictMu.Unlock()
+	var err error
+	if e.config.InlineDictionary {
+		err = e.exportInline(ctx, url, dictURL, update, request, contentType, encoder, partialSuccessHandler)
+	} else if err = e.syncTraceZipDictionary(ctx, dictURL, update); err == nil {
+		err = e.exportVersioned(ctx, url, dictURL, update.Uuid, update.dictionaryVersion, request, contentType, encoder, partialSuccessHandler)
+	}
+	if err == nil {
+		e.trainZstdDictionary(ctx, dictURL, update.Uuid, request)
+	}
+	return err
+}
+
+// payloadEncoder returns the encoder of TraceZip payloads, the one with the zstd
+// dictionary once the receiver has it. It must be called with dictMu held.
+func (e *baseExporter) payloadEncoder() *tracezipotlp.Encoder {
+	if e.zstdEncoder != nil {
+		return e.zstdEncoder
+	}
+	return e.encoder
+}
 
-	if e.config.EnableGzip && !e.config.NoTraceZip {
-		var buf bytes.Buffer
-		gzipWriter := gzip.NewWriter(&buf)
-		start := time.Now()
-		if _, err := gzipWriter.Write(request); err != nil {
+// trainZstdDictionary adds a TraceZip payload the receiver accepted to the samples
+// of the zstd dictionary. Once there are zstd_dictionary_samples of them, it
+// trains the dictionary and sends it to dictURL in a "z" dictionary request, and
+// payloads are compressed with it once the receiver has it. A receiver that does
+// not have the version of zip gets all of the dictionary with the zstd one. If
+// training or sending fails, the dictionary is trained again from new samples.
+// Training and sending run without dictMu, which is only taken to pick the
+// samples and to commit the dictionary.
+func (e *baseExporter) trainZstdDictionary(ctx context.Context, dictURL string, dictionaryUuid string, payload []byte) {
+	if e.config.ZstdDictionarySize == 0 {
+		return
+	}
+	e.dictMu.Lock()
+	if e.zstdDictionary != nil || e.zstdTraining {
+		e.dictMu.Unlock()
+		return
+	}
+	e.zstdSamples = append(e.zstdSamples, payload)
+	if len(e.zstdSamples) < e.config.ZstdDictionarySamples {
+		e.dictMu.Unlock()
+		return
+	}
+	samples := e.zstdSamples
+	e.zstdSamples = nil
+	e.zstdTraining = true
+	e.dictMu.Unlock()
+
+	dictionary, encoder, err := e.sendZstdDictionary(ctx, dictURL, dictionaryUuid, samples)
+	e.dictMu.Lock()
+	defer e.dictMu.Unlock()
+	e.zstdTraining = false
+	if err != nil {
+		e.zstdDictionary = nil
+		e.logger.Warn("Failed to train or send the zstd dictionary", zap.Error(err))
+		return
+	}
+	e.zstdEncoder = encoder
+	e.logger.Info("Compressing TraceZip payloads with a trained zstd dictionary",
+		zap.String("uuid", dictionaryUuid), zap.Int("size", len(dictionary)), zap.Int("samples", len(samples)))
+}
+
+// sendZstdDictionary trains a zstd dictionary from samples and sends it to dictURL
+// with the version of zip, or with all of the dictionary if the receiver does not
+// have that version. It returns the dictionary and the encoder of the payloads
+// with it. It must be called without dictMu.
+func (e *baseExporter) sendZstdDictionary(ctx context.Context, dictURL string, dictionaryUuid string, samples [][]byte) ([]byte, *tracezipotlp.Encoder, error) {
+	dictionary, err := tracezipotlp.TrainZstdDictionary(samples, e.config.ZstdDictionarySize, e.config.CodecLevel)
+	var encoder *tracezipotlp.Encoder
+	if err == nil {
+		encoder, err = tracezipotlp.NewEncoder(tracezipotlp.CodecZstd, e.config.CodecLevel, dictionary)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e.recoverMu.Lock()
+	defer e.recoverMu.Unlock()
+	e.dictMu.Lock()
+	// full updates carry it from now on
+	e.zstdDictionary = dictionary
+	request := dictionaryRequest{Uuid: dictionaryUuid, dictionaryVersion: e.version(), Type: "z", Zstd: dictionary}
+	e.dictMu.Unlock()
+	have, err := e.syncDictionary(ctx, dictURL, request)
+	var conflict *dictionaryConflictError
+	if errors.As(err, &conflict) {
+		err = e.resyncDictionary(ctx, dictURL, dictionaryUuid)
+	} else if err == nil {
+		e.dictMu.Lock()
+		e.acknowledge(have)
+		e.dictMu.Unlock()
+	}
+	return dictionary, encoder, err
+}
+
+// exportInline sends a TraceZip payload whose request carries the dictionary
+// request update of its batch to url. The receiver applies the update and decodes
+// the payload as one, so the update is acknowledged once the payload is. A
+// receiver that does not have the version the update follows is brought to the
+// version of zip through dictURL, and the batch is compressed again, see
+// recompressError.
+func (e *baseExporter) exportInline(ctx context.Context, url string, dictURL string, update dictionaryRequest, request []byte, contentType string, encoder *tracezipotlp.Encoder, partialSuccessHandler partialSuccessHandler) error {
+	if update.Type != "" {
+		// the receiver needs the update to know the zstd dictionary
+		encoder = e.encoder
+	}
+	err := e.exportWithContentType(ctx, url, request, contentType, update.dictionaryVersion, encoder, partialSuccessHandler)
+	var conflict *dictionaryConflictError
+	if errors.As(err, &conflict) {
+		if err = e.recoverDictionary(ctx, dictURL, update.Uuid, conflict.have); err != nil {
 			return err
 		}
-		if err := gzipWriter.Close(); err != nil {
+		return recompressError(conflict)
+	}
+	if err == nil && update.Type != "" {
+		e.dictMu.Lock()
+		e.acknowledge(update.dictionaryVersion)
+		e.dictMu.Unlock()
+	}
+	return err
+}
+
+// version returns the version of the dictionary of zip.
+func (e *baseExporter) version() dictionaryVersion {
+	var version dictionaryVersion
+	version.Epoch, version.Version = e.zip.Version()
+	return version
+}
+
+// newDictionaryRequest returns the request of the dictionary update of the last
+// payload of zip, or a verification of a restored dictionary if there is none,
+// else a request of no Type. An incremental update is kept in the history until
+// the receiver acknowledges it.
+func (e *baseExporter) newDictionaryRequest(dictionaryUuid string, fullUpdate []interface{}, incrementUpdate []interface{}) dictionaryRequest {
+	request := dictionaryRequest{Uuid: dictionaryUuid, dictionaryVersion: e.version()}
+	switch {
+	case len(fullUpdate) > 0:
+		request.Type, request.Update, request.Zstd = "a", fullUpdate, e.zstdDictionary
+		e.history = nil
+	case len(incrementUpdate) > 0:
+		request.Type, request.Update, request.Floor = "i", incrementUpdate, e.zip.Floor()
+		if len(e.history) == maxDictionaryHistory {
+			e.history = e.history[1:]
+		}
+		e.history = append(e.history, request)
+	case e.verifyDictionary:
+		request.Type = "v"
+	}
+	return request
+}
+
+// syncTraceZipDictionary sends a dictionary request of newDictionaryRequest to
+// url. A receiver that does not have the version the request applies to is
+// brought to the version of zip with recoverDictionary. It must be called without
+// dictMu, which is taken for the answer.
+func (e *baseExporter) syncTraceZipDictionary(ctx context.Context, url string, request dictionaryRequest) error {
+	if request.Type == "" {
+		return nil
+	}
+	have, err := e.syncDictionary(ctx, url, request)
+	var conflict *dictionaryConflictError
+	if errors.As(err, &conflict) {
+		return e.recoverDictionary(ctx, url, request.Uuid, conflict.have)
+	}
+	if err != nil {
+		return err
+	}
+	e.dictMu.Lock()
+	e.acknowledge(have)
+	e.dictMu.Unlock()
+	return nil
+}
+
+// recoverDictionary brings the receiver, which has the dictionary at have, to the
+// version of zip: it resends the updates after have if the history still has all
+// of them, else all of the dictionary under a new epoch. It must be called
+// without dictMu: the updates are picked under it and sent without it, and
+// recoveries run one at a time under recoverMu.
+func (e *baseExporter) recoverDictionary(ctx context.Context, url string, dictionaryUuid string, have dictionaryVersion) error {
+	e.recoverMu.Lock()
+	defer e.recoverMu.Unlock()
+	e.dictMu.Lock()
+	current := e.version()
+	var pending []dictionaryRequest
+	resync := have.Epoch != current.Epoch || have.Version > current.Version ||
+		have.Version < current.Version && (len(e.history) == 0 || e.history[0].Version > have.Version+1)
+	if !resync && have.Version == current.Version {
+		e.acknowledge(have)
+	} else if !resync {
+		for _, request := range e.history {
+			if request.Version > have.Version {
+				pending = append(pending, request)
+			}
+		}
+	}
+	e.dictMu.Unlock()
+	if resync {
+		return e.resyncDictionary(ctx, url, dictionaryUuid)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	e.logger.Info("The receiver missed TraceZip dictionary updates, sending them again",
+		zap.String("uuid", dictionaryUuid), zap.Stringer("from", have), zap.Stringer("to", current))
+	for _, request := range pending {
+		ack, err := e.syncDictionary(ctx, url, request)
+		var conflict *dictionaryConflictError
+		if errors.As(err, &conflict) {
+			return e.resyncDictionary(ctx, url, dictionaryUuid)
+		}
+		if err != nil {
 			return err
 		}
-		GzipTotalTime += time.Since(start)
-		requestCompressed := buf.Bytes()
-		bodyReader = bytes.NewReader(requestCompressed)
-	} else {
-		bodyReader = bytes.NewReader(request)
+		e.dictMu.Lock()
+		e.acknowledge(ack)
+		e.dictMu.Unlock()
 	}
-	if e.config.CalcZipRate {
-		compressorStat.mergingTotal += bodyReader.Len()
-		compressorStat.mergingNoGzipTotal += len(request)
-		bzip, _ := CompressBZIP2(request)
-		compressorStat.mergingBzipTotal += bzip
-		lzma, _ := CompressLZMA(request)
-		compressorStat.mergingLzmaTotal += lzma
-		fmt.Printf("[Origin] %f KB\n", float32(compressorStat.originTotal)/1024)
-		fmt.Printf("[NoGzipMerging] %f KB\n", float32(compressorStat.mergingNoGzipTotal)/1024)
-		fmt.Printf("[LZMA] %f KB\n", float32(compressorStat.lzmaTotal)/1024)
-		fmt.Printf("[BZIP] %f KB\n", float32(compressorStat.bzipTotal)/1024)
-		fmt.Printf("[Gzip] %f KB\n", float32(compressorStat.gzipOnlyTotal)/1024)
-		fmt.Printf("[MergingGZIP] %f KB\n", float32(compressorStat.mergingTotal)/1024)
-		fmt.Printf("[MergingBZIP] %f KB\n", float32(compressorStat.mergingBzipTotal)/1024)
-		fmt.Printf("[MergingLZMA] %f KB\n", float32(compressorStat.mergingLzmaTotal)/1024)
-		fmt.Printf("[Now Dict Size] %d KB \n", DictSizeNow/1024)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bodyReader)
+	return nil
+}
+
+// resyncDictionary sends the whole dictionary of zip to url under a new epoch. It
+// must be called with recoverMu held and without dictMu, which is taken to
+// take the dictionary and to record the answer.
+func (e *baseExporter) resyncDictionary(ctx context.Context, url string, dictionaryUuid string) error {
+	e.logger.Info("The receiver does not have the TraceZip dictionary, sending all of it", zap.String("uuid", dictionaryUuid))
+	e.dictMu.Lock()
+	request := dictionaryRequest{Uuid: dictionaryUuid, Type: "a", Update: e.zip.Resync(), Zstd: e.zstdDictionary}
+	request.dictionaryVersion = e.version()
+	e.history = nil
+	e.dictMu.Unlock()
+	have, err := e.syncDictionary(ctx, url, request)
 	if err != nil {
 		return err
 	}
+	e.dictMu.Lock()
+	e.acknowledge(have)
+	e.dictMu.Unlock()
+	return nil
+}
+
+// acknowledge records that the receiver has the dictionary of zip at have, and
+// drops the updates it has from the history.
+func (e *baseExporter) acknowledge(have dictionaryVersion) {
+	if have.Epoch == e.version().Epoch {
+		i := 0
+		for i < len(e.history) && e.history[i].Version <= have.Version {
+			i++
+		}
+		e.history = e.history[i:]
+	}
+	e.dictionaryChanged = true
+	e.verifyDictionary = false
+}
+
+// exportVersioned sends a TraceZip payload that needs version of the dictionary to
+// url. If the receiver does not have that version, it is brought to the version
+// of zip through dictURL, and the batch is compressed again, see recompressError.
+func (e *baseExporter) exportVersioned(ctx context.Context, url string, dictURL string, dictionaryUuid string, version dictionaryVersion, request []byte, contentType string, encoder *tracezipotlp.Encoder, partialSuccessHandler partialSuccessHandler) error {
+	err := e.exportWithContentType(ctx, url, request, contentType, version, encoder, partialSuccessHandler)
+	var conflict *dictionaryConflictError
+	if !errors.As(err, &conflict) {
+		return err
+	}
+	if err = e.recoverDictionary(ctx, dictURL, dictionaryUuid, conflict.have); err != nil {
+		return err
+	}
+	return recompressError(conflict)
+}
+
+// readDictionaryAck returns the version of the dictionary the receiver answered
+// with, or version if it did not answer with one.
+func readDictionaryAck(resp *http.Response, version dictionaryVersion) dictionaryVersion {
+	body, err := readResponseBody(resp)
+	if err != nil {
+		return version
+	}
+	var ack dictionaryAck
+	if err = json.Unmarshal(body, &ack); err != nil {
+		return version
+	}
+	return ack.dictionaryVersion
+}
+
+// syncDictionary sends a TraceZip dictionary request to url, or on the TraceZip
+// stream if there is one, and returns the version the receiver acknowledged. The
+// protocol is the same for all signals, see dictionaryRequest. The request goes
+// through the HTTP client of the payloads, and fails like them, see
+// responseError.
+func (e *baseExporter) syncDictionary(ctx context.Context, url string, request dictionaryRequest) (dictionaryVersion, error) {
+	if e.stream != nil {
+		return e.syncDictionaryStream(ctx, request)
+	}
+	var have dictionaryVersion
+	body, err := json.Marshal(request)
+	if err == nil {
+		// without the zstd dictionary, which the request may carry
+		body, err = e.encoder.Encode(body)
+	}
+	if err != nil {
+		return have, consumererror.NewPermanent(err)
+	}
 
-	if e.config.EnableGzip || e.config.NoTraceZip {
-		req.Header.Set("Content-Encoding", "gzip")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return have, consumererror.NewPermanent(err)
+	}
+	req.Header.Set("Content-Type", jsonContentType)
+	if encoding := e.encoder.ContentEncoding(); encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	req.Header.Set("User-Agent", e.userAgent)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return have, fmt.Errorf("synchronize dictionary failed: %w", err)
+	}
+	defer func() {
+		io.CopyN(io.Discard, resp.Body, maxHTTPResponseReadBytes) // nolint:errcheck
+		resp.Body.Close()
+	}()
+	if resp.StatusCode == http.StatusConflict {
+		return have, &dictionaryConflictError{have: readDictionaryAck(resp, have)}
 	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return have, responseError(url, resp, "synchronize dictionary failed")
+	}
+	return readDictionaryAck(resp, request.dictionaryVersion), nil
+}
 
+func (e *baseExporter) export(ctx context.Context, url string, request []byte, partialSuccessHandler partialSuccessHandler) error {
+	var contentType string
 	switch e.config.Encoding {
 	case EncodingJSON:
-		req.Header.Set("Content-Type", jsonContentType)
+		contentType = jsonContentType
 	case EncodingProto:
-		req.Header.Set("Content-Type", protobufContentType)
+		contentType = protobufContentType
 	default:
 		return fmt.Errorf("invalid encoding: %s", e.config.Encoding)
 	}
+	return e.exportWithContentType(ctx, url, request, contentType, dictionaryVersion{}, e.encoder, partialSuccessHandler)
+}
 
+// exportWithContentType sends request to url, compressed with encoder. A TraceZip
+// payload names the version of the dictionary it needs, other requests have
+// version 0.0.
+func (e *baseExporter) exportWithContentType(ctx context.Context, url string, request []byte, contentType string, version dictionaryVersion, encoder *tracezipotlp.Encoder, partialSuccessHandler partialSuccessHandler) error {
+	e.logger.Debug("Preparing to make HTTP request", zap.String("url", url))
+
+	start := time.Now()
+	body, err := encoder.Encode(request)
+	if err != nil {
+		return consumererror.NewPermanent(err)
+	}
+	SerilizeLock.Lock()
+	GzipTotalTime += time.Since(start)
+	SerilizeLock.Unlock()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if encoding := encoder.ContentEncoding(); encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("User-Agent", e.userAgent)
+	if version.Epoch != 0 {
+		req.Header.Set(headerDictionaryVersion, version.String())
+	}
 
 	resp, err := e.client.Do(req)
 	if err != nil {
-		needResetOrder = true
 		return fmt.Errorf("failed to make an HTTP request: %w", err)
 	}
 
@@ -478,21 +1041,29 @@ func (e *baseExporter) export(ctx context.Context, url string, request []byte, p
 	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
 		return handlePartialSuccessResponse(resp, partialSuccessHandler)
 	}
+	if resp.StatusCode == http.StatusConflict && version.Epoch != 0 {
+		return &dictionaryConflictError{have: readDictionaryAck(resp, dictionaryVersion{})}
+	}
+	return responseError(url, resp, "error exporting items")
+}
 
-	needResetOrder = true
-
+// responseError returns the error of a failed response to a request to url, for
+// payloads and dictionary requests alike: a retryable status is retried with
+// the backoff of retry_on_failure, or after Retry-After if the receiver is
+// overwhelmed, and any other status is permanent. failure starts the message.
+func responseError(url string, resp *http.Response, failure string) error {
 	respStatus := readResponseStatus(resp)
 
 	// Format the error message. Use the status if it is present in the response.
 	var formattedErr error
 	if respStatus != nil {
 		formattedErr = fmt.Errorf(
-			"error exporting items, request to %s responded with HTTP Status Code %d, Message=%s, Details=%v",
-			url, resp.StatusCode, respStatus.Message, respStatus.Details)
+			"%s, request to %s responded with HTTP Status Code %d, Message=%s, Details=%v",
+			failure, url, resp.StatusCode, respStatus.Message, respStatus.Details)
 	} else {
 		formattedErr = fmt.Errorf(
-			"error exporting items, request to %s responded with HTTP Status Code %d",
-			url, resp.StatusCode)
+			"%s, request to %s responded with HTTP Status Code %d",
+			failure, url, resp.StatusCode)
 	}
 
 	if isRetryableStatusCode(resp.StatusCode) {
@@ -0,0 +1,143 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package prefix_compressed_exporter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	noopmetric "go.opentelemetry.io/otel/metric/noop"
+	nooptrace "go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+
+	"angrychow/otel/prefix-compressed-exporter/internal/metadata"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configopaque"
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/exporter"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+)
+
+// host is a component.Host without extensions.
+type host struct {
+	component.Host
+}
+
+func (host) GetExtensions() map[component.ID]component.Component {
+	return nil
+}
+
+func newTestCreateSettings() exporter.CreateSettings {
+	return exporter.CreateSettings{
+		ID: component.NewID(metadata.Type),
+		TelemetrySettings: component.TelemetrySettings{
+			Logger:         zap.NewNop(),
+			TracerProvider: nooptrace.NewTracerProvider(),
+			MeterProvider:  noopmetric.NewMeterProvider(),
+		},
+		BuildInfo: component.NewDefaultBuildInfo(),
+	}
+}
+
+// newTestExporter returns a started exporter of cfg.
+func newTestExporter(t *testing.T, cfg *Config) *baseExporter {
+	e, err := newExporter(cfg, newTestCreateSettings())
+	require.NoError(t, err)
+	require.NoError(t, e.start(context.Background(), host{}))
+	t.Cleanup(func() {
+		assert.NoError(t, e.shutdown(context.Background()))
+	})
+	return e
+}
+
+func TestSyncDictionaryFailures(t *testing.T) {
+	request := dictionaryRequest{Uuid: "uuid", dictionaryVersion: dictionaryVersion{Epoch: 1, Version: 2}, Type: "i"}
+	for _, tt := range []struct {
+		name       string
+		status     int
+		retryAfter string
+		body       string
+		// want is the version the receiver acknowledges
+		want dictionaryVersion
+		// conflict is set for a 409 Conflict, which is recovered from
+		conflict bool
+		// permanent is set for failures that are not retried, else the request
+		// is retried after throttle
+		permanent bool
+		throttle  time.Duration
+	}{
+		{name: "ok", status: http.StatusOK, body: `{"_":"uuid","e":1,"s":2}`, want: request.dictionaryVersion},
+		{name: "ok without ack", status: http.StatusOK, want: request.dictionaryVersion},
+		{name: "conflict", status: http.StatusConflict, body: `{"_":"uuid","e":1,"s":1}`, want: dictionaryVersion{Epoch: 1, Version: 1}, conflict: true},
+		{name: "conflict without dictionary", status: http.StatusConflict, body: `{"_":"uuid","e":0,"s":0}`, conflict: true},
+		{name: "too large", status: http.StatusRequestEntityTooLarge, permanent: true},
+		{name: "bad request", status: http.StatusBadRequest, permanent: true},
+		{name: "too many requests", status: http.StatusTooManyRequests, retryAfter: "3", throttle: 3 * time.Second},
+		{name: "unavailable", status: http.StatusServiceUnavailable, retryAfter: "7", throttle: 7 * time.Second},
+		{name: "unavailable without retry-after", status: http.StatusServiceUnavailable},
+		{name: "bad gateway ignores retry-after", status: http.StatusBadGateway, retryAfter: "3"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// the client config applies to dictionary requests too
+				assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+				assert.Equal(t, jsonContentType, r.Header.Get("Content-Type"))
+				if tt.retryAfter != "" {
+					w.Header().Set(headerRetryAfter, tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = srv.URL
+			cfg.Headers = map[string]configopaque.String{"Authorization": "Bearer token"}
+			e := newTestExporter(t, cfg)
+
+			have, err := e.syncDictionary(context.Background(), srv.URL+"/v1/tracesdict", request)
+			var conflict *dictionaryConflictError
+			switch {
+			case tt.status == http.StatusOK:
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, have)
+			case tt.conflict:
+				require.ErrorAs(t, err, &conflict)
+				assert.Equal(t, tt.want, conflict.have)
+				assert.False(t, consumererror.IsPermanent(err))
+			case tt.permanent:
+				require.Error(t, err)
+				assert.True(t, consumererror.IsPermanent(err))
+				assert.False(t, errors.As(err, &conflict))
+			default:
+				require.Error(t, err)
+				assert.False(t, consumererror.IsPermanent(err))
+				assert.Equal(t, exporterhelper.NewThrottleRetry(errors.Unwrap(err), tt.throttle), err)
+			}
+		})
+	}
+}
+
+func TestSyncDictionaryCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = srv.URL
+	e := newTestExporter(t, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := e.syncDictionary(ctx, srv.URL+"/v1/tracesdict", dictionaryRequest{Uuid: "uuid", Type: "v"})
+	require.ErrorIs(t, err, context.Canceled)
+	// a failed request is retried like a failed batch
+	assert.False(t, consumererror.IsPermanent(err))
+}
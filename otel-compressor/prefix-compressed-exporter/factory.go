@@ -17,6 +17,9 @@ import (
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/exporter"
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
 )
 
 // NewFactory creates a factory for OTLP exporter.
@@ -44,13 +47,29 @@ func createDefaultConfig() component.Config {
 			// We almost read 0 bytes, so no need to tune ReadBufferSize.
 			WriteBufferSize: 512 * 1024,
 		},
-		TrieBuffer:     30000,
-		ThresholdRate:  50000,
-		AttrLimit:      100,
-		CalcZipRate:    false,
-		EnableGzip:     false,
-		DeleteResource: true,
-		NoTraceZip:     false,
+		TrieBuffer:             30000,
+		ThresholdRate:          50000,
+		AttrLimit:              100,
+		AttrOrder:              AttrOrderCardinality,
+		CalcZipRate:            false,
+		EnableGzip:             false,
+		Codec:                  CodecNone,
+		CodecLevel:             0,
+		ZstdDictionarySize:     0,
+		ZstdDictionarySamples:  128,
+		DeleteResource:         true,
+		TraceIdWindow:          4096,
+		NoTraceZip:             false,
+		LogsTraceZip:           false,
+		MetricsTraceZip:        false,
+		TraceZipFormat:         TraceZipFormatJSON,
+		InlineDictionary:       false,
+		TimestampCodec:         TimestampCodecOffset,
+		TemplateMining:         false,
+		StructuredCodecs:       false,
+		MemoryLimit:            0,
+		EvictionPolicy:         EvictionPolicyLRU,
+		DictionarySaveInterval: 10 * time.Second,
 	}
 }
 
@@ -72,6 +91,30 @@ func composeSignalURL(oCfg *Config, signalOverrideURL string, signalName string)
 	}
 }
 
+// traceZipTimestamps returns the ptraceotlp timestamp codec of codec.
+func traceZipTimestamps(codec TimestampCodec) ptraceotlp.TimestampCodec {
+	if codec == TimestampCodecRelative {
+		return ptraceotlp.TimestampRelative
+	}
+	return ptraceotlp.TimestampOffset
+}
+
+// traceZipOrder returns the ptraceotlp order strategy of order.
+func traceZipOrder(order AttrOrder) ptraceotlp.OrderStrategy {
+	if order == AttrOrderEntropy {
+		return ptraceotlp.OrderEntropy
+	}
+	return ptraceotlp.OrderCardinality
+}
+
+// traceZipEviction returns the ptraceotlp eviction policy of policy.
+func traceZipEviction(policy EvictionPolicy) ptraceotlp.EvictionPolicy {
+	if policy == EvictionPolicyLFU {
+		return ptraceotlp.EvictLFU
+	}
+	return ptraceotlp.EvictLRU
+}
+
 func createTracesExporter(
 	ctx context.Context,
 	set exporter.CreateSettings,
@@ -91,10 +134,25 @@ func createTracesExporter(
 	if err != nil {
 		return nil, err
 	}
+	oce.traceZip = ptraceotlp.NewTraceZipCompressor(ptraceotlp.TraceZipSettings{
+		BufferSize:       oCfg.TrieBuffer,
+		BufferWindow:     oCfg.SampleWindow,
+		AttrLimit:        oCfg.AttrLimit,
+		AttrOrder:        traceZipOrder(oCfg.AttrOrder),
+		ThresholdRate:    oCfg.ThresholdRate,
+		DeleteResource:   oCfg.DeleteResource,
+		TraceIdWindow:    oCfg.TraceIdWindow,
+		Timestamps:       traceZipTimestamps(oCfg.TimestampCodec),
+		TemplateMining:   oCfg.TemplateMining,
+		StructuredCodecs: oCfg.StructuredCodecs,
+		MemoryLimit:      oCfg.MemoryLimit,
+		Eviction:         traceZipEviction(oCfg.EvictionPolicy),
+	})
 
 	return exporterhelper.NewTracesExporter(ctx, set, cfg,
 		oce.pushTraces,
 		exporterhelper.WithStart(oce.start),
+		exporterhelper.WithShutdown(oce.shutdown),
 		exporterhelper.WithCapabilities(consumer.Capabilities{MutatesData: false}),
 		// explicitly disable since we rely on http.Client timeout logic.
 		exporterhelper.WithTimeout(exporterhelper.TimeoutSettings{Timeout: 0}),
@@ -117,10 +175,26 @@ func createMetricsExporter(
 	if err != nil {
 		return nil, err
 	}
+	oce.metricsdictURL, err = composeSignalURL(oCfg, oCfg.MetricsEndpoint, "metricsdict")
+	if err != nil {
+		return nil, err
+	}
+	if oCfg.MetricsTraceZip {
+		oce.metricZip = pmetricotlp.NewTraceZipCompressor(pmetricotlp.TraceZipSettings{
+			BufferSize:     oCfg.TrieBuffer,
+			BufferWindow:   oCfg.SampleWindow,
+			AttrLimit:      oCfg.AttrLimit,
+			ThresholdRate:  oCfg.ThresholdRate,
+			DeleteResource: oCfg.DeleteResource,
+			MemoryLimit:    oCfg.MemoryLimit,
+			Eviction:       traceZipEviction(oCfg.EvictionPolicy),
+		})
+	}
 
 	return exporterhelper.NewMetricsExporter(ctx, set, cfg,
 		oce.pushMetrics,
 		exporterhelper.WithStart(oce.start),
+		exporterhelper.WithShutdown(oce.shutdown),
 		exporterhelper.WithCapabilities(consumer.Capabilities{MutatesData: false}),
 		// explicitly disable since we rely on http.Client timeout logic.
 		exporterhelper.WithTimeout(exporterhelper.TimeoutSettings{Timeout: 0}),
@@ -143,10 +217,26 @@ func createLogsExporter(
 	if err != nil {
 		return nil, err
 	}
+	oce.logsdictURL, err = composeSignalURL(oCfg, oCfg.LogsEndpoint, "logsdict")
+	if err != nil {
+		return nil, err
+	}
+	if oCfg.LogsTraceZip {
+		oce.logZip = plogotlp.NewTraceZipCompressor(plogotlp.TraceZipSettings{
+			BufferSize:     oCfg.TrieBuffer,
+			BufferWindow:   oCfg.SampleWindow,
+			AttrLimit:      oCfg.AttrLimit,
+			ThresholdRate:  oCfg.ThresholdRate,
+			DeleteResource: oCfg.DeleteResource,
+			MemoryLimit:    oCfg.MemoryLimit,
+			Eviction:       traceZipEviction(oCfg.EvictionPolicy),
+		})
+	}
 
 	return exporterhelper.NewLogsExporter(ctx, set, cfg,
 		oce.pushLogs,
 		exporterhelper.WithStart(oce.start),
+		exporterhelper.WithShutdown(oce.shutdown),
 		exporterhelper.WithCapabilities(consumer.Capabilities{MutatesData: false}),
 		// explicitly disable since we rely on http.Client timeout logic.
 		exporterhelper.WithTimeout(exporterhelper.TimeoutSettings{Timeout: 0}),
@@ -0,0 +1,209 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package prefix_compressed_exporter // import "go.opentelemetry.io/collector/exporter/otlpexporter"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/pdata/tracezipotlp"
+)
+
+// traceZipStream is the TraceZip stream of an exporter. It is opened with the
+// first request, and again with the first request after it failed. Requests go
+// out in the order send is called, and their responses are matched by Seq.
+type traceZipStream struct {
+	client tracezipotlp.GRPCClient
+
+	mu      sync.Mutex
+	stream  tracezipotlp.ClientStream
+	cancel  context.CancelFunc
+	seq     uint64
+	pending map[uint64]chan streamResult
+}
+
+// streamResult is the response to a request of the stream, or the error the
+// stream failed with before it came.
+type streamResult struct {
+	response *tracezipotlp.StreamResponse
+	err      error
+}
+
+func newTraceZipStream(client tracezipotlp.GRPCClient) *traceZipStream {
+	return &traceZipStream{
+		client:  client,
+		pending: make(map[uint64]chan streamResult),
+	}
+}
+
+// send sends request and returns a function that waits for its response.
+func (s *traceZipStream) send(request *tracezipotlp.StreamRequest) (func(ctx context.Context) (*tracezipotlp.StreamResponse, error), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := s.client.Stream(ctx)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to open the TraceZip stream: %w", err)
+		}
+		s.stream, s.cancel = stream, cancel
+		go s.receive(stream)
+	}
+	s.seq++
+	request.Seq = s.seq
+	result := make(chan streamResult, 1)
+	s.pending[request.Seq] = result
+	if err := s.stream.Send(request); err != nil {
+		// the error the stream failed with is passed to the pending requests by
+		// receive, this one is io.EOF
+		delete(s.pending, request.Seq)
+		return nil, fmt.Errorf("failed to send on the TraceZip stream: %w", err)
+	}
+	return func(ctx context.Context) (*tracezipotlp.StreamResponse, error) {
+		select {
+		case r := <-result:
+			return r.response, r.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}, nil
+}
+
+// receive passes the responses of stream to their requests until it fails, and
+// then fails the requests that have none.
+func (s *traceZipStream) receive(stream tracezipotlp.ClientStream) {
+	for {
+		response, err := stream.Recv()
+		s.mu.Lock()
+		if err != nil {
+			if s.stream == stream {
+				s.reset(fmt.Errorf("the TraceZip stream failed: %w", err))
+			}
+			s.mu.Unlock()
+			return
+		}
+		result, ok := s.pending[response.Seq]
+		delete(s.pending, response.Seq)
+		s.mu.Unlock()
+		if ok {
+			result <- streamResult{response: response}
+		}
+	}
+}
+
+// reset drops the stream, and fails the requests waiting for a response with err.
+// It must be called with mu held.
+func (s *traceZipStream) reset(err error) {
+	s.cancel()
+	s.stream, s.cancel = nil, nil
+	for seq, result := range s.pending {
+		result <- streamResult{err: err}
+		delete(s.pending, seq)
+	}
+}
+
+// close ends the stream.
+func (s *traceZipStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream == nil {
+		return
+	}
+	_ = s.stream.CloseSend()
+	s.reset(fmt.Errorf("the TraceZip stream is closed"))
+}
+
+// exportStream sends a TraceZip payload on the TraceZip stream, together with the
+// dictionary request update of its batch if it has a Type. It must be called with
+// dictMu held, and releases it once the request is sent, so that requests go out
+// in the order their batches were compressed. A receiver that does not have the
+// version of the dictionary the payload needs is brought to the version of zip,
+// and the batch is compressed again, see recompressError.
+func (e *baseExporter) exportStream(ctx context.Context, update dictionaryRequest, payload []byte, contentType string) error {
+	request := &tracezipotlp.StreamRequest{
+		Signal:      e.signal,
+		Uuid:        update.Uuid,
+		Payload:     payload,
+		ContentType: contentType,
+		Epoch:       update.Epoch,
+		Version:     update.Version,
+	}
+	if update.Type != "" {
+		dictionary, err := json.Marshal(update)
+		if err != nil {
+			e.dictMu.Unlock()
+			return consumererror.NewPermanent(err)
+		}
+		request.Dictionary = dictionary
+	}
+	wait, err := e.stream.send(request)
+	e.dictMu.Unlock()
+	if err != nil {
+		return err
+	}
+	response, err := wait(ctx)
+	if err != nil {
+		return err
+	}
+	if codes.Code(response.Code) == codes.Aborted {
+		conflict := &dictionaryConflictError{have: dictionaryVersion{Epoch: response.Epoch, Version: response.Version}}
+		if err = e.recoverDictionary(ctx, "", update.Uuid, conflict.have); err != nil {
+			return err
+		}
+		return recompressError(conflict)
+	}
+	if update.Type != "" && codes.Code(response.Code) == codes.OK {
+		e.dictMu.Lock()
+		e.acknowledge(dictionaryVersion{Epoch: response.Epoch, Version: response.Version})
+		e.dictMu.Unlock()
+	}
+	return streamResponseError(response)
+}
+
+// syncDictionaryStream sends a TraceZip dictionary request on the TraceZip stream
+// and returns the version the receiver acknowledged, see syncDictionary.
+func (e *baseExporter) syncDictionaryStream(ctx context.Context, request dictionaryRequest) (dictionaryVersion, error) {
+	var have dictionaryVersion
+	dictionary, err := json.Marshal(request)
+	if err != nil {
+		return have, consumererror.NewPermanent(err)
+	}
+	wait, err := e.stream.send(&tracezipotlp.StreamRequest{Signal: e.signal, Uuid: request.Uuid, Dictionary: dictionary})
+	if err != nil {
+		return have, err
+	}
+	response, err := wait(ctx)
+	if err != nil {
+		return have, err
+	}
+	have = dictionaryVersion{Epoch: response.Epoch, Version: response.Version}
+	if codes.Code(response.Code) == codes.Aborted {
+		return have, &dictionaryConflictError{have: have}
+	}
+	return have, streamResponseError(response)
+}
+
+// streamResponseError returns the error of a response of the TraceZip stream, nil
+// if it is OK. Like for OTLP/gRPC, failures the receiver may get over are retried,
+// ResourceExhausted too since the receiver refuses new dictionaries with it until
+// idle ones expire.
+func streamResponseError(response *tracezipotlp.StreamResponse) error {
+	code := codes.Code(response.Code)
+	if code == codes.OK {
+		return nil
+	}
+	err := fmt.Errorf("error exporting items on the TraceZip stream: %w", status.Error(code, response.Message))
+	switch code {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss, codes.ResourceExhausted:
+		return err
+	}
+	return consumererror.NewPermanent(err)
+}
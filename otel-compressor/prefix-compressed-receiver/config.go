@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config/configgrpc"
@@ -30,14 +31,49 @@ type HTTPConfig struct {
 	// The URL path to receive metrics on. If omitted "/v1/metrics" will be used.
 	MetricsURLPath string `mapstructure:"metrics_url_path,omitempty"`
 
+	// The URL path to receive metrics dictionaries on. If omitted "/v1/metricsdict" will be used.
+	MetricsDictionaryURLPath string `mapstructure:"metrics_dictionary_url_path,omitempty"`
+
 	// The URL path to receive logs on. If omitted "/v1/logs" will be used.
 	LogsURLPath string `mapstructure:"logs_url_path,omitempty"`
 
+	// The URL path to receive logs dictionaries on. If omitted "/v1/logsdict" will be used.
+	LogsDictionaryURLPath string `mapstructure:"logs_dictionary_url_path,omitempty"`
+
 	EnableGzip bool `mapstructure:"enable_gzip,omitempty"`
 
 	ExportSpans string `mapstructure:"export_spans"`
 
 	NoTraceZip bool `mapstructure:"no_tracezip"`
+
+	// DictionaryDirectory is the directory the TraceZip dictionaries are saved in,
+	// so that exporters go on with them after a restart of the receiver.
+	DictionaryDirectory string `mapstructure:"dictionary_directory"`
+
+	// DictionaryStorage is the storage extension the TraceZip dictionaries are
+	// saved with. It takes precedence over DictionaryDirectory.
+	DictionaryStorage *component.ID `mapstructure:"dictionary_storage"`
+
+	// DictionarySaveInterval is how often the changed dictionaries are saved. With
+	// 0 they are only saved on shutdown.
+	DictionarySaveInterval time.Duration `mapstructure:"dictionary_save_interval"`
+
+	// DictionaryTTL is how long a dictionary no request names is kept. It is then
+	// dropped from memory and from its store. With 0 dictionaries are kept forever.
+	DictionaryTTL time.Duration `mapstructure:"dictionary_ttl"`
+
+	// DictionaryMemoryLimit bounds the bytes a dictionary takes. An update beyond
+	// it drops the dictionary. With 0 dictionaries are not bounded.
+	DictionaryMemoryLimit int `mapstructure:"dictionary_memory_limit"`
+
+	// TotalDictionaryMemoryLimit bounds the bytes the dictionaries of all exporters
+	// take. Beyond it no new dictionary is accepted. With 0 they are not bounded.
+	TotalDictionaryMemoryLimit int `mapstructure:"total_dictionary_memory_limit"`
+
+	// DictionaryWait is how long a payload or a dictionary update waits for the
+	// version of the dictionary it needs, which an update the exporter sent
+	// concurrently may bring. It is then refused with 409 Conflict.
+	DictionaryWait time.Duration `mapstructure:"dictionary_wait"`
 }
 
 // Protocols is the configuration for the supported protocols.
@@ -60,6 +96,18 @@ func (cfg *Config) Validate() error {
 	if cfg.GRPC == nil && cfg.HTTP == nil {
 		return errors.New("must specify at least one protocol when using the OTLP receiver")
 	}
+	if cfg.HTTP != nil && cfg.HTTP.DictionarySaveInterval < 0 {
+		return errors.New("dictionary_save_interval must not be negative")
+	}
+	if cfg.HTTP != nil && cfg.HTTP.DictionaryTTL < 0 {
+		return errors.New("dictionary_ttl must not be negative")
+	}
+	if cfg.HTTP != nil && (cfg.HTTP.DictionaryMemoryLimit < 0 || cfg.HTTP.TotalDictionaryMemoryLimit < 0) {
+		return errors.New("dictionary_memory_limit and total_dictionary_memory_limit must not be negative")
+	}
+	if cfg.HTTP != nil && cfg.HTTP.DictionaryWait < 0 {
+		return errors.New("dictionary_wait must not be negative")
+	}
 	return nil
 }
 
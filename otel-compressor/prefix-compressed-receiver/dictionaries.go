@@ -0,0 +1,480 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package prefix_compressed_receiver // import "go.opentelemetry.io/collector/receiver/otlpreceiver"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	dictionarystore "angrychow/otel/dictionary-store"
+
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"go.opentelemetry.io/collector/pdata/tracezipotlp"
+)
+
+// errDictionaryConflict is returned for a dictionary update or a payload that needs
+// a version of the dictionary the receiver does not have. It is answered with 409
+// Conflict and the version the receiver has, upon which the exporter resends the
+// updates after that version, or the whole dictionary.
+var errDictionaryConflict = errors.New("dictionary version mismatch")
+
+// errDictionaryStore is returned when a dictionary cannot be loaded from or saved
+// to its store.
+var errDictionaryStore = errors.New("dictionary store")
+
+// errDictionaryTooLarge is returned for a dictionary update that makes the
+// dictionary take more than dictionary_memory_limit. The dictionary is dropped, and
+// the request is answered with 413 Payload Too Large.
+var errDictionaryTooLarge = errors.New("dictionary exceeds dictionary_memory_limit")
+
+// errDictionaryCapacity is returned for a new dictionary while the dictionaries of
+// all exporters take total_dictionary_memory_limit. It is answered with 503 Service
+// Unavailable, the exporter tries again once idle dictionaries have expired.
+var errDictionaryCapacity = errors.New("dictionaries exceed total_dictionary_memory_limit")
+
+// dictionaryConflictError is an errDictionaryConflict with the version of the
+// dictionary the receiver has.
+type dictionaryConflictError struct {
+	ack    dictionaryAck
+	reason string
+}
+
+func (e *dictionaryConflictError) Error() string {
+	return fmt.Sprintf("%v: %s", errDictionaryConflict, e.reason)
+}
+
+func (e *dictionaryConflictError) Unwrap() error {
+	return errDictionaryConflict
+}
+
+// dictionaryVersion is the version of a dictionary, see
+// ptraceotlp.TraceZipCompressor.Version.
+type dictionaryVersion struct {
+	Epoch   uint64 `json:"e"`
+	Version uint64 `json:"s"`
+}
+
+func (v dictionaryVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Epoch, v.Version)
+}
+
+// dictionaryAck is the body of the answer to a dictionary request, and of a 409
+// Conflict to a payload: the version of the dictionary the receiver has applied,
+// 0.0 if it has none.
+type dictionaryAck struct {
+	Uuid string `json:"_"`
+	dictionaryVersion
+}
+
+// savedDictionary is what the store keeps of a dictionary.
+type savedDictionary[D traceZipDictionary] struct {
+	dictionaryVersion
+	Dictionary D      `json:"d"`
+	Zstd       []byte `json:"z,omitempty"`
+	Floor      uint64 `json:"f,omitempty"`
+}
+
+// traceZipDictionaries are the dictionaries of a signal by uuid, with their
+// versions, sizes and last use. The dictionaries are saved to the store of all
+// when it is set, and loaded from it on their first use. All methods must be
+// called with all.mu held.
+type traceZipDictionaries[D traceZipDictionary] struct {
+	all      *receiverDictionaries
+	signal   string
+	dicts    map[string]D
+	versions map[string]dictionaryVersion
+	// the oldest version of the current epoch a payload may need, older ones may
+	// use entries later updates dropped, see traceZipDictionaryRequest.Floor
+	floors map[string]uint64
+	// the zstd dictionaries of the dictionaries that have one, see
+	// receiverDictionaries.decoder
+	zstd map[string][]byte
+	// the uuids of the dictionaries changed since they were last saved
+	dirty    map[string]bool
+	sizes    map[string]int
+	lastUsed map[string]time.Time
+	// size is the sum of sizes
+	size int
+	// expired counts the dictionaries dropped after dictionary_ttl
+	expired int64
+	newDict func() D
+}
+
+func newTraceZipDictionaries[D traceZipDictionary](all *receiverDictionaries, signal string, newDict func() D) *traceZipDictionaries[D] {
+	return &traceZipDictionaries[D]{
+		all:      all,
+		signal:   signal,
+		dicts:    make(map[string]D),
+		versions: make(map[string]dictionaryVersion),
+		floors:   make(map[string]uint64),
+		zstd:     make(map[string][]byte),
+		dirty:    make(map[string]bool),
+		sizes:    make(map[string]int),
+		lastUsed: make(map[string]time.Time),
+		newDict:  newDict,
+	}
+}
+
+// receiverDictionaries are the TraceZip dictionaries of all signals of a
+// receiver, with the store they are saved in and their limits.
+type receiverDictionaries struct {
+	// mu guards the dictionaries and the fields below
+	mu      sync.Mutex
+	traces  *traceZipDictionaries[*ptraceotlp.TraceZipDictionary]
+	logs    *traceZipDictionaries[*plogotlp.TraceZipDictionary]
+	metrics *traceZipDictionaries[*pmetricotlp.TraceZipDictionary]
+	// store is where the dictionaries are saved, nil if they are not
+	store  dictionarystore.Store
+	limits dictionaryLimits
+	// changed is closed, and made again, when a dictionary request is applied,
+	// to wake up the requests waiting for a version
+	changed chan struct{}
+	// decoder decompresses the bodies of TraceZip requests by their
+	// Content-Encoding, with the zstd dictionaries of all dictionaries
+	decoder *tracezipotlp.Decoder
+}
+
+func newReceiverDictionaries() *receiverDictionaries {
+	d := &receiverDictionaries{
+		changed: make(chan struct{}),
+		decoder: tracezipotlp.NewDecoder(),
+	}
+	d.traces = newTraceZipDictionaries(d, "traces", ptraceotlp.NewTraceZipDictionary)
+	d.logs = newTraceZipDictionaries(d, "logs", plogotlp.NewTraceZipDictionary)
+	d.metrics = newTraceZipDictionaries(d, "metrics", pmetricotlp.NewTraceZipDictionary)
+	return d
+}
+
+// dictionaryLimits bound the dictionaries of all signals, see HTTPConfig. A zero
+// field does not bound them.
+type dictionaryLimits struct {
+	ttl      time.Duration
+	maxSize  int
+	maxTotal int
+	wait     time.Duration
+}
+
+type noDictionaryWaitKey struct{}
+
+// withoutDictionaryWait returns a context whose requests do not wait for the
+// versions they need, for the TraceZip stream, whose requests come in order.
+func withoutDictionaryWait(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noDictionaryWaitKey{}, true)
+}
+
+// await waits until a dictionary request is applied, with mu released, and
+// tells whether one was before deadline. Concurrent exports send their
+// dictionary updates and payloads concurrently, so a request may come before
+// the update it needs. It must be called with mu held.
+func (d *receiverDictionaries) await(ctx context.Context, deadline time.Time) bool {
+	wait := time.Until(deadline)
+	if wait <= 0 || ctx.Value(noDictionaryWaitKey{}) != nil {
+		return false
+	}
+	changed := d.changed
+	d.mu.Unlock()
+	defer d.mu.Lock()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-changed:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return false
+}
+
+// behind tells whether a dictionary at have, if ok, has yet to reach want, which
+// an update in flight may bring it to.
+func behind(ok bool, have dictionaryVersion, want dictionaryVersion) bool {
+	return want.Epoch != 0 && (!ok || have.Epoch < want.Epoch || have.Epoch == want.Epoch && have.Version < want.Version)
+}
+
+// ack returns the version of the dictionary of uuid the receiver has.
+func (s *traceZipDictionaries[D]) ack(uuid string) dictionaryAck {
+	return dictionaryAck{Uuid: uuid, dictionaryVersion: s.versions[uuid]}
+}
+
+func (s *traceZipDictionaries[D]) conflict(uuid string, format string, args ...any) error {
+	return &dictionaryConflictError{ack: s.ack(uuid), reason: s.signal + " dictionary " + uuid + " " + fmt.Sprintf(format, args...)}
+}
+
+func (s *traceZipDictionaries[D]) key(uuid string) string {
+	return s.signal + "-" + uuid
+}
+
+// get returns the dictionary of uuid, loading it from the store if it is not
+// in memory, and marks it used. ok is false if there is no such dictionary.
+func (s *traceZipDictionaries[D]) get(ctx context.Context, uuid string) (dict D, ok bool, err error) {
+	if dict, ok = s.dicts[uuid]; ok {
+		s.lastUsed[uuid] = time.Now()
+	}
+	if ok || s.all.store == nil || uuid == "" {
+		return dict, ok, nil
+	}
+	data, err := s.all.store.Get(ctx, s.key(uuid))
+	if err != nil {
+		return dict, false, fmt.Errorf("%w: load %s dictionary %q: %v", errDictionaryStore, s.signal, uuid, err)
+	}
+	if data == nil {
+		return dict, false, nil
+	}
+	saved := savedDictionary[D]{Dictionary: s.newDict()}
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return dict, false, fmt.Errorf("%w: saved %s dictionary %q: %v", errDictionaryStore, s.signal, uuid, err)
+	}
+	if err = s.all.admit(ctx); err != nil {
+		return dict, false, err
+	}
+	s.dicts[uuid] = saved.Dictionary
+	s.versions[uuid] = saved.dictionaryVersion
+	s.floors[uuid] = saved.Floor
+	s.lastUsed[uuid] = time.Now()
+	if err = s.setZstd(uuid, saved.Zstd); err != nil {
+		return dict, false, fmt.Errorf("%w: saved %s dictionary %q: %v", errDictionaryStore, s.signal, uuid, err)
+	}
+	if err = s.resize(ctx, uuid); err != nil {
+		return dict, false, err
+	}
+	return saved.Dictionary, true, nil
+}
+
+// lookup returns the dictionary of uuid to decode a payload that needs version of
+// it. The receiver must have that version or a later one of the same epoch, else
+// a dictionaryConflictError is returned once it has waited dictionary_wait for
+// it. A payload older than the floor of the dictionary is refused the same way,
+// later updates may have dropped entries it uses. A payload of epoch 0 comes from
+// an exporter that does not version its dictionaries, it only needs the
+// dictionary.
+func (s *traceZipDictionaries[D]) lookup(ctx context.Context, uuid string, version dictionaryVersion) (D, error) {
+	deadline := time.Now().Add(s.all.limits.wait)
+	dict, ok, err := s.get(ctx, uuid)
+	for err == nil && behind(ok, s.versions[uuid], version) && s.all.await(ctx, deadline) {
+		dict, ok, err = s.get(ctx, uuid)
+	}
+	if err != nil {
+		return dict, err
+	}
+	if version.Epoch == 0 {
+		if !ok {
+			return dict, fmt.Errorf("no dictionary for %q", uuid)
+		}
+		return dict, nil
+	}
+	if have := s.versions[uuid]; !ok || have.Epoch != version.Epoch || have.Version < version.Version {
+		return dict, s.conflict(uuid, "is at %v, the payload needs %v", have, version)
+	}
+	if floor := s.floors[uuid]; version.Version < floor {
+		return dict, s.conflict(uuid, "dropped entries of versions before %d.%d, the payload needs %v", version.Epoch, floor, version)
+	}
+	return dict, nil
+}
+
+// update applies a dictionary request. An incremental update must follow the
+// version the receiver has, and a verification or a zstd dictionary must name it,
+// else a dictionaryConflictError is returned. A request ahead of the version the
+// receiver has waits up to dictionary_wait for the updates before it. An
+// incremental update the receiver already applied is acknowledged again, for an
+// exporter that missed the first ack. Requests of epoch 0 come from exporters
+// that do not version their dictionaries, their updates are applied as they come.
+func (s *traceZipDictionaries[D]) update(ctx context.Context, request traceZipDictionaryRequest) error {
+	deadline := time.Now().Add(s.all.limits.wait)
+	want := dictionaryVersion{Epoch: request.Epoch, Version: request.Version}
+	// the version the request follows
+	follows := want
+	if request.Type == "i" && want.Version > 0 {
+		follows.Version--
+	}
+	dict, ok, err := s.get(ctx, request.Uuid)
+	for err == nil && request.Type != "a" && behind(ok, s.versions[request.Uuid], follows) && s.all.await(ctx, deadline) {
+		dict, ok, err = s.get(ctx, request.Uuid)
+	}
+	if err != nil {
+		return err
+	}
+	have := s.versions[request.Uuid]
+	if !ok && (request.Type == "a" || request.Type == "i" && request.Epoch == 0) {
+		if err = s.all.admit(ctx); err != nil {
+			return err
+		}
+	}
+	switch request.Type {
+	case "a":
+		if !ok {
+			dict = s.newDict()
+		}
+		if err = dict.FullUpdate(request.Update); err != nil {
+			return err
+		}
+		if err = s.setZstd(request.Uuid, request.Zstd); err != nil {
+			return err
+		}
+	case "i":
+		if request.Epoch == 0 {
+			if !ok {
+				dict = s.newDict()
+			}
+		} else if ok && have.Epoch == want.Epoch && want.Version <= have.Version {
+			return nil
+		} else if !ok || have.Epoch != want.Epoch || have.Version+1 != want.Version {
+			return s.conflict(request.Uuid, "is at %v, the update is %v", have, want)
+		}
+		if err = dict.IncrementUpdate(request.Update); err != nil {
+			return err
+		}
+	case "v":
+		if !ok || have != want {
+			return s.conflict(request.Uuid, "is at %v, the exporter has %v", have, want)
+		}
+		return nil
+	case "z":
+		if !ok || have != want {
+			return s.conflict(request.Uuid, "is at %v, the exporter has %v", have, want)
+		}
+		if err = s.setZstd(request.Uuid, request.Zstd); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown dictionary request type %q", request.Type)
+	}
+	switch {
+	case request.Type == "a":
+		delete(s.floors, request.Uuid)
+	case request.Type == "i" && request.Epoch != 0:
+		s.floors[request.Uuid] = max(s.floors[request.Uuid], request.Floor)
+	}
+	s.dicts[request.Uuid] = dict
+	s.versions[request.Uuid] = want
+	s.dirty[request.Uuid] = true
+	s.lastUsed[request.Uuid] = time.Now()
+	close(s.all.changed)
+	s.all.changed = make(chan struct{})
+	return s.resize(ctx, request.Uuid)
+}
+
+// setZstd sets the zstd dictionary of the dictionary of uuid, nil for none, and
+// adds it to the decoder in place of the one it had.
+func (s *traceZipDictionaries[D]) setZstd(uuid string, zstd []byte) error {
+	if id, err := tracezipotlp.ZstdDictionaryID(s.zstd[uuid]); err == nil {
+		s.all.decoder.RemoveZstdDictionary(id)
+	}
+	delete(s.zstd, uuid)
+	if zstd == nil {
+		return nil
+	}
+	if _, err := s.all.decoder.AddZstdDictionary(zstd); err != nil {
+		return fmt.Errorf("invalid zstd dictionary: %w", err)
+	}
+	s.zstd[uuid] = zstd
+	return nil
+}
+
+// resize updates the size of the dictionary of uuid, with its zstd dictionary. A
+// dictionary beyond dictionary_memory_limit is dropped, the exporter has to lower
+// its memory_limit.
+func (s *traceZipDictionaries[D]) resize(ctx context.Context, uuid string) error {
+	size := s.dicts[uuid].Size() + len(s.zstd[uuid])
+	s.size += size - s.sizes[uuid]
+	s.sizes[uuid] = size
+	if s.all.limits.maxSize <= 0 || size <= s.all.limits.maxSize {
+		return nil
+	}
+	err := fmt.Errorf("%w: %s dictionary %q takes %d bytes, the limit is %d", errDictionaryTooLarge, s.signal, uuid, size, s.all.limits.maxSize)
+	return errors.Join(err, s.drop(ctx, uuid))
+}
+
+// drop removes the dictionary of uuid from memory and from the store.
+func (s *traceZipDictionaries[D]) drop(ctx context.Context, uuid string) error {
+	s.size -= s.sizes[uuid]
+	s.setZstd(uuid, nil) // nolint:errcheck
+	delete(s.dicts, uuid)
+	delete(s.versions, uuid)
+	delete(s.floors, uuid)
+	delete(s.dirty, uuid)
+	delete(s.sizes, uuid)
+	delete(s.lastUsed, uuid)
+	if s.all.store == nil {
+		return nil
+	}
+	if err := s.all.store.Delete(ctx, s.key(uuid)); err != nil {
+		return fmt.Errorf("%w: delete %s dictionary %q: %v", errDictionaryStore, s.signal, uuid, err)
+	}
+	return nil
+}
+
+// expire drops the dictionaries not used for dictionary_ttl.
+func (s *traceZipDictionaries[D]) expire(ctx context.Context, now time.Time) error {
+	if s.all.limits.ttl <= 0 {
+		return nil
+	}
+	var errs error
+	for uuid, last := range s.lastUsed {
+		if now.Sub(last) < s.all.limits.ttl {
+			continue
+		}
+		s.expired++
+		errs = errors.Join(errs, s.drop(ctx, uuid))
+	}
+	return errs
+}
+
+// save writes the dictionaries changed since they were last saved to the store.
+func (s *traceZipDictionaries[D]) save(ctx context.Context) error {
+	if s.all.store == nil {
+		return nil
+	}
+	var errs error
+	for uuid := range s.dirty {
+		data, err := json.Marshal(savedDictionary[D]{dictionaryVersion: s.versions[uuid], Dictionary: s.dicts[uuid], Zstd: s.zstd[uuid], Floor: s.floors[uuid]})
+		if err == nil {
+			err = s.all.store.Set(ctx, s.key(uuid), data)
+		}
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("save %s dictionary %q: %w", s.signal, uuid, err))
+			continue
+		}
+		delete(s.dirty, uuid)
+	}
+	return errs
+}
+
+// totalSize returns the bytes the dictionaries of all signals take. It must be
+// called with mu held.
+func (d *receiverDictionaries) totalSize() int {
+	return d.traces.size + d.logs.size + d.metrics.size
+}
+
+// admit makes room for a new dictionary. At total_dictionary_memory_limit it
+// first drops the expired dictionaries, and refuses the new one with
+// errDictionaryCapacity if that is not enough. It must be called with mu held.
+func (d *receiverDictionaries) admit(ctx context.Context) error {
+	if d.limits.maxTotal <= 0 || d.totalSize() < d.limits.maxTotal {
+		return nil
+	}
+	err := d.expire(ctx, time.Now())
+	if total := d.totalSize(); total >= d.limits.maxTotal {
+		return errors.Join(fmt.Errorf("%w: the dictionaries take %d bytes, the limit is %d", errDictionaryCapacity, total, d.limits.maxTotal), err)
+	}
+	return err
+}
+
+// expire drops the dictionaries of all signals not used for dictionary_ttl. It
+// must be called with mu held.
+func (d *receiverDictionaries) expire(ctx context.Context, now time.Time) error {
+	return errors.Join(d.traces.expire(ctx, now), d.logs.expire(ctx, now), d.metrics.expire(ctx, now))
+}
+
+// save saves the dictionaries of all signals.
+func (d *receiverDictionaries) save(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return errors.Join(d.traces.save(ctx), d.logs.save(ctx), d.metrics.save(ctx))
+}
@@ -0,0 +1,139 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package prefix_compressed_receiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+)
+
+// postTracesDictionary sends a full update of the traces dictionary of uuid to
+// the receiver at url, and returns the status code and the body of the answer.
+func postTracesDictionary(t *testing.T, url string, uuid string) (int, string) {
+	c := ptraceotlp.NewTraceZipCompressor(ptraceotlp.TraceZipSettings{BufferSize: 64, AttrLimit: 5, ThresholdRate: 1000})
+	_, full, _, _ := c.MarshalWithTraceZip(ptraceotlp.NewExportRequestFromTraces(newTestTraces(0)), false)
+	update, err := json.Marshal(full)
+	require.NoError(t, err)
+	request := traceZipDictionaryRequest{Uuid: uuid, Epoch: 1, Type: "a"}
+	require.NoError(t, json.Unmarshal(update, &request.Update))
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	resp, err := http.Post(url+defaultTracesDictionaryURLPath, "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+// tracesDictionaries returns the uuids of the traces dictionaries r has.
+func tracesDictionaries(r *otlpReceiver) []string {
+	r.dictionaries.mu.Lock()
+	defer r.dictionaries.mu.Unlock()
+	var uuids []string
+	for uuid := range r.dictionaries.traces.dicts {
+		uuids = append(uuids, uuid)
+	}
+	return uuids
+}
+
+// idleTracesDictionary makes the traces dictionary of uuid last used age ago.
+func idleTracesDictionary(r *otlpReceiver, uuid string, age time.Duration) {
+	r.dictionaries.mu.Lock()
+	defer r.dictionaries.mu.Unlock()
+	r.dictionaries.traces.lastUsed[uuid] = time.Now().Add(-age)
+}
+
+func TestTraceZipDictionaryTTL(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.HTTP.DictionaryTTL = time.Hour
+	cfg.HTTP.DictionaryWait = 10 * time.Millisecond
+	r, sinks, url := newTestHTTPReceiver(t, cfg, nil)
+	exp := newTestExporter(t, url, nil)
+
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(0)))
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(1)))
+	uuid, before := tracesDictionary(t, r)
+
+	// A dictionary used within the TTL is kept, an idle one is dropped.
+	r.dictionaries.mu.Lock()
+	require.NoError(t, r.dictionaries.expire(context.Background(), time.Now().Add(time.Minute)))
+	r.dictionaries.mu.Unlock()
+	assert.Equal(t, []string{uuid}, tracesDictionaries(r))
+
+	idleTracesDictionary(r, uuid, 2*time.Hour)
+	r.dictionaries.mu.Lock()
+	require.NoError(t, r.dictionaries.expire(context.Background(), time.Now()))
+	expired := r.dictionaries.traces.expired
+	r.dictionaries.mu.Unlock()
+	assert.Empty(t, tracesDictionaries(r))
+	assert.EqualValues(t, 1, expired)
+
+	// The exporter gets a 409 for the expired dictionary and sends all of it
+	// under a new epoch.
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(2)))
+	_, resynced := tracesDictionary(t, r)
+	assert.Greater(t, resynced.Epoch, before.Epoch)
+	assert.Equal(t, []string{"GET /order/0", "GET /order/1", "GET /order/2"}, spanNames(sinks.traces))
+}
+
+func TestTraceZipDictionaryLimits(t *testing.T) {
+	t.Run("total limit", func(t *testing.T) {
+		cfg := createDefaultConfig().(*Config)
+		cfg.HTTP.DictionaryTTL = time.Hour
+		cfg.HTTP.TotalDictionaryMemoryLimit = 1
+		r, _, url := newTestHTTPReceiver(t, cfg, nil)
+
+		// the first dictionary takes all of the limit, the ones after it are
+		// refused while it is used
+		status, _ := postTracesDictionary(t, url, "a")
+		require.Equal(t, http.StatusOK, status)
+		status, body := postTracesDictionary(t, url, "b")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Contains(t, body, "total_dictionary_memory_limit")
+		assert.ElementsMatch(t, []string{"a"}, tracesDictionaries(r))
+
+		// an idle dictionary makes room for a new one, which is then kept
+		idleTracesDictionary(r, "a", 2*time.Hour)
+		status, _ = postTracesDictionary(t, url, "b")
+		require.Equal(t, http.StatusOK, status)
+		status, _ = postTracesDictionary(t, url, "c")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.ElementsMatch(t, []string{"b"}, tracesDictionaries(r))
+	})
+
+	t.Run("dictionary limit", func(t *testing.T) {
+		cfg := createDefaultConfig().(*Config)
+		r, _, url := newTestHTTPReceiver(t, cfg, nil)
+
+		status, _ := postTracesDictionary(t, url, "a")
+		require.Equal(t, http.StatusOK, status)
+
+		// The update that takes a dictionary beyond the limit drops it alone, the
+		// others are kept.
+		r.dictionaries.mu.Lock()
+		r.dictionaries.limits.maxSize = r.dictionaries.traces.sizes["a"] - 1
+		r.dictionaries.mu.Unlock()
+		status, body := postTracesDictionary(t, url, "b")
+		assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+		assert.Contains(t, body, "dictionary_memory_limit")
+		assert.ElementsMatch(t, []string{"a"}, tracesDictionaries(r))
+
+		r.dictionaries.mu.Lock()
+		size, sizeA := r.dictionaries.traces.size, r.dictionaries.traces.sizes["a"]
+		r.dictionaries.mu.Unlock()
+		assert.Equal(t, sizeA, size)
+	})
+}
@@ -2,6 +2,7 @@ package prefix_compressed_receiver // import "go.opentelemetry.io/collector/rece
 
 import (
 	"context"
+	"time"
 
 	"angrychow/otel/prefix-compressed-receiver/internal/localhostgate"
 	"angrychow/otel/prefix-compressed-receiver/internal/metadata"
@@ -20,10 +21,12 @@ const (
 	grpcPort = 4317
 	httpPort = 4318
 
-	defaultTracesURLPath           = "/v1/traces"
-	defaultMetricsURLPath          = "/v1/metrics"
-	defaultLogsURLPath             = "/v1/logs"
-	defaultTracesDictionaryURLPath = "/v1/tracesdict"
+	defaultTracesURLPath            = "/v1/traces"
+	defaultMetricsURLPath           = "/v1/metrics"
+	defaultLogsURLPath              = "/v1/logs"
+	defaultTracesDictionaryURLPath  = "/v1/tracesdict"
+	defaultLogsDictionaryURLPath    = "/v1/logsdict"
+	defaultMetricsDictionaryURLPath = "/v1/metricsdict"
 )
 
 // NewFactory creates a new OTLP receiver factory.
@@ -53,13 +56,18 @@ func createDefaultConfig() component.Config {
 				ServerConfig: &confighttp.ServerConfig{
 					Endpoint: localhostgate.EndpointForPort(httpPort),
 				},
-				TracesURLPath:           defaultTracesURLPath,
-				MetricsURLPath:          defaultMetricsURLPath,
-				LogsURLPath:             defaultLogsURLPath,
-				TracesDictionaryURLPath: defaultTracesDictionaryURLPath,
-				EnableGzip:              false,
-				ExportSpans:             "",
-				NoTraceZip:              false,
+				TracesURLPath:            defaultTracesURLPath,
+				MetricsURLPath:           defaultMetricsURLPath,
+				LogsURLPath:              defaultLogsURLPath,
+				TracesDictionaryURLPath:  defaultTracesDictionaryURLPath,
+				LogsDictionaryURLPath:    defaultLogsDictionaryURLPath,
+				MetricsDictionaryURLPath: defaultMetricsDictionaryURLPath,
+				EnableGzip:               false,
+				ExportSpans:              "",
+				NoTraceZip:               false,
+				DictionarySaveInterval:   10 * time.Second,
+				DictionaryTTL:            time.Hour,
+				DictionaryWait:           5 * time.Second,
 			},
 		},
 	}
@@ -2,12 +2,14 @@ package prefix_compressed_receiver // import "go.opentelemetry.io/collector/rece
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
+	"runtime/debug"
 	"time"
 
 	spb "google.golang.org/genproto/googleapis/rpc/status"
@@ -17,9 +19,12 @@ import (
 	"angrychow/otel/prefix-compressed-receiver/internal/logs"
 	"angrychow/otel/prefix-compressed-receiver/internal/metrics"
 	"angrychow/otel/prefix-compressed-receiver/internal/trace"
-)
 
-var Dictionary = make(map[string]*CompressionDictionary, 0)
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"go.opentelemetry.io/collector/pdata/tracezipotlp"
+)
 
 var DecompressionTotalTime time.Duration
 
@@ -27,203 +32,52 @@ var GzipDecompressionTotalTime time.Duration
 
 var BodyLengthTotal uint64
 
-type CompressionDictionary struct {
-	AttributeNameDict  map[string]string
-	AttributeValueDict map[string]string
-	EventAttributeDict map[string]string
-	EventNameDict      map[string]string
-	PathDict           map[string][]string
-	Orders             map[string][]string
-	SpanNameDict       map[string]string
+// traceZipRequest is the body of a compressed request. An exporter with
+// inline_dictionary puts the dictionary request of the payload next to it, which
+// has no Type otherwise.
+type traceZipRequest struct {
+	traceZipDictionaryRequest
+	Data json.RawMessage `json:"a"`
 }
 
-type UpdatesEntry struct {
-	Key   string `json:"k"`
-	Value string `json:"v"`
+// traceZipDictionaryRequest is the body of a dictionary request. Type is "a" for a
+// full update, "i" for an incremental one and "v" to verify that the receiver has
+// the dictionary at Epoch and Version, which are the ones with the update. "z"
+// verifies the same and sets Zstd, the zstd dictionary the payloads of the
+// dictionary are compressed with from then on, which a full update sets too. An
+// incremental update names in Floor the oldest version of its epoch the payloads
+// on their way may need; the entries it drops are used by none of them.
+type traceZipDictionaryRequest struct {
+	Uuid    string            `json:"_"`
+	Epoch   uint64            `json:"e"`
+	Version uint64            `json:"s"`
+	Type    string            `json:"t"`
+	Update  []json.RawMessage `json:"n"`
+	Zstd    []byte            `json:"z,omitempty"`
+	Floor   uint64            `json:"f,omitempty"`
 }
 
-func (cd *CompressionDictionary) IncrementUpdate(datas []interface{}) error {
-
-	// fmt.Println(datas)
-
-	var updates [7][]UpdatesEntry
-
-	for i := 0; i < 7; i++ {
-		updates[i] = make([]UpdatesEntry, 0)
-		t := datas[i].([]interface{})
-		for _, item_ := range t {
-			item := item_.(map[string]interface{})
-			updates[i] = append(updates[i], UpdatesEntry{
-				Key:   item["k"].(string),
-				Value: item["v"].(string),
-			})
-		}
-	}
-
-	if len(updates[0]) > 0 {
-		if cd.AttributeNameDict == nil {
-			cd.AttributeNameDict = make(map[string]string)
-		}
-		for _, entry := range updates[0] {
-			cd.AttributeNameDict[entry.Key] = entry.Value
-		}
-	}
-
-	if len(updates[1]) > 0 {
-		if cd.AttributeValueDict == nil {
-			cd.AttributeValueDict = make(map[string]string)
-		}
-		for _, entry := range updates[1] {
-			cd.AttributeValueDict[entry.Key] = entry.Value
-		}
-	}
-
-	if len(updates[2]) > 0 {
-		if cd.EventAttributeDict == nil {
-			cd.EventAttributeDict = make(map[string]string)
-		}
-		for _, entry := range updates[2] {
-			cd.EventAttributeDict[entry.Key] = entry.Value
-		}
-	}
-
-	if len(updates[3]) > 0 {
-		if cd.EventNameDict == nil {
-			cd.EventNameDict = make(map[string]string)
-		}
-		for _, entry := range updates[3] {
-			cd.EventNameDict[entry.Key] = entry.Value
-		}
-	}
-
-	if len(updates[4]) > 0 {
-		if cd.PathDict == nil {
-			cd.PathDict = make(map[string][]string)
-		}
-		for _, entry := range updates[4] {
-			var arr []string
-			if err := json.Unmarshal([]byte(entry.Value), &arr); err != nil {
-				return err
-			}
-			cd.PathDict[entry.Key] = arr
-		}
-	}
-
-	if len(updates[5]) > 0 {
-		if cd.SpanNameDict == nil {
-			cd.SpanNameDict = make(map[string]string)
-		}
-		for _, entry := range updates[5] {
-			cd.SpanNameDict[entry.Key] = entry.Value
-		}
-	}
-
-	if len(updates[6]) > 0 {
-		if cd.Orders == nil {
-			cd.Orders = make(map[string][]string)
-		}
-		for _, entry := range updates[6] {
-			// cd.Orders
-			var order []string
-			json.Unmarshal([]byte(entry.Value), &order)
-			cd.Orders[entry.Key] = order
-			fmt.Println(entry.Key)
-			fmt.Println(order)
-		}
-	}
-	return nil
-}
-
-func (cd *CompressionDictionary) FullUpdate(data []interface{}) error {
-	// A temporary structure to map the JSON structure to the CompressionDictionary
-	var temp []map[string]interface{} = make([]map[string]interface{}, 0)
-
-	for _, item := range data {
-		temp = append(temp, item.(map[string]interface{}))
-	}
-
-	// Initialize CompressionDictionary
-	cd.AttributeNameDict = make(map[string]string)
-	cd.AttributeValueDict = make(map[string]string)
-	cd.EventAttributeDict = make(map[string]string)
-	cd.EventNameDict = make(map[string]string)
-	cd.Orders = make(map[string][]string)
-	cd.PathDict = make(map[string][]string)
-	cd.SpanNameDict = make(map[string]string)
-
-	// Populate CompressionDictionary from the temporary structure
-	if len(temp) > 0 {
-		for k, v := range temp[0] {
-			cd.AttributeNameDict[k] = v.(string)
-		}
-	}
-	if len(temp) > 1 {
-		for k, v := range temp[1] {
-			cd.AttributeValueDict[k] = v.(string)
-		}
-	}
-	if len(temp) > 2 {
-		for k, v := range temp[2] {
-			cd.EventAttributeDict[k] = v.(string)
-		}
-	}
-	if len(temp) > 3 {
-		for k, v := range temp[3] {
-			cd.EventNameDict[k] = v.(string)
-		}
-	}
-	if len(temp) > 4 {
-		for k, v := range temp[4] {
-			paths := make([]string, 0)
-			for _, path := range v.([]interface{}) {
-				paths = append(paths, path.(string))
-			}
-			cd.PathDict[k] = paths
-		}
-	}
-	if len(temp) > 5 {
-		for k, v := range temp[5] {
-			var orders []string
-			for _, order := range v.([]interface{}) {
-				orders = append(orders, order.(string))
-			}
-			cd.Orders[k] = orders
-		}
-	}
-	if len(temp) > 6 {
-		for k, v := range temp[6] {
-			cd.SpanNameDict[k] = v.(string)
-		}
-	}
-	return nil
-}
-
-var fieldMap = map[string]string{
-	"0": "trace_id",
-	"1": "span_id",
-	"2": "parent_span_id",
-	"3": "flags",
-	"4": "name",
-	"5": "start_time_unix_nano",
-	"6": "end_time_unix_nano",
-	"7": "attributes",
-	"8": "status",
-	"9": "trace_state",
-	"a": "links",
-	"b": "dropped_attributes_count",
-	"c": "dropped_events_count",
-	"d": "dropped_links_count",
-	"e": "events",
-	"f": "kind",
-}
+// headerDictionaryVersion is the header of a TraceZip payload with the version of
+// the dictionary it needs, as "epoch.version". Payloads without it only need the
+// dictionary, of whatever version.
+const headerDictionaryVersion = "Tracezip-Dictionary-Version"
 
 // Pre-computed status with code=Internal to be used in case of a marshaling error.
 var fallbackMsg = []byte(`{"code": 13, "message": "failed to marshal error message"}`)
 
 const fallbackContentType = "application/json"
 
-func handleTraces(resp http.ResponseWriter, req *http.Request, tracesReceiver *trace.Receiver, exportSpans string, NoTraceZip bool) {
-	enc, ok := readContentType(resp, req)
+func handleTraces(resp http.ResponseWriter, req *http.Request, tracesReceiver *trace.Receiver, exportSpans string, NoTraceZip bool, dicts *receiverDictionaries, telemetry *traceZipTelemetry) {
+	// Binary TraceZip payloads are answered in protobuf.
+	isBinary := !NoTraceZip && getMimeTypeFromContentType(req.Header.Get("Content-Type")) == ptraceotlp.TraceZipBinaryContentType
+	var enc encoder = pbEncoder
+	ok := true
+	if !isBinary {
+		enc, ok = readContentType(resp, req)
+	} else if req.Method != http.MethodPost {
+		handleUnmatchedMethod(resp)
+		ok = false
+	}
 	if NoTraceZip {
 		var buf bytes.Buffer
 		_, err := io.Copy(&buf, req.Body)
@@ -253,166 +107,29 @@ func handleTraces(resp http.ResponseWriter, req *http.Request, tracesReceiver *t
 		writeResponse(resp, enc.contentType(), http.StatusOK, msg)
 		return
 	}
-	mu.Lock()
-	defer mu.Unlock()
-	var err error
-	if !ok {
-		return
-	}
-	var body []byte
-	var body_ map[string]interface{}
-	if req.Header.Get("Content-Encoding") == "gzip" {
-		var buf bytes.Buffer
-		_, err = io.Copy(&buf, req.Body)
-		if err != nil {
-			http.Error(resp, "Failed to read request body", http.StatusInternalServerError)
-			return
-		}
-		defer req.Body.Close()
-
-		gz, err := gzip.NewReader(&buf)
-		if err != nil {
-			http.Error(resp, "Failed to create gzip reader", http.StatusInternalServerError)
-			return
-		}
-		defer gz.Close()
-
-		body, err = io.ReadAll(gz)
-		if err != nil {
-			http.Error(resp, "Failed to read gzip body", http.StatusInternalServerError)
-			return
-		}
-	} else {
-		body, err = io.ReadAll(req.Body)
-		if err != nil {
-			http.Error(resp, "Failed to read request body", http.StatusInternalServerError)
-			return
-		}
-		defer req.Body.Close()
-	}
 	if !ok {
-		writeError(resp, enc, err, http.StatusBadRequest)
 		return
 	}
-	err = json.Unmarshal(body, &body_)
-	if err != nil {
-		writeError(resp, enc, err, http.StatusBadRequest)
-		return
+	body, err := readTraceZipBody(req)
+	var version dictionaryVersion
+	if err == nil {
+		version, err = readDictionaryVersion(req)
 	}
-	dict := Dictionary[body_["_"].(string)]
-	resourcesSpans := body_["a"].([]interface{})
-	for _, resourcesSpan := range resourcesSpans {
-		resource := resourcesSpan.(map[string]interface{})["resource"].(map[string]interface{})["attributes"].([]interface{})
-		for _, item := range resource {
-			d_ := make(map[string]interface{})
-			json.Unmarshal([]byte(item.(map[string]interface{})["value"].(string)), &d_)
-			item.(map[string]interface{})["value"] = d_
-		}
-		for _, scopeSpan := range resourcesSpan.(map[string]interface{})["scopeSpans"].([]interface{}) {
-			var minTime float64 = scopeSpan.(map[string]interface{})["to"].(float64)
-			var minEvtTime float64 = scopeSpan.(map[string]interface{})["eo"].(float64)
-			delete(scopeSpan.(map[string]interface{}), "to")
-			delete(scopeSpan.(map[string]interface{}), "eo")
-			for _, span_ := range scopeSpan.(map[string]interface{})["spans"].([]interface{}) {
-				span := span_.(map[string]interface{})
-				for k, v := range fieldMap {
-					if span[k] == nil {
-						continue
-					}
-					span[v] = span[k]
-					delete(span, k)
-				}
-				name__ := span["name"].(string)
-				span["name"] = dict.SpanNameDict[name__]
-				var pathId string
-				var pathArray []string
-				if span["_"] != nil {
-					pathId = span["_"].(string)
-					pathArray = dict.PathDict[pathId]
-					if pathArray == nil {
-						// diff sync system failed. we brutally cracked here, let exporter re-constructing SRT.
-						panic("no such pathId " + pathId)
-					}
-				} else {
-					pathArray = []string{}
-				}
-
-				Order := dict.Orders[span["name"].(string)]
-				span["start_time_unix_nano"] = span["start_time_unix_nano"].(float64) + minTime
-				span["end_time_unix_nano"] = span["end_time_unix_nano"].(float64) + minTime
-				// fmt.Println(span["attributes"])
-				for _, item_ := range span["attributes"].([]interface{}) {
-					item := item_.(map[string]interface{})
-					item["key"] = dict.AttributeNameDict[item["k"].(string)]
-					item["value"] = item["v"]
-					delete(item, "k")
-					delete(item, "v")
-				}
-				if len(pathArray) != len(Order) {
-					orderString, _ := json.Marshal(Order)
-					pathString, _ := json.Marshal(pathArray)
-					// diff sync system failed. we brutally cracked here, let exporter re-constructing SRT.
-					panic("SRT Dictionary Failed." + string(orderString) + "," + string(pathString))
-				}
-				for index, attr := range Order {
-					var valueParse interface{}
-					if pathArray[index] == "#" {
-						continue
-					}
-					json.Unmarshal([]byte(dict.AttributeValueDict[pathArray[index]]), &valueParse)
-					span["attributes"] = append(span["attributes"].([]interface{}), map[string]interface{}{
-						"key":   dict.AttributeNameDict[attr],
-						"value": valueParse,
-					})
-				}
-				if span["events"] != nil {
-					for _, event_ := range span["events"].([]interface{}) {
-						event := event_.(map[string]interface{})
-						event["time_unix_nano"] = event["t"].(float64) + minEvtTime
-						delete(event, "t")
-						event["name"] = dict.EventNameDict[event["n"].(string)]
-						delete(event, "n")
-						if event["d"] != nil {
-							event["dropped_attributes_count"] = event["d"].(int)
-							delete(event, "d")
-						}
-						if event["a"] != nil {
-							var value_ interface{}
-							json.Unmarshal([]byte(dict.EventAttributeDict[event["a"].(string)]), &value_)
-							for _, attr := range value_.([]interface{}) {
-								m_ := make(map[string]interface{})
-								json.Unmarshal([]byte(attr.(map[string]interface{})["value"].(string)), &m_)
-								attr.(map[string]interface{})["value"] = m_
-							}
-							event["attributes"] = value_
-							delete(event, "a")
-						}
-
-					}
-				}
-				delete(span, "_")
-			}
-		}
+	var otlpReq ptraceotlp.ExportRequest
+	if err == nil {
+		otlpReq, err = decodeTraceZip(func() (ptraceotlp.ExportRequest, error) {
+			return decodeTraceZipTraces(req.Context(), dicts, body, version, isBinary)
+		})
 	}
-
-	body, err = json.Marshal(map[string]interface{}{
-		"resource_spans": resourcesSpans,
-	})
-
 	if err != nil {
-		writeError(resp, enc, err, http.StatusBadRequest)
+		writeTraceZipError(resp, req, enc, telemetry, dicts.traces.signal, failureDecode, err)
 		return
 	}
 
 	if exportSpans != "" {
-		go sendPostRequest(exportSpans, body)
-	}
-
-	otlpReq, err := enc.unmarshalTracesRequest(body)
-
-	if err != nil {
-		writeError(resp, enc, err, http.StatusBadRequest)
-		return
+		if body, err = otlpReq.MarshalJSON(); err == nil {
+			go sendPostRequest(exportSpans, body)
+		}
 	}
 
 	otlpResp, err := tracesReceiver.Export(req.Context(), otlpReq)
@@ -429,21 +146,38 @@ func handleTraces(resp http.ResponseWriter, req *http.Request, tracesReceiver *t
 	writeResponse(resp, enc.contentType(), http.StatusOK, msg)
 }
 
-func handleMetrics(resp http.ResponseWriter, req *http.Request, metricsReceiver *metrics.Receiver) {
+func handleMetrics(resp http.ResponseWriter, req *http.Request, metricsReceiver *metrics.Receiver, NoTraceZip bool, dicts *receiverDictionaries, telemetry *traceZipTelemetry) {
 	enc, ok := readContentType(resp, req)
 	if !ok {
 		return
 	}
 
-	body, ok := readAndCloseBody(resp, req, enc)
-	if !ok {
-		return
-	}
-
-	otlpReq, err := enc.unmarshalMetricsRequest(body)
-	if err != nil {
-		writeError(resp, enc, err, http.StatusBadRequest)
-		return
+	var otlpReq pmetricotlp.ExportRequest
+	var err error
+	if NoTraceZip || !isTraceZipPayload(req) {
+		body, ok := readAndCloseBody(resp, req, enc)
+		if !ok {
+			return
+		}
+		if otlpReq, err = enc.unmarshalMetricsRequest(body); err != nil {
+			writeError(resp, enc, err, http.StatusBadRequest)
+			return
+		}
+	} else {
+		body, err := readTraceZipBody(req)
+		var version dictionaryVersion
+		if err == nil {
+			version, err = readDictionaryVersion(req)
+		}
+		if err == nil {
+			otlpReq, err = decodeTraceZip(func() (pmetricotlp.ExportRequest, error) {
+				return decodeTraceZipMetrics(req.Context(), dicts, body, version)
+			})
+		}
+		if err != nil {
+			writeTraceZipError(resp, req, enc, telemetry, dicts.metrics.signal, failureDecode, err)
+			return
+		}
 	}
 
 	otlpResp, err := metricsReceiver.Export(req.Context(), otlpReq)
@@ -460,21 +194,91 @@ func handleMetrics(resp http.ResponseWriter, req *http.Request, metricsReceiver
 	writeResponse(resp, enc.contentType(), http.StatusOK, msg)
 }
 
-func handleLogs(resp http.ResponseWriter, req *http.Request, logsReceiver *logs.Receiver) {
-	enc, ok := readContentType(resp, req)
-	if !ok {
-		return
+// decodeTraceZipTraces decodes a TraceZip traces payload in the JSON or the binary
+// format with the dictionary it names, at the version it needs.
+func decodeTraceZipTraces(ctx context.Context, dicts *receiverDictionaries, body []byte, version dictionaryVersion, isBinary bool) (ptraceotlp.ExportRequest, error) {
+	var body_ traceZipRequest
+	var export []ptraceotlp.ExportData
+	var err error
+	if isBinary {
+		body_.Uuid, export, err = ptraceotlp.UnmarshalTraceZipBinary(body)
+	} else if err = json.Unmarshal(body, &body_); err == nil {
+		err = json.Unmarshal(body_.Data, &export)
+	}
+	if err != nil {
+		return ptraceotlp.ExportRequest{}, err
+	}
+	dicts.mu.Lock()
+	defer dicts.mu.Unlock()
+	if err = applyInlineDictionary(ctx, dicts.traces, body_); err != nil {
+		return ptraceotlp.ExportRequest{}, err
 	}
+	dict, err := dicts.traces.lookup(ctx, body_.Uuid, version)
+	if err != nil {
+		return ptraceotlp.ExportRequest{}, err
+	}
+	td, err := ptraceotlp.DecodeWithTraceZip(dict, export)
+	if err != nil {
+		return ptraceotlp.ExportRequest{}, err
+	}
+	return ptraceotlp.NewExportRequestFromTraces(td), nil
+}
+
+// decodeTraceZipMetrics decodes a TraceZip metrics payload with the dictionary it
+// names, at the version it needs.
+func decodeTraceZipMetrics(ctx context.Context, dicts *receiverDictionaries, body []byte, version dictionaryVersion) (pmetricotlp.ExportRequest, error) {
+	var body_ traceZipRequest
+	if err := json.Unmarshal(body, &body_); err != nil {
+		return pmetricotlp.ExportRequest{}, err
+	}
+	dicts.mu.Lock()
+	defer dicts.mu.Unlock()
+	if err := applyInlineDictionary(ctx, dicts.metrics, body_); err != nil {
+		return pmetricotlp.ExportRequest{}, err
+	}
+	dict, err := dicts.metrics.lookup(ctx, body_.Uuid, version)
+	if err != nil {
+		return pmetricotlp.ExportRequest{}, err
+	}
+	md, err := pmetricotlp.UnmarshalWithTraceZip(dict, body_.Data)
+	if err != nil {
+		return pmetricotlp.ExportRequest{}, err
+	}
+	return pmetricotlp.NewExportRequestFromMetrics(md), nil
+}
 
-	body, ok := readAndCloseBody(resp, req, enc)
+func handleLogs(resp http.ResponseWriter, req *http.Request, logsReceiver *logs.Receiver, NoTraceZip bool, dicts *receiverDictionaries, telemetry *traceZipTelemetry) {
+	enc, ok := readContentType(resp, req)
 	if !ok {
 		return
 	}
 
-	otlpReq, err := enc.unmarshalLogsRequest(body)
-	if err != nil {
-		writeError(resp, enc, err, http.StatusBadRequest)
-		return
+	var otlpReq plogotlp.ExportRequest
+	var err error
+	if NoTraceZip || !isTraceZipPayload(req) {
+		body, ok := readAndCloseBody(resp, req, enc)
+		if !ok {
+			return
+		}
+		if otlpReq, err = enc.unmarshalLogsRequest(body); err != nil {
+			writeError(resp, enc, err, http.StatusBadRequest)
+			return
+		}
+	} else {
+		body, err := readTraceZipBody(req)
+		var version dictionaryVersion
+		if err == nil {
+			version, err = readDictionaryVersion(req)
+		}
+		if err == nil {
+			otlpReq, err = decodeTraceZip(func() (plogotlp.ExportRequest, error) {
+				return decodeTraceZipLogs(req.Context(), dicts, body, version)
+			})
+		}
+		if err != nil {
+			writeTraceZipError(resp, req, enc, telemetry, dicts.logs.signal, failureDecode, err)
+			return
+		}
 	}
 
 	otlpResp, err := logsReceiver.Export(req.Context(), otlpReq)
@@ -491,6 +295,29 @@ func handleLogs(resp http.ResponseWriter, req *http.Request, logsReceiver *logs.
 	writeResponse(resp, enc.contentType(), http.StatusOK, msg)
 }
 
+// decodeTraceZipLogs decodes a TraceZip logs payload with the dictionary it names,
+// at the version it needs.
+func decodeTraceZipLogs(ctx context.Context, dicts *receiverDictionaries, body []byte, version dictionaryVersion) (plogotlp.ExportRequest, error) {
+	var body_ traceZipRequest
+	if err := json.Unmarshal(body, &body_); err != nil {
+		return plogotlp.ExportRequest{}, err
+	}
+	dicts.mu.Lock()
+	defer dicts.mu.Unlock()
+	if err := applyInlineDictionary(ctx, dicts.logs, body_); err != nil {
+		return plogotlp.ExportRequest{}, err
+	}
+	dict, err := dicts.logs.lookup(ctx, body_.Uuid, version)
+	if err != nil {
+		return plogotlp.ExportRequest{}, err
+	}
+	ld, err := plogotlp.UnmarshalWithTraceZip(dict, body_.Data)
+	if err != nil {
+		return plogotlp.ExportRequest{}, err
+	}
+	return plogotlp.NewExportRequestFromLogs(ld), nil
+}
+
 func readContentType(resp http.ResponseWriter, req *http.Request) (encoder, bool) {
 	if req.Method != http.MethodPost {
 		handleUnmatchedMethod(resp)
@@ -587,56 +414,188 @@ func handleUnmatchedContentType(resp http.ResponseWriter) {
 	writeResponse(resp, "text/plain", status, []byte(fmt.Sprintf("%v unsupported media type, supported: [%s, %s]", status, jsonContentType, pbContentType)))
 }
 
-func handleTracesDictionary(resp http.ResponseWriter, req *http.Request) {
-	mu.Lock()
-	defer mu.Unlock()
-	_, ok := readContentType(resp, req)
+func handleTracesDictionary(resp http.ResponseWriter, req *http.Request, dicts *receiverDictionaries, telemetry *traceZipTelemetry) {
+	handleDictionary(resp, req, dicts.traces, telemetry)
+}
+
+// traceZipDictionary is the receiver side dictionary of any signal.
+type traceZipDictionary interface {
+	FullUpdate(data []json.RawMessage) error
+	IncrementUpdate(data []json.RawMessage) error
+	Size() int
+}
+
+// handleDictionary applies a dictionary request to the dictionary of dicts it names,
+// and answers with the version of the dictionary it has then. A request that does
+// not follow that version is answered with 409 Conflict, see
+// traceZipDictionaries.update.
+func handleDictionary[D traceZipDictionary](resp http.ResponseWriter, req *http.Request, dicts *traceZipDictionaries[D], telemetry *traceZipTelemetry) {
+	enc, ok := readContentType(resp, req)
 	if !ok {
 		return
 	}
-	var err error
-	var body []byte
+	body, err := readTraceZipBody(req)
+	var ack dictionaryAck
+	if err == nil {
+		ack, err = applyDictionaryRequest(req.Context(), dicts, body)
+	}
+	if err != nil {
+		writeTraceZipError(resp, req, enc, telemetry, dicts.signal, failureDictionary, err)
+		return
+	}
+	writeDictionaryAck(resp, http.StatusOK, ack)
+}
 
-	if req.Header.Get("Content-Encoding") == "gzip" {
-		var buf bytes.Buffer
-		_, err = io.Copy(&buf, req.Body)
-		if err != nil {
-			http.Error(resp, "Failed to read request body", http.StatusInternalServerError)
-			return
-		}
-		defer req.Body.Close()
+// applyDictionaryRequest applies the dictionary request in body to the dictionary
+// of dicts it names, and returns the version of the dictionary the receiver has
+// then.
+func applyDictionaryRequest[D traceZipDictionary](ctx context.Context, dicts *traceZipDictionaries[D], body []byte) (dictionaryAck, error) {
+	var request traceZipDictionaryRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return dictionaryAck{}, fmt.Errorf("invalid dictionary request: %w", err)
+	}
+	return decodeTraceZip(func() (dictionaryAck, error) {
+		dicts.all.mu.Lock()
+		defer dicts.all.mu.Unlock()
+		if err := dicts.update(ctx, request); err != nil {
+			return dictionaryAck{}, err
+		}
+		return dicts.ack(request.Uuid), nil
+	})
+}
 
-		gz, err := gzip.NewReader(&buf)
-		if err != nil {
-			http.Error(resp, "Failed to create gzip reader", http.StatusInternalServerError)
-			return
-		}
-		defer gz.Close()
+// applyInlineDictionary applies the dictionary request a TraceZip payload carries,
+// if it has one, to dicts. It must be called with dicts.all.mu held, and the payload decoded
+// before mu is released, so that no other request comes between the two.
+func applyInlineDictionary[D traceZipDictionary](ctx context.Context, dicts *traceZipDictionaries[D], body traceZipRequest) error {
+	if body.Type == "" {
+		return nil
+	}
+	return dicts.update(ctx, body.traceZipDictionaryRequest)
+}
 
-		body, err = io.ReadAll(gz)
-		if err != nil {
-			http.Error(resp, "Failed to read gzip body", http.StatusInternalServerError)
-			return
-		}
-	} else {
-		body, err = io.ReadAll(req.Body)
-		if err != nil {
-			http.Error(resp, "Failed to read request body", http.StatusInternalServerError)
-			return
-		}
-		defer req.Body.Close()
+// readDictionaryVersion returns the version of the dictionary a TraceZip payload
+// needs, 0.0 if it does not name one.
+// isTraceZipPayload tells whether req carries a TraceZip payload rather than an
+// OTLP request. Every TraceZip payload of logs or metrics names the version of its
+// dictionary, while exporters without logs_tracezip or metrics_tracezip send OTLP.
+func isTraceZipPayload(req *http.Request) bool {
+	return req.Header.Get(headerDictionaryVersion) != ""
+}
+
+func readDictionaryVersion(req *http.Request) (dictionaryVersion, error) {
+	var version dictionaryVersion
+	value := req.Header.Get(headerDictionaryVersion)
+	if value == "" {
+		return version, nil
 	}
-	body_ := make(map[string]interface{})
-	json.Unmarshal(body, &body_)
-	if Dictionary[body_["_"].(string)] == nil {
-		Dictionary[body_["_"].(string)] = &CompressionDictionary{}
+	if _, err := fmt.Sscanf(value, "%d.%d", &version.Epoch, &version.Version); err != nil {
+		return version, fmt.Errorf("invalid %s header %q", headerDictionaryVersion, value)
 	}
-	if body_["t"].(string) == "a" {
-		Dictionary[body_["_"].(string)].FullUpdate(body_["n"].([]interface{}))
-	} else if body_["t"].(string) == "i" {
-		Dictionary[body_["_"].(string)].IncrementUpdate(body_["n"].([]interface{}))
+	return version, nil
+}
+
+// writeDictionaryAck answers with the version of a dictionary the receiver has.
+func writeDictionaryAck(resp http.ResponseWriter, statusCode int, ack dictionaryAck) {
+	msg, err := json.Marshal(ack)
+	if err != nil {
+		writeResponse(resp, fallbackContentType, http.StatusInternalServerError, fallbackMsg)
+		return
+	}
+	writeResponse(resp, "application/json", statusCode, msg)
+}
+
+// writeTraceZipError answers a TraceZip payload or dictionary request of signal
+// that failed with err, and counts it under its reason, or reason if err has none.
+// A request that needs a version of the dictionary the receiver does not have is
+// answered with 409 Conflict and the version it has, other failures with an OTLP
+// status. A dictionary beyond its memory limit is answered with 413 Payload Too
+// Large, a new dictionary beyond the total limit with 503 Service Unavailable,
+// both with ResourceExhausted.
+func writeTraceZipError(resp http.ResponseWriter, req *http.Request, enc encoder, telemetry *traceZipTelemetry, signal string, reason string, err error) {
+	statusCode, s := traceZipFailure(req.Context(), telemetry, signal, reason, err)
+	var conflict *dictionaryConflictError
+	if errors.As(err, &conflict) {
+		writeDictionaryAck(resp, statusCode, conflict.ack)
+		return
+	}
+	writeStatusResponse(resp, enc, statusCode, s.Proto())
+}
+
+// traceZipFailure counts a TraceZip request of signal that failed with err under
+// its reason, or reason if err has none, and returns the HTTP status code and the
+// OTLP status the request is answered with.
+func traceZipFailure(ctx context.Context, telemetry *traceZipTelemetry, signal string, reason string, err error) (int, *status.Status) {
+	var conflict *dictionaryConflictError
+	var panicErr *decodePanicError
+	statusCode, code := http.StatusBadRequest, codes.InvalidArgument
+	switch {
+	case errors.As(err, &conflict):
+		reason, statusCode, code = failureConflict, http.StatusConflict, codes.Aborted
+	case errors.As(err, &panicErr):
+		reason = failurePanic
+		telemetry.logPanic(signal, panicErr)
+	case errors.Is(err, errReadBody):
+		reason = failureBody
+	case errors.Is(err, errDictionaryTooLarge):
+		reason, statusCode, code = failureTooLarge, http.StatusRequestEntityTooLarge, codes.ResourceExhausted
+	case errors.Is(err, errDictionaryCapacity):
+		reason, statusCode, code = failureCapacity, http.StatusServiceUnavailable, codes.ResourceExhausted
+	case errors.Is(err, errDictionaryStore):
+		reason, statusCode, code = failureStore, http.StatusInternalServerError, codes.Internal
+	}
+	telemetry.recordFailure(ctx, signal, reason)
+	return statusCode, status.New(code, err.Error())
+}
+
+// decodePanicError is the error of a decoder that panicked.
+type decodePanicError struct {
+	value any
+	stack []byte
+}
+
+func (e *decodePanicError) Error() string {
+	return fmt.Sprintf("malformed TraceZip request: %v", e.value)
+}
+
+// decodeTraceZip runs decode and turns a panic of it into an error. The decoders
+// validate their input, this is the last line of defense against a request that
+// would take the receiver down.
+func decodeTraceZip[R any](decode func() (R, error)) (result R, err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = &decodePanicError{value: value, stack: debug.Stack()}
+		}
+	}()
+	return decode()
+}
+
+// errReadBody is returned when the body of a TraceZip request cannot be read.
+var errReadBody = errors.New("failed to read request body")
+
+// readTraceZipBody reads the body of a TraceZip payload or dictionary request, which
+// the server decompressed by its Content-Encoding with the decoder of the
+// receiver's dictionaries. A body compressed with a zstd dictionary the receiver
+// does not have is a dictionaryConflictError without a version, upon which the
+// exporter sends all of its dictionary with the zstd one.
+func readTraceZipBody(req *http.Request) ([]byte, error) {
+	defer req.Body.Close()
+	body, err := io.ReadAll(req.Body)
+	if errors.Is(err, tracezipotlp.ErrUnknownDictionary) {
+		return nil, &dictionaryConflictError{reason: err.Error()}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errReadBody, err)
 	}
-	writeResponse(resp, "text/plain", http.StatusOK, []byte(`receive package`))
+	return body, nil
+}
+
+func handleLogsDictionary(resp http.ResponseWriter, req *http.Request, dicts *receiverDictionaries, telemetry *traceZipTelemetry) {
+	handleDictionary(resp, req, dicts.logs, telemetry)
+}
+
+func handleMetricsDictionary(resp http.ResponseWriter, req *http.Request, dicts *receiverDictionaries, telemetry *traceZipTelemetry) {
+	handleDictionary(resp, req, dicts.metrics, telemetry)
 }
 
 func sendPostRequest(url string, body []byte) {
@@ -0,0 +1,113 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package prefix_compressed_receiver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric"
+	noopmetric "go.opentelemetry.io/otel/metric/noop"
+
+	"go.opentelemetry.io/collector/pdata/tracezipotlp"
+)
+
+// failureCounter is a receiver_tracezip_failed_requests counter that keeps the
+// counts of each signal and reason.
+type failureCounter struct {
+	noopmetric.Int64Counter
+	mu     sync.Mutex
+	counts map[[2]string]int64
+}
+
+func (c *failureCounter) Add(_ context.Context, incr int64, options ...metric.AddOption) {
+	attrs := metric.NewAddConfig(options).Attributes()
+	signal, _ := attrs.Value("signal")
+	reason, _ := attrs.Value("reason")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[[2]string{signal.AsString(), reason.AsString()}] += incr
+}
+
+func (c *failureCounter) count(signal string, reason string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[[2]string{signal, reason}]
+}
+
+func TestTraceZipFailures(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.HTTP.DictionaryWait = 10 * time.Millisecond
+	r, _, url := newTestHTTPReceiver(t, cfg, nil)
+	counter := &failureCounter{counts: map[[2]string]int64{}}
+	r.telemetry.failedRequests = counter
+
+	for _, tt := range []struct {
+		name     string
+		header   http.Header
+		body     string
+		status   int
+		reason   string
+		response string
+	}{
+		{
+			name:     "malformed body",
+			body:     `{"_":"a","a":`,
+			status:   http.StatusBadRequest,
+			reason:   failureDecode,
+			response: `"code":3`,
+		},
+		{
+			name:     "malformed dictionary version",
+			header:   http.Header{headerDictionaryVersion: {"latest"}},
+			body:     `{"_":"a","a":[]}`,
+			status:   http.StatusBadRequest,
+			reason:   failureDecode,
+			response: `"code":3`,
+		},
+		{
+			name:   "unknown dictionary version",
+			header: http.Header{headerDictionaryVersion: {"1.5"}},
+			body:   `{"_":"a","a":[]}`,
+			status: http.StatusConflict,
+			reason: failureConflict,
+			// the ack names no version, the receiver has none of the dictionary
+			response: `"_":"a"`,
+		},
+		{
+			name:     "corrupt codec frame",
+			header:   http.Header{"Content-Encoding": {tracezipotlp.CodecZstd}},
+			body:     "\x28\xb5\x2f\xfd corrupt",
+			status:   http.StatusBadRequest,
+			reason:   failureBody,
+			response: `"code":3`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			before := counter.count("traces", tt.reason)
+			req, err := http.NewRequest(http.MethodPost, url+defaultTracesURLPath, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Contains(t, string(body), tt.response)
+			assert.Equal(t, before+1, counter.count("traces", tt.reason))
+		})
+	}
+}
@@ -6,13 +6,16 @@ package prefix_compressed_receiver // import "go.opentelemetry.io/collector/rece
 import (
 	"context"
 	"errors"
+	"io"
 	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 
+	dictionarystore "angrychow/otel/dictionary-store"
 	"angrychow/otel/prefix-compressed-receiver/internal/logs"
 	"angrychow/otel/prefix-compressed-receiver/internal/metrics"
 	"angrychow/otel/prefix-compressed-receiver/internal/trace"
@@ -23,6 +26,7 @@ import (
 	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
 	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
 	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"go.opentelemetry.io/collector/pdata/tracezipotlp"
 	"go.opentelemetry.io/collector/receiver"
 	"go.opentelemetry.io/collector/receiver/receiverhelper"
 )
@@ -41,7 +45,14 @@ type otlpReceiver struct {
 	obsrepGRPC *receiverhelper.ObsReport
 	obsrepHTTP *receiverhelper.ObsReport
 
-	settings *receiver.CreateSettings
+	settings     *receiver.CreateSettings
+	dictionaries *receiverDictionaries
+	telemetry    *traceZipTelemetry
+
+	// stopSaving and stopExpiring stop the maintenance of the dictionaries, nil
+	// if it does not run.
+	stopSaving   func()
+	stopExpiring func()
 }
 
 // newOtlpReceiver just creates the OpenTelemetry receiver services. It is the caller's
@@ -49,11 +60,12 @@ type otlpReceiver struct {
 // as the various Stop*Reception methods to end it.
 func newOtlpReceiver(cfg *Config, set *receiver.CreateSettings) (*otlpReceiver, error) {
 	r := &otlpReceiver{
-		cfg:         cfg,
-		nextTraces:  nil,
-		nextMetrics: nil,
-		nextLogs:    nil,
-		settings:    set,
+		cfg:          cfg,
+		nextTraces:   nil,
+		nextMetrics:  nil,
+		nextLogs:     nil,
+		settings:     set,
+		dictionaries: newReceiverDictionaries(),
 	}
 
 	var err error
@@ -73,6 +85,9 @@ func newOtlpReceiver(cfg *Config, set *receiver.CreateSettings) (*otlpReceiver,
 	if err != nil {
 		return nil, err
 	}
+	if r.telemetry, err = newTraceZipTelemetry(set.TelemetrySettings, set.ID, r.dictionaries); err != nil {
+		return nil, err
+	}
 
 	return r, nil
 }
@@ -100,6 +115,10 @@ func (r *otlpReceiver) startGRPCServer(host component.Host) error {
 		plogotlp.RegisterGRPCServer(r.serverGRPC, logs.New(r.nextLogs, r.obsrepGRPC))
 	}
 
+	if r.cfg.HTTP == nil || !r.cfg.HTTP.NoTraceZip {
+		tracezipotlp.RegisterGRPCServer(r.serverGRPC, r.newTraceZipStreamServer())
+	}
+
 	r.settings.Logger.Info("Starting GRPC server", zap.String("endpoint", r.cfg.GRPC.NetAddr.Endpoint))
 	var gln net.Listener
 	if gln, err = r.cfg.GRPC.NetAddr.Listen(context.Background()); err != nil {
@@ -117,7 +136,24 @@ func (r *otlpReceiver) startGRPCServer(host component.Host) error {
 	return nil
 }
 
-var mu sync.RWMutex
+// newTraceZipStreamServer returns the server of the TraceZip streams of the
+// signals the receiver has a consumer for.
+func (r *otlpReceiver) newTraceZipStreamServer() *traceZipStreamServer {
+	srv := &traceZipStreamServer{dicts: r.dictionaries, telemetry: r.telemetry}
+	if r.nextTraces != nil {
+		srv.traces = trace.New(r.nextTraces, r.obsrepGRPC)
+	}
+	if r.nextMetrics != nil {
+		srv.metrics = metrics.New(r.nextMetrics, r.obsrepGRPC)
+	}
+	if r.nextLogs != nil {
+		srv.logs = logs.New(r.nextLogs, r.obsrepGRPC)
+	}
+	if r.cfg.HTTP != nil {
+		srv.exportSpans = r.cfg.HTTP.ExportSpans
+	}
+	return srv
+}
 
 func (r *otlpReceiver) startHTTPServer(host component.Host) error {
 	// If HTTP is not enabled, nothing to start.
@@ -129,29 +165,44 @@ func (r *otlpReceiver) startHTTPServer(host component.Host) error {
 	if r.nextTraces != nil {
 		httpTracesReceiver := trace.New(r.nextTraces, r.obsrepHTTP)
 		httpMux.HandleFunc(r.cfg.HTTP.TracesURLPath, func(resp http.ResponseWriter, req *http.Request) {
-			handleTraces(resp, req, httpTracesReceiver, r.cfg.HTTP.ExportSpans, r.cfg.HTTP.NoTraceZip)
+			handleTraces(resp, req, httpTracesReceiver, r.cfg.HTTP.ExportSpans, r.cfg.HTTP.NoTraceZip, r.dictionaries, r.telemetry)
 		})
 		httpMux.HandleFunc(r.cfg.HTTP.TracesDictionaryURLPath, func(resp http.ResponseWriter, req *http.Request) {
-			handleTracesDictionary(resp, req)
+			handleTracesDictionary(resp, req, r.dictionaries, r.telemetry)
 		})
 	}
 
 	if r.nextMetrics != nil {
 		httpMetricsReceiver := metrics.New(r.nextMetrics, r.obsrepHTTP)
 		httpMux.HandleFunc(r.cfg.HTTP.MetricsURLPath, func(resp http.ResponseWriter, req *http.Request) {
-			handleMetrics(resp, req, httpMetricsReceiver)
+			handleMetrics(resp, req, httpMetricsReceiver, r.cfg.HTTP.NoTraceZip, r.dictionaries, r.telemetry)
+		})
+		httpMux.HandleFunc(r.cfg.HTTP.MetricsDictionaryURLPath, func(resp http.ResponseWriter, req *http.Request) {
+			handleMetricsDictionary(resp, req, r.dictionaries, r.telemetry)
 		})
 	}
 
 	if r.nextLogs != nil {
 		httpLogsReceiver := logs.New(r.nextLogs, r.obsrepHTTP)
 		httpMux.HandleFunc(r.cfg.HTTP.LogsURLPath, func(resp http.ResponseWriter, req *http.Request) {
-			handleLogs(resp, req, httpLogsReceiver)
+			handleLogs(resp, req, httpLogsReceiver, r.cfg.HTTP.NoTraceZip, r.dictionaries, r.telemetry)
+		})
+		httpMux.HandleFunc(r.cfg.HTTP.LogsDictionaryURLPath, func(resp http.ResponseWriter, req *http.Request) {
+			handleLogsDictionary(resp, req, r.dictionaries, r.telemetry)
 		})
 	}
 
+	// The bodies of all codecs of the exporter are decompressed by the decoder of the
+	// dictionaries, which has their zstd dictionaries.
+	options := []confighttp.ToServerOption{confighttp.WithErrorHandler(errorHandler)}
+	for _, codec := range []string{tracezipotlp.CodecGzip, tracezipotlp.CodecZstd, tracezipotlp.CodecSnappy, tracezipotlp.CodecLz4} {
+		codec := codec
+		options = append(options, confighttp.WithDecoder(codec, func(body io.ReadCloser) (io.ReadCloser, error) {
+			return r.dictionaries.decoder.NewReader(codec, body), nil
+		}))
+	}
 	var err error
-	if r.serverHTTP, err = r.cfg.HTTP.ToServer(host, r.settings.TelemetrySettings, httpMux, confighttp.WithErrorHandler(errorHandler)); err != nil {
+	if r.serverHTTP, err = r.cfg.HTTP.ToServer(host, r.settings.TelemetrySettings, httpMux, options...); err != nil {
 		return err
 	}
 
@@ -175,9 +226,12 @@ func (r *otlpReceiver) startHTTPServer(host component.Host) error {
 // Start runs the trace receiver on the gRPC server. Currently
 // it also enables the metrics receiver too.
 func (r *otlpReceiver) Start(ctx context.Context, host component.Host) error {
-	if err := r.startGRPCServer(host); err != nil {
+	if err := r.startDictionaries(ctx, host); err != nil {
 		return err
 	}
+	if err := r.startGRPCServer(host); err != nil {
+		return errors.Join(err, r.stopDictionaryMaintenance(ctx))
+	}
 	if err := r.startHTTPServer(host); err != nil {
 		// It's possible that a valid GRPC server configuration was specified,
 		// but an invalid HTTP configuration. If that's the case, the successfully
@@ -201,7 +255,74 @@ func (r *otlpReceiver) Shutdown(ctx context.Context) error {
 	}
 
 	r.shutdownWG.Wait()
-	return err
+	return errors.Join(err, r.stopDictionaryMaintenance(ctx), r.telemetry.shutdown())
+}
+
+// startDictionaries sets the limits of the TraceZip dictionaries and opens the
+// store they are saved in. It then saves them every dictionary_save_interval and
+// drops the ones idle for dictionary_ttl. The dictionaries are loaded from the
+// store when a request names them.
+func (r *otlpReceiver) startDictionaries(ctx context.Context, host component.Host) error {
+	if r.cfg.HTTP == nil || r.cfg.HTTP.NoTraceZip {
+		return nil
+	}
+	store, err := dictionarystore.New(ctx, host, component.KindReceiver, r.settings.ID, r.cfg.HTTP.DictionaryDirectory, r.cfg.HTTP.DictionaryStorage)
+	if err != nil {
+		return err
+	}
+	r.dictionaries.mu.Lock()
+	r.dictionaries.store = store
+	r.dictionaries.limits = dictionaryLimits{
+		ttl:      r.cfg.HTTP.DictionaryTTL,
+		maxSize:  r.cfg.HTTP.DictionaryMemoryLimit,
+		maxTotal: r.cfg.HTTP.TotalDictionaryMemoryLimit,
+		wait:     r.cfg.HTTP.DictionaryWait,
+	}
+	r.dictionaries.mu.Unlock()
+
+	if store != nil && r.cfg.HTTP.DictionarySaveInterval > 0 {
+		r.stopSaving = dictionarystore.RunEvery(r.cfg.HTTP.DictionarySaveInterval, func(time.Time) {
+			if err := r.dictionaries.save(context.Background()); err != nil {
+				r.settings.Logger.Warn("Failed to save the TraceZip dictionaries", zap.Error(err))
+			}
+		})
+	}
+	if r.cfg.HTTP.DictionaryTTL > 0 {
+		// check often enough that a dictionary outlives its TTL by a tenth at most
+		r.stopExpiring = dictionarystore.RunEvery(max(r.cfg.HTTP.DictionaryTTL/10, time.Second), func(now time.Time) {
+			r.dictionaries.mu.Lock()
+			err := r.dictionaries.expire(context.Background(), now)
+			r.dictionaries.mu.Unlock()
+			if err != nil {
+				r.settings.Logger.Warn("Failed to drop expired TraceZip dictionaries", zap.Error(err))
+			}
+		})
+	}
+	return nil
+}
+
+// stopDictionaryMaintenance stops saving and expiring the TraceZip dictionaries,
+// saves them a last time and closes their store.
+func (r *otlpReceiver) stopDictionaryMaintenance(ctx context.Context) error {
+	if r.stopSaving != nil {
+		r.stopSaving()
+		r.stopSaving = nil
+	}
+	if r.stopExpiring != nil {
+		r.stopExpiring()
+		r.stopExpiring = nil
+	}
+	r.dictionaries.mu.Lock()
+	store := r.dictionaries.store
+	r.dictionaries.mu.Unlock()
+	if store == nil {
+		return nil
+	}
+	err := r.dictionaries.save(ctx)
+	r.dictionaries.mu.Lock()
+	r.dictionaries.store = nil
+	r.dictionaries.mu.Unlock()
+	return errors.Join(err, store.Close(ctx))
 }
 
 func (r *otlpReceiver) registerTraceConsumer(tc consumer.Traces) {
@@ -0,0 +1,136 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package prefix_compressed_receiver // import "go.opentelemetry.io/collector/receiver/otlpreceiver"
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+
+	"angrychow/otel/prefix-compressed-receiver/internal/metadata"
+
+	"go.opentelemetry.io/collector/component"
+)
+
+// Reasons a TraceZip request fails, the reason attribute of receiver_tracezip_failed_requests.
+const (
+	// the body could not be read or decompressed
+	failureBody = "body"
+	// the payload could not be decoded
+	failureDecode = "decode"
+	// the dictionary request could not be applied
+	failureDictionary = "dictionary"
+	// the payload or the dictionary update needs a version the receiver does not have
+	failureConflict = "conflict"
+	// the dictionary could not be loaded from or saved to its store
+	failureStore = "store"
+	// the dictionary update exceeds dictionary_memory_limit
+	failureTooLarge = "too_large"
+	// a new dictionary exceeds total_dictionary_memory_limit
+	failureCapacity = "capacity"
+	// decoding panicked, which is a bug
+	failurePanic = "panic"
+)
+
+// traceZipTelemetry are the metrics and logs of the TraceZip requests of a receiver.
+type traceZipTelemetry struct {
+	logger         *zap.Logger
+	failedRequests metric.Int64Counter
+	receiverID     attribute.KeyValue
+	// observed reports the dictionaries until the receiver shuts down
+	observed metric.Registration
+}
+
+func newTraceZipTelemetry(settings component.TelemetrySettings, id component.ID, dicts *receiverDictionaries) (*traceZipTelemetry, error) {
+	meter := metadata.Meter(settings)
+	failedRequests, err := meter.Int64Counter(
+		"receiver_tracezip_failed_requests",
+		metric.WithDescription("Number of TraceZip payloads and dictionary requests the receiver refused."),
+		metric.WithUnit("{requests}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	t := &traceZipTelemetry{
+		logger:         settings.Logger,
+		failedRequests: failedRequests,
+		receiverID:     attribute.String("receiver", id.String()),
+	}
+	if err = t.observeDictionaries(meter, dicts); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// observeDictionaries reports the number, the size and the expirations of the
+// dictionaries of each signal.
+func (t *traceZipTelemetry) observeDictionaries(meter metric.Meter, dicts *receiverDictionaries) error {
+	count, err := meter.Int64ObservableGauge(
+		"receiver_tracezip_dictionaries",
+		metric.WithDescription("Number of TraceZip dictionaries the receiver holds in memory."),
+		metric.WithUnit("{dictionaries}"),
+	)
+	if err != nil {
+		return err
+	}
+	size, err := meter.Int64ObservableGauge(
+		"receiver_tracezip_dictionary_size",
+		metric.WithDescription("Estimated bytes the TraceZip dictionaries of the receiver take."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return err
+	}
+	expired, err := meter.Int64ObservableCounter(
+		"receiver_tracezip_expired_dictionaries",
+		metric.WithDescription("Number of TraceZip dictionaries dropped after dictionary_ttl."),
+		metric.WithUnit("{dictionaries}"),
+	)
+	if err != nil {
+		return err
+	}
+	t.observed, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		dicts.mu.Lock()
+		defer dicts.mu.Unlock()
+		observe := func(signal string, n int, bytes int, expirations int64) {
+			attrs := metric.WithAttributes(t.receiverID, attribute.String("signal", signal))
+			o.ObserveInt64(count, int64(n), attrs)
+			o.ObserveInt64(size, int64(bytes), attrs)
+			o.ObserveInt64(expired, expirations, attrs)
+		}
+		observe(dicts.traces.signal, len(dicts.traces.dicts), dicts.traces.size, dicts.traces.expired)
+		observe(dicts.logs.signal, len(dicts.logs.dicts), dicts.logs.size, dicts.logs.expired)
+		observe(dicts.metrics.signal, len(dicts.metrics.dicts), dicts.metrics.size, dicts.metrics.expired)
+		return nil
+	}, count, size, expired)
+	return err
+}
+
+// recordFailure counts a failed request of signal.
+func (t *traceZipTelemetry) recordFailure(ctx context.Context, signal string, reason string) {
+	if t == nil {
+		return
+	}
+	t.failedRequests.Add(ctx, 1, metric.WithAttributes(t.receiverID, attribute.String("signal", signal), attribute.String("reason", reason)))
+}
+
+// logPanic logs a decoder panic of signal, which is a bug.
+func (t *traceZipTelemetry) logPanic(signal string, err *decodePanicError) {
+	if t == nil {
+		return
+	}
+	t.logger.Error("TraceZip decoding panicked", zap.String("signal", signal), zap.Any("panic", err.value), zap.ByteString("stack", err.stack))
+}
+
+// shutdown stops reporting the dictionaries.
+func (t *traceZipTelemetry) shutdown() error {
+	if t == nil || t.observed == nil {
+		return nil
+	}
+	err := t.observed.Unregister()
+	t.observed = nil
+	return err
+}
@@ -0,0 +1,337 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package prefix_compressed_receiver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	noopmetric "go.opentelemetry.io/otel/metric/noop"
+	nooptrace "go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+
+	prefix_compressed_exporter "angrychow/otel/prefix-compressed-exporter"
+	"angrychow/otel/prefix-compressed-receiver/internal/metadata"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/exporter"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/receiver"
+)
+
+// host is a component.Host without extensions.
+type host struct {
+	component.Host
+}
+
+func (host) GetExtensions() map[component.ID]component.Component {
+	return nil
+}
+
+func newTestTelemetrySettings() component.TelemetrySettings {
+	return component.TelemetrySettings{
+		Logger:         zap.NewNop(),
+		TracerProvider: nooptrace.NewTracerProvider(),
+		MeterProvider:  noopmetric.NewMeterProvider(),
+		ReportStatus:   func(*component.StatusEvent) {},
+	}
+}
+
+// testSinks are the consumers of the signals of a test receiver.
+type testSinks struct {
+	traces  *consumertest.TracesSink
+	logs    *consumertest.LogsSink
+	metrics *consumertest.MetricsSink
+}
+
+// newTestReceiver returns a started receiver of cfg, whose signals go to the
+// returned sinks.
+func newTestReceiver(t *testing.T, cfg *Config) (*otlpReceiver, *testSinks) {
+	set := &receiver.CreateSettings{
+		ID:                component.NewID(metadata.Type),
+		TelemetrySettings: newTestTelemetrySettings(),
+		BuildInfo:         component.NewDefaultBuildInfo(),
+	}
+	r, err := newOtlpReceiver(cfg, set)
+	require.NoError(t, err)
+	sinks := &testSinks{
+		traces:  new(consumertest.TracesSink),
+		logs:    new(consumertest.LogsSink),
+		metrics: new(consumertest.MetricsSink),
+	}
+	r.registerTraceConsumer(sinks.traces)
+	r.registerLogsConsumer(sinks.logs)
+	r.registerMetricsConsumer(sinks.metrics)
+	require.NoError(t, r.Start(context.Background(), host{}))
+	t.Cleanup(func() {
+		assert.NoError(t, r.Shutdown(context.Background()))
+	})
+	return r, sinks
+}
+
+// newTestHTTPReceiver returns a started receiver of cfg without gRPC and the URL
+// of an httptest server of its HTTP handler. intercept, if not nil, sees the
+// requests first and answers them itself when it returns true.
+func newTestHTTPReceiver(t *testing.T, cfg *Config, intercept func(w http.ResponseWriter, req *http.Request) bool) (*otlpReceiver, *testSinks, string) {
+	cfg.GRPC = nil
+	cfg.HTTP.Endpoint = "127.0.0.1:0"
+	r, sinks := newTestReceiver(t, cfg)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if intercept != nil && intercept(w, req) {
+			return
+		}
+		r.serverHTTP.Handler.ServeHTTP(w, req)
+	}))
+	t.Cleanup(srv.Close)
+	return r, sinks, srv.URL
+}
+
+// newTestExporterConfig returns the config of a TraceZip exporter of the exporter
+// module, which fails the batches it could not send at once, and retries the
+// others within milliseconds, and the factory and settings to create it with.
+// configure, if not nil, changes the config.
+func newTestExporterConfig(endpoint string, configure func(cfg *prefix_compressed_exporter.Config)) (exporter.Factory, exporter.CreateSettings, *prefix_compressed_exporter.Config) {
+	factory := prefix_compressed_exporter.NewFactory()
+	cfg := factory.CreateDefaultConfig().(*prefix_compressed_exporter.Config)
+	cfg.Endpoint = endpoint
+	cfg.DeleteResource = false
+	cfg.QueueConfig.Enabled = false
+	cfg.RetryConfig.InitialInterval = time.Millisecond
+	cfg.RetryConfig.MaxInterval = 10 * time.Millisecond
+	cfg.RetryConfig.MaxElapsedTime = 10 * time.Second
+	if configure != nil {
+		configure(cfg)
+	}
+	set := exporter.CreateSettings{
+		ID:                component.NewID(factory.Type()),
+		TelemetrySettings: newTestTelemetrySettings(),
+		BuildInfo:         component.NewDefaultBuildInfo(),
+	}
+	return factory, set, cfg
+}
+
+// startTestExporter starts exp, created with err, and shuts it down at the end of
+// the test.
+func startTestExporter[T component.Component](t *testing.T, exp T, err error) T {
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), host{}))
+	t.Cleanup(func() {
+		assert.NoError(t, exp.Shutdown(context.Background()))
+	})
+	return exp
+}
+
+// newTestExporter returns a started traces exporter of newTestExporterConfig.
+func newTestExporter(t *testing.T, endpoint string, configure func(cfg *prefix_compressed_exporter.Config)) exporter.Traces {
+	factory, set, cfg := newTestExporterConfig(endpoint, configure)
+	exp, err := factory.CreateTracesExporter(context.Background(), set, cfg)
+	return startTestExporter(t, exp, err)
+}
+
+// newTestLogsExporter returns a started logs exporter of newTestExporterConfig.
+func newTestLogsExporter(t *testing.T, endpoint string, configure func(cfg *prefix_compressed_exporter.Config)) exporter.Logs {
+	factory, set, cfg := newTestExporterConfig(endpoint, configure)
+	exp, err := factory.CreateLogsExporter(context.Background(), set, cfg)
+	return startTestExporter(t, exp, err)
+}
+
+// newTestMetricsExporter returns a started metrics exporter of newTestExporterConfig.
+func newTestMetricsExporter(t *testing.T, endpoint string, configure func(cfg *prefix_compressed_exporter.Config)) exporter.Metrics {
+	factory, set, cfg := newTestExporterConfig(endpoint, configure)
+	exp, err := factory.CreateMetricsExporter(context.Background(), set, cfg)
+	return startTestExporter(t, exp, err)
+}
+
+// newTestTraces returns batch i, a span whose name the dictionary does not have
+// before it.
+func newTestTraces(i int) ptrace.Traces {
+	td := ptrace.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().PutStr("service.name", "checkout")
+	span := rs.ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetTraceID(pcommon.TraceID{1, byte(i)})
+	span.SetSpanID(pcommon.SpanID{1, byte(i)})
+	span.SetName(fmt.Sprintf("GET /order/%d", i))
+	span.SetStartTimestamp(pcommon.Timestamp(1700000000000000000 + i*1000))
+	span.SetEndTimestamp(pcommon.Timestamp(1700000000000000000 + i*1000 + 500))
+	span.Attributes().PutStr("http.method", "GET")
+	return td
+}
+
+// spanNames returns the names of the spans sink received.
+func spanNames(sink *consumertest.TracesSink) []string {
+	var names []string
+	for _, td := range sink.AllTraces() {
+		for i := 0; i < td.ResourceSpans().Len(); i++ {
+			for j := 0; j < td.ResourceSpans().At(i).ScopeSpans().Len(); j++ {
+				spans := td.ResourceSpans().At(i).ScopeSpans().At(j).Spans()
+				for k := 0; k < spans.Len(); k++ {
+					names = append(names, spans.At(k).Name())
+				}
+			}
+		}
+	}
+	return names
+}
+
+// tracesDictionary returns the uuid and the version of the only traces
+// dictionary of r.
+func tracesDictionary(t *testing.T, r *otlpReceiver) (string, dictionaryVersion) {
+	r.dictionaries.mu.Lock()
+	defer r.dictionaries.mu.Unlock()
+	require.Len(t, r.dictionaries.traces.versions, 1)
+	for uuid, version := range r.dictionaries.traces.versions {
+		return uuid, version
+	}
+	return "", dictionaryVersion{}
+}
+
+// dropTracesDictionary makes r lose the traces dictionary of uuid, like a
+// receiver that restarted without a store.
+func dropTracesDictionary(t *testing.T, r *otlpReceiver, uuid string) {
+	r.dictionaries.mu.Lock()
+	defer r.dictionaries.mu.Unlock()
+	require.NoError(t, r.dictionaries.traces.drop(context.Background(), uuid))
+}
+
+func TestTraceZipConflictRecovery(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.HTTP.DictionaryWait = 10 * time.Millisecond
+	var failDictionary atomic.Bool
+	var dictionaryRequests atomic.Int32
+	r, sinks, url := newTestHTTPReceiver(t, cfg, func(w http.ResponseWriter, req *http.Request) bool {
+		if req.URL.Path != defaultTracesDictionaryURLPath {
+			return false
+		}
+		dictionaryRequests.Add(1)
+		if failDictionary.CompareAndSwap(true, false) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	})
+	// trace IDs stay in hex, so that a batch compressed again has no update
+	exp := newTestExporter(t, url, func(cfg *prefix_compressed_exporter.Config) {
+		cfg.TraceIdWindow = 0
+	})
+
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(0)))
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(1)))
+	uuid, before := tracesDictionary(t, r)
+
+	// The update of batch 2 fails, and its retry, which has no update, gets a 409
+	// with the version before it. The exporter sends the update again from its
+	// history and compresses the batch again.
+	failDictionary.Store(true)
+	dictionaryRequests.Store(0)
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(2)))
+	_, after := tracesDictionary(t, r)
+	assert.Equal(t, before.Epoch, after.Epoch)
+	assert.Equal(t, before.Version+1, after.Version)
+	assert.EqualValues(t, 2, dictionaryRequests.Load())
+
+	// A receiver without the dictionary answers the update of batch 3 with a 409
+	// and no version. The exporter sends all of the dictionary under a new epoch.
+	dropTracesDictionary(t, r, uuid)
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(3)))
+	_, resynced := tracesDictionary(t, r)
+	assert.Greater(t, resynced.Epoch, after.Epoch)
+
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(4)))
+	assert.Equal(t, []string{"GET /order/0", "GET /order/1", "GET /order/2", "GET /order/3", "GET /order/4"}, spanNames(sinks.traces))
+}
+
+func TestTraceZipOutOfOrderBatches(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	// the second update is parked until the first one comes
+	cfg.HTTP.DictionaryWait = 10 * time.Second
+	var dictionaryRequests atomic.Int32
+	blocked, overtaken, release := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	r, sinks, url := newTestHTTPReceiver(t, cfg, func(_ http.ResponseWriter, req *http.Request) bool {
+		if req.URL.Path != defaultTracesDictionaryURLPath {
+			return false
+		}
+		switch dictionaryRequests.Add(1) {
+		case 2:
+			// the update of batch 1 is held back until the one of batch 2 came
+			close(blocked)
+			<-release
+		case 3:
+			close(overtaken)
+		}
+		return false
+	})
+	exp := newTestExporter(t, url, nil)
+
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(0)))
+	_, before := tracesDictionary(t, r)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(1)))
+	}()
+	<-blocked
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(2)))
+	}()
+	<-overtaken
+	close(release)
+	wg.Wait()
+
+	// both updates were applied in order, without a recovery
+	_, after := tracesDictionary(t, r)
+	assert.Equal(t, dictionaryVersion{Epoch: before.Epoch, Version: before.Version + 2}, after)
+	assert.EqualValues(t, 3, dictionaryRequests.Load())
+	assert.ElementsMatch(t, []string{"GET /order/0", "GET /order/1", "GET /order/2"}, spanNames(sinks.traces))
+}
+
+func TestTraceZipInlineDictionary(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.HTTP.DictionaryWait = 10 * time.Millisecond
+	var dictionaryRequests atomic.Int32
+	r, sinks, url := newTestHTTPReceiver(t, cfg, func(_ http.ResponseWriter, req *http.Request) bool {
+		if req.URL.Path == defaultTracesDictionaryURLPath {
+			dictionaryRequests.Add(1)
+		}
+		return false
+	})
+	exp := newTestExporter(t, url, func(cfg *prefix_compressed_exporter.Config) {
+		cfg.InlineDictionary = true
+	})
+
+	// the updates go in the payloads
+	for i := 0; i < 3; i++ {
+		require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(i)))
+	}
+	uuid, before := tracesDictionary(t, r)
+	assert.Zero(t, dictionaryRequests.Load())
+
+	// A receiver without the dictionary answers the payload of batch 3 with a 409.
+	// The exporter sends all of the dictionary to the dictionary URL under a new
+	// epoch, and compresses the batch again.
+	dropTracesDictionary(t, r, uuid)
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(3)))
+	_, resynced := tracesDictionary(t, r)
+	assert.Greater(t, resynced.Epoch, before.Epoch)
+	assert.EqualValues(t, 1, dictionaryRequests.Load())
+
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(4)))
+	_, after := tracesDictionary(t, r)
+	assert.Equal(t, dictionaryVersion{Epoch: resynced.Epoch, Version: resynced.Version + 1}, after)
+	assert.EqualValues(t, 1, dictionaryRequests.Load())
+	assert.Equal(t, []string{"GET /order/0", "GET /order/1", "GET /order/2", "GET /order/3", "GET /order/4"}, spanNames(sinks.traces))
+}
@@ -0,0 +1,195 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package prefix_compressed_receiver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	prefix_compressed_exporter "angrychow/otel/prefix-compressed-exporter"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// newTestLogs returns batch i, log records of two scopes and severities, with
+// bodies and attributes repeated across the batches and some that are not.
+func newTestLogs(i int) plog.Logs {
+	ld := plog.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().PutStr("service.name", "checkout")
+	for s, scope := range []string{"http", "db"} {
+		sl := rl.ScopeLogs().AppendEmpty()
+		sl.Scope().SetName(scope)
+		sl.Scope().SetVersion("1.0.0")
+		for j, severity := range []plog.SeverityNumber{plog.SeverityNumberInfo, plog.SeverityNumberError} {
+			lr := sl.LogRecords().AppendEmpty()
+			lr.SetTimestamp(pcommon.Timestamp(1700000000000000000 + i*1000 + s*100 + j))
+			lr.SetObservedTimestamp(pcommon.Timestamp(1700000000000000000 + i*1000 + s*100 + j + 7))
+			lr.SetSeverityNumber(severity)
+			lr.SetSeverityText(severity.String())
+			lr.SetTraceID(pcommon.TraceID{2, byte(i)})
+			lr.SetSpanID(pcommon.SpanID{2, byte(s), byte(j)})
+			if j == 0 {
+				lr.Body().SetStr(fmt.Sprintf("%s request done", scope))
+			} else {
+				lr.Body().SetStr(fmt.Sprintf("%s request %d failed", scope, i))
+			}
+			// in key order, the order of the attributes TraceZip decodes
+			lr.Attributes().PutInt("attempt", int64(j))
+			lr.Attributes().PutStr("order.id", fmt.Sprintf("order-%d", i))
+		}
+	}
+	return ld
+}
+
+// logsJSON returns the OTLP JSON of each of logs, to compare them.
+func logsJSON(t *testing.T, logs ...plog.Logs) []string {
+	var marshaler plog.JSONMarshaler
+	var batches []string
+	for _, ld := range logs {
+		buf, err := marshaler.MarshalLogs(ld)
+		require.NoError(t, err)
+		batches = append(batches, string(buf))
+	}
+	return batches
+}
+
+func TestTraceZipLogs(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		tracezip bool
+	}{
+		{name: "tracezip", tracezip: true},
+		{name: "otlp"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var dictionaryRequests atomic.Int32
+			_, sinks, url := newTestHTTPReceiver(t, createDefaultConfig().(*Config), func(_ http.ResponseWriter, req *http.Request) bool {
+				if req.URL.Path == defaultLogsDictionaryURLPath {
+					dictionaryRequests.Add(1)
+				}
+				return false
+			})
+			exp := newTestLogsExporter(t, url, func(cfg *prefix_compressed_exporter.Config) {
+				cfg.LogsTraceZip = tt.tracezip
+			})
+
+			for i := 0; i < 3; i++ {
+				require.NoError(t, exp.ConsumeLogs(context.Background(), newTestLogs(i)))
+			}
+			assert.Equal(t, logsJSON(t, newTestLogs(0), newTestLogs(1), newTestLogs(2)), logsJSON(t, sinks.logs.AllLogs()...))
+			if tt.tracezip {
+				assert.Positive(t, dictionaryRequests.Load())
+			} else {
+				assert.Zero(t, dictionaryRequests.Load())
+			}
+		})
+	}
+}
+
+// newTestMetrics returns batch i, a gauge, a sum and a histogram whose data point
+// timestamps go up irregularly, repeat, and go back down, for the delta-of-delta
+// timestamps.
+func newTestMetrics(i int) pmetric.Metrics {
+	base := 1700000000000000000 + i*60000000000
+	offsets := []int{0, 1000, 1000, 1000, 7, 5000000000, 5000000000, 3}
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("service.name", "checkout")
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName("http")
+
+	gauge := sm.Metrics().AppendEmpty()
+	gauge.SetName("http.server.active_requests")
+	gauge.SetUnit("{request}")
+	gauge.SetEmptyGauge()
+	for j, offset := range offsets {
+		dp := gauge.Gauge().DataPoints().AppendEmpty()
+		dp.SetTimestamp(pcommon.Timestamp(base + offset))
+		dp.SetIntValue(int64(i*10 + j))
+		dp.Attributes().PutStr("http.route", fmt.Sprintf("/order/%d", j%3))
+	}
+
+	sum := sm.Metrics().AppendEmpty()
+	sum.SetName("http.server.requests")
+	sum.SetDescription("requests served")
+	sum.SetEmptySum().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	sum.Sum().SetIsMonotonic(true)
+	for j, offset := range offsets {
+		dp := sum.Sum().DataPoints().AppendEmpty()
+		// all the points of a series share a start time
+		dp.SetStartTimestamp(pcommon.Timestamp(1700000000000000000))
+		dp.SetTimestamp(pcommon.Timestamp(base + offset))
+		dp.SetDoubleValue(float64(i*100+j) + 0.5)
+		dp.Attributes().PutStr("http.route", fmt.Sprintf("/order/%d", j%3))
+	}
+
+	histogram := sm.Metrics().AppendEmpty()
+	histogram.SetName("http.server.duration")
+	histogram.SetUnit("ms")
+	histogram.SetEmptyHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	for j, offset := range offsets[:4] {
+		dp := histogram.Histogram().DataPoints().AppendEmpty()
+		dp.SetStartTimestamp(pcommon.Timestamp(base + offset - 1000))
+		dp.SetTimestamp(pcommon.Timestamp(base + offset))
+		dp.ExplicitBounds().FromRaw([]float64{5, 10, 100})
+		dp.BucketCounts().FromRaw([]uint64{uint64(j), 1, 2, 0})
+		dp.SetCount(uint64(j) + 3)
+		dp.SetSum(float64(j) * 12.5)
+	}
+	return md
+}
+
+// metricsJSON returns the OTLP JSON of each of metrics, to compare them.
+func metricsJSON(t *testing.T, metrics ...pmetric.Metrics) []string {
+	var marshaler pmetric.JSONMarshaler
+	var batches []string
+	for _, md := range metrics {
+		buf, err := marshaler.MarshalMetrics(md)
+		require.NoError(t, err)
+		batches = append(batches, string(buf))
+	}
+	return batches
+}
+
+func TestTraceZipMetrics(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		tracezip bool
+	}{
+		{name: "tracezip", tracezip: true},
+		{name: "otlp"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var dictionaryRequests atomic.Int32
+			_, sinks, url := newTestHTTPReceiver(t, createDefaultConfig().(*Config), func(_ http.ResponseWriter, req *http.Request) bool {
+				if req.URL.Path == defaultMetricsDictionaryURLPath {
+					dictionaryRequests.Add(1)
+				}
+				return false
+			})
+			exp := newTestMetricsExporter(t, url, func(cfg *prefix_compressed_exporter.Config) {
+				cfg.MetricsTraceZip = tt.tracezip
+			})
+
+			for i := 0; i < 3; i++ {
+				require.NoError(t, exp.ConsumeMetrics(context.Background(), newTestMetrics(i)))
+			}
+			assert.Equal(t, metricsJSON(t, newTestMetrics(0), newTestMetrics(1), newTestMetrics(2)), metricsJSON(t, sinks.metrics.AllMetrics()...))
+			if tt.tracezip {
+				assert.Positive(t, dictionaryRequests.Load())
+			} else {
+				assert.Zero(t, dictionaryRequests.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package prefix_compressed_receiver // import "go.opentelemetry.io/collector/receiver/otlpreceiver"
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"angrychow/otel/prefix-compressed-receiver/internal/logs"
+	"angrychow/otel/prefix-compressed-receiver/internal/metrics"
+	"angrychow/otel/prefix-compressed-receiver/internal/trace"
+
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"go.opentelemetry.io/collector/pdata/tracezipotlp"
+)
+
+// traceZipStreamServer serves the TraceZip streams of the exporters. The requests
+// of a stream are handled one after the other, so that every payload finds the
+// dictionary updates sent before it applied without waiting for them, and each is answered with the
+// version of its dictionary the receiver has then. A signal without a consumer is
+// refused with Unimplemented.
+type traceZipStreamServer struct {
+	tracezipotlp.UnimplementedGRPCServer
+
+	traces      *trace.Receiver
+	metrics     *metrics.Receiver
+	logs        *logs.Receiver
+	exportSpans string
+	dicts       *receiverDictionaries
+	telemetry   *traceZipTelemetry
+}
+
+func (s *traceZipStreamServer) Stream(stream tracezipotlp.ServerStream) error {
+	for {
+		request, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(s.handle(withoutDictionaryWait(stream.Context()), request)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle applies the dictionary request of a stream request and decodes and
+// consumes its payload.
+func (s *traceZipStreamServer) handle(ctx context.Context, request *tracezipotlp.StreamRequest) *tracezipotlp.StreamResponse {
+	version := dictionaryVersion{Epoch: request.Epoch, Version: request.Version}
+	var ack dictionaryAck
+	var err error
+	switch {
+	case request.Signal == s.dicts.traces.signal && s.traces != nil:
+		isBinary := getMimeTypeFromContentType(request.ContentType) == ptraceotlp.TraceZipBinaryContentType
+		ack, err = serveStreamRequest(ctx, request, s.dicts.traces, s.telemetry, func() (ptraceotlp.ExportRequest, error) {
+			return decodeTraceZipTraces(ctx, s.dicts, request.Payload, version, isBinary)
+		}, func(otlpReq ptraceotlp.ExportRequest) error {
+			if s.exportSpans != "" {
+				if body, err := otlpReq.MarshalJSON(); err == nil {
+					go sendPostRequest(s.exportSpans, body)
+				}
+			}
+			_, err := s.traces.Export(ctx, otlpReq)
+			return err
+		})
+	case request.Signal == s.dicts.metrics.signal && s.metrics != nil:
+		ack, err = serveStreamRequest(ctx, request, s.dicts.metrics, s.telemetry, func() (pmetricotlp.ExportRequest, error) {
+			return decodeTraceZipMetrics(ctx, s.dicts, request.Payload, version)
+		}, func(otlpReq pmetricotlp.ExportRequest) error {
+			_, err := s.metrics.Export(ctx, otlpReq)
+			return err
+		})
+	case request.Signal == s.dicts.logs.signal && s.logs != nil:
+		ack, err = serveStreamRequest(ctx, request, s.dicts.logs, s.telemetry, func() (plogotlp.ExportRequest, error) {
+			return decodeTraceZipLogs(ctx, s.dicts, request.Payload, version)
+		}, func(otlpReq plogotlp.ExportRequest) error {
+			_, err := s.logs.Export(ctx, otlpReq)
+			return err
+		})
+	default:
+		err = status.Errorf(codes.Unimplemented, "the receiver does not accept %q", request.Signal)
+	}
+	response := &tracezipotlp.StreamResponse{Seq: request.Seq, Uuid: ack.Uuid, Epoch: ack.Epoch, Version: ack.Version}
+	if err != nil {
+		s := status.Convert(err)
+		response.Code, response.Message = uint32(s.Code()), s.Message()
+	}
+	return response
+}
+
+// serveStreamRequest applies the dictionary request of a stream request to dicts,
+// then decodes its payload with decode and hands it to consume. It returns the
+// version of the dictionary the receiver has then, and the failure as a gRPC
+// status error: a request that needs a version the receiver does not have fails
+// with Aborted.
+func serveStreamRequest[D traceZipDictionary, R any](ctx context.Context, request *tracezipotlp.StreamRequest, dicts *traceZipDictionaries[D], telemetry *traceZipTelemetry, decode func() (R, error), consume func(R) error) (dictionaryAck, error) {
+	var err error
+	reason := failureDictionary
+	if len(request.Dictionary) > 0 {
+		_, err = applyDictionaryRequest(ctx, dicts, request.Dictionary)
+	}
+	var otlpReq R
+	if err == nil && len(request.Payload) > 0 {
+		reason = failureDecode
+		otlpReq, err = decodeTraceZip(decode)
+	}
+	dicts.all.mu.Lock()
+	ack := dicts.ack(request.Uuid)
+	dicts.all.mu.Unlock()
+	if err != nil {
+		_, s := traceZipFailure(ctx, telemetry, dicts.signal, reason, err)
+		return ack, s.Err()
+	}
+	if len(request.Payload) > 0 {
+		err = consume(otlpReq)
+	}
+	return ack, err
+}
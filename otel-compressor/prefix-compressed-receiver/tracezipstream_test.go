@@ -0,0 +1,67 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package prefix_compressed_receiver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	prefix_compressed_exporter "angrychow/otel/prefix-compressed-exporter"
+
+	"go.opentelemetry.io/collector/config/configgrpc"
+	"go.opentelemetry.io/collector/config/configtls"
+)
+
+func TestTraceZipStream(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.GRPC.NetAddr.Endpoint = "127.0.0.1:0"
+	cfg.HTTP.Endpoint = "127.0.0.1:0"
+	r, sinks := newTestReceiver(t, cfg)
+	// the exporter cannot dial a bufconn listener through its config, so the
+	// server of the receiver serves a listener whose address the test knows too
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = r.serverGRPC.Serve(lis)
+	}()
+
+	// payloads and dictionary requests all go on the stream
+	var httpRequests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		httpRequests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	exp := newTestExporter(t, srv.URL, func(cfg *prefix_compressed_exporter.Config) {
+		cfg.GRPC = &configgrpc.ClientConfig{
+			Endpoint:   lis.Addr().String(),
+			TLSSetting: configtls.ClientConfig{Insecure: true},
+		}
+	})
+
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(0)))
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(1)))
+	uuid, before := tracesDictionary(t, r)
+
+	// The receiver answers the update of batch 2 with Aborted and no version. The
+	// exporter sends all of the dictionary on the stream under a new epoch, and
+	// compresses the batch again.
+	dropTracesDictionary(t, r, uuid)
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(2)))
+	_, resynced := tracesDictionary(t, r)
+	assert.Greater(t, resynced.Epoch, before.Epoch)
+
+	require.NoError(t, exp.ConsumeTraces(context.Background(), newTestTraces(3)))
+	_, after := tracesDictionary(t, r)
+	assert.Equal(t, dictionaryVersion{Epoch: resynced.Epoch, Version: resynced.Version + 1}, after)
+	assert.Equal(t, []string{"GET /order/0", "GET /order/1", "GET /order/2", "GET /order/3"}, spanNames(sinks.traces))
+	assert.Zero(t, httpRequests.Load())
+}